@@ -0,0 +1,124 @@
+package idempotency
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stats counts the non-expired records of the storage by status
+type Stats struct {
+	Pending   int `json:"pending"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+
+	// Stale is the number of pending records whose lock expired (see ListStale)
+	Stale int `json:"stale"`
+}
+
+// Stats counts the records of the storage by status, e.g. for operations dashboards.
+// The storage backend must implement RecordLister.
+func (m *Manager) Stats(ctx context.Context) (Stats, error) {
+	records, err := m.storageList(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	now := m.now()
+	var stats Stats
+	for _, record := range records {
+		if !record.ExpiresAt.IsZero() && now.After(record.ExpiresAt) {
+			continue
+		}
+		switch record.Status {
+		case StatusPending:
+			stats.Pending++
+			if now.After(record.CreatedAt.Add(m.config.LockTimeout)) {
+				stats.Stale++
+			}
+		case StatusCompleted:
+			stats.Completed++
+		case StatusFailed:
+			stats.Failed++
+		}
+	}
+
+	return stats, nil
+}
+
+// RecordFilter selects the records returned by ListRecords. Zero fields match every
+// record.
+type RecordFilter struct {
+	// Status keeps the records with this status (optional)
+	Status RecordStatus
+
+	// KeyPrefix keeps the records whose key starts with this prefix (optional)
+	KeyPrefix string
+
+	// CreatedAfter keeps the records created after this time (optional)
+	CreatedAfter time.Time
+
+	// CreatedBefore keeps the records created before this time (optional)
+	CreatedBefore time.Time
+
+	// Limit is the maximum number of records returned (optional)
+	Limit int
+}
+
+// match reports whether record is selected by the filter
+func (f RecordFilter) match(record *Record) bool {
+	return (f.Status == "" || record.Status == f.Status) &&
+		strings.HasPrefix(record.Key, f.KeyPrefix) &&
+		(f.CreatedAfter.IsZero() || record.CreatedAt.After(f.CreatedAfter)) &&
+		(f.CreatedBefore.IsZero() || record.CreatedAt.Before(f.CreatedBefore))
+}
+
+// ListRecords returns the non-expired records selected by filter, most recently created
+// first, for support tooling investigating stuck requests or wrong cached responses
+// (see GetRecord and Purge). The storage backend must implement RecordLister; the
+// records are filtered once listed, so listing a large storage is expensive.
+func (m *Manager) ListRecords(ctx context.Context, filter RecordFilter) ([]*Record, error) {
+	records, err := m.storageList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	selected := make([]*Record, 0, len(records))
+	for _, record := range records {
+		if !record.ExpiresAt.IsZero() && now.After(record.ExpiresAt) {
+			continue
+		}
+		if filter.match(record) {
+			selected = append(selected, record)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].CreatedAt.After(selected[j].CreatedAt)
+	})
+	if filter.Limit > 0 && len(selected) > filter.Limit {
+		selected = selected[:filter.Limit]
+	}
+	return selected, nil
+}
+
+// Purge deletes the record and the lock of key, e.g. to invalidate a wrong cached
+// response. The next request with the key is processed as a new one.
+func (m *Manager) Purge(ctx context.Context, key string) error {
+	if key == "" {
+		return ErrNoIdempotencyKey
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	if err := m.storageDelete(ctx, key); err != nil {
+		return NewStorageError("delete", err)
+	}
+	if err := m.storageUnlock(ctx, key); err != nil {
+		return NewStorageError("unlock", err)
+	}
+	m.quota.release(key)
+
+	return nil
+}
@@ -0,0 +1,251 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: admin.proto
+
+package adminpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	IdempotencyAdmin_Inspect_FullMethodName     = "/gopotency.admin.v1.IdempotencyAdmin/Inspect"
+	IdempotencyAdmin_Purge_FullMethodName       = "/gopotency.admin.v1.IdempotencyAdmin/Purge"
+	IdempotencyAdmin_ReleaseLock_FullMethodName = "/gopotency.admin.v1.IdempotencyAdmin/ReleaseLock"
+	IdempotencyAdmin_Stats_FullMethodName       = "/gopotency.admin.v1.IdempotencyAdmin/Stats"
+)
+
+// IdempotencyAdminClient is the client API for IdempotencyAdmin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// IdempotencyAdmin manages the idempotency records of a service.
+type IdempotencyAdminClient interface {
+	// Inspect returns the state of a key. Fails with NOT_FOUND when there is no record.
+	Inspect(ctx context.Context, in *InspectRequest, opts ...grpc.CallOption) (*InspectResponse, error)
+	// Purge deletes the record and the lock of a key, so it is processed again.
+	Purge(ctx context.Context, in *PurgeRequest, opts ...grpc.CallOption) (*PurgeResponse, error)
+	// ReleaseLock fails the pending record of a key and releases its lock, so a stuck
+	// request can be retried immediately.
+	ReleaseLock(ctx context.Context, in *ReleaseLockRequest, opts ...grpc.CallOption) (*ReleaseLockResponse, error)
+	// Stats counts the records by status. Fails with UNIMPLEMENTED when the storage
+	// cannot list records.
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+}
+
+type idempotencyAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIdempotencyAdminClient(cc grpc.ClientConnInterface) IdempotencyAdminClient {
+	return &idempotencyAdminClient{cc}
+}
+
+func (c *idempotencyAdminClient) Inspect(ctx context.Context, in *InspectRequest, opts ...grpc.CallOption) (*InspectResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InspectResponse)
+	err := c.cc.Invoke(ctx, IdempotencyAdmin_Inspect_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *idempotencyAdminClient) Purge(ctx context.Context, in *PurgeRequest, opts ...grpc.CallOption) (*PurgeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PurgeResponse)
+	err := c.cc.Invoke(ctx, IdempotencyAdmin_Purge_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *idempotencyAdminClient) ReleaseLock(ctx context.Context, in *ReleaseLockRequest, opts ...grpc.CallOption) (*ReleaseLockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReleaseLockResponse)
+	err := c.cc.Invoke(ctx, IdempotencyAdmin_ReleaseLock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *idempotencyAdminClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, IdempotencyAdmin_Stats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// IdempotencyAdminServer is the server API for IdempotencyAdmin service.
+// All implementations must embed UnimplementedIdempotencyAdminServer
+// for forward compatibility.
+//
+// IdempotencyAdmin manages the idempotency records of a service.
+type IdempotencyAdminServer interface {
+	// Inspect returns the state of a key. Fails with NOT_FOUND when there is no record.
+	Inspect(context.Context, *InspectRequest) (*InspectResponse, error)
+	// Purge deletes the record and the lock of a key, so it is processed again.
+	Purge(context.Context, *PurgeRequest) (*PurgeResponse, error)
+	// ReleaseLock fails the pending record of a key and releases its lock, so a stuck
+	// request can be retried immediately.
+	ReleaseLock(context.Context, *ReleaseLockRequest) (*ReleaseLockResponse, error)
+	// Stats counts the records by status. Fails with UNIMPLEMENTED when the storage
+	// cannot list records.
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	mustEmbedUnimplementedIdempotencyAdminServer()
+}
+
+// UnimplementedIdempotencyAdminServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedIdempotencyAdminServer struct{}
+
+func (UnimplementedIdempotencyAdminServer) Inspect(context.Context, *InspectRequest) (*InspectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Inspect not implemented")
+}
+func (UnimplementedIdempotencyAdminServer) Purge(context.Context, *PurgeRequest) (*PurgeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Purge not implemented")
+}
+func (UnimplementedIdempotencyAdminServer) ReleaseLock(context.Context, *ReleaseLockRequest) (*ReleaseLockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseLock not implemented")
+}
+func (UnimplementedIdempotencyAdminServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedIdempotencyAdminServer) mustEmbedUnimplementedIdempotencyAdminServer() {}
+func (UnimplementedIdempotencyAdminServer) testEmbeddedByValue()                          {}
+
+// UnsafeIdempotencyAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IdempotencyAdminServer will
+// result in compilation errors.
+type UnsafeIdempotencyAdminServer interface {
+	mustEmbedUnimplementedIdempotencyAdminServer()
+}
+
+func RegisterIdempotencyAdminServer(s grpc.ServiceRegistrar, srv IdempotencyAdminServer) {
+	// If the following call pancis, it indicates UnimplementedIdempotencyAdminServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&IdempotencyAdmin_ServiceDesc, srv)
+}
+
+func _IdempotencyAdmin_Inspect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InspectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IdempotencyAdminServer).Inspect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IdempotencyAdmin_Inspect_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IdempotencyAdminServer).Inspect(ctx, req.(*InspectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IdempotencyAdmin_Purge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IdempotencyAdminServer).Purge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IdempotencyAdmin_Purge_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IdempotencyAdminServer).Purge(ctx, req.(*PurgeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IdempotencyAdmin_ReleaseLock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseLockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IdempotencyAdminServer).ReleaseLock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IdempotencyAdmin_ReleaseLock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IdempotencyAdminServer).ReleaseLock(ctx, req.(*ReleaseLockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IdempotencyAdmin_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IdempotencyAdminServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IdempotencyAdmin_Stats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IdempotencyAdminServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// IdempotencyAdmin_ServiceDesc is the grpc.ServiceDesc for IdempotencyAdmin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IdempotencyAdmin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gopotency.admin.v1.IdempotencyAdmin",
+	HandlerType: (*IdempotencyAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Inspect",
+			Handler:    _IdempotencyAdmin_Inspect_Handler,
+		},
+		{
+			MethodName: "Purge",
+			Handler:    _IdempotencyAdmin_Purge_Handler,
+		},
+		{
+			MethodName: "ReleaseLock",
+			Handler:    _IdempotencyAdmin_ReleaseLock_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _IdempotencyAdmin_Stats_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
@@ -0,0 +1,5 @@
+// Package adminpb contains the protobuf messages and gRPC stubs of the idempotency admin
+// service, generated from admin.proto.
+package adminpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative admin.proto
@@ -0,0 +1,137 @@
+// Package grpc exposes the idempotency admin operations (inspect, purge, release lock,
+// stats) as a gRPC service, for platforms managing services over gRPC:
+//
+//	server := grpc.NewServer(grpc.UnaryInterceptor(authInterceptor))
+//	grpcadmin.Register(server, manager)
+//
+// The service is defined in adminpb/admin.proto. It gives full control over cached
+// responses, so only expose it behind authentication.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/admin/grpc/adminpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements adminpb.IdempotencyAdminServer with a manager
+type Server struct {
+	adminpb.UnimplementedIdempotencyAdminServer
+
+	manager *idempotency.Manager
+}
+
+// NewServer creates the admin service of manager
+func NewServer(manager *idempotency.Manager) *Server {
+	return &Server{manager: manager}
+}
+
+// Register registers the admin service of manager on registrar (e.g. a *grpc.Server)
+func Register(registrar grpc.ServiceRegistrar, manager *idempotency.Manager) {
+	adminpb.RegisterIdempotencyAdminServer(registrar, NewServer(manager))
+}
+
+// Inspect returns the state of a key
+func (s *Server) Inspect(ctx context.Context, req *adminpb.InspectRequest) (*adminpb.InspectResponse, error) {
+	var info *idempotency.KeyInfo
+	var err error
+	if req.GetIncludeBody() {
+		info, err = s.manager.InspectWithBody(ctx, req.GetKey())
+	} else {
+		info, err = s.manager.Inspect(ctx, req.GetKey())
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &adminpb.InspectResponse{Info: toKeyInfo(info)}, nil
+}
+
+// Purge deletes the record and the lock of a key
+func (s *Server) Purge(ctx context.Context, req *adminpb.PurgeRequest) (*adminpb.PurgeResponse, error) {
+	if err := s.manager.Purge(ctx, req.GetKey()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &adminpb.PurgeResponse{}, nil
+}
+
+// ReleaseLock fails the pending record of a key and releases its lock
+func (s *Server) ReleaseLock(ctx context.Context, req *adminpb.ReleaseLockRequest) (*adminpb.ReleaseLockResponse, error) {
+	if err := s.manager.Fail(ctx, req.GetKey()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &adminpb.ReleaseLockResponse{}, nil
+}
+
+// Stats counts the records by status
+func (s *Server) Stats(ctx context.Context, req *adminpb.StatsRequest) (*adminpb.StatsResponse, error) {
+	stats, err := s.manager.Stats(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &adminpb.StatsResponse{
+		Pending:   int64(stats.Pending),
+		Completed: int64(stats.Completed),
+		Failed:    int64(stats.Failed),
+		Stale:     int64(stats.Stale),
+	}, nil
+}
+
+// toStatus converts a manager error into a gRPC status
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, idempotency.ErrNoIdempotencyKey):
+		return status.Error(codes.InvalidArgument, "key is required")
+	case errors.Is(err, idempotency.ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, idempotency.ErrListingNotSupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Unavailable, err.Error())
+	}
+}
+
+// toKeyInfo converts a KeyInfo into its protobuf message
+func toKeyInfo(info *idempotency.KeyInfo) *adminpb.KeyInfo {
+	msg := &adminpb.KeyInfo{
+		Key:         info.Key,
+		Status:      string(info.Status),
+		RequestHash: info.RequestHash,
+		CreatedAt:   timestamp(info.CreatedAt),
+		ExpiresAt:   timestamp(info.ExpiresAt),
+		CompletedAt: timestamp(info.CompletedAt),
+		Replays:     int64(info.Replays),
+		Error:       info.Error,
+		StatusCode:  int32(info.StatusCode),
+		ContentType: info.ContentType,
+		Etag:        info.ETag,
+		Region:      info.Region,
+		BodySize:    int64(info.BodySize),
+		Body:        info.Body,
+	}
+	if len(info.Headers) > 0 {
+		msg.Headers = make(map[string]*adminpb.HeaderValues, len(info.Headers))
+		for name, values := range info.Headers {
+			msg.Headers[name] = &adminpb.HeaderValues{Values: values}
+		}
+	}
+	return msg
+}
+
+// timestamp converts t, leaving zero times unset
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
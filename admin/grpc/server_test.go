@@ -0,0 +1,98 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/admin/grpc/adminpb"
+	"github.com/fco-gt/gopotency/storage/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store})
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	Register(server, manager)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	client := adminpb.NewIdempotencyAdminClient(conn)
+
+	// A completed and a pending request
+	done := &idempotency.Request{Method: "POST", Path: "/orders", IdempotencyKey: "done"}
+	outcome, _ := manager.Begin(ctx, done)
+	_ = outcome.Token.Complete(&idempotency.Response{StatusCode: http.StatusCreated, Body: []byte("created")})
+	_, _ = manager.Begin(ctx, &idempotency.Request{Method: "POST", Path: "/orders", IdempotencyKey: "stuck"})
+
+	t.Run("Inspect", func(t *testing.T) {
+		resp, err := client.Inspect(ctx, &adminpb.InspectRequest{Key: "done", IncludeBody: true})
+		if err != nil {
+			t.Fatalf("inspect: %v", err)
+		}
+		info := resp.GetInfo()
+		if info.GetStatus() != "completed" || info.GetStatusCode() != http.StatusCreated || string(info.GetBody()) != "created" {
+			t.Errorf("unexpected info: %v", info)
+		}
+		if info.GetCompletedAt().AsTime().Before(time.Now().Add(-time.Minute)) {
+			t.Errorf("unexpected completion time: %v", info.GetCompletedAt())
+		}
+
+		_, err = client.Inspect(ctx, &adminpb.InspectRequest{Key: "missing"})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("expected NotFound, got %v", err)
+		}
+		_, err = client.Inspect(ctx, &adminpb.InspectRequest{})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		resp, err := client.Stats(ctx, &adminpb.StatsRequest{})
+		if err != nil {
+			t.Fatalf("stats: %v", err)
+		}
+		if resp.GetCompleted() != 1 || resp.GetPending() != 1 {
+			t.Errorf("unexpected stats: %v", resp)
+		}
+	})
+
+	t.Run("ReleaseLock", func(t *testing.T) {
+		if _, err := client.ReleaseLock(ctx, &adminpb.ReleaseLockRequest{Key: "stuck"}); err != nil {
+			t.Fatalf("release lock: %v", err)
+		}
+		record, _ := manager.GetRecord(ctx, "stuck")
+		if record == nil || record.Status != idempotency.StatusFailed {
+			t.Errorf("expected the record to be failed, got %+v", record)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		if _, err := client.Purge(ctx, &adminpb.PurgeRequest{Key: "done"}); err != nil {
+			t.Fatalf("purge: %v", err)
+		}
+		if record, _ := manager.GetRecord(ctx, "done"); record != nil {
+			t.Errorf("expected the record to be purged, got %+v", record)
+		}
+	})
+}
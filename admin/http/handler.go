@@ -0,0 +1,224 @@
+// Package http exposes the idempotency admin operations as JSON HTTP endpoints, for
+// support and operations tooling:
+//
+//	GET    /idempotency/records        list records (ListRecords)
+//	GET    /idempotency/records/{key}  inspect a record (?body=true includes the body)
+//	DELETE /idempotency/records/{key}  purge a record and its lock (Purge)
+//	GET    /idempotency/stats          count records by status (Stats)
+//
+// The records list is filtered with the status, prefix, created_after and
+// created_before (RFC 3339) and limit query parameters. Listing and stats require a
+// storage implementing idempotency.RecordLister.
+//
+// The handler gives full control over cached responses, so only mount it behind
+// internal authentication:
+//
+//	mux.Handle("/idempotency/", requireAdmin(adminhttp.Handler(manager, adminhttp.Options{})))
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Options configures the admin handler
+type Options struct {
+	// Prefix is the path under which the endpoints are served
+	// Default: "/idempotency"
+	Prefix string
+
+	// ReadOnly disables DELETE, for handlers exposed to read-only roles
+	// Default: false
+	ReadOnly bool
+
+	// DefaultLimit is the number of records listed without a limit query parameter
+	// Default: 100
+	DefaultLimit int
+}
+
+func (o *Options) setDefaults() {
+	if o.Prefix == "" {
+		o.Prefix = "/idempotency"
+	}
+	if o.DefaultLimit <= 0 {
+		o.DefaultLimit = 100
+	}
+}
+
+// Record is the JSON representation of a record
+type Record struct {
+	Key         string              `json:"key"`
+	Status      string              `json:"status"`
+	RequestHash string              `json:"request_hash,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	ExpiresAt   time.Time           `json:"expires_at"`
+	CompletedAt *time.Time          `json:"completed_at,omitempty"`
+	Replays     int                 `json:"replays,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	StatusCode  int                 `json:"status_code,omitempty"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+	ContentType string              `json:"content_type,omitempty"`
+	Region      string              `json:"region,omitempty"`
+	BodySize    int                 `json:"body_size"`
+	Body        []byte              `json:"body,omitempty"`
+}
+
+// Handler returns the admin endpoints of manager
+func Handler(manager *idempotency.Manager, opts Options) http.Handler {
+	opts.setDefaults()
+	h := &handler{manager: manager, opts: opts}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+opts.Prefix+"/records", h.list)
+	mux.HandleFunc("GET "+opts.Prefix+"/records/{key}", h.get)
+	mux.HandleFunc("GET "+opts.Prefix+"/stats", h.stats)
+	if !opts.ReadOnly {
+		mux.HandleFunc("DELETE "+opts.Prefix+"/records/{key}", h.purge)
+	}
+	return mux
+}
+
+type handler struct {
+	manager *idempotency.Manager
+	opts    Options
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	filter, err := h.filter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	records, err := h.manager.ListRecords(r.Context(), filter)
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+
+	views := make([]Record, 0, len(records))
+	for _, record := range records {
+		views = append(views, toRecord(record, false))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"records": views})
+}
+
+// filter parses the query parameters of the records list
+func (h *handler) filter(r *http.Request) (idempotency.RecordFilter, error) {
+	query := r.URL.Query()
+	filter := idempotency.RecordFilter{
+		Status:    idempotency.RecordStatus(query.Get("status")),
+		KeyPrefix: query.Get("prefix"),
+		Limit:     h.opts.DefaultLimit,
+	}
+
+	for name, t := range map[string]*time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*t = parsed
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", value)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	record, err := h.manager.GetRecord(r.Context(), r.PathValue("key"))
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	if record == nil {
+		writeManagerError(w, idempotency.ErrKeyNotFound)
+		return
+	}
+	withBody, _ := strconv.ParseBool(r.URL.Query().Get("body"))
+	writeJSON(w, http.StatusOK, toRecord(record, withBody))
+}
+
+func (h *handler) purge(w http.ResponseWriter, r *http.Request) {
+	if err := h.manager.Purge(r.Context(), r.PathValue("key")); err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.manager.Stats(r.Context())
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// toRecord converts a record into its JSON representation
+func toRecord(record *idempotency.Record, withBody bool) Record {
+	view := Record{
+		Key:         record.Key,
+		Status:      string(record.Status),
+		RequestHash: record.RequestHash,
+		CreatedAt:   record.CreatedAt,
+		ExpiresAt:   record.ExpiresAt,
+		Replays:     record.Replays,
+		Error:       record.Error,
+	}
+	if resp := record.Response; resp != nil {
+		if !resp.CompletedAt.IsZero() {
+			view.CompletedAt = &resp.CompletedAt
+		}
+		view.StatusCode = resp.StatusCode
+		view.Headers = resp.Headers
+		view.ContentType = resp.ContentType
+		view.Region = resp.Region
+		view.BodySize = len(resp.Body)
+		if withBody {
+			view.Body = resp.Body
+		}
+	}
+	return view
+}
+
+// writeManagerError writes the HTTP error matching a manager error
+func writeManagerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, idempotency.ErrNoIdempotencyKey):
+		writeError(w, http.StatusBadRequest, errors.New("key is required"))
+	case errors.Is(err, idempotency.ErrKeyNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, idempotency.ErrListingNotSupported):
+		writeError(w, http.StatusNotImplemented, err)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, err)
+	default:
+		writeError(w, http.StatusServiceUnavailable, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store})
+
+	// A completed and a pending request
+	outcome, _ := manager.Begin(ctx, &idempotency.Request{Method: "POST", Path: "/orders", IdempotencyKey: "done"})
+	_ = outcome.Token.Complete(&idempotency.Response{StatusCode: http.StatusCreated, Body: []byte("created")})
+	_, _ = manager.Begin(ctx, &idempotency.Request{Method: "POST", Path: "/orders", IdempotencyKey: "stuck"})
+
+	handler := Handler(manager, Options{})
+	serve := func(method, target string, v any) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		if v != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatalf("%s %s: invalid JSON %q: %v", method, target, rec.Body.String(), err)
+			}
+		}
+		return rec.Code
+	}
+
+	t.Run("List", func(t *testing.T) {
+		var list struct{ Records []Record }
+		if code := serve("GET", "/idempotency/records?status=completed", &list); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if len(list.Records) != 1 || list.Records[0].Key != "done" || list.Records[0].Body != nil {
+			t.Errorf("expected the completed record without its body, got %+v", list.Records)
+		}
+
+		if code := serve("GET", "/idempotency/records?limit=-1", nil); code != http.StatusBadRequest {
+			t.Errorf("expected an invalid limit to be rejected, got %d", code)
+		}
+		if code := serve("GET", "/idempotency/records?created_after=yesterday", nil); code != http.StatusBadRequest {
+			t.Errorf("expected an invalid time to be rejected, got %d", code)
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		var record Record
+		if code := serve("GET", "/idempotency/records/done?body=true", &record); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if record.Status != "completed" || record.StatusCode != http.StatusCreated || string(record.Body) != "created" || record.CompletedAt == nil {
+			t.Errorf("unexpected record: %+v", record)
+		}
+
+		if code := serve("GET", "/idempotency/records/missing", nil); code != http.StatusNotFound {
+			t.Errorf("expected 404 for a missing record, got %d", code)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		var stats idempotency.Stats
+		if code := serve("GET", "/idempotency/stats", &stats); code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", code)
+		}
+		if stats.Completed != 1 || stats.Pending != 1 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		readOnly := Handler(manager, Options{ReadOnly: true})
+		rec := httptest.NewRecorder()
+		readOnly.ServeHTTP(rec, httptest.NewRequest("DELETE", "/idempotency/records/stuck", nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected a read-only handler to reject DELETE, got %d", rec.Code)
+		}
+
+		if code := serve("DELETE", "/idempotency/records/stuck", nil); code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d", code)
+		}
+		if code := serve("GET", "/idempotency/records/stuck", nil); code != http.StatusNotFound {
+			t.Errorf("expected the purged record to be gone, got %d", code)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManager_Stats(t *testing.T) {
+	ctx := context.Background()
+
+	m, _ := NewManager(Config{Storage: &MockStorage{}})
+	if _, err := m.Stats(ctx); !errors.Is(err, ErrListingNotSupported) {
+		t.Fatalf("expected ErrListingNotSupported, got %v", err)
+	}
+
+	storage := newListingStorage()
+	now := time.Now()
+	storage.records["pending"] = &Record{Key: "pending", Status: StatusPending, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	storage.records["stale"] = &Record{Key: "stale", Status: StatusPending, CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+	storage.records["completed"] = &Record{Key: "completed", Status: StatusCompleted, ExpiresAt: now.Add(time.Hour)}
+	storage.records["failed"] = &Record{Key: "failed", Status: StatusFailed, ExpiresAt: now.Add(time.Hour)}
+	storage.records["expired"] = &Record{Key: "expired", Status: StatusCompleted, ExpiresAt: now.Add(-time.Hour)}
+
+	m, _ = NewManager(Config{Storage: storage, LockTimeout: time.Minute})
+	stats, err := m.Stats(ctx)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	want := Stats{Pending: 2, Completed: 1, Failed: 1, Stale: 1}
+	if stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+}
+
+func TestManager_Purge(t *testing.T) {
+	ctx := context.Background()
+	var deleted, unlocked string
+	m, _ := NewManager(Config{Storage: &MockStorage{
+		DeleteFunc: func(ctx context.Context, key string) error { deleted = key; return nil },
+		UnlockFunc: func(ctx context.Context, key string) error { unlocked = key; return nil },
+	}})
+
+	if err := m.Purge(ctx, ""); !errors.Is(err, ErrNoIdempotencyKey) {
+		t.Fatalf("expected ErrNoIdempotencyKey, got %v", err)
+	}
+	if err := m.Purge(ctx, "k"); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if deleted != "k" || unlocked != "k" {
+		t.Errorf("expected the record and lock to be deleted, got %q %q", deleted, unlocked)
+	}
+}
+
+func TestManager_ListRecords(t *testing.T) {
+	ctx := context.Background()
+
+	m, _ := NewManager(Config{Storage: &MockStorage{}})
+	if _, err := m.ListRecords(ctx, RecordFilter{}); !errors.Is(err, ErrListingNotSupported) {
+		t.Fatalf("expected ErrListingNotSupported, got %v", err)
+	}
+
+	storage := newListingStorage()
+	now := time.Now()
+	storage.records["order-1"] = &Record{Key: "order-1", Status: StatusCompleted, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)}
+	storage.records["order-2"] = &Record{Key: "order-2", Status: StatusPending, CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)}
+	storage.records["order-3"] = &Record{Key: "order-3", Status: StatusCompleted, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	storage.records["refund-1"] = &Record{Key: "refund-1", Status: StatusCompleted, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	storage.records["order-old"] = &Record{Key: "order-old", Status: StatusCompleted, CreatedAt: now.Add(-48 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	m, _ = NewManager(Config{Storage: storage})
+
+	tests := []struct {
+		name   string
+		filter RecordFilter
+		want   []string
+	}{
+		{"All", RecordFilter{KeyPrefix: "order-"}, []string{"order-3", "order-2", "order-1"}},
+		{"Status", RecordFilter{KeyPrefix: "order-", Status: StatusCompleted}, []string{"order-3", "order-1"}},
+		{"CreatedRange", RecordFilter{CreatedAfter: now.Add(-90 * time.Minute), CreatedBefore: now.Add(-time.Minute)}, []string{"order-2"}},
+		{"Limit", RecordFilter{KeyPrefix: "order-", Limit: 1}, []string{"order-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := m.ListRecords(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("ListRecords failed: %v", err)
+			}
+			var keys []string
+			for _, r := range records {
+				keys = append(keys, r.Key)
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("expected %v, got %v", tt.want, keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// GetRecord returns the current, non-expired record for key, or nil if there is none
+func (m *Manager) GetRecord(ctx context.Context, key string) (*Record, error) {
+	if key == "" {
+		return nil, ErrNoIdempotencyKey
+	}
+
+	record, err := m.storageGet(ctx, key)
+	if err != nil {
+		return nil, NewStorageError("get", err)
+	}
+
+	if record == nil || (!record.ExpiresAt.IsZero() && m.now().After(record.ExpiresAt)) {
+		return nil, nil
+	}
+
+	return record, nil
+}
+
+// AcceptedResponse returns the 202 Accepted response sent to duplicates of an in-progress
+// request when Config.AsyncStatusURL is set, or nil otherwise.
+// The Location header points to the status URL where the client can poll for the result.
+func (m *Manager) AcceptedResponse(key string) *CachedResponse {
+	if m.config.AsyncStatusURL == nil {
+		return nil
+	}
+
+	location := m.config.AsyncStatusURL(key)
+	body, _ := json.Marshal(map[string]string{
+		"status":     string(StatusPending),
+		"status_url": location,
+	})
+
+	return &CachedResponse{
+		StatusCode: http.StatusAccepted,
+		Headers: map[string][]string{
+			"Location":     {location},
+			"Content-Type": {"application/json"},
+		},
+		Body:        body,
+		ContentType: "application/json",
+	}
+}
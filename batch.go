@@ -0,0 +1,99 @@
+package idempotency
+
+import (
+	"context"
+	"strconv"
+)
+
+// BatchItemSeparator separates the batch key from the item id in item sub-keys
+const BatchItemSeparator = "#"
+
+// BatchItem is one operation of a batch request
+type BatchItem struct {
+	// ID identifies the item within the batch (e.g. a client-provided reference).
+	// Default: the index of the item
+	ID string
+
+	// Body is the payload of the item, hashed to detect reuse with different content
+	Body []byte
+}
+
+// BatchResult is the outcome of one batch item, in the order of the items
+type BatchResult struct {
+	// Key is the sub-key of the item
+	Key string
+
+	// Response is the response of the item, replayed from the cache or just processed
+	// (nil when Err is set)
+	Response *CachedResponse
+
+	// Replayed is true when the item was already processed by a previous batch
+	Replayed bool
+
+	// Err is ErrRequestInProgress or ErrRequestMismatch when the item could not be
+	// processed, or the error returned for it by the process function
+	Err error
+}
+
+// BatchItemKey derives the sub-key of an item from the key of its batch
+func BatchItemKey(batchKey, itemID string) string {
+	return batchKey + BatchItemSeparator + itemID
+}
+
+// ProcessBatch handles a request carrying several operations, e.g. bulk payments.
+// Every item gets its own sub-key (see BatchItemKey) that is checked and locked
+// separately, so process only runs for the items not processed yet and retrying a
+// partially failed batch replays the items that succeeded. The results are returned
+// in the order of items, to be merged into the batch response.
+// The batch key is req.IdempotencyKey or the key header; ErrNoIdempotencyKey is
+// returned without one.
+func (m *Manager) ProcessBatch(ctx context.Context, req *Request, items []BatchItem, process func(ctx context.Context, item BatchItem) (*Response, error)) ([]BatchResult, error) {
+	batchKey := req.IdempotencyKey
+	if batchKey == "" {
+		batchKey = m.KeyFromHeaders(req.Headers)
+	}
+	if batchKey == "" {
+		return nil, ErrNoIdempotencyKey
+	}
+
+	results := make([]BatchResult, len(items))
+	for i, item := range items {
+		if item.ID == "" {
+			item.ID = strconv.Itoa(i)
+		}
+		results[i] = m.processBatchItem(ctx, req, BatchItemKey(batchKey, item.ID), item, process)
+	}
+	return results, nil
+}
+
+// processBatchItem runs process for item unless its sub-key was already processed
+func (m *Manager) processBatchItem(ctx context.Context, req *Request, key string, item BatchItem, process func(ctx context.Context, item BatchItem) (*Response, error)) BatchResult {
+	itemReq := &Request{
+		Method:         req.Method,
+		Path:           req.Path,
+		Query:          req.Query,
+		Headers:        req.Headers,
+		Body:           item.Body,
+		IdempotencyKey: key,
+	}
+
+	outcome, err := m.Begin(ctx, itemReq)
+	if err != nil {
+		return BatchResult{Key: key, Err: err}
+	}
+
+	switch outcome.Kind {
+	case OutcomeReplay:
+		return BatchResult{Key: key, Response: outcome.Response, Replayed: true}
+	case OutcomeConflict:
+		return BatchResult{Key: key, Err: ErrRequestInProgress}
+	}
+
+	resp, err := process(ctx, item)
+	if err != nil {
+		_ = outcome.Token.Fail(err)
+		return BatchResult{Key: key, Err: err}
+	}
+	_ = outcome.Token.Complete(resp)
+	return BatchResult{Key: key, Response: resp.ToCachedResponse()}
+}
@@ -0,0 +1,69 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestManager_ProcessBatch(t *testing.T) {
+	store := newListingStorage()
+	m, _ := NewManager(Config{Storage: store})
+	ctx := context.Background()
+
+	req := &Request{Method: "POST", Path: "/payments/batch", IdempotencyKey: "batch-1"}
+	items := []BatchItem{
+		{ID: "pay-a", Body: []byte(`{"amount":10}`)},
+		{ID: "pay-b", Body: []byte(`{"amount":20}`)},
+		{Body: []byte(`{"amount":30}`)},
+	}
+
+	var processed []string
+	failB := true
+	process := func(ctx context.Context, item BatchItem) (*Response, error) {
+		processed = append(processed, item.ID)
+		if item.ID == "pay-b" && failB {
+			return nil, errors.New("card declined")
+		}
+		return &Response{StatusCode: 201, Body: []byte(item.ID)}, nil
+	}
+
+	results, err := m.ProcessBatch(ctx, req, items, process)
+	if err != nil {
+		t.Fatalf("ProcessBatch failed: %v", err)
+	}
+	if len(processed) != 3 {
+		t.Fatalf("expected every item to be processed, got %v", processed)
+	}
+	if results[1].Err == nil || results[0].Err != nil || results[2].Key != "batch-1#2" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	// Retrying the batch only processes the failed item
+	processed = nil
+	failB = false
+	results, err = m.ProcessBatch(ctx, req, items, process)
+	if err != nil {
+		t.Fatalf("ProcessBatch failed: %v", err)
+	}
+	if len(processed) != 1 || processed[0] != "pay-b" {
+		t.Fatalf("expected only pay-b to be processed, got %v", processed)
+	}
+	if !results[0].Replayed || string(results[0].Response.Body) != "pay-a" {
+		t.Errorf("expected pay-a to be replayed, got %+v", results[0])
+	}
+	if results[1].Replayed || results[1].Response.StatusCode != 201 {
+		t.Errorf("expected pay-b to be processed, got %+v", results[1])
+	}
+
+	// Reusing an item id with a different payload is a mismatch
+	items[0].Body = []byte(`{"amount":99}`)
+	results, _ = m.ProcessBatch(ctx, req, items[:1], process)
+	if !errors.Is(results[0].Err, ErrRequestMismatch) {
+		t.Errorf("expected ErrRequestMismatch, got %v", results[0].Err)
+	}
+
+	if _, err := m.ProcessBatch(ctx, &Request{Method: "POST"}, items, process); !errors.Is(err, ErrNoIdempotencyKey) {
+		t.Errorf("expected ErrNoIdempotencyKey, got %v", err)
+	}
+}
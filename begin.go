@@ -0,0 +1,249 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// OutcomeKind describes what the caller of Manager.Begin must do with a request
+type OutcomeKind int
+
+const (
+	// OutcomeProceed means the request must be processed and its Token completed or failed
+	OutcomeProceed OutcomeKind = iota
+
+	// OutcomeReplay means the request was already processed and Outcome.Response must be replayed
+	OutcomeReplay
+
+	// OutcomeConflict means a request with the same key is currently being processed
+	OutcomeConflict
+)
+
+// String returns the name of the outcome kind
+func (k OutcomeKind) String() string {
+	switch k {
+	case OutcomeProceed:
+		return "proceed"
+	case OutcomeReplay:
+		return "replay"
+	case OutcomeConflict:
+		return "conflict"
+	default:
+		return "unknown"
+	}
+}
+
+// Outcome is the result of Manager.Begin
+type Outcome struct {
+	// Kind tells which of the fields below is set
+	Kind OutcomeKind
+
+	// Response is the cached response to replay (OutcomeReplay)
+	Response *CachedResponse
+
+	// Token must be completed or failed once the request is processed (OutcomeProceed)
+	Token *Token
+
+	// Headers must be added to the response of the processed request (OutcomeProceed),
+	// e.g. ExpiredKeyHeaderName with ExpiredKeyWarn
+	Headers map[string]string
+}
+
+// Token represents a request being processed under an idempotency lock.
+// Exactly one of Complete or Fail must be called once processing is over.
+type Token struct {
+	manager *Manager
+	ctx     context.Context
+	req     *Request
+	capture *BodyCapture
+}
+
+// Key returns the idempotency key of the request, empty when idempotency does not apply
+func (t *Token) Key() string {
+	return t.req.IdempotencyKey
+}
+
+// BodyCapture returns the writer the response body must be written to while the
+// handler runs, instead of being buffered as a whole, so Config.MaxCachedBodySize
+// bounds the memory used by the response. The captured body replaces the Body of the
+// response passed to Complete.
+func (t *Token) BodyCapture() *BodyCapture {
+	if t.capture == nil {
+		t.capture = &BodyCapture{ctx: t.ctx, manager: t.manager, key: t.Key()}
+	}
+	return t.capture
+}
+
+// Complete caches resp and releases the lock. Responses that must not be cached
+// (see Manager.ShouldStore) fail the record instead, so the request can be retried,
+// after Config.FailureTTL during which they are replayed. Bodies larger than
+// Config.MaxCachedBodySize are handled according to Config.BodyOverflow, streaming
+// responses according to Config.Streaming.
+func (t *Token) Complete(resp *Response) error {
+	if t.Key() == "" {
+		return nil
+	}
+	if !t.manager.shouldCache(t.req, resp.StatusCode, resp.Headers) {
+		if t.manager.config.FailureTTL > 0 {
+			return t.manager.storeFailure(t.ctx, t.Key(), resp)
+		}
+		return t.manager.fail(t.ctx, t.Key(), "")
+	}
+
+	limited, reason, err := t.manager.limitBody(t.ctx, t.Key(), resp, t.capture)
+	if err != nil {
+		_ = t.manager.fail(t.ctx, t.Key(), err.Error())
+		return err
+	}
+	if limited == nil {
+		return t.manager.fail(t.ctx, t.Key(), reason)
+	}
+	return t.manager.Store(t.ctx, t.Key(), limited)
+}
+
+// Record returns the completed record Complete stores for resp, e.g. to write it in the
+// database transaction of the domain changes with SetTx of the SQL and GORM storages, so
+// both commit atomically. Complete must still be called after the commit, to release
+// the lock. Returns nil when idempotency does not apply.
+func (t *Token) Record(resp *Response) *Record {
+	if t.Key() == "" {
+		return nil
+	}
+	record := &Record{
+		Key:         t.Key(),
+		RequestHash: t.req.hash,
+		CreatedAt:   t.manager.now(),
+		TTL:         t.manager.policy(t.req).TTL,
+	}
+	t.manager.completeRecord(record, resp)
+	return record
+}
+
+// Fail marks the record as failed with the given cause and releases the lock,
+// so the request can be retried
+func (t *Token) Fail(cause error) error {
+	if t.Key() == "" {
+		return nil
+	}
+	reason := ""
+	if cause != nil {
+		reason = cause.Error()
+	}
+	ctx, span := t.manager.startSpan(t.ctx, "idempotency.Fail", attrKey.String(t.Key()))
+	err := t.manager.fail(ctx, t.Key(), reason)
+	endSpan(span, err)
+	return err
+}
+
+// Begin combines Check and Lock: it returns either the cached response to replay, a
+// conflict if the request is in progress, or a Token to complete once the request is
+// processed. Requests without an idempotency key (when not required) proceed with a
+// Token whose Complete and Fail are no-ops. With a storage implementing GetOrLocker,
+// the record is read and the lock acquired in a single atomic operation.
+// Other errors (ErrRequestMismatch, ErrNoIdempotencyKey, storage errors) are returned as is.
+func (m *Manager) Begin(ctx context.Context, req *Request) (Outcome, error) {
+	ctx, span := m.startSpan(ctx, "idempotency.Begin")
+	outcome, err := m.begin(ctx, req)
+	span.SetAttributes(attrKey.String(req.IdempotencyKey))
+	if err == nil {
+		span.SetAttributes(attrOutcome.String(outcome.Kind.String()))
+	}
+	endSpan(span, err)
+	return outcome, err
+}
+
+// begin is Begin without tracing
+func (m *Manager) begin(ctx context.Context, req *Request) (Outcome, error) {
+	if Supports[GetOrLocker](m.config.Storage) {
+		return m.beginAtomic(ctx, req)
+	}
+
+	cached, err := m.Check(ctx, req)
+	switch {
+	case errors.Is(err, ErrRequestInProgress):
+		return Outcome{Kind: OutcomeConflict}, nil
+	case err != nil:
+		return Outcome{}, err
+	case cached != nil:
+		return Outcome{Kind: OutcomeReplay, Response: cached}, nil
+	}
+
+	token := &Token{manager: m, ctx: context.WithoutCancel(ctx), req: req}
+	if req.IdempotencyKey == "" {
+		return Outcome{Kind: OutcomeProceed, Token: token}, nil
+	}
+	return m.beginLocked(ctx, req, token)
+}
+
+// beginAtomic is Begin with a GetOrLocker storage
+func (m *Manager) beginAtomic(ctx context.Context, req *Request) (Outcome, error) {
+	if err := m.resolveKey(ctx, req); err != nil {
+		return Outcome{}, err
+	}
+
+	token := &Token{manager: m, ctx: context.WithoutCancel(ctx), req: req}
+	if req.IdempotencyKey == "" {
+		return Outcome{Kind: OutcomeProceed, Token: token}, nil
+	}
+
+	start := time.Now()
+	record, policy, err := m.pendingRecord(req)
+	if err != nil {
+		return Outcome{}, err
+	}
+	releaseQuota, err := m.acquireQuota(req)
+	if err != nil {
+		return Outcome{}, err
+	}
+
+	existing, locked, err := m.storageGetOrLock(ctx, record, policy.PendingTTL, policy.LockTimeout)
+	if err != nil {
+		releaseQuota()
+		return Outcome{}, NewStorageError("getorlock", err)
+	}
+	if locked {
+		m.locked(req, start, policy)
+		return m.proceed(req, token), nil
+	}
+	releaseQuota()
+
+	if existing == nil {
+		// Locked by a request whose pending record is not visible
+		m.conflict(ctx, req)
+		return Outcome{Kind: OutcomeConflict}, nil
+	}
+
+	cached, err := m.checkRecord(ctx, req, existing)
+	switch {
+	case errors.Is(err, ErrRequestInProgress):
+		return Outcome{Kind: OutcomeConflict}, nil
+	case err != nil:
+		return Outcome{}, err
+	case cached != nil:
+		return Outcome{Kind: OutcomeReplay, Response: cached}, nil
+	}
+
+	// The record failed or expired: retry the lock like a new request
+	return m.beginLocked(ctx, req, token)
+}
+
+// beginLocked locks req, which has a key and no replayable record
+func (m *Manager) beginLocked(ctx context.Context, req *Request, token *Token) (Outcome, error) {
+	if err := m.Lock(ctx, req); err != nil {
+		if errors.Is(err, ErrRequestInProgress) {
+			return Outcome{Kind: OutcomeConflict}, nil
+		}
+		return Outcome{}, err
+	}
+	return m.proceed(req, token), nil
+}
+
+// proceed returns the outcome of a locked request
+func (m *Manager) proceed(req *Request, token *Token) Outcome {
+	outcome := Outcome{Kind: OutcomeProceed, Token: token}
+	if req.keyExpired && m.config.ExpiredKeys == ExpiredKeyWarn {
+		outcome.Headers = map[string]string{ExpiredKeyHeaderName: "true"}
+	}
+	return outcome
+}
@@ -0,0 +1,223 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManager_Begin(t *testing.T) {
+	ctx := context.Background()
+	store := newListingStorage()
+	m, _ := NewManager(Config{Storage: store})
+
+	newReq := func(key string) *Request {
+		return &Request{Method: "POST", Path: "/orders", IdempotencyKey: key, Body: []byte(`{"id":1}`)}
+	}
+
+	t.Run("ProceedAndReplay", func(t *testing.T) {
+		outcome, err := m.Begin(ctx, newReq("k1"))
+		if err != nil || outcome.Kind != OutcomeProceed || outcome.Token == nil {
+			t.Fatalf("expected proceed, got %v, %v", outcome.Kind, err)
+		}
+		if outcome.Token.Key() != "k1" {
+			t.Errorf("expected token key k1, got %q", outcome.Token.Key())
+		}
+
+		// Duplicate while in progress
+		dup, err := m.Begin(ctx, newReq("k1"))
+		if err != nil || dup.Kind != OutcomeConflict {
+			t.Fatalf("expected conflict, got %v, %v", dup.Kind, err)
+		}
+
+		if err := outcome.Token.Complete(&Response{StatusCode: 201, Body: []byte("created")}); err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+
+		replay, err := m.Begin(ctx, newReq("k1"))
+		if err != nil || replay.Kind != OutcomeReplay || string(replay.Response.Body) != "created" {
+			t.Fatalf("expected replay, got %v, %v", replay.Kind, err)
+		}
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		outcome, _ := m.Begin(ctx, newReq("k2"))
+		if err := outcome.Token.Fail(errors.New("db down")); err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+		if r := store.records["k2"]; r.Status != StatusFailed || r.Error != "db down" {
+			t.Fatalf("expected failed record with cause, got %+v", r)
+		}
+
+		retry, err := m.Begin(ctx, newReq("k2"))
+		if err != nil || retry.Kind != OutcomeProceed {
+			t.Fatalf("expected failed request to proceed again, got %v, %v", retry.Kind, err)
+		}
+	})
+
+	t.Run("CompleteServerError", func(t *testing.T) {
+		outcome, _ := m.Begin(ctx, newReq("k3"))
+		if err := outcome.Token.Complete(&Response{StatusCode: 500}); err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+		if r := store.records["k3"]; r.Status != StatusFailed {
+			t.Fatalf("expected 5xx to fail the record, got %s", r.Status)
+		}
+	})
+
+	t.Run("NoKey", func(t *testing.T) {
+		outcome, err := m.Begin(ctx, newReq(""))
+		if err != nil || outcome.Kind != OutcomeProceed {
+			t.Fatalf("expected proceed without key, got %v, %v", outcome.Kind, err)
+		}
+		if err := outcome.Token.Complete(&Response{StatusCode: 200}); err != nil {
+			t.Fatalf("expected no-op Complete, got %v", err)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		req := newReq("k1")
+		req.Body = []byte(`{"id":2}`)
+		if _, err := m.Begin(ctx, req); !errors.Is(err, ErrRequestMismatch) {
+			t.Fatalf("expected ErrRequestMismatch, got %v", err)
+		}
+	})
+}
+
+func TestToken_Record(t *testing.T) {
+	ctx := context.Background()
+	store := newListingStorage()
+	m, _ := NewManager(Config{Storage: store})
+
+	req := &Request{Method: "POST", Path: "/orders", IdempotencyKey: "tx", Body: []byte(`{"id":1}`)}
+	outcome, err := m.Begin(ctx, req)
+	if err != nil || outcome.Kind != OutcomeProceed {
+		t.Fatalf("expected proceed, got %v, %v", outcome.Kind, err)
+	}
+
+	resp := &Response{StatusCode: 201, Body: []byte("created")}
+	record := outcome.Token.Record(resp)
+	pending := store.records["tx"]
+	if record.Status != StatusCompleted || string(record.Response.Body) != "created" {
+		t.Errorf("expected completed record with the response, got %+v", record)
+	}
+	if record.RequestHash == "" || record.RequestHash != pending.RequestHash {
+		t.Errorf("expected request hash %q, got %q", pending.RequestHash, record.RequestHash)
+	}
+	if ttl := time.Until(record.ExpiresAt); ttl < 23*time.Hour || ttl > 24*time.Hour {
+		t.Errorf("expected record to expire after the default TTL, expires in %v", ttl)
+	}
+	if pending.Status != StatusPending {
+		t.Error("expected Record not to store anything")
+	}
+
+	// Written in the domain transaction, then completed to release the lock
+	store.records["tx"] = record
+	if err := outcome.Token.Complete(resp); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if cached, err := m.Check(ctx, &Request{Method: "POST", Path: "/orders", IdempotencyKey: "tx", Body: []byte(`{"id":1}`)}); err != nil || cached == nil {
+		t.Errorf("expected replay after completion, got %v, %v", cached, err)
+	}
+
+	if (&Token{manager: m, req: &Request{}}).Record(resp) != nil {
+		t.Error("expected nil record without idempotency key")
+	}
+}
+
+// getOrLockStorage is a MockStorage implementing GetOrLocker with canned results
+type getOrLockStorage struct {
+	MockStorage
+	existing *Record
+	locked   bool
+	calls    int
+}
+
+func (s *getOrLockStorage) GetOrLock(ctx context.Context, key string, r *Record, ttl, lockTTL time.Duration) (*Record, bool, error) {
+	s.calls++
+	return s.existing, s.locked, nil
+}
+
+func TestManager_Begin_GetOrLocker(t *testing.T) {
+	ctx := context.Background()
+	store := &getOrLockStorage{}
+	store.GetFunc = func(ctx context.Context, key string) (*Record, error) {
+		return nil, errors.New("Get must not be called")
+	}
+	var tryLocks int
+	store.TryLockFunc = func(ctx context.Context, k string, t time.Duration) (bool, error) {
+		tryLocks++
+		return true, nil
+	}
+	m, _ := NewManager(Config{Storage: store})
+
+	tests := []struct {
+		name     string
+		existing *Record
+		locked   bool
+		want     OutcomeKind
+		tryLocks int
+	}{
+		{"Locked", nil, true, OutcomeProceed, 0},
+		{"LockHeld", nil, false, OutcomeConflict, 0},
+		{"Pending", &Record{Status: StatusPending}, false, OutcomeConflict, 0},
+		{"Completed", &Record{Status: StatusCompleted, Response: &CachedResponse{StatusCode: 201}}, false, OutcomeReplay, 0},
+		{"Failed", &Record{Status: StatusFailed}, false, OutcomeProceed, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.existing, store.locked, store.calls, tryLocks = tt.existing, tt.locked, 0, 0
+
+			outcome, err := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: "k"})
+			if err != nil || outcome.Kind != tt.want {
+				t.Fatalf("expected %v, got %v, %v", tt.want, outcome.Kind, err)
+			}
+			if store.calls != 1 || tryLocks != tt.tryLocks {
+				t.Errorf("expected 1 GetOrLock and %d TryLock, got %d and %d", tt.tryLocks, store.calls, tryLocks)
+			}
+		})
+	}
+}
+
+func TestToken_Complete_FailureTTL(t *testing.T) {
+	storage := newListingStorage()
+	m, _ := NewManager(Config{
+		Storage:              storage,
+		FailureTTL:           50 * time.Millisecond,
+		RetryableStatusCodes: []int{429, 503},
+	})
+	ctx := context.Background()
+	begin := func(key string) Outcome {
+		t.Helper()
+		outcome, err := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: key})
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		return outcome
+	}
+
+	t.Run("NotRetryableReplayed", func(t *testing.T) {
+		_ = begin("k1").Token.Complete(&Response{StatusCode: 500, Body: []byte("boom")})
+		if outcome := begin("k1"); outcome.Kind != OutcomeReplay || outcome.Response.StatusCode != 500 {
+			t.Fatalf("expected a status code not retryable to be replayed, got %v", outcome.Kind)
+		}
+	})
+
+	t.Run("RetryableAfterCooldown", func(t *testing.T) {
+		_ = begin("k2").Token.Complete(&Response{StatusCode: 503})
+		if record := storage.records["k2"]; record.Status != StatusFailed {
+			t.Fatalf("expected a failed record, got %s", record.Status)
+		}
+
+		outcome := begin("k2")
+		if outcome.Kind != OutcomeReplay || outcome.Response.StatusCode != 503 {
+			t.Fatalf("expected the failure to be replayed during the cooldown, got %v", outcome.Kind)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		if outcome := begin("k2"); outcome.Kind != OutcomeProceed {
+			t.Fatalf("expected a retry after the cooldown, got %v", outcome.Kind)
+		}
+	})
+}
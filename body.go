@@ -0,0 +1,241 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// BodyOverflow is the behavior when a response body exceeds Config.MaxCachedBodySize
+type BodyOverflow int
+
+const (
+	// BodyOverflowSkip does not cache the response: the record is failed so retries are
+	// processed again
+	BodyOverflowSkip BodyOverflow = iota
+
+	// BodyOverflowTruncate caches the first MaxCachedBodySize bytes of the body, replayed
+	// with CachedResponse.Truncated set
+	BodyOverflowTruncate
+
+	// BodyOverflowBlob streams the body to Config.BlobStore, the record only references
+	// it with CachedResponse.BlobKey
+	BodyOverflowBlob
+)
+
+// String returns the name of the policy
+func (o BodyOverflow) String() string {
+	switch o {
+	case BodyOverflowSkip:
+		return "skip"
+	case BodyOverflowTruncate:
+		return "truncate"
+	case BodyOverflowBlob:
+		return "blob"
+	default:
+		return fmt.Sprintf("BodyOverflow(%d)", int(o))
+	}
+}
+
+// StreamingPolicy is the behavior for streaming responses: server-sent events
+// (Content-Type text/event-stream), flushed chunked responses and hijacked connections
+type StreamingPolicy int
+
+const (
+	// StreamingPassthrough passes streaming responses through untouched: they are not
+	// buffered nor cached, and the record is failed so retries are processed again
+	StreamingPassthrough StreamingPolicy = iota
+
+	// StreamingCachePrefix caches the first MaxCachedBodySize bytes of streaming
+	// responses, replayed with CachedResponse.Truncated set when the stream was longer
+	StreamingCachePrefix
+)
+
+// String returns the name of the policy
+func (p StreamingPolicy) String() string {
+	switch p {
+	case StreamingPassthrough:
+		return "passthrough"
+	case StreamingCachePrefix:
+		return "cache-prefix"
+	default:
+		return fmt.Sprintf("StreamingPolicy(%d)", int(p))
+	}
+}
+
+// IsStreamingResponse reports whether a response with the given headers is a stream of
+// server-sent events
+func IsStreamingResponse(headers http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(headers.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// BlobStore stores response bodies too large to be cached with their record, with
+// BodyOverflowBlob. Blobs are named after the idempotency key and overwritten when the
+// key is processed again; they should expire after Config.TTL on the blob store side.
+// Records with a blob body are therefore kept at most Config.TTL, whatever the TTL of
+// their response, and are not refreshed by Config.RefreshTTLOnHit.
+type BlobStore interface {
+	// Create returns a writer storing the body of key, complete once closed
+	Create(ctx context.Context, key string) (io.WriteCloser, error)
+
+	// Open returns a reader of the body of key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// BodyCapture receives the response body while the handler writes it, buffering at
+// most Config.MaxCachedBodySize bytes: beyond, the rest is discarded or, with
+// BodyOverflowBlob, streamed to Config.BlobStore. Writes never fail, so capturing
+// cannot break the response sent to the client. Get one with Token.BodyCapture.
+type BodyCapture struct {
+	ctx       context.Context
+	manager   *Manager
+	key       string
+	buf       bytes.Buffer
+	size      int
+	blob      io.WriteCloser
+	err       error
+	streaming bool
+	discarded bool
+}
+
+// Write captures p
+func (c *BodyCapture) Write(p []byte) (int, error) {
+	c.size += len(p)
+	limit := c.manager.config.MaxCachedBodySize
+	switch {
+	case c.discarded || c.streaming && c.manager.config.Streaming == StreamingPassthrough:
+		// Not cached
+	case c.streaming:
+		c.writePrefix(p, limit)
+	case limit <= 0 || c.size <= limit && c.blob == nil:
+		c.buf.Write(p)
+	case c.manager.config.BodyOverflow == BodyOverflowBlob:
+		c.writeBlob(p)
+	default:
+		c.writePrefix(p, limit)
+	}
+	return len(p), nil
+}
+
+// writePrefix buffers p up to limit bytes in total
+func (c *BodyCapture) writePrefix(p []byte, limit int) {
+	if room := limit - c.buf.Len(); room > 0 {
+		c.buf.Write(p[:min(room, len(p))])
+	}
+}
+
+// Stream marks the response as streaming, once flushed or detected as server-sent
+// events (see IsStreamingResponse), to be handled according to Config.Streaming
+func (c *BodyCapture) Stream() {
+	if c.streaming {
+		return
+	}
+	c.streaming = true
+	if c.manager.config.Streaming == StreamingPassthrough {
+		c.buf = bytes.Buffer{}
+	}
+}
+
+// Discard marks the response as not cacheable, e.g. once its connection is hijacked
+func (c *BodyCapture) Discard() {
+	c.discarded = true
+	c.buf = bytes.Buffer{}
+}
+
+// writeBlob streams p to the blob store, after the bytes buffered so far
+func (c *BodyCapture) writeBlob(p []byte) {
+	if c.blob == nil && c.err == nil {
+		c.blob, c.err = c.manager.config.BlobStore.Create(c.ctx, c.key)
+		if c.err == nil {
+			_, c.err = c.blob.Write(c.buf.Bytes())
+			c.buf = bytes.Buffer{}
+		}
+	}
+	if c.err == nil {
+		_, c.err = c.blob.Write(p)
+	}
+}
+
+// Bytes returns the buffered body
+func (c *BodyCapture) Bytes() []byte {
+	return c.buf.Bytes()
+}
+
+// Size returns the size in bytes of the whole body written so far
+func (c *BodyCapture) Size() int {
+	return c.size
+}
+
+// Overflowed reports whether the body exceeds Config.MaxCachedBodySize
+func (c *BodyCapture) Overflowed() bool {
+	limit := c.manager.config.MaxCachedBodySize
+	return limit > 0 && c.size > limit
+}
+
+// limitBody applies Config.MaxCachedBodySize and Config.Streaming to resp, whose body
+// was written to capture when not nil. It returns the response to cache, or nil and the
+// reason it must not be cached.
+func (m *Manager) limitBody(ctx context.Context, key string, resp *Response, capture *BodyCapture) (*Response, string, error) {
+	if capture == nil {
+		if limit := m.config.MaxCachedBodySize; limit <= 0 || len(resp.Body) <= limit {
+			return resp, "", nil
+		}
+		capture = &BodyCapture{ctx: ctx, manager: m, key: key}
+		_, _ = capture.Write(resp.Body)
+	}
+	if capture.blob != nil {
+		if err := capture.blob.Close(); err != nil && capture.err == nil {
+			capture.err = err
+		}
+	}
+
+	limited := *resp
+	limited.Body = capture.Bytes()
+	switch {
+	case capture.discarded:
+		return nil, "connection hijacked", nil
+	case capture.streaming && m.config.Streaming == StreamingPassthrough:
+		return nil, "streaming response", nil
+	case capture.streaming:
+		limited.Truncated = capture.Overflowed()
+		return &limited, "", nil
+	case !capture.Overflowed():
+		return &limited, "", nil
+	}
+
+	switch m.config.BodyOverflow {
+	case BodyOverflowTruncate:
+		limited.Truncated = true
+		return &limited, "", nil
+	case BodyOverflowBlob:
+		if capture.err != nil {
+			return nil, "", fmt.Errorf("failed to store response body in blob store: %w", capture.err)
+		}
+		limited.Body = nil
+		limited.BlobKey = key
+		return &limited, "", nil
+	default:
+		return nil, "response body exceeds MaxCachedBodySize", nil
+	}
+}
+
+// loadBlob returns a copy of resp with the body read back from Config.BlobStore
+func (m *Manager) loadBlob(ctx context.Context, resp *CachedResponse) (*CachedResponse, error) {
+	r, err := m.config.BlobStore.Open(ctx, resp.BlobKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open response body in blob store: %w", err)
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body from blob store: %w", err)
+	}
+	loaded := *resp
+	loaded.Body = body
+	return &loaded, nil
+}
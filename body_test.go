@@ -0,0 +1,171 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// memoryBlobStore is an in-memory BlobStore
+type memoryBlobStore struct {
+	blobs map[string][]byte
+}
+
+type blobWriter struct {
+	bytes.Buffer
+	store *memoryBlobStore
+	key   string
+}
+
+func (w *blobWriter) Close() error {
+	w.store.blobs[w.key] = w.Bytes()
+	return nil
+}
+
+func (s *memoryBlobStore) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	return &blobWriter{store: s, key: key}, nil
+}
+
+func (s *memoryBlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	blob, ok := s.blobs[key]
+	if !ok {
+		return nil, errors.New("blob not found")
+	}
+	return io.NopCloser(bytes.NewReader(blob)), nil
+}
+
+func TestManager_MaxCachedBodySize(t *testing.T) {
+	ctx := context.Background()
+	body := []byte("0123456789")
+
+	// complete processes a request writing body in two chunks through the body capture
+	complete := func(t *testing.T, m *Manager, key string) Outcome {
+		outcome, err := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: key})
+		if err != nil || outcome.Kind != OutcomeProceed {
+			t.Fatalf("expected to proceed, got %v, %v", outcome.Kind, err)
+		}
+		capture := outcome.Token.BodyCapture()
+		_, _ = capture.Write(body[:6])
+		_, _ = capture.Write(body[6:])
+		if err := outcome.Token.Complete(&Response{StatusCode: 200}); err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+		replay, err := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: key})
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		return replay
+	}
+
+	t.Run("WithinLimit", func(t *testing.T) {
+		m, _ := NewManager(Config{Storage: newListingStorage(), MaxCachedBodySize: 10})
+		replay := complete(t, m, "k1")
+		if replay.Kind != OutcomeReplay || !bytes.Equal(replay.Response.Body, body) {
+			t.Errorf("expected the whole body to be replayed, got %v", replay.Kind)
+		}
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		store := newListingStorage()
+		m, _ := NewManager(Config{Storage: store, MaxCachedBodySize: 4})
+		if replay := complete(t, m, "k1"); replay.Kind != OutcomeProceed {
+			t.Errorf("expected the request to be processed again, got %v", replay.Kind)
+		}
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		m, _ := NewManager(Config{Storage: newListingStorage(), MaxCachedBodySize: 4, BodyOverflow: BodyOverflowTruncate})
+		replay := complete(t, m, "k1")
+		if replay.Kind != OutcomeReplay || string(replay.Response.Body) != "0123" || !replay.Response.Truncated {
+			t.Errorf("expected a truncated replay, got %+v", replay.Response)
+		}
+	})
+
+	t.Run("Blob", func(t *testing.T) {
+		store := newListingStorage()
+		blobs := &memoryBlobStore{blobs: make(map[string][]byte)}
+		m, _ := NewManager(Config{Storage: store, MaxCachedBodySize: 4, BodyOverflow: BodyOverflowBlob, BlobStore: blobs})
+		replay := complete(t, m, "k1")
+		if replay.Kind != OutcomeReplay || !bytes.Equal(replay.Response.Body, body) {
+			t.Errorf("expected the body to be replayed from the blob store, got %+v", replay.Response)
+		}
+		if stored := store.records["k1"].Response; len(stored.Body) != 0 || stored.BlobKey != "k1" {
+			t.Errorf("expected the record to reference the blob only, got %+v", stored)
+		}
+	})
+
+	t.Run("BlobRetention", func(t *testing.T) {
+		store := newListingStorage()
+		clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		m, _ := NewManager(Config{
+			Storage: store, Clock: clock, TTL: time.Hour, TTLHeader: "X-Idempotency-TTL", RefreshTTLOnHit: true,
+			MaxCachedBodySize: 4, BodyOverflow: BodyOverflowBlob, BlobStore: &memoryBlobStore{blobs: make(map[string][]byte)},
+		})
+		outcome, _ := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: "k1"})
+		_, _ = outcome.Token.BodyCapture().Write(body)
+		resp := &Response{StatusCode: 200, Headers: http.Header{"X-Idempotency-Ttl": {"72h"}}}
+		if err := outcome.Token.Complete(resp); err != nil {
+			t.Fatalf("Complete failed: %v", err)
+		}
+		expiry := clock.Now().Add(time.Hour)
+		if got := store.records["k1"].ExpiresAt; !got.Equal(expiry) {
+			t.Errorf("expected the record not to outlive its blob (%s), got %s", expiry, got)
+		}
+
+		clock.Advance(50 * time.Minute)
+		if replay, _ := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: "k1"}); replay.Kind != OutcomeReplay {
+			t.Fatalf("expected a replay, got %v", replay.Kind)
+		}
+		if got := store.records["k1"].ExpiresAt; !got.Equal(expiry) {
+			t.Errorf("expected a record with a blob body not to be refreshed, got %s", got)
+		}
+	})
+
+	t.Run("Streaming", func(t *testing.T) {
+		// stream processes a request sending server-sent events through a ResponseRecorder
+		stream := func(t *testing.T, m *Manager) Outcome {
+			outcome, _ := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: "k1"})
+			w := httptest.NewRecorder()
+			recorder := RecordResponseBody(w, outcome.Token.BodyCapture())
+			recorder.Header().Set("Content-Type", "text/event-stream")
+			_, _ = recorder.Write([]byte("data: 1\n\n"))
+			http.NewResponseController(recorder).Flush()
+			if !w.Flushed || w.Body.String() != "data: 1\n\n" {
+				t.Fatalf("expected the event to be flushed to the client, got %q", w.Body.String())
+			}
+			if err := outcome.Token.Complete(recorder.Response()); err != nil {
+				t.Fatalf("Complete failed: %v", err)
+			}
+			replay, _ := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: "k1"})
+			return replay
+		}
+
+		m, _ := NewManager(Config{Storage: newListingStorage()})
+		if replay := stream(t, m); replay.Kind != OutcomeProceed {
+			t.Errorf("expected streaming responses not to be cached, got %v", replay.Kind)
+		}
+
+		m, _ = NewManager(Config{Storage: newListingStorage(), Streaming: StreamingCachePrefix, MaxCachedBodySize: 4})
+		replay := stream(t, m)
+		if replay.Kind != OutcomeReplay || string(replay.Response.Body) != "data" || !replay.Response.Truncated {
+			t.Errorf("expected the prefix of the stream to be replayed, got %v %+v", replay.Kind, replay.Response)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		if _, err := NewManager(Config{Storage: &MockStorage{}, MaxCachedBodySize: -1}); !errors.Is(err, ErrInvalidConfiguration) {
+			t.Errorf("expected a negative size to be rejected, got %v", err)
+		}
+		if _, err := NewManager(Config{Storage: &MockStorage{}, BodyOverflow: BodyOverflowBlob}); !errors.Is(err, ErrInvalidConfiguration) {
+			t.Errorf("expected BodyOverflowBlob without BlobStore to be rejected, got %v", err)
+		}
+		if _, err := NewManager(Config{Storage: &MockStorage{}, Streaming: StreamingCachePrefix}); !errors.Is(err, ErrInvalidConfiguration) {
+			t.Errorf("expected StreamingCachePrefix without MaxCachedBodySize to be rejected, got %v", err)
+		}
+	})
+}
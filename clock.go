@@ -0,0 +1,63 @@
+package idempotency
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time used for expirations: record TTLs, lock timeouts and stale
+// records. Latencies reported to metrics and traces are always measured in real time.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+}
+
+// SystemClock is the real time. It is the default clock of the manager and storages.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ClockSetter is an optional Storage extension implemented by storages computing
+// expirations themselves (memory, SQL, GORM...), used by NewManager to apply
+// Config.Clock. Storages relying on server-side expiration (Redis) ignore the clock.
+type ClockSetter interface {
+	// SetClock replaces the clock of the storage. It must be called before the storage is used.
+	SetClock(clock Clock)
+}
+
+// FakeClock is a Clock only moving when told to, for deterministic tests of TTLs and
+// lock timeouts. It is safe for concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time of the clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to now
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
@@ -0,0 +1,58 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clockStorage is a listingStorage recording the clock applied by NewManager
+type clockStorage struct {
+	*listingStorage
+	clock Clock
+}
+
+func (s *clockStorage) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+func TestManager_Clock(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	store := &clockStorage{listingStorage: newListingStorage()}
+
+	m, err := NewManager(Config{Storage: store, Clock: clock, TTL: time.Hour, LockTimeout: time.Minute})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	if store.clock != clock {
+		t.Error("expected the clock to be applied to the storage")
+	}
+
+	store.records["k1"] = &Record{Key: "k1", Status: StatusPending, CreatedAt: start, ExpiresAt: start.Add(time.Hour)}
+	if stale, _ := m.ListStale(ctx); len(stale) != 0 {
+		t.Errorf("expected no stale record before the lock timeout, got %d", len(stale))
+	}
+	clock.Advance(2 * time.Minute)
+	if stale, _ := m.ListStale(ctx); len(stale) != 1 {
+		t.Errorf("expected the record to be stale once the clock passed its lock timeout, got %d", len(stale))
+	}
+
+	err = m.Store(ctx, "k1", &Response{StatusCode: 200})
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	record := store.records["k1"]
+	if !record.Response.CompletedAt.Equal(start.Add(2*time.Minute)) || !record.ExpiresAt.Equal(start.Add(2*time.Minute+time.Hour)) {
+		t.Errorf("expected times from the clock, got completed at %v, expiring at %v", record.Response.CompletedAt, record.ExpiresAt)
+	}
+
+	t.Run("SystemClockNotApplied", func(t *testing.T) {
+		store := &clockStorage{listingStorage: newListingStorage()}
+		_, _ = NewManager(Config{Storage: store})
+		if store.clock != nil {
+			t.Error("expected the default clock to leave the storage clock alone")
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+	idempotencyredis "github.com/fco-gt/gopotency/storage/redis"
+	idempotencysql "github.com/fco-gt/gopotency/storage/sql"
+	"github.com/fco-gt/gopotency/storage/sqlite"
+	"github.com/redis/go-redis/v9"
+)
+
+// connection holds the flags selecting the storage backend
+type connection struct {
+	backend   string
+	dsn       string
+	keyPrefix string
+	driver    string
+	table     string
+}
+
+func (c *connection) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.backend, "backend", "redis", "storage backend: redis, sql or sqlite (there is no MongoDB storage)")
+	fs.StringVar(&c.dsn, "dsn", "redis://localhost:6379/0", "redis URL, SQL data source name or SQLite file")
+	fs.StringVar(&c.keyPrefix, "key-prefix", "", "redis KeyPrefix of the application")
+	fs.StringVar(&c.driver, "driver", "sqlite", "database/sql driver of the sql backend: only sqlite is built in")
+	fs.StringVar(&c.table, "table", "", "records table of the sql backend (default idempotency_records)")
+}
+
+// open connects to the storage backend
+func (c *connection) open(ctx context.Context) (idempotency.Storage, error) {
+	switch c.backend {
+	case "redis":
+		opts, err := redis.ParseURL(c.dsn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis URL: %w", err)
+		}
+		return idempotencyredis.NewRedisStorageWithOptions(ctx, idempotencyredis.Options{
+			Addr:      opts.Addr,
+			Username:  opts.Username,
+			Password:  opts.Password,
+			DB:        opts.DB,
+			TLSConfig: opts.TLSConfig,
+			KeyPrefix: c.keyPrefix,
+		})
+	case "sql":
+		if !slices.Contains(sql.Drivers(), c.driver) {
+			return nil, fmt.Errorf("database/sql driver %q is not built in (available: %s): add a blank import of it to cmd/gopotency", c.driver, strings.Join(sql.Drivers(), ", "))
+		}
+		db, err := sql.Open(c.driver, c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.PingContext(ctx); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to %s: %w", c.driver, err)
+		}
+		return idempotencysql.NewSQLStorageWithOptions(db, idempotencysql.Options{TableName: c.table}), nil
+	case "sqlite":
+		return sqlite.Open(c.dsn, sqlite.Options{})
+	case "mongo", "mongodb":
+		return nil, fmt.Errorf("backend %q is not supported: the library has no MongoDB storage", c.backend)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", c.backend)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// filter holds the flags selecting records
+type filter struct {
+	status    string
+	match     string
+	olderThan time.Duration
+	limit     int
+}
+
+func (f *filter) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.status, "status", "", "keep the records with this status: pending, completed or failed")
+	fs.StringVar(&f.match, "match", "", "keep the records whose key starts with this prefix")
+	fs.DurationVar(&f.olderThan, "older-than", 0, "keep the records created more than this duration ago")
+}
+
+func (f *filter) recordFilter() idempotency.RecordFilter {
+	rf := idempotency.RecordFilter{
+		Status:    idempotency.RecordStatus(f.status),
+		KeyPrefix: f.match,
+		Limit:     f.limit,
+	}
+	if f.olderThan > 0 {
+		rf.CreatedBefore = time.Now().Add(-f.olderThan)
+	}
+	return rf
+}
+
+func listCommand(c *cli, fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	var f filter
+	f.register(fs)
+	fs.IntVar(&f.limit, "limit", 100, "maximum number of records listed, 0 for all")
+
+	return func(ctx context.Context, args []string) error {
+		records, err := c.manager.ListRecords(ctx, f.recordFilter())
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tSTATUS\tCODE\tCREATED\tEXPIRES")
+		for _, record := range records {
+			code := "-"
+			if record.Response != nil {
+				code = fmt.Sprint(record.Response.StatusCode)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", record.Key, record.Status, code,
+				record.CreatedAt.Format(time.RFC3339), record.ExpiresAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+}
+
+func showCommand(c *cli, fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	withBody := fs.Bool("body", false, "print the cached response body")
+
+	return func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			return errors.New("show takes a single key")
+		}
+		record, err := c.manager.GetRecord(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return fmt.Errorf("%w: %s", idempotency.ErrKeyNotFound, args[0])
+		}
+
+		body := []byte(nil)
+		if record.Response != nil {
+			body = record.Response.Body
+			response := *record.Response
+			response.Body = nil
+			record.Response = &response
+		}
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+		if *withBody && len(body) > 0 {
+			_, err = fmt.Fprintf(c.stdout, "\n%s\n", body)
+		}
+		return err
+	}
+}
+
+func deleteCommand(c *cli, fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		if len(args) == 0 {
+			return errors.New("delete takes at least one key")
+		}
+		for _, key := range args {
+			if err := c.manager.Purge(ctx, key); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			fmt.Fprintln(c.stdout, "deleted", key)
+		}
+		return nil
+	}
+}
+
+func expireCommand(c *cli, fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	var f filter
+	f.register(fs)
+	dryRun := fs.Bool("dry-run", false, "print the matching keys without deleting them")
+
+	return func(ctx context.Context, args []string) error {
+		rf := f.recordFilter()
+		if rf == (idempotency.RecordFilter{}) {
+			return errors.New("expire needs a -status, -match or -older-than filter")
+		}
+		records, err := c.manager.ListRecords(ctx, rf)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			if !*dryRun {
+				if err := c.manager.Purge(ctx, record.Key); err != nil {
+					return fmt.Errorf("%s: %w", record.Key, err)
+				}
+			}
+			fmt.Fprintln(c.stdout, record.Key)
+		}
+		verb := "expired"
+		if *dryRun {
+			verb = "would expire"
+		}
+		fmt.Fprintf(c.stdout, "%s %d records\n", verb, len(records))
+		return nil
+	}
+}
+
+func dumpCommand(c *cli, fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	var f filter
+	f.register(fs)
+
+	return func(ctx context.Context, args []string) error {
+		records, err := c.manager.ListRecords(ctx, f.recordFilter())
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			data, err := idempotency.JSONCodec.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("%s: %w", record.Key, err)
+			}
+			if _, err := fmt.Fprintf(c.stdout, "%s\n", data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func restoreCommand(c *cli, fs *flag.FlagSet) func(ctx context.Context, args []string) error {
+	overwrite := fs.Bool("overwrite", false, "replace the records already stored")
+
+	return func(ctx context.Context, args []string) error {
+		scanner := bufio.NewScanner(c.stdin)
+		scanner.Buffer(nil, 64<<20)
+		restored, skipped := 0, 0
+		for line := 1; scanner.Scan(); line++ {
+			data := strings.TrimSpace(scanner.Text())
+			if data == "" {
+				continue
+			}
+			record, err := idempotency.JSONCodec.Unmarshal([]byte(data))
+			if err != nil || record.Key == "" {
+				return fmt.Errorf("line %d: invalid record: %v", line, err)
+			}
+
+			ttl := time.Until(record.ExpiresAt)
+			if record.ExpiresAt.IsZero() || ttl <= 0 {
+				skipped++
+				continue
+			}
+			if !*overwrite {
+				exists, err := c.storage.Exists(ctx, record.Key)
+				if err != nil {
+					return fmt.Errorf("%s: %w", record.Key, err)
+				}
+				if exists {
+					skipped++
+					continue
+				}
+			}
+			if err := c.storage.Set(ctx, record, ttl); err != nil {
+				return fmt.Errorf("%s: %w", record.Key, err)
+			}
+			restored++
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "restored %d records, skipped %d\n", restored, skipped)
+		return nil
+	}
+}
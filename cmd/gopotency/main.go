@@ -0,0 +1,114 @@
+// Command gopotency inspects and cleans up the idempotency records of a Redis, SQL or
+// SQLite storage, through the storage implementations of the library rather than raw
+// keys, so records are never corrupted by hand edits:
+//
+//	gopotency list    [-status s] [-match prefix] [-older-than d] [-limit n]
+//	gopotency show    [-body] KEY
+//	gopotency delete  KEY...
+//	gopotency expire  [-status s] [-match prefix] [-older-than d] [-dry-run]
+//	gopotency dump    [-status s] [-match prefix] > records.jsonl
+//	gopotency restore [-overwrite] < records.jsonl
+//
+// Every command takes the connection flags -backend (redis, sql or sqlite), -dsn,
+// -key-prefix (Redis) and -driver and -table (SQL). Only the pure Go "sqlite"
+// database/sql driver is built in: build the command with a blank import of another
+// driver (pgx, mysql...) to reach Postgres or MySQL with the sql backend. MongoDB is
+// not supported, as the library has no MongoDB storage.
+//
+// Records are dumped as JSON lines, one record per line, and restored with their
+// remaining retention; expired records are skipped.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+const usage = `usage: gopotency <command> [flags]
+
+commands:
+  list     list the records matching a filter, most recent first
+  show     print a record as JSON
+  delete   delete records and their locks
+  expire   delete the records matching a filter
+  dump     write the records matching a filter as JSON lines
+  restore  read records written by dump
+
+backends (-backend): redis, sql and sqlite. The sql backend only has the sqlite
+driver built in; MongoDB is not supported.
+
+run "gopotency <command> -h" for the flags of a command`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "gopotency:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// cli is the state shared by the commands
+type cli struct {
+	manager *idempotency.Manager
+	storage idempotency.Storage
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+// command registers its flags on fs and returns the function running it with the
+// remaining arguments, once the storage is open
+type command func(c *cli, fs *flag.FlagSet) func(ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"list":    listCommand,
+	"show":    showCommand,
+	"delete":  deleteCommand,
+	"expire":  expireCommand,
+	"dump":    dumpCommand,
+	"restore": restoreCommand,
+}
+
+// run executes the command given by args
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprintln(os.Stderr, usage)
+		if len(args) == 0 {
+			return flag.ErrHelp
+		}
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	c := &cli{stdin: stdin, stdout: stdout}
+	fs := flag.NewFlagSet("gopotency "+args[0], flag.ContinueOnError)
+	var conn connection
+	conn.register(fs)
+	exec := commands[args[0]](c, fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	store, err := conn.open(ctx)
+	if err != nil {
+		return err
+	}
+	manager, err := idempotency.NewManager(idempotency.Config{Storage: store})
+	if err != nil {
+		store.Close()
+		return err
+	}
+	defer manager.Close()
+
+	c.manager, c.storage = manager, store
+	return exec(ctx, fs.Args())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	idempotency "github.com/fco-gt/gopotency"
+	idempotencyredis "github.com/fco-gt/gopotency/storage/redis"
+)
+
+func TestCommands(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	conn := []string{"-dsn", "redis://" + server.Addr(), "-key-prefix", "idem:"}
+
+	store, err := idempotencyredis.NewRedisStorageWithOptions(ctx, idempotencyredis.Options{Addr: server.Addr(), KeyPrefix: "idem:"})
+	if err != nil {
+		t.Fatalf("NewRedisStorageWithOptions failed: %v", err)
+	}
+	defer store.Close()
+	now := time.Now()
+	for _, record := range []*idempotency.Record{
+		{Key: "order-1", Status: idempotency.StatusCompleted, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour),
+			Response: &idempotency.CachedResponse{StatusCode: 201, Body: []byte("created")}},
+		{Key: "order-2", Status: idempotency.StatusPending, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+	} {
+		_ = store.Set(ctx, record, time.Hour)
+	}
+
+	exec := func(args []string, stdin string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if err := run(ctx, append(args[:1:1], append(conn, args[1:]...)...), strings.NewReader(stdin), &out); err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+		return out.String()
+	}
+
+	if out := exec([]string{"list", "-status", "pending"}, ""); !strings.Contains(out, "order-2") || strings.Contains(out, "order-1") {
+		t.Errorf("expected the pending record to be listed, got:\n%s", out)
+	}
+	if out := exec([]string{"show", "-body", "order-1"}, ""); !strings.Contains(out, `"StatusCode": 201`) || !strings.Contains(out, "created") {
+		t.Errorf("expected the record and its body, got:\n%s", out)
+	}
+
+	dump := exec([]string{"dump"}, "")
+	if lines := strings.Count(dump, "\n"); lines != 2 {
+		t.Fatalf("expected 2 dumped records, got %d:\n%s", lines, dump)
+	}
+
+	if out := exec([]string{"expire", "-older-than", "1h", "-dry-run"}, ""); !strings.Contains(out, "would expire 1 records") {
+		t.Errorf("expected the old record to match, got:\n%s", out)
+	}
+	exec([]string{"expire", "-older-than", "1h"}, "")
+	exec([]string{"delete", "order-2"}, "")
+	if records, _ := store.List(ctx); len(records) != 0 {
+		t.Fatalf("expected every record to be deleted, got %d", len(records))
+	}
+
+	if out := exec([]string{"restore"}, dump); !strings.Contains(out, "restored 2 records") {
+		t.Errorf("expected the dump to be restored, got:\n%s", out)
+	}
+	if record, _ := store.Get(ctx, "order-1"); record == nil || string(record.Response.Body) != "created" {
+		t.Errorf("expected the restored record to be read back, got %+v", record)
+	}
+	if out := exec([]string{"restore"}, dump); !strings.Contains(out, "skipped 2") {
+		t.Errorf("expected existing records to be kept without -overwrite, got:\n%s", out)
+	}
+
+	if err := run(ctx, append([]string{"expire"}, conn...), nil, &bytes.Buffer{}); err == nil {
+		t.Error("expected expire without a filter to be rejected")
+	}
+	for _, backend := range [][]string{{"-backend", "sql", "-driver", "pgx"}, {"-backend", "mongo"}} {
+		if err := run(ctx, append([]string{"list"}, backend...), nil, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "not") {
+			t.Errorf("expected %v to be reported as unsupported, got %v", backend, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package idempotency
+
+import "encoding/json"
+
+// Codec serializes records for storages that store them as bytes, so formats and
+// wrappers (encryption, compression) apply to every such backend at one layer
+type Codec interface {
+	// Marshal encodes record
+	Marshal(record *Record) ([]byte, error)
+
+	// Unmarshal decodes a record encoded by Marshal
+	Unmarshal(data []byte) (*Record, error)
+}
+
+// CodecSetter is an optional Storage extension implemented by storages serializing
+// records (Redis, SQL, GORM...), used by NewManager to apply Config.Codec
+type CodecSetter interface {
+	// SetCodec replaces the codec of the storage. It must be called before the storage is used.
+	SetCodec(codec Codec)
+}
+
+// JSONCodec encodes records as JSON. It is the default codec of all storages.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(record *Record) ([]byte, error) {
+	return json.Marshal(record)
+}
+
+func (jsonCodec) Unmarshal(data []byte) (*Record, error) {
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ValueCodec serializes the results cached by Do, such as encoding/json or a gob or
+// protobuf adapter
+type ValueCodec interface {
+	// Marshal encodes v
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONValueCodec encodes results as JSON. It is the default ValueCodec.
+var JSONValueCodec ValueCodec = jsonValueCodec{}
+
+type jsonValueCodec struct{}
+
+func (jsonValueCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonValueCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
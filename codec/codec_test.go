@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestCodecs(t *testing.T) {
+	now := time.Now().UTC()
+	record := &idempotency.Record{
+		Key:         "order-1",
+		RequestHash: "hash",
+		Status:      idempotency.StatusCompleted,
+		Response: &idempotency.CachedResponse{
+			StatusCode:  201,
+			Headers:     map[string][]string{"Content-Type": {"image/png"}, "Vary": {"Accept", "Origin"}},
+			Body:        bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 1024),
+			ContentType: "image/png",
+			CompletedAt: now,
+			Truncated:   true,
+		},
+		CreatedAt:   now.Add(-time.Second),
+		ExpiresAt:   now.Add(time.Hour),
+		TTL:         time.Hour,
+		LockTimeout: time.Minute,
+		Replays:     3,
+	}
+	plain, _ := idempotency.JSONCodec.Marshal(record)
+
+	for name, c := range map[string]idempotency.Codec{"MsgPack": MsgPack, "Protobuf": Protobuf} {
+		t.Run(name, func(t *testing.T) {
+			data, err := c.Marshal(record)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if len(data) >= len(plain)*4/5 {
+				t.Errorf("expected a smaller encoding than JSON, got %d bytes for %d", len(data), len(plain))
+			}
+
+			got, err := c.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if !got.CreatedAt.Equal(record.CreatedAt) || !got.ExpiresAt.Equal(record.ExpiresAt) || !got.Response.CompletedAt.Equal(now) {
+				t.Errorf("expected times to round-trip, got %v", got)
+			}
+			got.CreatedAt, got.ExpiresAt, got.Response.CompletedAt = record.CreatedAt, record.ExpiresAt, now
+			if !reflect.DeepEqual(got, record) {
+				t.Errorf("expected the record to round-trip, got %+v", got)
+			}
+
+			if _, err := c.Unmarshal([]byte{0xff, 0xff}); err == nil {
+				t.Error("expected malformed data to be rejected")
+			}
+		})
+	}
+
+	t.Run("ProtobufUnknownFields", func(t *testing.T) {
+		data, _ := Protobuf.Marshal(&idempotency.Record{Key: "k1", Status: idempotency.StatusPending})
+		data = protowire.AppendTag(data, 99, protowire.Fixed64Type)
+		data = protowire.AppendFixed64(data, 42)
+		got, err := Protobuf.Unmarshal(data)
+		if err != nil || got.Key != "k1" || got.Response != nil {
+			t.Errorf("expected unknown fields to be skipped, got %+v, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+// Package codec provides record codecs more compact than the default JSON, for the
+// backends storing records as bytes (Redis, SQL, GORM, kv...). JSON encodes response
+// bodies in base64, inflating them by a third; both codecs below store them as raw
+// bytes:
+//
+//   - MsgPack encodes records with MessagePack
+//   - Protobuf encodes records in the protocol buffers wire format of record.proto
+//
+// Set one for every backend with Config.Codec, or for a single storage with SetCodec:
+//
+//	config := idempotency.Config{Storage: store, Codec: codec.Protobuf}
+//
+// Records are not converted when the codec changes: switch codecs on an empty storage,
+// or wait for the records of the previous codec to expire.
+package codec
@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"reflect"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/ugorji/go/codec"
+)
+
+// MsgPack encodes records with MessagePack, bodies as binary strings and times as
+// timestamp extensions
+var MsgPack idempotency.Codec = newMsgPack()
+
+type msgPack struct {
+	handle *codec.MsgpackHandle
+}
+
+func newMsgPack() *msgPack {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.MapType = reflect.TypeOf(map[string]any(nil))
+	return &msgPack{handle: h}
+}
+
+func (c *msgPack) Marshal(record *idempotency.Record) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, c.handle).Encode(record)
+	return data, err
+}
+
+func (c *msgPack) Unmarshal(data []byte) (*idempotency.Record, error) {
+	var record idempotency.Record
+	if err := codec.NewDecoderBytes(data, c.handle).Decode(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
@@ -0,0 +1,250 @@
+package codec
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encodes records in the protocol buffers wire format described by
+// record.proto, readable from other languages. Unknown fields are skipped, so fields
+// added later are ignored by older readers.
+var Protobuf idempotency.Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+// Field numbers of record.proto
+const (
+	recordKey         protowire.Number = 1
+	recordRequestHash protowire.Number = 2
+	recordStatus      protowire.Number = 3
+	recordResponse    protowire.Number = 4
+	recordCreatedAt   protowire.Number = 5
+	recordError       protowire.Number = 6
+	recordExpiresAt   protowire.Number = 7
+	recordTTL         protowire.Number = 8
+	recordLockTimeout protowire.Number = 9
+	recordReplays     protowire.Number = 10
+
+	responseStatusCode  protowire.Number = 1
+	responseHeaders     protowire.Number = 2
+	responseBody        protowire.Number = 3
+	responseContentType protowire.Number = 4
+	responseETag        protowire.Number = 5
+	responseCompletedAt protowire.Number = 6
+	responseRegion      protowire.Number = 7
+	responseTruncated   protowire.Number = 8
+	responseBlobKey     protowire.Number = 9
+
+	headerName   protowire.Number = 1
+	headerValues protowire.Number = 2
+)
+
+var errMalformed = errors.New("codec: malformed protobuf record")
+
+func (protobufCodec) Marshal(record *idempotency.Record) ([]byte, error) {
+	var b []byte
+	b = appendString(b, recordKey, record.Key)
+	b = appendString(b, recordRequestHash, record.RequestHash)
+	b = appendString(b, recordStatus, string(record.Status))
+	if record.Response != nil {
+		b = protowire.AppendTag(b, recordResponse, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalResponse(record.Response))
+	}
+	b = appendTime(b, recordCreatedAt, record.CreatedAt)
+	b = appendString(b, recordError, record.Error)
+	b = appendTime(b, recordExpiresAt, record.ExpiresAt)
+	b = appendInt(b, recordTTL, int64(record.TTL))
+	b = appendInt(b, recordLockTimeout, int64(record.LockTimeout))
+	b = appendInt(b, recordReplays, int64(record.Replays))
+	return b, nil
+}
+
+func marshalResponse(resp *idempotency.CachedResponse) []byte {
+	var b []byte
+	b = appendInt(b, responseStatusCode, int64(resp.StatusCode))
+
+	// Sorted for a deterministic encoding
+	names := make([]string, 0, len(resp.Headers))
+	for name := range resp.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var h []byte
+		h = protowire.AppendTag(h, headerName, protowire.BytesType)
+		h = protowire.AppendString(h, name)
+		for _, value := range resp.Headers[name] {
+			h = protowire.AppendTag(h, headerValues, protowire.BytesType)
+			h = protowire.AppendString(h, value)
+		}
+		b = protowire.AppendTag(b, responseHeaders, protowire.BytesType)
+		b = protowire.AppendBytes(b, h)
+	}
+
+	if len(resp.Body) > 0 {
+		b = protowire.AppendTag(b, responseBody, protowire.BytesType)
+		b = protowire.AppendBytes(b, resp.Body)
+	}
+	b = appendString(b, responseContentType, resp.ContentType)
+	b = appendString(b, responseETag, resp.ETag)
+	b = appendTime(b, responseCompletedAt, resp.CompletedAt)
+	b = appendString(b, responseRegion, resp.Region)
+	if resp.Truncated {
+		b = appendInt(b, responseTruncated, 1)
+	}
+	b = appendString(b, responseBlobKey, resp.BlobKey)
+	return b
+}
+
+func (protobufCodec) Unmarshal(data []byte) (*idempotency.Record, error) {
+	record := &idempotency.Record{}
+	err := parseFields(data, func(num protowire.Number, v uint64, s []byte) error {
+		switch num {
+		case recordKey:
+			record.Key = string(s)
+		case recordRequestHash:
+			record.RequestHash = string(s)
+		case recordStatus:
+			record.Status = idempotency.RecordStatus(s)
+		case recordResponse:
+			resp, err := unmarshalResponse(s)
+			if err != nil {
+				return err
+			}
+			record.Response = resp
+		case recordCreatedAt:
+			record.CreatedAt = toTime(v)
+		case recordError:
+			record.Error = string(s)
+		case recordExpiresAt:
+			record.ExpiresAt = toTime(v)
+		case recordTTL:
+			record.TTL = time.Duration(v)
+		case recordLockTimeout:
+			record.LockTimeout = time.Duration(v)
+		case recordReplays:
+			record.Replays = int(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func unmarshalResponse(data []byte) (*idempotency.CachedResponse, error) {
+	resp := &idempotency.CachedResponse{}
+	err := parseFields(data, func(num protowire.Number, v uint64, s []byte) error {
+		switch num {
+		case responseStatusCode:
+			resp.StatusCode = int(v)
+		case responseHeaders:
+			var name string
+			var values []string
+			err := parseFields(s, func(num protowire.Number, _ uint64, s []byte) error {
+				switch num {
+				case headerName:
+					name = string(s)
+				case headerValues:
+					values = append(values, string(s))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if resp.Headers == nil {
+				resp.Headers = make(map[string][]string)
+			}
+			resp.Headers[name] = values
+		case responseBody:
+			resp.Body = append([]byte(nil), s...)
+		case responseContentType:
+			resp.ContentType = string(s)
+		case responseETag:
+			resp.ETag = string(s)
+		case responseCompletedAt:
+			resp.CompletedAt = toTime(v)
+		case responseRegion:
+			resp.Region = string(s)
+		case responseTruncated:
+			resp.Truncated = v != 0
+		case responseBlobKey:
+			resp.BlobKey = string(s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// parseFields calls fn with the number and the value of each field of a message:
+// v for varints, s for length-delimited fields. Other wire types are skipped.
+func parseFields(data []byte, fn func(num protowire.Number, v uint64, s []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errMalformed
+		}
+		data = data[n:]
+
+		var v uint64
+		var s []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			s, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return errMalformed
+		}
+		data = data[n:]
+
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := fn(num, v, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendInt(b, num, t.UnixNano())
+}
+
+func toTime(v uint64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(v)).UTC()
+}
@@ -0,0 +1,88 @@
+package idempotency
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// codecStorage records the codec applied by NewManager
+type codecStorage struct {
+	MockStorage
+	codec Codec
+}
+
+func (s *codecStorage) SetCodec(codec Codec) {
+	s.codec = codec
+}
+
+// reverseCodec wraps JSONCodec, reversing the encoded bytes
+type reverseCodec struct{}
+
+func (reverseCodec) Marshal(record *Record) ([]byte, error) {
+	data, err := JSONCodec.Marshal(record)
+	return reverse(data), err
+}
+
+func (reverseCodec) Unmarshal(data []byte) (*Record, error) {
+	return JSONCodec.Unmarshal(reverse(data))
+}
+
+func reverse(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out
+}
+
+func TestJSONCodec_RoundTrip(t *testing.T) {
+	record := &Record{
+		Key:         "key",
+		RequestHash: "hash",
+		Status:      StatusCompleted,
+		Response: &CachedResponse{
+			StatusCode: 201,
+			Headers:    map[string][]string{"Content-Type": {"application/json"}},
+			Body:       []byte(`{"id":1}`),
+		},
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	data, err := JSONCodec.Marshal(record)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	got, err := JSONCodec.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if got.Key != record.Key || got.Status != record.Status || !got.CreatedAt.Equal(record.CreatedAt) {
+		t.Errorf("expected %+v, got %+v", record, got)
+	}
+	if got.Response == nil || !bytes.Equal(got.Response.Body, record.Response.Body) {
+		t.Errorf("expected body to round-trip, got %+v", got.Response)
+	}
+
+	if _, err := JSONCodec.Unmarshal([]byte("not json")); err == nil {
+		t.Error("expected error decoding invalid data")
+	}
+}
+
+func TestNewManager_Codec(t *testing.T) {
+	storage := &codecStorage{}
+	if _, err := NewManager(Config{Storage: storage}); err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	if storage.codec != nil {
+		t.Error("expected storage codec to be left untouched without Config.Codec")
+	}
+
+	if _, err := NewManager(Config{Storage: storage, Codec: reverseCodec{}}); err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	if _, ok := storage.codec.(reverseCodec); !ok {
+		t.Errorf("expected Config.Codec to be applied, got %T", storage.codec)
+	}
+}
@@ -2,9 +2,13 @@ package idempotency
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Config holds the configuration for the idempotency manager
@@ -16,11 +20,70 @@ type Config struct {
 	// Default: 24 hours
 	TTL time.Duration
 
+	// TTLHeader is a response header set by handlers to override TTL for their response,
+	// as a Go duration ("72h") or a number of seconds, e.g. "X-Idempotency-TTL". The
+	// header is neither cached nor replayed. Responses whose body is in BlobStore are
+	// kept at most TTL (optional)
+	TTLHeader string
+
 	// LockTimeout is the maximum time a lock can be held
 	// This prevents deadlocks if a server crashes while processing
-	// Default: 5 minutes
+	// Default: 5 minutes, or TTL if shorter
 	LockTimeout time.Duration
 
+	// LockRenewalInterval renews the lock of requests being processed at this interval,
+	// so handlers may legitimately run longer than LockTimeout (but not PendingTTL). It
+	// requires a storage implementing LockExtender and must be shorter than LockTimeout
+	// (optional)
+	LockRenewalInterval time.Duration
+
+	// PendingTTL is the retention of records still pending, separate from the TTL of
+	// completed records so records left behind by crashed instances are garbage-collected
+	// quickly. It is never shorter than LockTimeout
+	// Default: TTL
+	PendingTTL time.Duration
+
+	// FailureTTL is the cooldown during which retries of a request that failed with a
+	// retryable status code (see RetryableStatusCodes) are answered with the failed
+	// response, before being processed again
+	// Default: 0 (retried immediately)
+	FailureTTL time.Duration
+
+	// RetryableStatusCodes are the status codes of responses not cached, so the request
+	// can be retried (after FailureTTL). Responses with any other status code, client
+	// errors included, are cached and replayed
+	// Default: 5xx
+	RetryableStatusCodes []int
+
+	// CacheableStatusCodes, when set, are the only status codes of responses cached,
+	// e.g. 2xx only. Responses with other status codes are handled like retryable
+	// ones (optional)
+	CacheableStatusCodes []int
+
+	// ShouldCache decides whether a response is cached from its status code and
+	// headers, overriding CacheableStatusCodes and RetryableStatusCodes. Responses not
+	// cached are handled like retryable ones (optional)
+	ShouldCache func(statusCode int, headers map[string][]string) bool
+
+	// HeaderName is the request header carrying the idempotency key
+	// Default: DefaultHeaderName ("Idempotency-Key")
+	HeaderName string
+
+	// HeaderAliases are additional header names accepted for the idempotency key,
+	// checked in order when HeaderName is not present (optional)
+	HeaderAliases []string
+
+	// StorageTimeout bounds each individual storage operation (Get, Set, TryLock, ...)
+	// performed by the manager, so a slow backend cannot stall request handling
+	// Default: 0 (no timeout besides the request context)
+	StorageTimeout time.Duration
+
+	// KeyPrefix namespaces the keys of records and locks in the storage (e.g.
+	// "billing/prod/"), so several services or environments can share one Redis or SQL
+	// instance. Keys seen by strategies, hooks and events are not prefixed, and listing
+	// only returns the records of the namespace (optional)
+	KeyPrefix string
+
 	// KeyStrategy is the strategy for generating idempotency keys
 	// Default: HeaderBased("Idempotency-Key")
 	KeyStrategy KeyStrategy
@@ -33,10 +96,174 @@ type Config struct {
 	// Default: ["POST", "PUT", "PATCH", "DELETE"]
 	AllowedMethods []string
 
-	// ErrorHandler is called when an error occurs, allowing custom error responses
-	// Default: returns standard error responses
+	// RoutePolicies customize idempotency for specific routes, checked in order (optional)
+	RoutePolicies []RoutePolicy
+
+	// IncludePaths restricts idempotency to the requests whose path matches one of these
+	// patterns: path.Match globs (e.g. "/v1/payments/*"), or regular expressions when
+	// starting with "^" (e.g. "^/v1/payments/.+$") (optional, all paths when empty).
+	// It applies to every middleware of the manager and to Wrap; the WithPaths and
+	// SkipPaths middleware options restrict a single middleware further
+	IncludePaths []string
+
+	// ExcludePaths are the path patterns of requests passed straight to the handler,
+	// even with an idempotency key, e.g. "/health" and "/metrics" (optional)
+	ExcludePaths []string
+
+	// Region identifies the deployment region in active-active setups. It is recorded
+	// with cached responses and returned on replays in OriginRegionHeaderName (optional)
+	Region string
+
+	// MaxPendingPerScope limits the number of requests pending at the same time per scope
+	// on this instance. Requests over the limit are rejected with ErrQuotaExceeded (429),
+	// which stops a buggy client from filling the storage with unique keys
+	// Default: 0 (unlimited)
+	MaxPendingPerScope int
+
+	// QuotaScope returns the scope (user, tenant...) a request counts against for
+	// MaxPendingPerScope
+	// Default: a single scope shared by all requests
+	QuotaScope func(req *Request) string
+
+	// PolicyFor returns the policy of a scope (tenant, plan...), resolved for every request
+	// to override TTL, LockTimeout and strictness, e.g. 72h replay windows for enterprise
+	// customers (optional)
+	PolicyFor func(scope string) Policy
+
+	// PolicyScope returns the scope of a request passed to PolicyFor
+	// Default: QuotaScope
+	PolicyScope func(req *Request) string
+
+	// APIVersion extracts the API version of a request (see VersionFromPath,
+	// VersionFromAccept and VersionFromHeader). It is appended to idempotency keys, so a
+	// key reused against another version does not replay the response of the first (optional)
+	APIVersion func(req *Request) string
+
+	// AsyncStatusURL enables the asynchronous pattern: duplicates of an in-progress request
+	// receive 202 Accepted with a Location header set to the returned URL instead of 409,
+	// and can poll it (see httpmw.StatusHandler) until the final response is ready (optional)
+	AsyncStatusURL func(key string) string
+
+	// Codec serializes records in storages implementing CodecSetter (Redis, SQL, GORM...)
+	// Default: the storage codec (JSONCodec)
+	Codec Codec
+
+	// ValueCodec serializes the results cached by Do
+	// Default: JSONValueCodec
+	ValueCodec ValueCodec
+
+	// Clock tells the time of record and lock expirations, also applied to storages
+	// implementing ClockSetter. Set a FakeClock to test expirations deterministically.
+	// Default: SystemClock
+	Clock Clock
+
+	// HTTPCaching makes replays compose with HTTP caching: an Age header is added,
+	// Cache-Control defaults to "no-store" when the original response has none, and a
+	// replay whose If-None-Match matches the cached ETag is answered with 304 Not Modified
+	// Default: false
+	HTTPCaching bool
+
+	// FailClosed rejects requests with 503 when the storage is unavailable, instead of
+	// processing them without idempotency protection
+	// Default: false
+	FailClosed bool
+
+	// StripHeaders are response headers never cached nor replayed, e.g. session cookies
+	// (see SensitiveHeaders) (optional)
+	StripHeaders []string
+
+	// CacheHeaders, when set, are the only response headers cached and replayed, e.g.
+	// Location and ETag, so new headers of the application (Date, tracing, rate
+	// limits...) are never replayed by accident. StripHeaders still applies (optional)
+	CacheHeaders []string
+
+	// MaxCachedBodySize is the maximum size in bytes of a cached response body, bodies
+	// larger are handled according to BodyOverflow. The middlewares then buffer at most
+	// this size of each response.
+	// Default: 0 (unlimited)
+	MaxCachedBodySize int
+
+	// BodyOverflow is the behavior when a response body exceeds MaxCachedBodySize
+	// Default: BodyOverflowSkip
+	BodyOverflow BodyOverflow
+
+	// BlobStore stores the bodies exceeding MaxCachedBodySize with BodyOverflowBlob
+	// (optional)
+	BlobStore BlobStore
+
+	// Streaming is the behavior for streaming responses (server-sent events, flushed or
+	// hijacked responses), which are not buffered with StreamingPassthrough
+	// Default: StreamingPassthrough
+	Streaming StreamingPolicy
+
+	// RetryAfter is sent in a Retry-After header with 409 responses to duplicates of an
+	// in-progress request, telling clients when to retry (optional)
+	RetryAfter time.Duration
+
+	// RetryAfterFromLock derives the Retry-After header of 409 responses from the
+	// remaining time-to-live of the lock of the in-progress request, read from storages
+	// implementing LockTTLReader (memory, Redis, SQLite). RetryAfter, when set, caps it
+	// and is sent when the remaining time is unknown
+	// Default: false
+	RetryAfterFromLock bool
+
+	// WaitForResult makes duplicates of an in-progress request wait for the original to
+	// complete and replay its response, instead of failing with ErrRequestInProgress (409).
+	// Storages implementing CompletionWaiter wake waiters on completion, others are polled
+	// Default: false
+	WaitForResult bool
+
+	// WaitTimeout bounds the wait of WaitForResult, after which the duplicate fails with
+	// ErrRequestInProgress
+	// Default: 10s
+	WaitTimeout time.Duration
+
+	// PollInterval is the interval at which WaitForResult reads the record of storages not
+	// implementing CompletionWaiter
+	// Default: 50ms
+	PollInterval time.Duration
+
+	// ExpiredKeys is the behavior when a key is reused after its completed record expired,
+	// e.g. a client retrying beyond the retention window
+	// Default: ExpiredKeyProcess
+	ExpiredKeys ExpiredKeyPolicy
+
+	// ExpiredKeyRetention keeps records in the storage this long after they expire, so
+	// ExpiredKeys can detect reused keys after the storage TTL. Storages expiring records
+	// at Record.ExpiresAt (memory) ignore it
+	// Default: 0 (reuse is detected while the storage still returns the expired record)
+	ExpiredKeyRetention time.Duration
+
+	// TrackReplays counts the replays of each record in Record.Replays, reported by
+	// Inspect. Every replay then updates the record in the storage, and concurrent replays
+	// may be counted once
+	// Default: false
+	TrackReplays bool
+
+	// RefreshTTLOnHit restarts the retention of a completed record (TTL, or the TTL of
+	// its response) on every replay, so keys retried for longer than TTL keep being
+	// replayed while keys no longer used expire. Every replay then updates the record
+	// in the storage. Records whose body is in BlobStore are not refreshed
+	// Default: false
+	RefreshTTLOnHit bool
+
+	// ErrorHandler renders errors, e.g. in the error envelope of the API: the errors
+	// returned by Wrap handlers and the idempotency errors written by the middlewares
+	// (ErrRequestInProgress, ErrRequestMismatch, ErrNoIdempotencyKey, ErrInvalidBody,
+	// ErrQuotaExceeded, ErrKeyExpired and, with FailClosed, *StorageError). body is
+	// encoded as JSON, or written as is when []byte; a zero statusCode keeps the default
+	// status code of the error
+	// Default: ErrorResponse for idempotency errors, 500 for handler errors
 	ErrorHandler func(error) (statusCode int, body any)
 
+	// Messages are the client-facing error messages written by the middlewares
+	// Default: DefaultMessages() (empty fields are filled individually)
+	Messages Messages
+
+	// ReplayHeaders are the names of the metadata headers added to replayed responses
+	// Default: DefaultReplayHeaderNames() (empty fields are filled individually)
+	ReplayHeaders ReplayHeaderNames
+
 	// OnCacheHit is called when a cached response is returned (optional)
 	OnCacheHit func(key string)
 
@@ -46,10 +273,43 @@ type Config struct {
 	// OnLockConflict is called when a request is already in progress (optional)
 	OnLockConflict func(key string)
 
+	// OnComplete is called when a record transitions to completed or failed, e.g. to notify
+	// downstream systems (see the webhook package). It runs synchronously on the request
+	// path, so slow work should be done asynchronously (optional)
+	OnComplete func(record *Record)
+
+	// OnExpired is called when the idempotency window of a record closes, e.g. to audit or
+	// archive it. Storages implementing ExpiryNotifier report their own expirations;
+	// otherwise it is called when Check finds an expired record. record is nil when the
+	// storage cannot provide it (Redis keyspace events) (optional)
+	OnExpired func(key string, record *Record)
+
+	// OnLockEvent is called when a lock is acquired, released, expires while held or is
+	// taken over, reporting wait and hold times to tune LockTimeout (optional)
+	OnLockEvent func(event LockEvent)
+
+	// MetricsCollector receives cache hit, miss, conflict and mismatch counts and storage
+	// latencies (see the metrics package) (optional)
+	MetricsCollector MetricsCollector
+
+	// TracerProvider enables OpenTelemetry spans for Begin, Check, Lock, Store, Unlock and
+	// every storage operation, e.g. otel.GetTracerProvider() (optional)
+	TracerProvider trace.TracerProvider
+
+	// EventSink receives every idempotency decision (stored, replayed, conflict,
+	// mismatch) as an event (optional)
+	EventSink EventSink
+
 	// RequireKey if true, the middleware will return an error if the idempotency key is missing
 	// for an allowed method/route.
 	// Default: false
 	RequireKey bool
+
+	// ProblemDetails writes idempotency errors (missing key, conflict, mismatch...) as
+	// RFC 9457 problem details (application/problem+json), as recommended by the IETF
+	// Idempotency-Key header draft, instead of a {"error": message} JSON body
+	// Default: false
+	ProblemDetails bool
 }
 
 // setDefaults sets default values for unspecified config options
@@ -58,31 +318,174 @@ func (c *Config) setDefaults() {
 		c.TTL = 24 * time.Hour
 	}
 
+	if c.HeaderName == "" {
+		c.HeaderName = DefaultHeaderName
+	}
+
 	if c.LockTimeout == 0 {
 		c.LockTimeout = 5 * time.Minute
+		if c.TTL > 0 {
+			c.LockTimeout = min(c.LockTimeout, c.TTL)
+		}
 	}
 
 	if c.AllowedMethods == nil {
 		c.AllowedMethods = []string{"POST", "PUT", "PATCH", "DELETE"}
 	}
 
+	if c.WaitTimeout == 0 {
+		c.WaitTimeout = 10 * time.Second
+	}
+
+	if c.PollInterval == 0 {
+		c.PollInterval = 50 * time.Millisecond
+	}
+
+	if c.PolicyScope == nil {
+		c.PolicyScope = c.QuotaScope
+	}
+
+	c.Messages.setDefaults()
+	c.ReplayHeaders.setDefaults()
+
+	if c.ValueCodec == nil {
+		c.ValueCodec = JSONValueCodec
+	}
+
+	if c.Clock == nil {
+		c.Clock = SystemClock
+	}
+
 	if c.RequestHasher == nil {
 		c.RequestHasher = &defaultRequestHasher{}
 	}
 }
 
-// validate checks if the configuration is valid
+// validate checks if the configuration is valid.
+// All problems are reported at once, joined in a single error; each of them
+// matches ErrStorageNotConfigured or ErrInvalidConfiguration with errors.Is.
 func (c *Config) validate() error {
+	var errs []error
+
 	if c.Storage == nil {
-		return ErrStorageNotConfigured
+		errs = append(errs, ErrStorageNotConfigured)
 	}
 
-	return nil
+	if c.TTL < 0 {
+		errs = append(errs, invalidConfig("TTL must be positive, got %s", c.TTL))
+	}
+
+	if c.LockTimeout < 0 {
+		errs = append(errs, invalidConfig("LockTimeout must be positive, got %s", c.LockTimeout))
+	}
+
+	if c.LockRenewalInterval < 0 {
+		errs = append(errs, invalidConfig("LockRenewalInterval must not be negative, got %s", c.LockRenewalInterval))
+	}
+
+	if c.LockRenewalInterval > 0 && c.LockRenewalInterval >= c.LockTimeout {
+		errs = append(errs, invalidConfig("LockRenewalInterval (%s) must be shorter than LockTimeout (%s), otherwise locks expire before being renewed", c.LockRenewalInterval, c.LockTimeout))
+	}
+
+	if c.LockRenewalInterval > 0 && c.Storage != nil && !Supports[LockExtender](c.Storage) {
+		errs = append(errs, invalidConfig("LockRenewalInterval requires a storage implementing LockExtender"))
+	}
+
+	if c.PendingTTL < 0 {
+		errs = append(errs, invalidConfig("PendingTTL must not be negative, got %s", c.PendingTTL))
+	}
+
+	if c.FailureTTL < 0 {
+		errs = append(errs, invalidConfig("FailureTTL must not be negative, got %s", c.FailureTTL))
+	}
+
+	for _, code := range c.RetryableStatusCodes {
+		if code < 100 || code > 599 {
+			errs = append(errs, invalidConfig("RetryableStatusCodes contains invalid status code %d", code))
+		}
+	}
+	if _, err := compilePathPatterns(c.IncludePaths); err != nil {
+		errs = append(errs, invalidConfig("IncludePaths contains an invalid pattern: %v", err))
+	}
+	if _, err := compilePathPatterns(c.ExcludePaths); err != nil {
+		errs = append(errs, invalidConfig("ExcludePaths contains an invalid pattern: %v", err))
+	}
+
+	for _, code := range c.CacheableStatusCodes {
+		if code < 100 || code > 599 {
+			errs = append(errs, invalidConfig("CacheableStatusCodes contains invalid status code %d", code))
+		}
+	}
+
+	if c.MaxCachedBodySize < 0 {
+		errs = append(errs, invalidConfig("MaxCachedBodySize must not be negative, got %d", c.MaxCachedBodySize))
+	}
+
+	if c.BodyOverflow == BodyOverflowBlob && c.BlobStore == nil {
+		errs = append(errs, invalidConfig("BodyOverflowBlob requires a BlobStore"))
+	}
+
+	if c.Streaming == StreamingCachePrefix && c.MaxCachedBodySize == 0 {
+		errs = append(errs, invalidConfig("StreamingCachePrefix requires a MaxCachedBodySize"))
+	}
+
+	if c.ExpiredKeyRetention < 0 {
+		errs = append(errs, invalidConfig("ExpiredKeyRetention must not be negative, got %s", c.ExpiredKeyRetention))
+	}
+
+	if c.RetryAfter < 0 {
+		errs = append(errs, invalidConfig("RetryAfter must not be negative, got %s", c.RetryAfter))
+	}
+
+	if c.WaitTimeout < 0 {
+		errs = append(errs, invalidConfig("WaitTimeout must not be negative, got %s", c.WaitTimeout))
+	}
+
+	if c.PollInterval < 0 {
+		errs = append(errs, invalidConfig("PollInterval must not be negative, got %s", c.PollInterval))
+	}
+
+	if c.StorageTimeout < 0 {
+		errs = append(errs, invalidConfig("StorageTimeout must not be negative, got %s", c.StorageTimeout))
+	}
+
+	if c.MaxPendingPerScope < 0 {
+		errs = append(errs, invalidConfig("MaxPendingPerScope must not be negative, got %d", c.MaxPendingPerScope))
+	}
+
+	if c.TTL > 0 && c.LockTimeout > c.TTL {
+		errs = append(errs, invalidConfig("LockTimeout (%s) must not exceed TTL (%s), otherwise pending records expire while still locked", c.LockTimeout, c.TTL))
+	}
+
+	if c.RequireKey && len(c.AllowedMethods) == 0 {
+		errs = append(errs, invalidConfig("RequireKey has no effect with an empty AllowedMethods list"))
+	}
+
+	if slices.Contains(c.HeaderAliases, c.HeaderName) {
+		errs = append(errs, invalidConfig("HeaderAliases must not contain HeaderName %q", c.HeaderName))
+	}
+
+	for _, p := range c.RoutePolicies {
+		if p.Method != http.MethodGet && p.Method != http.MethodHead || p.KeyStrategy == nil {
+			continue
+		}
+		if scoped, ok := p.KeyStrategy.(CallerScoped); !ok || !scoped.CallerScoped() {
+			errs = append(errs, invalidConfig("route policy %s %s caches responses with a key strategy not scoped by the caller (see key.RequestURI and key.Scoped)", p.Method, p.Path))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// invalidConfig builds a configuration error wrapping ErrInvalidConfiguration
+func invalidConfig(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfiguration}, args...)...)
 }
 
 // Storage is the interface for storing and retrieving idempotency records
 type Storage interface {
-	// Get retrieves an idempotency record by key
+	// Get retrieves an idempotency record by key. It returns nil without error when
+	// there is no record, errors are reserved to storage failures
 	Get(ctx context.Context, key string) (*Record, error)
 
 	// Set stores an idempotency record
@@ -105,6 +508,95 @@ type Storage interface {
 	Close() error
 }
 
+// CompletionWaiter is an optional Storage extension that lets a duplicate request block
+// until the original one completes, instead of polling Get in a loop
+type CompletionWaiter interface {
+	// WaitForCompletion blocks until the record for key is no longer pending, its lock
+	// is released, or ctx is done. Callers must re-read the record afterwards.
+	WaitForCompletion(ctx context.Context, key string) error
+}
+
+// LockSetter is an optional Storage extension that acquires the lock and stores the
+// pending record atomically, used by Manager.Lock instead of TryLock followed by Set
+type LockSetter interface {
+	// TryLockAndSet acquires the lock for record.Key with lockTTL and, only if acquired,
+	// stores record with ttl. Nothing is written when the lock is held elsewhere.
+	TryLockAndSet(ctx context.Context, record *Record, ttl, lockTTL time.Duration) (bool, error)
+}
+
+// LockExtender is an optional Storage extension that renews held locks, required by
+// Config.LockRenewalInterval
+type LockExtender interface {
+	// ExtendLock makes the lock held for key expire ttl from now. It reports false,
+	// extending nothing, when the lock is not held anymore.
+	ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// LockTTLReader is an optional Storage extension reporting the remaining time-to-live of
+// locks, used by Config.RetryAfterFromLock
+type LockTTLReader interface {
+	// LockTTL returns the time until the lock held for key expires, 0 when it is not
+	// held
+	LockTTL(ctx context.Context, key string) (time.Duration, error)
+}
+
+// SetUnlocker is an optional Storage extension that stores the completed record and
+// releases its lock atomically, used by Manager.Store instead of Set followed by Unlock
+type SetUnlocker interface {
+	// SetAndUnlock stores record with ttl and releases the lock for record.Key
+	SetAndUnlock(ctx context.Context, record *Record, ttl time.Duration) error
+}
+
+// GetOrLocker is an optional Storage extension that reads the record for a key and, when
+// there is none, acquires the lock and stores the pending record in a single atomic
+// operation, used by Manager.Begin instead of Check followed by Lock
+type GetOrLocker interface {
+	// GetOrLock returns the non-expired record stored for key, writing nothing, if there
+	// is one. Otherwise it acquires the lock for key with lockTTL and, only if acquired,
+	// stores record with ttl; locked reports whether the lock was acquired.
+	GetOrLock(ctx context.Context, key string, record *Record, ttl, lockTTL time.Duration) (existing *Record, locked bool, err error)
+}
+
+// RecordLister is an optional Storage extension that enumerates stored records,
+// required by Manager.ListStale
+type RecordLister interface {
+	// List returns all non-expired records
+	List(ctx context.Context) ([]*Record, error)
+}
+
+// ExpiryNotifier is an optional Storage extension that reports records removed because
+// their TTL elapsed, used to deliver Config.OnExpired
+type ExpiryNotifier interface {
+	// OnExpired registers fn, called with the key and, when still available, the record
+	// of every expired record
+	OnExpired(fn func(key string, record *Record))
+}
+
+// StorageWrapper is implemented by storages wrapping another one (encryption,
+// compression, circuit breaking, caching...). A wrapper implements every optional
+// Storage extension, forwarding it to the wrapped storage: the manager only uses an
+// extension when every storage down the chain implements it.
+type StorageWrapper interface {
+	// Unwrap returns the wrapped storage
+	Unwrap() Storage
+}
+
+// Supports reports whether storage implements the optional extension T (LockSetter,
+// LockExtender...), together with every storage it wraps (see StorageWrapper)
+func Supports[T any](storage Storage) bool {
+	for storage != nil {
+		if _, ok := storage.(T); !ok {
+			return false
+		}
+		wrapper, ok := storage.(StorageWrapper)
+		if !ok {
+			return true
+		}
+		storage = wrapper.Unwrap()
+	}
+	return false
+}
+
 // KeyStrategy is the interface for generating idempotency keys
 type KeyStrategy interface {
 	// Generate generates an idempotency key from the request
@@ -112,6 +604,23 @@ type KeyStrategy interface {
 	Generate(req *Request) (string, error)
 }
 
+// KeyTransformer is an optional KeyStrategy extension applied to the key sent by the
+// client in the idempotency key header, which otherwise takes precedence over the
+// strategy, e.g. to combine it with other headers (see key.MultiHeader)
+type KeyTransformer interface {
+	// TransformKey returns the idempotency key of req, whose key header carries key
+	TransformKey(key string, req *Request) (string, error)
+}
+
+// CallerScoped is an optional KeyStrategy extension reporting that keys are scoped by
+// the caller (user, tenant...), so a response is never replayed to another caller.
+// Route policies for GET and HEAD requests require it (see key.RequestURI and
+// key.Scoped).
+type CallerScoped interface {
+	// CallerScoped reports whether the keys of the strategy are scoped by the caller
+	CallerScoped() bool
+}
+
 // RequestHasher is the interface for hashing requests
 type RequestHasher interface {
 	// Hash computes a hash of the request for validation
@@ -126,6 +635,5 @@ func (d *defaultRequestHasher) Hash(req *Request) (string, error) {
 		return "", nil
 	}
 
-	hash := sha256.Sum256(req.Body)
-	return hex.EncodeToString(hash[:]), nil
+	return req.BodyDigest(), nil
 }
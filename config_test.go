@@ -2,6 +2,8 @@ package idempotency
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -55,6 +57,47 @@ func TestConfig_Validate(t *testing.T) {
 			t.Fatalf("expected nil error, got %v", err)
 		}
 	})
+
+	t.Run("aggregates all problems", func(t *testing.T) {
+		cfg := Config{
+			TTL:            time.Minute,
+			LockTimeout:    time.Hour,
+			RequireKey:     true,
+			AllowedMethods: []string{},
+			HeaderName:     "Idempotency-Key",
+			HeaderAliases:  []string{"Idempotency-Key"},
+		}
+		err := cfg.validate()
+		if !errors.Is(err, ErrStorageNotConfigured) {
+			t.Fatalf("expected ErrStorageNotConfigured, got %v", err)
+		}
+		if !errors.Is(err, ErrInvalidConfiguration) {
+			t.Fatalf("expected ErrInvalidConfiguration, got %v", err)
+		}
+		for _, want := range []string{"LockTimeout", "RequireKey", "HeaderAliases"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("expected error to mention %s, got %q", want, err.Error())
+			}
+		}
+	})
+
+	t.Run("short TTL without LockTimeout", func(t *testing.T) {
+		m, err := NewManager(Config{Storage: &dummyStorage{}, TTL: time.Minute})
+		if err != nil {
+			t.Fatalf("expected the default LockTimeout to fit a short TTL, got %v", err)
+		}
+		if m.config.LockTimeout != time.Minute {
+			t.Errorf("expected LockTimeout clamped to TTL, got %s", m.config.LockTimeout)
+		}
+	})
+
+	t.Run("negative durations", func(t *testing.T) {
+		cfg := Config{Storage: &dummyStorage{}, TTL: -time.Second, LockTimeout: -time.Second}
+		err := cfg.validate()
+		if !strings.Contains(err.Error(), "TTL must be positive") || !strings.Contains(err.Error(), "LockTimeout must be positive") {
+			t.Fatalf("expected both negative durations to be reported, got %q", err.Error())
+		}
+	})
 }
 
 func TestDefaultRequestHasher(t *testing.T) {
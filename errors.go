@@ -27,6 +27,22 @@ var (
 
 	// ErrNoIdempotencyKey is returned when no idempotency key could be extracted or generated
 	ErrNoIdempotencyKey = errors.New("idempotency: no idempotency key found or generated")
+
+	// ErrQuotaExceeded is returned when the request scope already has MaxPendingPerScope pending requests
+	ErrQuotaExceeded = errors.New("idempotency: too many pending requests for this scope")
+
+	// ErrKeyExpired is returned when a key is reused after its record expired and
+	// Config.ExpiredKeys is ExpiredKeyReject
+	ErrKeyExpired = errors.New("idempotency: idempotency key expired and cannot be reused")
+
+	// ErrInvalidBody is returned when the request body cannot be read
+	ErrInvalidBody = errors.New("idempotency: failed to read request body")
+
+	// ErrKeyNotFound is returned when no record exists for an idempotency key
+	ErrKeyNotFound = errors.New("idempotency: no record found for this idempotency key")
+
+	// ErrListingNotSupported is returned when the storage backend cannot enumerate records
+	ErrListingNotSupported = errors.New("idempotency: storage backend does not support listing records")
 )
 
 // StorageError wraps errors from storage operations
@@ -0,0 +1,85 @@
+package idempotency
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Decision is the outcome of idempotency handling for a request
+type Decision string
+
+const (
+	// DecisionStored means the request was processed and its response cached
+	DecisionStored Decision = "stored"
+
+	// DecisionReplayed means a duplicate was answered with the cached response
+	DecisionReplayed Decision = "replayed"
+
+	// DecisionConflict means a duplicate was rejected because the original is in progress
+	DecisionConflict Decision = "conflict"
+
+	// DecisionMismatch means the key was reused with a different payload
+	DecisionMismatch Decision = "mismatch"
+)
+
+// DecisionEvent describes an idempotency decision, e.g. to let fraud or reconciliation
+// pipelines know when duplicates were suppressed
+type DecisionEvent struct {
+	Decision   Decision  `json:"decision"`
+	Key        string    `json:"key"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// EventSink receives every idempotency decision (see the sink packages for Kafka and NATS).
+// Publish is called synchronously on the request path and its error is ignored, so
+// implementations should be fast or buffer internally.
+type EventSink interface {
+	Publish(ctx context.Context, event DecisionEvent) error
+}
+
+// NewLogSink returns an EventSink logging every decision with logger at info level,
+// e.g. as an audit trail of suppressed duplicates
+func NewLogSink(logger *slog.Logger) EventSink {
+	return &logSink{logger: logger}
+}
+
+type logSink struct {
+	logger *slog.Logger
+}
+
+func (s *logSink) Publish(ctx context.Context, event DecisionEvent) error {
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "idempotency decision",
+		slog.String("decision", string(event.Decision)),
+		slog.String("key", event.Key),
+		slog.String("method", event.Method),
+		slog.String("path", event.Path),
+		slog.Int("status_code", event.StatusCode),
+	)
+	return nil
+}
+
+// emit publishes a decision to the configured event sink
+func (m *Manager) emit(ctx context.Context, decision Decision, key string, req *Request, statusCode int) {
+	if m.config.EventSink == nil {
+		return
+	}
+
+	event := DecisionEvent{
+		Decision:   decision,
+		Key:        key,
+		StatusCode: statusCode,
+		Region:     m.config.Region,
+		Time:       m.now(),
+	}
+	if req != nil {
+		event.Method = req.Method
+		event.Path = req.Path
+	}
+
+	_ = m.config.EventSink.Publish(context.WithoutCancel(ctx), event)
+}
@@ -0,0 +1,47 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+)
+
+type recordingSink struct {
+	events []DecisionEvent
+}
+
+func (s *recordingSink) Publish(ctx context.Context, event DecisionEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestManager_EventSink(t *testing.T) {
+	ctx := context.Background()
+	sink := &recordingSink{}
+	m, _ := NewManager(Config{Storage: newListingStorage(), EventSink: sink, Region: "eu"})
+
+	req := func(body string) *Request {
+		return &Request{Method: "POST", Path: "/pay", IdempotencyKey: "k", Body: []byte(body)}
+	}
+
+	_ = m.Lock(ctx, req("a"))
+	_, _ = m.Check(ctx, req("a")) // conflict
+	_ = m.Store(ctx, "k", &Response{StatusCode: 201})
+	_, _ = m.Check(ctx, req("a")) // replayed
+	_, _ = m.Check(ctx, req("b")) // mismatch
+
+	want := []Decision{DecisionConflict, DecisionStored, DecisionReplayed, DecisionMismatch}
+	if len(sink.events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), sink.events)
+	}
+	for i, decision := range want {
+		if sink.events[i].Decision != decision {
+			t.Errorf("event %d: expected %s, got %s", i, decision, sink.events[i].Decision)
+		}
+		if sink.events[i].Key != "k" || sink.events[i].Region != "eu" {
+			t.Errorf("event %d: unexpected key or region: %+v", i, sink.events[i])
+		}
+	}
+	if sink.events[2].StatusCode != 201 || sink.events[2].Path != "/pay" {
+		t.Errorf("expected replayed event with status and path, got %+v", sink.events[2])
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
@@ -21,16 +22,9 @@ func main() {
 	defer db.Close()
 
 	// 2. Create tables if they don't exist
-	// In production, use a migration tool!
-	_, _ = db.Exec(`CREATE TABLE IF NOT EXISTS idempotency_records (
-		key TEXT PRIMARY KEY,
-		data BLOB NOT NULL,
-		expires_at DATETIME NOT NULL
-	)`)
-	_, _ = db.Exec(`CREATE TABLE IF NOT EXISTS idempotency_records_locks (
-		key TEXT PRIMARY KEY,
-		expires_at DATETIME NOT NULL
-	)`)
+	if err := idempotencySQL.Migrate(context.Background(), db); err != nil {
+		log.Fatal(err)
+	}
 
 	// 3. Create storage
 	store := idempotencySQL.NewSQLStorage(db, "idempotency_records")
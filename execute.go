@@ -0,0 +1,87 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Execute runs fn at most once for key, for idempotent work outside of HTTP handlers such
+// as queue consumers and cron jobs: it checks and locks the key, runs fn and stores its
+// response, and returns the stored response to later calls instead of running fn again.
+// When fn returns an error or panics, the record is failed and the lock released so the
+// work can be retried, then the error is returned or the panic resumed. Calls made while
+// key is being executed elsewhere return ErrRequestInProgress (see Config.WaitForResult).
+// A nil response is stored as an empty one.
+func (m *Manager) Execute(ctx context.Context, key string, fn func(ctx context.Context) (*Response, error)) (*CachedResponse, error) {
+	if key == "" {
+		return nil, ErrNoIdempotencyKey
+	}
+
+	outcome, err := m.Begin(ctx, &Request{IdempotencyKey: key})
+	if err != nil {
+		return nil, err
+	}
+	switch outcome.Kind {
+	case OutcomeReplay:
+		return outcome.Response, nil
+	case OutcomeConflict:
+		return nil, ErrRequestInProgress
+	}
+
+	resp, err := runLocked(ctx, outcome.Token, fn)
+	if err != nil {
+		_ = outcome.Token.Fail(err)
+		return nil, err
+	}
+	if resp == nil {
+		resp = &Response{}
+	}
+	_ = outcome.Token.Complete(resp)
+	return resp.ToCachedResponse(), nil
+}
+
+// runLocked runs fn, failing token before resuming a panic so the lock is not held until
+// it expires
+func runLocked(ctx context.Context, token *Token, fn func(ctx context.Context) (*Response, error)) (*Response, error) {
+	defer func() {
+		if r := recover(); r != nil {
+			_ = token.Fail(fmt.Errorf("panic: %v", r))
+			panic(r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// Do is Execute for functions returning a Go value instead of a Response, for idempotent
+// service-layer code. The value is stored serialized with Config.ValueCodec and later
+// calls for key decode it into a new T; the first call returns the value of fn as is.
+// T must round-trip through the codec (exported fields with JSONValueCodec). A value
+// that cannot be encoded fails the record and the error is returned.
+func Do[T any](ctx context.Context, m *Manager, key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result, zero T
+	ran := false
+	resp, err := m.Execute(ctx, key, func(ctx context.Context) (*Response, error) {
+		v, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		body, err := m.config.ValueCodec.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("idempotency: encode result: %w", err)
+		}
+		result, ran = v, true
+		return &Response{StatusCode: http.StatusOK, Body: body}, nil
+	})
+	if err != nil {
+		return zero, err
+	}
+	if ran {
+		return result, nil
+	}
+
+	if err := m.config.ValueCodec.Unmarshal(resp.Body, &result); err != nil {
+		return zero, fmt.Errorf("idempotency: decode result: %w", err)
+	}
+	return result, nil
+}
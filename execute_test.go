@@ -0,0 +1,119 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestManager_Execute(t *testing.T) {
+	ctx := context.Background()
+	store := newListingStorage()
+	m, _ := NewManager(Config{Storage: store})
+
+	var runs int
+	job := func(ctx context.Context) (*Response, error) {
+		runs++
+		return &Response{StatusCode: 200, Body: []byte("sent")}, nil
+	}
+
+	t.Run("RunsOnce", func(t *testing.T) {
+		for range 2 {
+			resp, err := m.Execute(ctx, "job-1", job)
+			if err != nil || string(resp.Body) != "sent" {
+				t.Fatalf("unexpected result: %v, %v", resp, err)
+			}
+		}
+		if runs != 1 {
+			t.Errorf("expected 1 run, got %d", runs)
+		}
+	})
+
+	t.Run("ErrorIsRetried", func(t *testing.T) {
+		boom := errors.New("smtp down")
+		if _, err := m.Execute(ctx, "job-2", func(ctx context.Context) (*Response, error) { return nil, boom }); err != boom {
+			t.Fatalf("expected the job error, got %v", err)
+		}
+		if r := store.records["job-2"]; r.Status != StatusFailed || r.Error != "smtp down" {
+			t.Fatalf("expected failed record, got %+v", r)
+		}
+		if _, err := m.Execute(ctx, "job-2", job); err != nil {
+			t.Fatalf("expected the retry to run, got %v", err)
+		}
+	})
+
+	t.Run("PanicReleasesLock", func(t *testing.T) {
+		unlocked := false
+		store.UnlockFunc = func(ctx context.Context, key string) error {
+			unlocked = true
+			return nil
+		}
+		defer func() { store.UnlockFunc = nil }()
+
+		func() {
+			defer func() {
+				if r := recover(); r != "boom" {
+					t.Errorf("expected the panic to be resumed, got %v", r)
+				}
+			}()
+			_, _ = m.Execute(ctx, "job-3", func(ctx context.Context) (*Response, error) { panic("boom") })
+		}()
+		if r := store.records["job-3"]; !unlocked || r.Status != StatusFailed || r.Error != "panic: boom" {
+			t.Errorf("expected failed record and released lock, got %+v (unlocked %v)", r, unlocked)
+		}
+	})
+
+	t.Run("InProgress", func(t *testing.T) {
+		store.records["job-4"] = &Record{Key: "job-4", Status: StatusPending}
+		if _, err := m.Execute(ctx, "job-4", job); err != ErrRequestInProgress {
+			t.Fatalf("expected ErrRequestInProgress, got %v", err)
+		}
+	})
+
+	t.Run("NoKey", func(t *testing.T) {
+		if _, err := m.Execute(ctx, "", job); err != ErrNoIdempotencyKey {
+			t.Fatalf("expected ErrNoIdempotencyKey, got %v", err)
+		}
+	})
+}
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	store := newListingStorage()
+	m, _ := NewManager(Config{Storage: store})
+
+	type receipt struct {
+		ID     string
+		Amount int
+	}
+	var runs int
+	charge := func(ctx context.Context) (receipt, error) {
+		runs++
+		return receipt{ID: "r-1", Amount: 100}, nil
+	}
+
+	t.Run("DecodesReplays", func(t *testing.T) {
+		for range 2 {
+			got, err := Do(ctx, m, "charge-1", charge)
+			if err != nil || got != (receipt{ID: "r-1", Amount: 100}) {
+				t.Fatalf("unexpected result: %+v, %v", got, err)
+			}
+		}
+		if runs != 1 {
+			t.Errorf("expected 1 run, got %d", runs)
+		}
+		if body := string(store.records["charge-1"].Response.Body); body != `{"ID":"r-1","Amount":100}` {
+			t.Errorf("expected JSON result, got %s", body)
+		}
+	})
+
+	t.Run("EncodeError", func(t *testing.T) {
+		_, err := Do(ctx, m, "charge-2", func(ctx context.Context) (chan int, error) { return make(chan int), nil })
+		if err == nil {
+			t.Fatal("expected an encoding error")
+		}
+		if r := store.records["charge-2"]; r.Status != StatusFailed {
+			t.Errorf("expected failed record, got %+v", r)
+		}
+	})
+}
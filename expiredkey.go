@@ -0,0 +1,33 @@
+package idempotency
+
+// ExpiredKeyPolicy is the behavior when a key is reused after its completed record
+// expired. The original response can no longer be replayed, so the request would be
+// processed again, which may duplicate its side effects.
+type ExpiredKeyPolicy int
+
+const (
+	// ExpiredKeyProcess processes the request again as a new one
+	ExpiredKeyProcess ExpiredKeyPolicy = iota
+
+	// ExpiredKeyWarn processes the request again and sets ExpiredKeyHeaderName on its
+	// response, so the client can tell it was not replayed
+	ExpiredKeyWarn
+
+	// ExpiredKeyReject rejects the request with ErrKeyExpired (422), so the client must
+	// reconcile its state and retry with a new key
+	ExpiredKeyReject
+)
+
+// String returns the name of the policy
+func (p ExpiredKeyPolicy) String() string {
+	switch p {
+	case ExpiredKeyProcess:
+		return "process"
+	case ExpiredKeyWarn:
+		return "warn"
+	case ExpiredKeyReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,111 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestManager_ExpiredKeys(t *testing.T) {
+	ctx := context.Background()
+	expired := func() *listingStorage {
+		storage := newListingStorage()
+		storage.records["reused"] = &Record{
+			Key:       "reused",
+			Status:    StatusCompleted,
+			Response:  &CachedResponse{StatusCode: http.StatusCreated},
+			CreatedAt: time.Now().Add(-2 * time.Hour),
+			ExpiresAt: time.Now().Add(-time.Hour),
+		}
+		return storage
+	}
+	newReq := func() *Request {
+		return &Request{Method: "POST", Path: "/orders", IdempotencyKey: "reused"}
+	}
+
+	t.Run("Process", func(t *testing.T) {
+		m, _ := NewManager(Config{Storage: expired()})
+		outcome, err := m.Begin(ctx, newReq())
+		if err != nil || outcome.Kind != OutcomeProceed {
+			t.Fatalf("expected to proceed, got %v, %v", outcome.Kind, err)
+		}
+		if len(outcome.Headers) != 0 {
+			t.Errorf("expected no headers, got %v", outcome.Headers)
+		}
+	})
+
+	t.Run("Warn", func(t *testing.T) {
+		m, _ := NewManager(Config{Storage: expired(), ExpiredKeys: ExpiredKeyWarn})
+		outcome, err := m.Begin(ctx, newReq())
+		if err != nil || outcome.Kind != OutcomeProceed {
+			t.Fatalf("expected to proceed, got %v, %v", outcome.Kind, err)
+		}
+		if outcome.Headers[ExpiredKeyHeaderName] != "true" {
+			t.Fatalf("expected the expired key header, got %v", outcome.Headers)
+		}
+
+		resp := &Response{StatusCode: http.StatusCreated, Headers: map[string][]string{ExpiredKeyHeaderName: {"true"}}}
+		if err := outcome.Token.Complete(resp); err != nil {
+			t.Fatalf("complete: %v", err)
+		}
+		record, _ := m.GetRecord(ctx, "reused")
+		if _, ok := record.Response.Headers[ExpiredKeyHeaderName]; ok {
+			t.Error("expected the warning not to be cached for replays")
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		storage := expired()
+		m, _ := NewManager(Config{Storage: storage, ExpiredKeys: ExpiredKeyReject})
+		for range 2 {
+			if _, err := m.Begin(ctx, newReq()); !errors.Is(err, ErrKeyExpired) {
+				t.Fatalf("expected ErrKeyExpired, got %v", err)
+			}
+		}
+		if storage.records["reused"] == nil {
+			t.Fatal("expected the expired record to be kept")
+		}
+	})
+
+	t.Run("Wrap", func(t *testing.T) {
+		m, _ := NewManager(Config{Storage: expired(), ExpiredKeys: ExpiredKeyWarn})
+		handler := m.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		})
+		r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		r.Header.Set(DefaultHeaderName, "reused")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Header().Get(ExpiredKeyHeaderName) != "true" {
+			t.Errorf("expected the expired key header, got %v", w.Header())
+		}
+
+		m, _ = NewManager(Config{Storage: expired(), ExpiredKeys: ExpiredKeyReject})
+		w = httptest.NewRecorder()
+		m.Wrap(func(w http.ResponseWriter, r *http.Request) error { return nil }).ServeHTTP(w, r)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected 422, got %d", w.Code)
+		}
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		var ttls []time.Duration
+		storage := &MockStorage{
+			SetFunc: func(ctx context.Context, record *Record, ttl time.Duration) error {
+				ttls = append(ttls, ttl)
+				return nil
+			},
+		}
+		m, _ := NewManager(Config{Storage: storage, TTL: time.Hour, ExpiredKeyRetention: 24 * time.Hour})
+		if err := m.Store(ctx, "k", &Response{StatusCode: http.StatusOK}); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+		if len(ttls) != 1 || ttls[0] != 25*time.Hour {
+			t.Errorf("expected the storage TTL to include the retention, got %v", ttls)
+		}
+	})
+}
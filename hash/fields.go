@@ -0,0 +1,108 @@
+package hash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fco-gt/gopotency"
+)
+
+// JSONFields creates a request hasher that hashes only the given fields of a JSON
+// body, so volatile fields (timestamps, client trace IDs...) do not cause mismatches.
+//
+// Fields are JSONPath-like: "amount", "$.customer.id" or "items[0].sku". Objects are
+// hashed whatever the order of their keys, missing fields hash differently from null.
+// Empty bodies hash to "" like BodyHasher, and bodies that are not JSON fail to hash.
+func JSONFields(fields ...string) idempotency.RequestHasher {
+	h := &jsonFieldsHasher{fields: fields, paths: make([][]any, len(fields))}
+	for i, field := range fields {
+		h.paths[i] = parseJSONPath(field)
+	}
+	return h
+}
+
+type jsonFieldsHasher struct {
+	fields []string
+
+	// paths holds the steps of each field: object keys (string) or array indexes (int)
+	paths [][]any
+}
+
+func (j *jsonFieldsHasher) Hash(req *idempotency.Request) (string, error) {
+	if len(req.Body) == 0 {
+		return "", nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(req.Body))
+	decoder.UseNumber()
+	var body any
+	if err := decoder.Decode(&body); err != nil {
+		return "", fmt.Errorf("hash: invalid JSON body: %w", err)
+	}
+
+	h := sha256.New()
+	for i, path := range j.paths {
+		value, ok := lookupJSONPath(body, path)
+		if !ok {
+			fmt.Fprintf(h, "%q!\n", j.fields[i])
+			continue
+		}
+		// Maps are marshaled with sorted keys, numbers as they were sent
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%q=%s\n", j.fields[i], data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// parseJSONPath splits a field such as "$.items[0].sku" into its steps
+func parseJSONPath(field string) []any {
+	field = strings.TrimPrefix(strings.TrimPrefix(field, "$"), ".")
+	var steps []any
+	for _, part := range strings.Split(field, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name != "" {
+			steps = append(steps, name)
+		}
+		for rest != "" {
+			index, after, _ := strings.Cut(rest, "]")
+			if n, err := strconv.Atoi(index); err == nil {
+				steps = append(steps, n)
+			} else {
+				steps = append(steps, strings.Trim(index, `'"`))
+			}
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return steps
+}
+
+// lookupJSONPath returns the value at path in a decoded JSON document
+func lookupJSONPath(value any, path []any) (any, bool) {
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[step]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := value.([]any)
+			if !ok || step < 0 || step >= len(array) {
+				return nil, false
+			}
+			value = array[step]
+		}
+	}
+	return value, true
+}
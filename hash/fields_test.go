@@ -0,0 +1,57 @@
+package hash
+
+import (
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+func TestJSONFields(t *testing.T) {
+	hasher := JSONFields("amount", "currency", "$.customer.id", "items[0].sku")
+	hash := func(body string) string {
+		t.Helper()
+		h, err := hasher.Hash(&idempotency.Request{Body: []byte(body)})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return h
+	}
+
+	base := hash(`{"amount":100,"currency":"EUR","customer":{"id":"c1"},"items":[{"sku":"a"}],"timestamp":"2024-01-01T00:00:00Z"}`)
+	if base == "" {
+		t.Fatal("expected a hash for a JSON body")
+	}
+
+	t.Run("IgnoresOtherFields", func(t *testing.T) {
+		got := hash(`{"trace_id":"t2","items":[{"sku":"a","qty":2}],"customer":{"name":"x","id":"c1"},"currency":"EUR","amount":100,"timestamp":"2024-06-01T00:00:00Z"}`)
+		if got != base {
+			t.Errorf("expected undeclared fields and key order to be ignored, got %q and %q", base, got)
+		}
+	})
+
+	t.Run("DetectsChanges", func(t *testing.T) {
+		for _, body := range []string{
+			`{"amount":200,"currency":"EUR","customer":{"id":"c1"},"items":[{"sku":"a"}]}`,
+			`{"amount":100,"currency":"EUR","customer":{"id":"c2"},"items":[{"sku":"a"}]}`,
+			`{"amount":100,"currency":"EUR","customer":{"id":"c1"},"items":[{"sku":"b"}]}`,
+			`{"amount":100,"currency":null,"customer":{"id":"c1"},"items":[{"sku":"a"}]}`,
+			`{"amount":100,"customer":{"id":"c1"},"items":[{"sku":"a"}]}`,
+		} {
+			if hash(body) == base {
+				t.Errorf("expected %s to hash differently", body)
+			}
+		}
+	})
+
+	t.Run("EmptyBody", func(t *testing.T) {
+		if got := hash(""); got != "" {
+			t.Errorf("expected empty hash for empty body, got %q", got)
+		}
+	})
+
+	t.Run("InvalidJSON", func(t *testing.T) {
+		if _, err := hasher.Hash(&idempotency.Request{Body: []byte("amount=100")}); err == nil {
+			t.Error("expected a body that is not JSON to fail")
+		}
+	})
+}
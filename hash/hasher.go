@@ -24,8 +24,7 @@ func (b *bodyHasher) Hash(req *idempotency.Request) (string, error) {
 		return "", nil
 	}
 
-	hash := sha256.Sum256(req.Body)
-	return hex.EncodeToString(hash[:]), nil
+	return req.BodyDigest(), nil
 }
 
 // FullHasher creates a request hasher that hashes method + path + body
@@ -0,0 +1,123 @@
+package idempotency
+
+import "net/textproto"
+
+// SensitiveHeaders returns the response headers that must not be replayed to another
+// request: session cookies and credentials
+func SensitiveHeaders() []string {
+	return []string{"Set-Cookie", "Authorization", "Proxy-Authorization"}
+}
+
+// PerRequestHeaders returns the response headers describing a single response rather
+// than its result, which replays should not repeat: date, request IDs and trace context
+func PerRequestHeaders() []string {
+	return []string{"Date", "X-Request-Id", "X-Correlation-Id", "Traceparent", "Tracestate", "Server-Timing"}
+}
+
+// ReplayHeaderNames are the names of the metadata headers added to replayed responses,
+// to follow the header names of an API specification. A name set to "-" omits the
+// header.
+type ReplayHeaderNames struct {
+	// Replayed is set to "true" on every replay
+	// Default: ReplayedHeaderName
+	Replayed string
+
+	// OriginalTimestamp is the time (RFC 3339) at which the original request completed
+	// Default: OriginalTimestampHeaderName
+	OriginalTimestamp string
+
+	// OriginRegion is the region that processed the original request
+	// Default: OriginRegionHeaderName
+	OriginRegion string
+
+	// Age is the number of seconds since the original request completed, e.g.
+	// "X-Idempotency-Age" (optional)
+	Age string
+
+	// Expires is the time (RFC 3339) until which retries are replayed, e.g.
+	// "X-Idempotency-Expires" (optional)
+	Expires string
+}
+
+// DefaultReplayHeaderNames returns the default replay header names
+func DefaultReplayHeaderNames() ReplayHeaderNames {
+	return ReplayHeaderNames{
+		Replayed:          ReplayedHeaderName,
+		OriginalTimestamp: OriginalTimestampHeaderName,
+		OriginRegion:      OriginRegionHeaderName,
+	}
+}
+
+// setDefaults fills empty names with their default value and clears omitted ones
+func (n *ReplayHeaderNames) setDefaults() {
+	defaults := DefaultReplayHeaderNames()
+
+	for _, name := range []struct {
+		value *string
+		def   string
+	}{
+		{&n.Replayed, defaults.Replayed},
+		{&n.OriginalTimestamp, defaults.OriginalTimestamp},
+		{&n.OriginRegion, defaults.OriginRegion},
+		{&n.Age, ""},
+		{&n.Expires, ""},
+	} {
+		switch *name.value {
+		case "":
+			*name.value = name.def
+		case "-":
+			*name.value = ""
+		}
+	}
+}
+
+// headerSet returns the set of the canonical names of headers, nil when empty
+func headerSet(headers []string) map[string]bool {
+	if len(headers) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(headers))
+	for _, name := range headers {
+		set[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+	return set
+}
+
+// uncachedHeaders returns the set of headers never cached: strip, the TTL header and
+// ExpiredKeyHeaderName, whose warning concerns a single response and not its replays
+func uncachedHeaders(strip []string, ttlHeader string) map[string]bool {
+	uncached := map[string]bool{ExpiredKeyHeaderName: true}
+	for _, name := range strip {
+		uncached[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+	if ttlHeader != "" {
+		uncached[textproto.CanonicalMIMEHeaderKey(ttlHeader)] = true
+	}
+	return uncached
+}
+
+// cachedHeaders returns the copy of the response headers cached with the record. The
+// middlewares pass the live headers of the response writer, so they are copied once
+// here, with their values in a single allocation, and only for cached responses.
+func (m *Manager) cachedHeaders(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+
+	count := 0
+	for _, values := range headers {
+		count += len(values)
+	}
+	all := make([]string, 0, count)
+
+	cached := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if m.uncached[canonical] || m.cacheOnly != nil && !m.cacheOnly[canonical] {
+			continue
+		}
+		all = append(all, values...)
+		cached[name] = all[len(all)-len(values) : len(all) : len(all)]
+	}
+	return cached
+}
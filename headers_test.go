@@ -0,0 +1,49 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestManager_CachedHeaders(t *testing.T) {
+	headers := func() map[string][]string {
+		return map[string][]string{
+			"Location":     {"/orders/1"},
+			"Set-Cookie":   {"session=secret"},
+			"Date":         {"Mon, 01 Jan 2024 00:00:00 GMT"},
+			"X-Request-Id": {"req-1"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{"All", Config{}, []string{"Location", "Set-Cookie", "Date", "X-Request-Id"}},
+		{"Strip", Config{StripHeaders: append(SensitiveHeaders(), PerRequestHeaders()...)}, []string{"Location"}},
+		{"Allowlist", Config{CacheHeaders: []string{"location", "set-cookie"}}, []string{"Location", "Set-Cookie"}},
+		{"AllowlistAndStrip", Config{CacheHeaders: []string{"Location", "Set-Cookie"}, StripHeaders: SensitiveHeaders()}, []string{"Location"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newListingStorage()
+			tt.config.Storage = storage
+			m, _ := NewManager(tt.config)
+			if err := m.Store(context.Background(), "k1", &Response{StatusCode: http.StatusCreated, Headers: headers()}); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+
+			cached := storage.records["k1"].Response.Headers
+			if len(cached) != len(tt.want) {
+				t.Errorf("expected headers %v to be cached, got %v", tt.want, cached)
+			}
+			for _, name := range tt.want {
+				if _, ok := cached[name]; !ok {
+					t.Errorf("expected %s to be cached, got %v", name, cached)
+				}
+			}
+		})
+	}
+}
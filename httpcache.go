@@ -0,0 +1,89 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// notModifiedHeaders are the headers kept on a 304 Not Modified replay (RFC 9110)
+var notModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Vary"}
+
+// Replay returns the response to send for a replay of resp given the request headers.
+// With Config.HTTPCaching enabled, a request whose If-None-Match matches the ETag of the
+// cached response (see CachedResponse.ETag) gets a bodiless 304 Not Modified instead;
+// otherwise resp is returned.
+func (m *Manager) Replay(resp *CachedResponse, reqHeaders map[string][]string) *CachedResponse {
+	if !m.config.HTTPCaching || resp == nil {
+		return resp
+	}
+
+	etag := resp.ETag
+	if etag == "" {
+		etag = textproto.MIMEHeader(resp.Headers).Get("ETag")
+	}
+	ifNoneMatch := textproto.MIMEHeader(reqHeaders).Get("If-None-Match")
+	if etag == "" || ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag) {
+		return resp
+	}
+
+	headers := make(map[string][]string)
+	for _, name := range notModifiedHeaders {
+		if values := textproto.MIMEHeader(resp.Headers).Values(name); len(values) > 0 {
+			headers[name] = values
+		}
+	}
+	headers["ETag"] = []string{etag}
+
+	return &CachedResponse{
+		StatusCode:  http.StatusNotModified,
+		Headers:     headers,
+		CompletedAt: resp.CompletedAt,
+		Region:      resp.Region,
+	}
+}
+
+// cacheHeaders returns the HTTP caching headers added to replays of resp:
+// Age since the original completion, the ETag of the cached response when the original
+// response did not set one, and Cache-Control "no-store" when the original response did
+// not set one, since replays are specific to the client holding the key
+func (m *Manager) cacheHeaders(resp *CachedResponse) map[string]string {
+	headers := make(map[string]string)
+	if !resp.CompletedAt.IsZero() {
+		age := int(m.now().Sub(resp.CompletedAt).Seconds())
+		headers["Age"] = strconv.Itoa(max(age, 0))
+	}
+	if resp.ETag != "" && textproto.MIMEHeader(resp.Headers).Get("ETag") == "" {
+		headers["ETag"] = resp.ETag
+	}
+	if textproto.MIMEHeader(resp.Headers).Get("Cache-Control") == "" {
+		headers["Cache-Control"] = "no-store"
+	}
+	return headers
+}
+
+// responseETag returns the ETag header of resp, or a strong entity tag hashing its body
+func responseETag(resp *CachedResponse) string {
+	if etag := textproto.MIMEHeader(resp.Headers).Get("ETag"); etag != "" {
+		return etag
+	}
+	sum := sha256.Sum256(resp.Body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag (weak comparison)
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		etag        string
+		want        bool
+	}{
+		{name: "exact", ifNoneMatch: `"abc"`, etag: `"abc"`, want: true},
+		{name: "wildcard", ifNoneMatch: "*", etag: `"abc"`, want: true},
+		{name: "weak request", ifNoneMatch: `W/"abc"`, etag: `"abc"`, want: true},
+		{name: "weak stored", ifNoneMatch: `"abc"`, etag: `W/"abc"`, want: true},
+		{name: "list", ifNoneMatch: `"x", "abc"`, etag: `"abc"`, want: true},
+		{name: "different", ifNoneMatch: `"x"`, etag: `"abc"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.ifNoneMatch, tt.etag); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestManager_Replay_HTTPCaching(t *testing.T) {
+	resp := &CachedResponse{
+		StatusCode: http.StatusCreated,
+		Headers: map[string][]string{
+			"Etag":     {`"v1"`},
+			"X-Custom": {"value"},
+		},
+		Body:        []byte("created"),
+		CompletedAt: time.Now().Add(-30 * time.Second),
+	}
+	matching := map[string][]string{"If-None-Match": {`"v1"`}}
+
+	t.Run("disabled", func(t *testing.T) {
+		m, _ := NewManager(Config{Storage: &MockStorage{}})
+		if got := m.Replay(resp, matching); got != resp {
+			t.Fatal("expected the cached response when HTTPCaching is disabled")
+		}
+		if _, ok := m.ReplayHeaders(resp)["Age"]; ok {
+			t.Fatal("expected no Age header when HTTPCaching is disabled")
+		}
+	})
+
+	m, _ := NewManager(Config{Storage: &MockStorage{}, HTTPCaching: true})
+
+	t.Run("not modified", func(t *testing.T) {
+		got := m.Replay(resp, matching)
+		if got.StatusCode != http.StatusNotModified {
+			t.Fatalf("expected 304, got %d", got.StatusCode)
+		}
+		if len(got.Body) != 0 {
+			t.Fatal("expected no body on 304")
+		}
+		if got.Headers["ETag"][0] != `"v1"` {
+			t.Fatalf("expected ETag to be kept, got %v", got.Headers)
+		}
+		if _, ok := got.Headers["X-Custom"]; ok {
+			t.Fatal("expected non-validator headers to be dropped")
+		}
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		got := m.Replay(resp, map[string][]string{"If-None-Match": {`"v0"`}})
+		if got != resp {
+			t.Fatal("expected the cached response for a different ETag")
+		}
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		headers := m.ReplayHeaders(resp)
+		if headers["Age"] != "30" {
+			t.Errorf("expected Age 30, got %q", headers["Age"])
+		}
+		if headers["Cache-Control"] != "no-store" {
+			t.Errorf("expected default Cache-Control no-store, got %q", headers["Cache-Control"])
+		}
+
+		withCacheControl := *resp
+		withCacheControl.Headers = map[string][]string{"Cache-Control": {"max-age=60"}}
+		if _, ok := m.ReplayHeaders(&withCacheControl)["Cache-Control"]; ok {
+			t.Error("expected the original Cache-Control to be kept")
+		}
+	})
+}
+
+func TestManager_Replay_GeneratedETag(t *testing.T) {
+	storage := newListingStorage()
+	m, _ := NewManager(Config{Storage: storage, HTTPCaching: true})
+	ctx := context.Background()
+
+	req := &Request{Method: "POST", Path: "/orders", Body: []byte("{}"), IdempotencyKey: "etag-key"}
+	if _, err := m.Check(ctx, req); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if err := m.Lock(ctx, req); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	body := []byte(`{"id":1}`)
+	if err := m.Store(ctx, "etag-key", &Response{StatusCode: http.StatusCreated, Body: body}); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	record, _ := storage.Get(ctx, "etag-key")
+	etag := record.Response.ETag
+	if etag == "" {
+		t.Fatal("expected an ETag to be stored")
+	}
+
+	again := &Request{Method: "POST", Path: "/orders", Body: []byte("{}"), IdempotencyKey: "etag-key"}
+	cached, err := m.Check(ctx, again)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if got := m.ReplayHeaders(cached)["ETag"]; got != etag {
+		t.Fatalf("expected replay ETag %q, got %q", etag, got)
+	}
+
+	got := m.Replay(cached, map[string][]string{"If-None-Match": {etag}})
+	if got.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", got.StatusCode)
+	}
+	if got.Headers["ETag"][0] != etag {
+		t.Fatalf("expected ETag on 304, got %v", got.Headers)
+	}
+
+	if got := m.Replay(cached, nil); string(got.Body) != string(body) {
+		t.Fatalf("expected full replay without If-None-Match, got %q", got.Body)
+	}
+}
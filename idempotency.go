@@ -67,4 +67,19 @@ const (
 
 	// DefaultHeaderName is the default header name for idempotency keys
 	DefaultHeaderName = "Idempotency-Key"
+
+	// ReplayedHeaderName is the response header set to "true" on replayed responses
+	ReplayedHeaderName = "X-Idempotent-Replayed"
+
+	// OriginalTimestampHeaderName is the response header carrying, on replayed responses,
+	// the time (RFC 3339) at which the original request completed
+	OriginalTimestampHeaderName = "X-Idempotency-Original-Timestamp"
+
+	// OriginRegionHeaderName is the response header carrying, on replayed responses,
+	// the region that processed the original request (see Config.Region)
+	OriginRegionHeaderName = "X-Idempotency-Origin-Region"
+
+	// ExpiredKeyHeaderName is the response header set to "true" on requests processed
+	// again because their key was reused after the record expired (see ExpiredKeyWarn)
+	ExpiredKeyHeaderName = "X-Idempotency-Expired-Key"
 )
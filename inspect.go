@@ -0,0 +1,131 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// KeyInfo describes the state of an idempotency key, as returned by Inspect
+type KeyInfo struct {
+	// Key is the idempotency key as stored (including any API version suffix)
+	Key string
+
+	// Status is the status of the record
+	Status RecordStatus
+
+	// RequestHash is the hash of the request that created the record
+	RequestHash string
+
+	// CreatedAt is when the record was created
+	CreatedAt time.Time
+
+	// ExpiresAt is when the record expires
+	ExpiresAt time.Time
+
+	// CompletedAt is when the request completed (zero while pending or failed)
+	CompletedAt time.Time
+
+	// Replays is the number of replays, counted with Config.TrackReplays
+	Replays int
+
+	// Error describes why processing failed if status is failed
+	Error string
+
+	// StatusCode is the status code of the cached response (zero without one)
+	StatusCode int
+
+	// Headers are the headers of the cached response
+	Headers map[string][]string
+
+	// ContentType is the content type of the cached response
+	ContentType string
+
+	// ETag is the entity tag of the cached response (see Config.HTTPCaching)
+	ETag string
+
+	// Region is the region that processed the request
+	Region string
+
+	// BodySize is the size in bytes of the cached response body
+	BodySize int
+
+	// Body is the cached response body, only set by InspectWithBody
+	Body []byte
+}
+
+// Inspect returns the state of the record for key, without the cached response body,
+// for support tooling and tests. key is the stored key, including the API version
+// suffix when Config.APIVersion is set. ErrKeyNotFound is returned when there is no
+// record or it expired.
+func (m *Manager) Inspect(ctx context.Context, key string) (*KeyInfo, error) {
+	return m.inspect(ctx, key, false)
+}
+
+// InspectWithBody is Inspect including the cached response body
+func (m *Manager) InspectWithBody(ctx context.Context, key string) (*KeyInfo, error) {
+	return m.inspect(ctx, key, true)
+}
+
+func (m *Manager) inspect(ctx context.Context, key string, withBody bool) (*KeyInfo, error) {
+	record, err := m.GetRecord(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	info := &KeyInfo{
+		Key:         record.Key,
+		Status:      record.Status,
+		RequestHash: record.RequestHash,
+		CreatedAt:   record.CreatedAt,
+		ExpiresAt:   record.ExpiresAt,
+		Replays:     record.Replays,
+		Error:       record.Error,
+	}
+	if resp := record.Response; resp != nil {
+		info.CompletedAt = resp.CompletedAt
+		info.StatusCode = resp.StatusCode
+		info.Headers = resp.Headers
+		info.ContentType = resp.ContentType
+		info.ETag = resp.ETag
+		info.Region = resp.Region
+		info.BodySize = len(resp.Body)
+		if withBody {
+			info.Body = resp.Body
+		}
+	}
+
+	return info, nil
+}
+
+// updateReplayed updates record in the storage after a replay: increments its replay
+// count with Config.TrackReplays and restarts its retention with
+// Config.RefreshTTLOnHit, unless its body is in the blob store, which expires it after
+// Config.TTL regardless, and returns its expiry. It is best effort: a failed update
+// does not fail the replay.
+func (m *Manager) updateReplayed(ctx context.Context, record *Record) time.Time {
+	ttl := record.ExpiresAt.Sub(m.now())
+	if record.ExpiresAt.IsZero() || ttl <= 0 {
+		return record.ExpiresAt
+	}
+
+	updated := *record
+	if m.config.TrackReplays {
+		updated.Replays++
+	}
+	if m.config.RefreshTTLOnHit && record.Status == StatusCompleted && !hasBlob(record) {
+		ttl = m.recordTTL(record)
+		updated.ExpiresAt = m.now().Add(ttl)
+	}
+	if err := m.storageSet(ctx, &updated, ttl); err != nil {
+		return record.ExpiresAt
+	}
+	return updated.ExpiresAt
+}
+
+// hasBlob reports whether the response body of record is stored in Config.BlobStore
+func hasBlob(record *Record) bool {
+	return record.Response != nil && record.Response.BlobKey != ""
+}
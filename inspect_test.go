@@ -0,0 +1,104 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestManager_Inspect(t *testing.T) {
+	ctx := context.Background()
+	storage := newListingStorage()
+	m, _ := NewManager(Config{Storage: storage, TrackReplays: true})
+
+	if _, err := m.Inspect(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	newReq := func() *Request {
+		return &Request{Method: "POST", Path: "/orders", Body: []byte(`{"n":1}`), IdempotencyKey: "inspect-key"}
+	}
+	req := newReq()
+	if _, err := m.Check(ctx, req); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if err := m.Lock(ctx, req); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	info, err := m.Inspect(ctx, "inspect-key")
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if info.Status != StatusPending || info.RequestHash == "" || info.CreatedAt.IsZero() {
+		t.Fatalf("unexpected pending info: %+v", info)
+	}
+
+	resp := &Response{StatusCode: http.StatusCreated, Body: []byte("created"), Headers: map[string][]string{"X-Id": {"1"}}}
+	if err := m.Store(ctx, "inspect-key", resp); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	for range 2 {
+		if cached, err := m.Check(ctx, newReq()); err != nil || cached == nil {
+			t.Fatalf("expected a replay, got %v, %v", cached, err)
+		}
+	}
+
+	info, err = m.Inspect(ctx, "inspect-key")
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if info.Status != StatusCompleted || info.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected completed info: %+v", info)
+	}
+	if info.Replays != 2 {
+		t.Errorf("expected 2 replays, got %d", info.Replays)
+	}
+	if info.BodySize != len("created") || info.Body != nil {
+		t.Errorf("expected body size only, got %d, %q", info.BodySize, info.Body)
+	}
+	if info.Headers["X-Id"][0] != "1" || info.CompletedAt.IsZero() {
+		t.Errorf("unexpected response metadata: %+v", info)
+	}
+
+	info, err = m.InspectWithBody(ctx, "inspect-key")
+	if err != nil || string(info.Body) != "created" {
+		t.Fatalf("expected the body, got %q, %v", info.Body, err)
+	}
+}
+
+func TestManager_RefreshTTLOnHit(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	storage := newListingStorage()
+	m, _ := NewManager(Config{Storage: storage, Clock: clock, TTL: time.Hour, RefreshTTLOnHit: true})
+
+	newReq := func() *Request {
+		return &Request{Method: "POST", Path: "/orders", Body: []byte(`{"n":1}`), IdempotencyKey: "hot"}
+	}
+	if _, err := m.Check(ctx, newReq()); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if err := m.Store(ctx, "hot", &Response{StatusCode: http.StatusCreated}); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	// Replays every 50 minutes keep the record alive beyond its TTL
+	for range 3 {
+		clock.Advance(50 * time.Minute)
+		if cached, err := m.Check(ctx, newReq()); err != nil || cached == nil {
+			t.Fatalf("expected a replay at %s, got %v, %v", clock.Now(), cached, err)
+		}
+	}
+	if got, want := storage.records["hot"].ExpiresAt, clock.Now().Add(time.Hour); !got.Equal(want) {
+		t.Errorf("expected the expiry to be refreshed to %s, got %s", want, got)
+	}
+
+	clock.Advance(2 * time.Hour)
+	if cached, _ := m.Check(ctx, newReq()); cached != nil {
+		t.Error("expected the record to expire once no longer replayed")
+	}
+}
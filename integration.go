@@ -0,0 +1,143 @@
+package idempotency
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ExtractRequest builds the Request of an http.Request for Begin or Check. The body is
+// read and replaced so the handler can still read it. IdempotencyKey is left empty, to be
+// set with Manager.KeyFromHeaders or generated by the key strategy.
+func ExtractRequest(r *http.Request) (*Request, error) {
+	req := &Request{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Headers: r.Header,
+	}
+
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		req.Body = body
+	}
+
+	return req, nil
+}
+
+// WriteCachedResponse writes a cached response, e.g. a replay, overriding its headers
+// with extra (see Manager.ReplayHeaders)
+func WriteCachedResponse(w http.ResponseWriter, resp *CachedResponse, extra map[string]string) {
+	for key, values := range resp.Headers {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	for key, value := range extra {
+		w.Header().Set(key, value)
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(resp.Body)
+}
+
+// ResponseRecorder is an http.ResponseWriter passing writes through to the wrapped writer
+// while recording the status code and body, to complete a Token with the response.
+// Flush and Hijack are passed through, marking the response as streaming.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	capture     *BodyCapture
+	buf         bytes.Buffer
+}
+
+// RecordResponse returns a ResponseRecorder wrapping w, to be passed to the handler
+func RecordResponse(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+// RecordResponseBody returns a ResponseRecorder wrapping w that writes the body to
+// capture (see Token.BodyCapture) instead of buffering it. Response then has no body.
+func RecordResponseBody(w http.ResponseWriter, capture *BodyCapture) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, statusCode: http.StatusOK, capture: capture}
+}
+
+// WriteHeader records and writes the status code
+func (r *ResponseRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.writeHeader()
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// writeHeader records that the header was written, detecting server-sent events
+func (r *ResponseRecorder) writeHeader() {
+	r.wroteHeader = true
+	if r.capture != nil && IsStreamingResponse(r.Header()) {
+		r.capture.Stream()
+	}
+}
+
+// Write records and writes data
+func (r *ResponseRecorder) Write(data []byte) (int, error) {
+	if !r.wroteHeader {
+		r.writeHeader()
+	}
+	if r.capture != nil {
+		_, _ = r.capture.Write(data)
+	} else {
+		r.buf.Write(data)
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// Flush flushes the wrapped writer if it supports it, marking the response as streaming
+func (r *ResponseRecorder) Flush() {
+	if r.capture != nil {
+		r.capture.Stream()
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hijacks the connection of the wrapped writer if it supports it. The response
+// is then not cached.
+func (r *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	if r.capture != nil {
+		r.capture.Discard()
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (r *ResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Written reports whether the handler wrote a status code or body
+func (r *ResponseRecorder) Written() bool {
+	return r.wroteHeader
+}
+
+// Response returns the recorded response
+func (r *ResponseRecorder) Response() *Response {
+	return &Response{
+		StatusCode:  r.statusCode,
+		Headers:     r.Header(),
+		Body:        r.buf.Bytes(),
+		ContentType: r.Header().Get("Content-Type"),
+	}
+}
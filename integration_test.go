@@ -0,0 +1,74 @@
+package idempotency
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIntegrationHelpers(t *testing.T) {
+	manager, err := NewManager(Config{Storage: newListingStorage()})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(append([]byte("created "), body...))
+	})
+
+	// A minimal adapter built on the helpers
+	adapter := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := ExtractRequest(r)
+		if err != nil {
+			t.Fatalf("ExtractRequest failed: %v", err)
+		}
+		req.IdempotencyKey = manager.KeyFromHeaders(req.Headers)
+		outcome, err := manager.Begin(r.Context(), req)
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		switch outcome.Kind {
+		case OutcomeReplay:
+			WriteCachedResponse(w, outcome.Response, manager.ReplayHeaders(outcome.Response))
+			return
+		case OutcomeConflict:
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		recorder := RecordResponse(w)
+		handler.ServeHTTP(recorder, r)
+		if !recorder.Written() {
+			t.Error("expected recorder to report the handler response")
+		}
+		_ = outcome.Token.Complete(recorder.Response())
+	})
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("order"))
+		r.Header.Set(DefaultHeaderName, "key")
+		w := httptest.NewRecorder()
+		adapter.ServeHTTP(w, r)
+
+		if w.Code != http.StatusCreated || w.Body.String() != "created order" {
+			t.Errorf("request %d: expected 201 \"created order\", got %d %q", i, w.Code, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("request %d: expected cached Content-Type, got %q", i, w.Header().Get("Content-Type"))
+		}
+		if replayed := w.Header().Get(ReplayedHeaderName) == "true"; replayed != (i == 1) {
+			t.Errorf("request %d: unexpected replay header %q", i, w.Header().Get(ReplayedHeaderName))
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected handler to run once, ran %d times", calls)
+	}
+}
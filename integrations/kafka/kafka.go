@@ -0,0 +1,106 @@
+// Package kafka makes Kafka consumers idempotent: the side effects of each message run
+// once, even when it is redelivered after a rebalance or a crash before its offset was
+// committed.
+//
+// The package depends on a minimal Message struct instead of a specific client.
+// With IBM/sarama, in ConsumeClaim:
+//
+//	handle := kafka.Wrap(manager, processOrder, kafka.Options{})
+//	for m := range claim.Messages() {
+//		msg := &kafka.Message{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Key: m.Key, Value: m.Value}
+//		if err := handle(session.Context(), msg); err != nil {
+//			return err
+//		}
+//		session.MarkMessage(m, "")
+//	}
+//
+// With twmb/franz-go:
+//
+//	fetches.EachRecord(func(r *kgo.Record) {
+//		msg := &kafka.Message{Topic: r.Topic, Partition: r.Partition, Offset: r.Offset, Key: r.Key, Value: r.Value}
+//		_ = handle(ctx, msg)
+//	})
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Message is a consumed Kafka message
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string][]byte
+}
+
+// Handler processes a message
+type Handler func(ctx context.Context, msg *Message) error
+
+// KeyFunc derives the idempotency key of a message, "" to process it without
+// idempotency
+type KeyFunc func(msg *Message) string
+
+// MessageKey derives keys from the topic, partition and key of the message. Use it when
+// the message key identifies the message (e.g. an event ID): messages sharing a key,
+// such as successive updates of an entity, would be processed only once.
+// Messages without key get no idempotency key.
+func MessageKey(msg *Message) string {
+	if len(msg.Key) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d/%s", msg.Topic, msg.Partition, msg.Key)
+}
+
+// OffsetKey derives keys from the topic, partition and offset of the message, so only
+// redeliveries of the same message are deduplicated
+func OffsetKey(msg *Message) string {
+	return fmt.Sprintf("%s/%d@%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// HeaderKey derives keys from a header set by the producer (e.g. "Idempotency-Key"),
+// scoped by topic, so messages produced twice by a retrying producer are processed once
+func HeaderKey(name string) KeyFunc {
+	return func(msg *Message) string {
+		value := msg.Headers[name]
+		if len(value) == 0 {
+			return ""
+		}
+		return msg.Topic + "/" + string(value)
+	}
+}
+
+// Options configures Wrap
+type Options struct {
+	// KeyFunc derives the idempotency key of a message
+	// Default: MessageKey
+	KeyFunc KeyFunc
+}
+
+// Wrap returns a Handler running handler at most once per idempotency key with
+// Manager.Execute. Messages already processed return nil without running handler, so
+// their offset can be committed. When handler fails, the key is released and the error
+// returned so the message can be retried. Messages being processed by another consumer
+// return idempotency.ErrRequestInProgress (see Config.WaitForResult).
+func Wrap(manager *idempotency.Manager, handler Handler, opts Options) Handler {
+	keyFunc := opts.KeyFunc
+	if keyFunc == nil {
+		keyFunc = MessageKey
+	}
+
+	return func(ctx context.Context, msg *Message) error {
+		key := keyFunc(msg)
+		if key == "" {
+			return handler(ctx, msg)
+		}
+		_, err := manager.Execute(ctx, key, func(ctx context.Context) (*idempotency.Response, error) {
+			return nil, handler(ctx, msg)
+		})
+		return err
+	}
+}
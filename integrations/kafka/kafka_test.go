@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+func TestWrap(t *testing.T) {
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store})
+	ctx := context.Background()
+
+	calls := 0
+	fail := true
+	handle := Wrap(manager, func(ctx context.Context, msg *Message) error {
+		calls++
+		if fail {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	}, Options{})
+	msg := &Message{Topic: "orders", Partition: 3, Offset: 10, Key: []byte("evt-1")}
+
+	if err := handle(ctx, msg); err == nil {
+		t.Fatal("expected the handler error to be returned")
+	}
+	fail = false
+	if err := handle(ctx, msg); err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	// Redelivery after a rebalance
+	if err := handle(ctx, &Message{Topic: "orders", Partition: 3, Offset: 10, Key: []byte("evt-1")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the redelivered message to be skipped, handler called %d times", calls)
+	}
+
+	// Messages without key are always processed
+	_ = handle(ctx, &Message{Topic: "orders"})
+	_ = handle(ctx, &Message{Topic: "orders"})
+	if calls != 4 {
+		t.Errorf("expected messages without key to be processed, handler called %d times", calls)
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	msg := &Message{Topic: "orders", Partition: 1, Offset: 42, Key: []byte("evt-1"), Headers: map[string][]byte{"Idempotency-Key": []byte("abc")}}
+
+	if got := MessageKey(msg); got != "orders/1/evt-1" {
+		t.Errorf("unexpected message key %q", got)
+	}
+	if got := OffsetKey(msg); got != "orders/1@42" {
+		t.Errorf("unexpected offset key %q", got)
+	}
+	if got := HeaderKey("Idempotency-Key")(msg); got != "orders/abc" {
+		t.Errorf("unexpected header key %q", got)
+	}
+	if got := HeaderKey("X-Missing")(msg); got != "" {
+		t.Errorf("expected no key without header, got %q", got)
+	}
+}
@@ -0,0 +1,174 @@
+// Package lambda makes AWS Lambda handlers idempotent, storing their results in any
+// idempotency.Storage (e.g. DynamoDB through the kv storage, or Redis):
+//
+//	import idemlambda "github.com/fco-gt/gopotency/integrations/lambda"
+//
+//	lambda.Start(idemlambda.SQSHandler(manager, func(ctx context.Context, msg events.SQSMessage) error {
+//		return billing.SendInvoice(ctx, msg.Body)
+//	}))
+//
+//	lambda.Start(idemlambda.APIGatewayHandler(manager, createOrder))
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/textproto"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/internal/engine"
+)
+
+// SQSHandler returns an SQS handler running handler at most once per message ID with
+// Manager.Execute, so messages delivered again (SQS is at-least-once) are skipped once
+// processed. Failed messages, and messages being processed by another invocation, are
+// reported as batch item failures to be redelivered: enable ReportBatchItemFailures on
+// the event source mapping.
+func SQSHandler(manager *idempotency.Manager, handler func(ctx context.Context, msg events.SQSMessage) error) func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	return func(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+		var resp events.SQSEventResponse
+		for _, msg := range event.Records {
+			_, err := manager.Execute(ctx, "sqs/"+msg.MessageId, func(ctx context.Context) (*idempotency.Response, error) {
+				return nil, handler(ctx, msg)
+			})
+			if err != nil {
+				resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: msg.MessageId})
+			}
+		}
+		return resp, nil
+	}
+}
+
+// APIGatewayHandler returns an API Gateway (REST, proxy integration) handler applying
+// idempotency to handler like the HTTP middlewares: requests are keyed by the
+// idempotency key header or Config.KeyStrategy, responses replayed to retries and
+// duplicates in progress answered with 409.
+func APIGatewayHandler(manager *idempotency.Manager, handler func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)) func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		s := &shim{ctx: ctx, req: req, handler: handler}
+		err := engine.Run(manager, nil, s)
+		for name, value := range s.headers {
+			if s.resp.Headers == nil {
+				s.resp.Headers = make(map[string]string)
+			}
+			s.resp.Headers[name] = value
+		}
+		return s.resp, err
+	}
+}
+
+// shim adapts API Gateway events to the idempotency engine
+type shim struct {
+	ctx     context.Context
+	req     events.APIGatewayProxyRequest
+	handler func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+	resp    events.APIGatewayProxyResponse
+	headers map[string]string
+}
+
+func (s *shim) Context() context.Context {
+	return s.ctx
+}
+
+func (s *shim) Request(req *idempotency.Request) {
+	req.Method = s.req.HTTPMethod
+	req.Path = s.req.Path
+	req.Query = url.Values(s.req.MultiValueQueryStringParameters).Encode()
+
+	// Headers are canonicalized like net/http, API Gateway forwards them as sent
+	req.Headers = make(map[string][]string)
+	for name, values := range s.req.MultiValueHeaders {
+		req.Headers[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+	for name, value := range s.req.Headers {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if _, ok := req.Headers[name]; !ok {
+			req.Headers[name] = []string{value}
+		}
+	}
+}
+
+func (s *shim) ReadBody() ([]byte, error) {
+	if s.req.IsBase64Encoded {
+		return base64.StdEncoding.DecodeString(s.req.Body)
+	}
+	return []byte(s.req.Body), nil
+}
+
+func (s *shim) Skip() error {
+	var err error
+	s.resp, err = s.handler(s.ctx, s.req)
+	return err
+}
+
+func (s *shim) Next(body *idempotency.BodyCapture) (*idempotency.Response, error) {
+	if err := s.Skip(); err != nil {
+		return nil, err
+	}
+
+	data := []byte(s.resp.Body)
+	if s.resp.IsBase64Encoded {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(s.resp.Body); err != nil {
+			return nil, err
+		}
+	}
+	_, _ = body.Write(data)
+
+	headers := make(map[string][]string)
+	for name, values := range s.resp.MultiValueHeaders {
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+	for name, value := range s.resp.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = []string{value}
+	}
+	return &idempotency.Response{
+		StatusCode:  s.resp.StatusCode,
+		Headers:     headers,
+		ContentType: textproto.MIMEHeader(headers).Get("Content-Type"),
+	}, nil
+}
+
+func (s *shim) Header(name, value string) {
+	if s.headers == nil {
+		s.headers = make(map[string]string)
+	}
+	s.headers[name] = value
+}
+
+func (s *shim) Write(resp *idempotency.CachedResponse, extra map[string]string) error {
+	s.resp = events.APIGatewayProxyResponse{
+		StatusCode:        resp.StatusCode,
+		MultiValueHeaders: make(map[string][]string),
+	}
+	for name, values := range resp.Headers {
+		s.resp.MultiValueHeaders[name] = values
+	}
+	for name, value := range extra {
+		s.resp.MultiValueHeaders[name] = []string{value}
+	}
+
+	if utf8.Valid(resp.Body) {
+		s.resp.Body = string(resp.Body)
+	} else {
+		s.resp.Body = base64.StdEncoding.EncodeToString(resp.Body)
+		s.resp.IsBase64Encoded = true
+	}
+	return nil
+}
+
+func (s *shim) Error(statusCode int, message string) error {
+	var body bytes.Buffer
+	_ = json.NewEncoder(&body).Encode(map[string]string{"error": message})
+	s.resp = events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       body.String(),
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package lambda
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+func newManager(t *testing.T) *idempotency.Manager {
+	store := memory.NewMemoryStorage()
+	t.Cleanup(func() { store.Close() })
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store})
+	return manager
+}
+
+func TestSQSHandler(t *testing.T) {
+	calls := map[string]int{}
+	handle := SQSHandler(newManager(t), func(ctx context.Context, msg events.SQSMessage) error {
+		calls[msg.MessageId]++
+		if msg.Body == "poison" {
+			return errors.New("invalid message")
+		}
+		return nil
+	})
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		{MessageId: "m1", Body: "ok"},
+		{MessageId: "m2", Body: "poison"},
+	}}
+
+	for range 2 {
+		resp, err := handle(context.Background(), event)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(resp.BatchItemFailures) != 1 || resp.BatchItemFailures[0].ItemIdentifier != "m2" {
+			t.Errorf("expected m2 to be reported as failed, got %+v", resp.BatchItemFailures)
+		}
+	}
+	if calls["m1"] != 1 || calls["m2"] != 2 {
+		t.Errorf("expected processed messages to be skipped and failed ones retried, got %v", calls)
+	}
+}
+
+func TestAPIGatewayHandler(t *testing.T) {
+	calls := 0
+	handle := APIGatewayHandler(newManager(t), func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		calls++
+		return events.APIGatewayProxyResponse{
+			StatusCode: 201,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"order_id":"ORD-1"}`,
+		}, nil
+	})
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Path:       "/orders",
+		Headers:    map[string]string{"idempotency-key": "k1"},
+		Body:       `{"item":1}`,
+	}
+
+	first, err := handle(context.Background(), req)
+	if err != nil || first.StatusCode != 201 {
+		t.Fatalf("unexpected response %+v, %v", first, err)
+	}
+	replay, err := handle(context.Background(), req)
+	if err != nil || replay.StatusCode != 201 || replay.Body != first.Body || calls != 1 {
+		t.Fatalf("expected a replay, got %+v after %d calls", replay, calls)
+	}
+	if replay.MultiValueHeaders[idempotency.ReplayedHeaderName] == nil {
+		t.Errorf("expected the replay header, got %v", replay.MultiValueHeaders)
+	}
+
+	req.Body = `{"item":2}`
+	if mismatch, _ := handle(context.Background(), req); mismatch.StatusCode != 422 {
+		t.Errorf("expected 422 for a reused key, got %d", mismatch.StatusCode)
+	}
+}
@@ -0,0 +1,80 @@
+// Package nats makes NATS JetStream consumers idempotent: the side effects of each
+// message run once, even when it is redelivered because its ack was lost or timed out.
+//
+// The package depends on a minimal Msg interface instead of a specific client, which
+// jetstream.Msg satisfies:
+//
+//	handle := nats.Wrap(manager, func(ctx context.Context, msg jetstream.Msg) error {
+//		return billing.SendInvoice(ctx, msg.Data())
+//	}, nats.Options{})
+//	consumer.Consume(func(msg jetstream.Msg) { _ = handle(ctx, msg) })
+package nats
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// MsgIDHeader is the header JetStream publishers set to deduplicate messages
+const MsgIDHeader = "Nats-Msg-Id"
+
+// Msg is the part of a JetStream message used by Wrap. H is the header type of the
+// client (nats.Header).
+type Msg[H ~map[string][]string] interface {
+	Headers() H
+	Ack() error
+	Nak() error
+}
+
+// Options configures Wrap
+type Options struct {
+	// Header is the message header carrying the idempotency key
+	// Default: MsgIDHeader
+	Header string
+}
+
+// Wrap returns a handler running handler at most once per idempotency key with
+// Manager.Execute, and acknowledging messages itself: processed messages are acked, as
+// are redelivered messages whose record is completed, immediately and without running
+// handler. When handler fails, the key is released and the message nacked so it is
+// redelivered; so are messages being processed by another consumer. The error is
+// returned in both cases. Messages without key are processed without idempotency.
+func Wrap[H ~map[string][]string, M Msg[H]](manager *idempotency.Manager, handler func(ctx context.Context, msg M) error, opts Options) func(ctx context.Context, msg M) error {
+	header := opts.Header
+	if header == "" {
+		header = MsgIDHeader
+	}
+
+	return func(ctx context.Context, msg M) error {
+		key := headerValue(msg.Headers(), header)
+		var err error
+		if key == "" {
+			err = handler(ctx, msg)
+		} else {
+			_, err = manager.Execute(ctx, key, func(ctx context.Context) (*idempotency.Response, error) {
+				return nil, handler(ctx, msg)
+			})
+		}
+
+		if err != nil {
+			return errors.Join(err, msg.Nak())
+		}
+		return msg.Ack()
+	}
+}
+
+// headerValue returns the first value of the header name, looked up as given and in its
+// canonical MIME form
+func headerValue(headers map[string][]string, name string) string {
+	values, ok := headers[name]
+	if !ok {
+		values = headers[textproto.CanonicalMIMEHeaderKey(name)]
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
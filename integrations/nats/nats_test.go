@@ -0,0 +1,78 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+// header mimics nats.Header
+type header map[string][]string
+
+// jsMsg mimics the jetstream.Msg interface
+type jsMsg interface {
+	Headers() header
+	Ack() error
+	Nak() error
+}
+
+type fakeMsg struct {
+	headers    header
+	acks, naks int
+}
+
+func (m *fakeMsg) Headers() header { return m.headers }
+func (m *fakeMsg) Ack() error      { m.acks++; return nil }
+func (m *fakeMsg) Nak() error      { m.naks++; return nil }
+
+func TestWrap(t *testing.T) {
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store})
+	ctx := context.Background()
+
+	calls := 0
+	fail := true
+	handle := Wrap(manager, func(ctx context.Context, msg jsMsg) error {
+		calls++
+		if fail {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	}, Options{})
+	msg := &fakeMsg{headers: header{MsgIDHeader: {"evt-1"}}}
+
+	if err := handle(ctx, msg); err == nil || msg.naks != 1 || msg.acks != 0 {
+		t.Fatalf("expected the failed message to be nacked, got %v (%d naks)", err, msg.naks)
+	}
+	fail = false
+	if err := handle(ctx, msg); err != nil || msg.acks != 1 {
+		t.Fatalf("expected the retry to be acked, got %v (%d acks)", err, msg.acks)
+	}
+
+	// Redelivery of a completed message
+	redelivered := &fakeMsg{headers: header{MsgIDHeader: {"evt-1"}}}
+	if err := handle(ctx, redelivered); err != nil || redelivered.acks != 1 {
+		t.Fatalf("expected the redelivered message to be acked, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the redelivered message to be skipped, handler called %d times", calls)
+	}
+
+	t.Run("CustomHeader", func(t *testing.T) {
+		handle := Wrap(manager, func(ctx context.Context, msg jsMsg) error {
+			calls++
+			return nil
+		}, Options{Header: "idempotency-key"})
+
+		for range 2 {
+			_ = handle(ctx, &fakeMsg{headers: header{"Idempotency-Key": {"evt-2"}}})
+		}
+		if calls != 3 {
+			t.Errorf("expected the key to be read from the custom header, handler called %d times", calls)
+		}
+	})
+}
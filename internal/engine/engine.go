@@ -0,0 +1,194 @@
+// Package engine implements the idempotency flow shared by the framework adapters in
+// middleware/: key extraction, check, lock, replay and storage of the response.
+// Adapters only provide a Shim translating between their framework and the engine, so
+// new behavior lands in every adapter at once.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Shim adapts a web framework request to the engine
+type Shim interface {
+	// Context returns the request context
+	Context() context.Context
+
+	// Request fills req with the method, path, query and headers of the request
+	Request(req *idempotency.Request)
+
+	// ReadBody reads the request body, leaving it readable by the handler
+	ReadBody() ([]byte, error)
+
+	// Skip runs the handler without idempotency handling
+	Skip() error
+
+	// Next runs the handler, writing its response body to body, and returns its captured
+	// status code and headers. Streaming responses must be reported with body.Stream.
+	Next(body *idempotency.BodyCapture) (*idempotency.Response, error)
+
+	// Header sets a response header before the handler runs
+	Header(name, value string)
+
+	// Write writes a cached response, overriding its headers with extra
+	Write(resp *idempotency.CachedResponse, extra map[string]string) error
+
+	// Error writes an error message with the given status code
+	Error(statusCode int, message string) error
+}
+
+// Option configures the middleware of an adapter
+type Option func(*options)
+
+type options struct {
+	include, exclude []string
+}
+
+// WithPaths restricts the middleware to the request paths matching one of patterns,
+// in the syntax of Config.IncludePaths
+func WithPaths(patterns ...string) Option {
+	return func(o *options) { o.include = append(o.include, patterns...) }
+}
+
+// SkipPaths passes the requests whose path matches one of patterns straight to the
+// handler, in the syntax of Config.ExcludePaths
+func SkipPaths(patterns ...string) Option {
+	return func(o *options) { o.exclude = append(o.exclude, patterns...) }
+}
+
+// Paths returns the path filter of opts, nil without path options. Like
+// regexp.MustCompile, it panics on an invalid pattern, as the adapters build their
+// middleware without returning errors.
+func Paths(opts []Option) *idempotency.PathFilter {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.include) == 0 && len(o.exclude) == 0 {
+		return nil
+	}
+	paths, err := idempotency.NewPathFilter(o.include, o.exclude)
+	if err != nil {
+		panic(fmt.Sprintf("idempotency: invalid middleware path pattern: %v", err))
+	}
+	return paths
+}
+
+// Run handles the request of shim with manager, for the request paths selected by both
+// the manager and paths. The returned error is the one of the handler or of the shim
+// writes.
+func Run(manager *idempotency.Manager, paths *idempotency.PathFilter, shim Shim) error {
+	messages := manager.Config().Messages
+	req := requestPool.Get().(*idempotency.Request)
+	defer releaseRequest(req)
+	shim.Request(req)
+
+	// Protocol upgrades (e.g. WebSocket) hijack the connection, skip them entirely, like
+	// the paths idempotency does not apply to
+	if idempotency.IsUpgradeRequest(req.Headers) || !manager.IsPathIncluded(req.Path) || !paths.Match(req.Path) {
+		return shim.Skip()
+	}
+
+	// Without header key, only allowed requests may get one from the key strategy
+	req.IdempotencyKey = manager.KeyFromHeaders(req.Headers)
+	if req.IdempotencyKey == "" && !manager.IsRequestAllowed(req) {
+		return shim.Skip()
+	}
+
+	body, err := shim.ReadBody()
+	if err != nil {
+		return writeError(manager, shim, fmt.Errorf("%w: %v", idempotency.ErrInvalidBody, err), http.StatusBadRequest, messages.InvalidBody)
+	}
+	req.Body = body
+
+	outcome, err := manager.Begin(shim.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, idempotency.ErrRequestMismatch):
+			return writeError(manager, shim, err, http.StatusUnprocessableEntity, messages.RequestMismatch)
+		case errors.Is(err, idempotency.ErrNoIdempotencyKey):
+			return writeError(manager, shim, err, http.StatusBadRequest, messages.KeyRequired)
+		case errors.Is(err, idempotency.ErrQuotaExceeded):
+			return writeError(manager, shim, err, http.StatusTooManyRequests, messages.QuotaExceeded)
+		case errors.Is(err, idempotency.ErrKeyExpired):
+			return writeError(manager, shim, err, http.StatusUnprocessableEntity, messages.KeyExpired)
+		case manager.Config().FailClosed:
+			return writeError(manager, shim, err, http.StatusServiceUnavailable, messages.StorageUnavailable)
+		default:
+			// Storage unavailable: proceed without idempotency
+			return shim.Skip()
+		}
+	}
+
+	switch outcome.Kind {
+	case idempotency.OutcomeReplay:
+		replay := manager.Replay(outcome.Response, req.Headers)
+		return shim.Write(replay, manager.ReplayHeaders(replay))
+	case idempotency.OutcomeConflict:
+		if accepted := manager.AcceptedResponse(req.IdempotencyKey); accepted != nil {
+			return shim.Write(accepted, nil)
+		}
+		if retryAfter := manager.RetryAfterHeader(shim.Context(), req.IdempotencyKey); retryAfter != "" {
+			shim.Header("Retry-After", retryAfter)
+		}
+		return writeError(manager, shim, idempotency.ErrRequestInProgress, http.StatusConflict, messages.RequestInProgress)
+	}
+
+	if outcome.Token.Key() == "" {
+		return shim.Skip()
+	}
+
+	for name, value := range outcome.Headers {
+		shim.Header(name, value)
+	}
+
+	resp, err := next(shim, outcome.Token)
+	if err != nil {
+		// The framework writes the error after the middleware returns, so the request
+		// is failed to let it be retried instead of caching an incomplete response
+		_ = outcome.Token.Fail(err)
+		return err
+	}
+	_ = outcome.Token.Complete(resp)
+	return nil
+}
+
+// next runs the handler through shim, failing token before resuming a panic so the
+// lock, its renewal and the pending quota slot are released even when the panic is
+// recovered by the framework
+func next(shim Shim, token *idempotency.Token) (*idempotency.Response, error) {
+	defer func() {
+		if r := recover(); r != nil {
+			_ = token.Fail(fmt.Errorf("panic: %v", r))
+			panic(r)
+		}
+	}()
+	return shim.Next(token.BodyCapture())
+}
+
+// writeError writes the idempotency error err, rendered by Config.ErrorHandler or as
+// problem details with Config.ProblemDetails
+func writeError(manager *idempotency.Manager, shim Shim, err error, statusCode int, message string) error {
+	if config := manager.Config(); config.ProblemDetails || config.ErrorHandler != nil {
+		return shim.Write(manager.HandleError(err, statusCode, message), nil)
+	}
+	return shim.Error(statusCode, message)
+}
+
+// requestPool recycles the requests built by Run, which do not outlive it. Records and
+// responses are not pooled: storages such as memory and tiered keep the records they
+// are given, and replayed records are shared with the callers of Get, so neither can be
+// reused once the request returns.
+var requestPool = sync.Pool{
+	New: func() any { return new(idempotency.Request) },
+}
+
+func releaseRequest(req *idempotency.Request) {
+	*req = idempotency.Request{}
+	requestPool.Put(req)
+}
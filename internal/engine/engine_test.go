@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+// fakeShim records what the engine asked the framework to do
+type fakeShim struct {
+	req     *idempotency.Request
+	handler func() (*idempotency.Response, error)
+
+	skipped, handled int
+	written          *idempotency.CachedResponse
+	errorStatus      int
+	headers          map[string]string
+}
+
+func (s *fakeShim) Context() context.Context         { return context.Background() }
+func (s *fakeShim) Request(req *idempotency.Request) { *req = *s.req }
+func (s *fakeShim) ReadBody() ([]byte, error)        { return []byte("body"), nil }
+func (s *fakeShim) Skip() error                      { s.skipped++; return nil }
+func (s *fakeShim) Next(body *idempotency.BodyCapture) (*idempotency.Response, error) {
+	s.handled++
+	resp, err := s.handler()
+	if resp != nil {
+		_, _ = body.Write(resp.Body)
+	}
+	return resp, err
+}
+func (s *fakeShim) Header(name, value string) {
+	if s.headers == nil {
+		s.headers = make(map[string]string)
+	}
+	s.headers[name] = value
+}
+func (s *fakeShim) Write(resp *idempotency.CachedResponse, extra map[string]string) error {
+	s.written = resp
+	return nil
+}
+func (s *fakeShim) Error(statusCode int, message string) error {
+	s.errorStatus = statusCode
+	return nil
+}
+
+func newShim(method, key string, handler func() (*idempotency.Response, error)) *fakeShim {
+	headers := map[string][]string{}
+	if key != "" {
+		headers["Idempotency-Key"] = []string{key}
+	}
+	return &fakeShim{
+		req:     &idempotency.Request{Method: method, Path: "/orders", Headers: headers},
+		handler: handler,
+	}
+}
+
+func TestRun(t *testing.T) {
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store})
+
+	created := func() (*idempotency.Response, error) {
+		return &idempotency.Response{StatusCode: http.StatusCreated, Body: []byte("created")}, nil
+	}
+
+	t.Run("SkipNotAllowed", func(t *testing.T) {
+		shim := newShim(http.MethodGet, "", created)
+		if err := Run(manager, nil, shim); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if shim.skipped != 1 || shim.handled != 0 {
+			t.Errorf("expected request to skip idempotency, got %+v", shim)
+		}
+	})
+
+	t.Run("StoreAndReplay", func(t *testing.T) {
+		first := newShim(http.MethodPost, "k1", created)
+		_ = Run(manager, nil, first)
+		if first.handled != 1 {
+			t.Fatalf("expected handler to run, got %+v", first)
+		}
+
+		second := newShim(http.MethodPost, "k1", created)
+		_ = Run(manager, nil, second)
+		if second.handled != 0 || second.written == nil || string(second.written.Body) != "created" {
+			t.Errorf("expected cached response to be replayed, got %+v", second)
+		}
+	})
+
+	t.Run("HandlerErrorFails", func(t *testing.T) {
+		boom := errors.New("boom")
+		shim := newShim(http.MethodPost, "k2", func() (*idempotency.Response, error) {
+			return &idempotency.Response{StatusCode: http.StatusOK}, boom
+		})
+		if err := Run(manager, nil, shim); !errors.Is(err, boom) {
+			t.Fatalf("expected handler error, got %v", err)
+		}
+
+		record, _ := manager.GetRecord(context.Background(), "k2")
+		if record == nil || record.Status != idempotency.StatusFailed {
+			t.Fatalf("expected failed record, got %+v", record)
+		}
+
+		retry := newShim(http.MethodPost, "k2", created)
+		_ = Run(manager, nil, retry)
+		if retry.handled != 1 {
+			t.Errorf("expected retry to run the handler, got %+v", retry)
+		}
+	})
+}
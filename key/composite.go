@@ -9,24 +9,21 @@ import (
 )
 
 // Composite creates a key strategy that combines header-based key with request hash
-// If header is present, uses it; otherwise falls back to body hash
-func Composite(headerName string) idempotency.KeyStrategy {
+// If header (or one of its aliases) is present, uses it; otherwise falls back to body hash
+func Composite(headerName string, aliases ...string) idempotency.KeyStrategy {
 	return &compositeGenerator{
-		headerName: headerName,
+		headerNames: append([]string{headerName}, aliases...),
 	}
 }
 
 type compositeGenerator struct {
-	headerName string
+	headerNames []string
 }
 
 func (c *compositeGenerator) Generate(req *idempotency.Request) (string, error) {
 	// Try to get key from header first
-	if req.Headers != nil {
-		values := req.Headers[c.headerName]
-		if len(values) > 0 && values[0] != "" {
-			return values[0], nil
-		}
+	if value := headerValue(req.Headers, c.headerNames); value != "" {
+		return value, nil
 	}
 
 	// Fall back to body hash
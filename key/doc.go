@@ -6,6 +6,10 @@
 //
 //	strategy := key.HeaderBased("Idempotency-Key")
 //
+// Additional header names can be accepted as aliases:
+//
+//	strategy := key.HeaderBased("Idempotency-Key", "X-Idempotency-Key")
+//
 // BodyHash: Generates a key from the hash of the request content (method + path + body)
 //
 //	strategy := key.BodyHash()
@@ -13,4 +17,25 @@
 // Composite: Tries header-based first, falls back to body hash if header is not present
 //
 //	strategy := key.Composite("Idempotency-Key")
+//
+// MultiHeader: Combines the idempotency key header with other headers into one hashed key,
+// for APIs where keys are only unique per account, source...
+//
+//	strategy := key.MultiHeader("Idempotency-Key", "X-Account-Id", "X-Request-Source")
+//
+// RequestURI: Generates a key from method + path + normalized query, scoped by at least one
+// request header. Meant for caching safe methods (e.g. GET) through a RoutePolicy
+//
+//	strategy := key.RequestURI("Authorization")
+//
+// RegionPinned: Prefixes generated keys with the issuing region, read back with RegionOf,
+// so multi-region routers can send retries to the key's home region
+//
+//	strategy := key.RegionPinned("eu-west-1", key.BodyHash())
+//
+// Scoped: Prefixes keys with the scope of the request (authenticated user, tenant...),
+// read from the context (ContextScope), a header (HeaderScope) or a JWT claim
+// (JWTClaimScope), so two customers sending the same key never share a record
+//
+//	strategy := key.Scoped(key.HeaderBased("Idempotency-Key"), key.ContextScope())
 package key
@@ -1,28 +1,44 @@
 // Package key provides strategies for generating idempotency keys from HTTP requests
 package key
 
-import idempotency "github.com/fco-gt/gopotency"
+import (
+	"net/textproto"
 
-// HeaderBased creates a key strategy that extracts the key from a request header
-func HeaderBased(headerName string) idempotency.KeyStrategy {
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// HeaderBased creates a key strategy that extracts the key from a request header.
+// Optional aliases are checked in order when the primary header is not present.
+func HeaderBased(headerName string, aliases ...string) idempotency.KeyStrategy {
 	return &headerGenerator{
-		headerName: headerName,
+		headerNames: append([]string{headerName}, aliases...),
 	}
 }
 
 type headerGenerator struct {
-	headerName string
+	headerNames []string
 }
 
 func (h *headerGenerator) Generate(req *idempotency.Request) (string, error) {
-	if req.Headers == nil {
-		return "", nil
+	return headerValue(req.Headers, h.headerNames), nil
+}
+
+// headerValue returns the first non-empty value found for the given header names.
+// Names are looked up as given and in their canonical MIME form.
+func headerValue(headers map[string][]string, names []string) string {
+	if headers == nil {
+		return ""
 	}
 
-	values := req.Headers[h.headerName]
-	if len(values) == 0 {
-		return "", nil
+	for _, name := range names {
+		values := headers[name]
+		if len(values) == 0 {
+			values = headers[textproto.CanonicalMIMEHeaderKey(name)]
+		}
+		if len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
 	}
 
-	return values[0], nil
+	return ""
 }
@@ -2,6 +2,7 @@ package key
 
 import (
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"testing"
@@ -126,3 +127,188 @@ func TestComposite_PrefersHeaderAndFallsBackToBody(t *testing.T) {
 	})
 }
 
+func TestHeaderBased_Aliases(t *testing.T) {
+	strategy := HeaderBased("Idempotency-Key", "X-Idempotency-Key")
+
+	req := &idempotency.Request{Headers: map[string][]string{"X-Idempotency-Key": {"alias-key"}}}
+	got, err := strategy.Generate(req)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got != "alias-key" {
+		t.Fatalf("expected alias key, got %q", got)
+	}
+
+	req.Headers["Idempotency-Key"] = []string{"primary-key"}
+	got, _ = strategy.Generate(req)
+	if got != "primary-key" {
+		t.Fatalf("expected primary header to win, got %q", got)
+	}
+
+	lower := HeaderBased("idempotency-key")
+	got, _ = lower.Generate(req)
+	if got != "primary-key" {
+		t.Fatalf("expected canonical lookup to match, got %q", got)
+	}
+}
+
+func TestRequestURI_NormalizesQueryAndScopes(t *testing.T) {
+	strategy := RequestURI("Authorization")
+
+	a, _ := strategy.Generate(&idempotency.Request{
+		Method:  "GET",
+		Path:    "/reports",
+		Query:   "b=2&a=1",
+		Headers: map[string][]string{"Authorization": {"Bearer alice"}},
+	})
+	b, _ := strategy.Generate(&idempotency.Request{
+		Method:  "GET",
+		Path:    "/reports",
+		Query:   "a=1&b=2",
+		Headers: map[string][]string{"Authorization": {"Bearer alice"}},
+	})
+	if a == "" || a != b {
+		t.Fatalf("expected query order not to matter, got %q and %q", a, b)
+	}
+
+	c, _ := strategy.Generate(&idempotency.Request{
+		Method:  "GET",
+		Path:    "/reports",
+		Query:   "a=1&b=2",
+		Headers: map[string][]string{"Authorization": {"Bearer bob"}},
+	})
+	if c == a {
+		t.Fatal("expected different scopes to produce different keys")
+	}
+
+	for _, s := range []idempotency.KeyStrategy{strategy, Scoped(BodyHash(), ContextScope()), RegionPinned("eu-west-1", strategy)} {
+		if scoped, ok := s.(idempotency.CallerScoped); !ok || !scoped.CallerScoped() {
+			t.Errorf("expected %T to be caller scoped", s)
+		}
+	}
+	if scoped, ok := RegionPinned("eu-west-1", BodyHash()).(idempotency.CallerScoped); ok && scoped.CallerScoped() {
+		t.Error("expected RegionPinned to forward an unscoped strategy")
+	}
+}
+
+func TestRegionPinned(t *testing.T) {
+	strategy := RegionPinned("eu-west-1", BodyHash())
+
+	k, err := strategy.Generate(&idempotency.Request{Method: "POST", Path: "/orders", Body: []byte(`{"id":1}`)})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if region, ok := RegionOf(k); !ok || region != "eu-west-1" {
+		t.Fatalf("expected key pinned to eu-west-1, got %q", k)
+	}
+
+	empty, _ := RegionPinned("eu-west-1", HeaderBased("Idempotency-Key")).Generate(&idempotency.Request{})
+	if empty != "" {
+		t.Errorf("expected empty key to stay empty, got %q", empty)
+	}
+
+	if _, ok := RegionOf("8e03978e-40d5-43e8-bc93-6894a57f9324"); ok {
+		t.Error("expected unpinned key to have no region")
+	}
+}
+
+func TestMultiHeader(t *testing.T) {
+	strategy := MultiHeader("Idempotency-Key", "X-Account-Id", "X-Request-Source")
+	request := func(headers map[string][]string) *idempotency.Request {
+		return &idempotency.Request{Method: "POST", Path: "/payments", Headers: headers}
+	}
+
+	k1, err := strategy.Generate(request(map[string][]string{
+		"Idempotency-Key":  {"abc"},
+		"X-Account-Id":     {"acct_1"},
+		"X-Request-Source": {"mobile"},
+	}))
+	if err != nil || k1 == "" {
+		t.Fatalf("expected a key, got %q, %v", k1, err)
+	}
+
+	k2, _ := strategy.Generate(request(map[string][]string{
+		"Idempotency-Key":  {"abc"},
+		"X-Account-Id":     {"acct_2"},
+		"X-Request-Source": {"mobile"},
+	}))
+	if k1 == k2 {
+		t.Error("expected different accounts to get different keys")
+	}
+
+	// Length prefixes keep values containing separators apart
+	k3, _ := MultiHeader("Idempotency-Key", "X-Account-Id").Generate(request(map[string][]string{
+		"Idempotency-Key": {"ab"},
+		"X-Account-Id":    {"c"},
+	}))
+	k4, _ := MultiHeader("Idempotency-Key", "X-Account-Id").Generate(request(map[string][]string{
+		"Idempotency-Key": {"a"},
+		"X-Account-Id":    {"bc"},
+	}))
+	if k3 == k4 {
+		t.Error("expected the values not to be ambiguous")
+	}
+
+	empty, _ := strategy.Generate(request(map[string][]string{"X-Account-Id": {"acct_1"}}))
+	if empty != "" {
+		t.Errorf("expected no key without the key header, got %q", empty)
+	}
+
+	transformer, ok := strategy.(idempotency.KeyTransformer)
+	if !ok {
+		t.Fatal("expected MultiHeader to transform header keys")
+	}
+	transformed, _ := transformer.TransformKey("abc", request(map[string][]string{
+		"X-Account-Id":     {"acct_1"},
+		"X-Request-Source": {"mobile"},
+	}))
+	if transformed != k1 {
+		t.Errorf("expected the transformed header key to match the generated one")
+	}
+}
+
+func TestScoped(t *testing.T) {
+	strategy := Scoped(HeaderBased("Idempotency-Key"), HeaderScope("X-Tenant-Id"))
+	request := func(tenant string) *idempotency.Request {
+		return &idempotency.Request{Headers: map[string][]string{
+			"Idempotency-Key": {"abc"},
+			"X-Tenant-Id":     {tenant},
+		}}
+	}
+
+	k1, err := strategy.Generate(request("acme"))
+	if err != nil || k1 != "acme:abc" {
+		t.Fatalf("expected a scoped key, got %q, %v", k1, err)
+	}
+	k2, _ := strategy.(idempotency.KeyTransformer).TransformKey("abc", request("globex"))
+	if k2 != "globex:abc" {
+		t.Errorf("expected the header key to be scoped, got %q", k2)
+	}
+	// The escaped scope cannot forge the key of another scope
+	forged, _ := strategy.Generate(&idempotency.Request{Headers: map[string][]string{
+		"Idempotency-Key": {"abc"},
+		"X-Tenant-Id":     {"acme:"},
+	}})
+	unscoped, _ := strategy.Generate(&idempotency.Request{Headers: map[string][]string{
+		"Idempotency-Key": {"acme:abc"},
+	}})
+	if forged == k1 || unscoped == k1 {
+		t.Errorf("expected distinct keys, got %q and %q for %q", forged, unscoped, k1)
+	}
+
+	t.Run("JWTClaimScope", func(t *testing.T) {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user_1","org":42}`))
+		req := &idempotency.Request{Headers: map[string][]string{
+			"Authorization": {"Bearer header." + payload + ".signature"},
+		}}
+		if got := JWTClaimScope("sub")(req); got != "user_1" {
+			t.Errorf("expected user_1, got %q", got)
+		}
+		if got := JWTClaimScope("org")(req); got != "42" {
+			t.Errorf("expected 42, got %q", got)
+		}
+		if got := JWTClaimScope("sub")(&idempotency.Request{}); got != "" {
+			t.Errorf("expected no scope without token, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package key
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// MultiHeader creates a key strategy combining the idempotency key header with other
+// request headers, for APIs where a key is only unique within that tuple (e.g. the
+// account and the source issuing it). Without the key header no key is generated;
+// missing secondary headers count as empty values.
+// The values are joined length-prefixed in the given order and hashed with SHA-256, so
+// keys are deterministic and values containing separators cannot collide. The strategy
+// also applies when the key header is read by the middlewares (see
+// idempotency.KeyTransformer).
+func MultiHeader(keyHeader string, headers ...string) idempotency.KeyStrategy {
+	return &multiHeaderGenerator{
+		keyHeader: keyHeader,
+		headers:   headers,
+	}
+}
+
+type multiHeaderGenerator struct {
+	keyHeader string
+	headers   []string
+}
+
+func (g *multiHeaderGenerator) Generate(req *idempotency.Request) (string, error) {
+	key := headerValue(req.Headers, []string{g.keyHeader})
+	if key == "" {
+		return "", nil
+	}
+	return g.TransformKey(key, req)
+}
+
+// TransformKey combines key with the values of the secondary headers of req
+func (g *multiHeaderGenerator) TransformKey(key string, req *idempotency.Request) (string, error) {
+	var b strings.Builder
+	writeValue := func(value string) {
+		b.WriteString(strconv.Itoa(len(value)))
+		b.WriteByte(':')
+		b.WriteString(value)
+	}
+
+	writeValue(key)
+	for _, name := range g.headers {
+		writeValue(strings.Join(textproto.MIMEHeader(req.Headers).Values(name), ","))
+	}
+
+	hash := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,49 @@
+package key
+
+import (
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// RegionSeparator separates the region from the key in region-pinned keys
+const RegionSeparator = "/"
+
+// RegionPinned wraps a key strategy so generated keys are prefixed with the region that
+// issued them ("eu-west-1/<key>"). In active-active deployments, a router can send
+// retries to the key's home region with RegionOf, so they are never processed twice
+// in two regions.
+func RegionPinned(region string, strategy idempotency.KeyStrategy) idempotency.KeyStrategy {
+	return &regionPinnedGenerator{
+		region:   region,
+		strategy: strategy,
+	}
+}
+
+type regionPinnedGenerator struct {
+	region   string
+	strategy idempotency.KeyStrategy
+}
+
+func (g *regionPinnedGenerator) Generate(req *idempotency.Request) (string, error) {
+	key, err := g.strategy.Generate(req)
+	if err != nil || key == "" {
+		return key, err
+	}
+	return g.region + RegionSeparator + key, nil
+}
+
+// CallerScoped implements idempotency.CallerScoped, forwarding the wrapped strategy
+func (g *regionPinnedGenerator) CallerScoped() bool {
+	scoped, ok := g.strategy.(idempotency.CallerScoped)
+	return ok && scoped.CallerScoped()
+}
+
+// RegionOf returns the home region of a region-pinned key
+func RegionOf(key string) (string, bool) {
+	region, _, ok := strings.Cut(key, RegionSeparator)
+	if !ok || region == "" {
+		return "", false
+	}
+	return region, true
+}
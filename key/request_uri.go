@@ -0,0 +1,48 @@
+package key
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// RequestURI creates a key strategy that generates a key from the method, path and
+// query string, scoped by the values of the given request headers (e.g. "Authorization"),
+// so a caller never gets the response cached for another. It is meant for response
+// caching of safe methods such as GET, where clients do not send an idempotency key.
+// Query parameters are normalized so their order does not matter.
+func RequestURI(scopeHeader string, moreScopeHeaders ...string) idempotency.KeyStrategy {
+	return &requestURIGenerator{
+		scopeHeaders: append([]string{scopeHeader}, moreScopeHeaders...),
+	}
+}
+
+type requestURIGenerator struct {
+	scopeHeaders []string
+}
+
+func (g *requestURIGenerator) Generate(req *idempotency.Request) (string, error) {
+	query := req.Query
+	if values, err := url.ParseQuery(req.Query); err == nil {
+		query = values.Encode()
+	}
+
+	var b strings.Builder
+	b.WriteString(req.Method + ":" + req.Path + "?" + query)
+	for _, name := range g.scopeHeaders {
+		b.WriteString("\n" + name + ":")
+		b.WriteString(strings.Join(textproto.MIMEHeader(req.Headers).Values(name), ","))
+	}
+
+	hash := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// CallerScoped implements idempotency.CallerScoped
+func (g *requestURIGenerator) CallerScoped() bool {
+	return true
+}
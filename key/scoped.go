@@ -0,0 +1,114 @@
+package key
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// ScopeSeparator separates the escaped scope from the key in scoped keys
+const ScopeSeparator = ":"
+
+// ScopeFunc returns the scope of a request, e.g. the authenticated user or tenant
+type ScopeFunc func(req *idempotency.Request) string
+
+// Scoped wraps a key strategy so keys are prefixed with the scope of the request
+// ("<scope>:<key>"). Two customers sending the same key then get distinct records
+// instead of replaying each other's responses. The scope is query-escaped so it can
+// never contain the separator, and requests without scope get an empty one, so their
+// keys cannot collide with scoped keys either. The strategy also applies to the key
+// sent in the key header (see idempotency.KeyTransformer).
+func Scoped(inner idempotency.KeyStrategy, scopeFn ScopeFunc) idempotency.KeyStrategy {
+	return &scopedGenerator{
+		inner:   inner,
+		scopeFn: scopeFn,
+	}
+}
+
+type scopedGenerator struct {
+	inner   idempotency.KeyStrategy
+	scopeFn ScopeFunc
+}
+
+func (g *scopedGenerator) Generate(req *idempotency.Request) (string, error) {
+	key, err := g.inner.Generate(req)
+	if err != nil || key == "" {
+		return key, err
+	}
+	return g.scope(key, req), nil
+}
+
+// TransformKey applies the transformation of the inner strategy, if any, and prefixes
+// the key with the scope of req
+func (g *scopedGenerator) TransformKey(key string, req *idempotency.Request) (string, error) {
+	if transformer, ok := g.inner.(idempotency.KeyTransformer); ok {
+		var err error
+		if key, err = transformer.TransformKey(key, req); err != nil {
+			return "", err
+		}
+	}
+	return g.scope(key, req), nil
+}
+
+// CallerScoped implements idempotency.CallerScoped
+func (g *scopedGenerator) CallerScoped() bool {
+	return true
+}
+
+func (g *scopedGenerator) scope(key string, req *idempotency.Request) string {
+	return url.QueryEscape(g.scopeFn(req)) + ScopeSeparator + key
+}
+
+// ContextScope returns the scope set on the request context with
+// idempotency.WithKeyScope by an upstream authentication middleware
+func ContextScope() ScopeFunc {
+	return func(req *idempotency.Request) string {
+		return idempotency.KeyScopeFromContext(req.Context())
+	}
+}
+
+// HeaderScope returns the value of the first present header, e.g. a tenant ID set by
+// a trusted gateway
+func HeaderScope(headerName string, aliases ...string) ScopeFunc {
+	names := append([]string{headerName}, aliases...)
+	return func(req *idempotency.Request) string {
+		return headerValue(req.Headers, names)
+	}
+}
+
+// JWTClaimScope returns the given claim (e.g. "sub" or "tenant_id") of the bearer token
+// in the Authorization header. The token signature is NOT verified: the token must be
+// authenticated by a middleware running before the idempotency middleware. Requests
+// without a valid token get an empty scope.
+func JWTClaimScope(claim string) ScopeFunc {
+	return func(req *idempotency.Request) string {
+		token, ok := strings.CutPrefix(headerValue(req.Headers, []string{"Authorization"}), "Bearer ")
+		if !ok {
+			return ""
+		}
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return ""
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return ""
+		}
+		var claims map[string]any
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return ""
+		}
+		value, ok := claims[claim]
+		if !ok || value == nil {
+			return ""
+		}
+		if s, ok := value.(string); ok {
+			return s
+		}
+		return fmt.Sprint(value)
+	}
+}
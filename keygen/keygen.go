@@ -0,0 +1,88 @@
+// Package keygen provides idempotency key generators and validators for API clients and
+// SDK authors.
+//
+// Random keys are time-ordered, which keeps storage indexes compact:
+//
+//	req.Header.Set("Idempotency-Key", keygen.UUIDv7())
+//	req.Header.Set("Idempotency-Key", keygen.ULID())
+//
+// Deterministic keys are derived from the business fields identifying an operation, so
+// a client that lost its state (crash, restart) regenerates the same key:
+//
+//	key := keygen.FromFields("payment", map[string]any{"invoice": "INV-42", "amount": 1999})
+//
+// Validate checks keys against the recommended format before they are sent.
+package keygen
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UUIDv7 returns a random, time-ordered UUID version 7 (RFC 9562)
+func UUIDv7() string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+
+	ms := uint64(time.Now().UnixMilli())
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant
+
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID returns a random, lexicographically sortable ULID
+func ULID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+
+	ms := uint64(time.Now().UnixMilli())
+	b[0] = byte(ms >> 40)
+	b[1] = byte(ms >> 32)
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+
+	// 128 bits encoded as 26 characters of 5 bits, the first one holding 3 bits
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// FromFields returns a deterministic key derived from a namespace (the operation, e.g.
+// "payment") and the business fields identifying it. Field order does not matter and
+// values are formatted with fmt's %v verb.
+func FromFields(namespace string, fields map[string]any) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(namespace)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n%s=%v", name, fields[name])
+	}
+
+	hash := sha256.Sum256([]byte(b.String()))
+	return namespace + "-" + hex.EncodeToString(hash[:16])
+}
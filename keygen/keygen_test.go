@@ -0,0 +1,80 @@
+package keygen
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUUIDv7(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	a := UUIDv7()
+	time.Sleep(2 * time.Millisecond)
+	b := UUIDv7()
+
+	if !pattern.MatchString(a) {
+		t.Fatalf("invalid UUIDv7 %q", a)
+	}
+	if a >= b {
+		t.Errorf("expected time-ordered UUIDs, got %q then %q", a, b)
+	}
+}
+
+func TestULID(t *testing.T) {
+	a := ULID()
+	time.Sleep(2 * time.Millisecond)
+	b := ULID()
+
+	if len(a) != 26 || strings.Trim(a, crockford) != "" {
+		t.Fatalf("invalid ULID %q", a)
+	}
+	if a[0] > '7' {
+		t.Errorf("expected first character to hold 3 bits, got %q", a)
+	}
+	if a >= b {
+		t.Errorf("expected sortable ULIDs, got %q then %q", a, b)
+	}
+}
+
+func TestFromFields(t *testing.T) {
+	a := FromFields("payment", map[string]any{"invoice": "INV-42", "amount": 1999})
+	b := FromFields("payment", map[string]any{"amount": 1999, "invoice": "INV-42"})
+	c := FromFields("payment", map[string]any{"invoice": "INV-43", "amount": 1999})
+
+	if a != b {
+		t.Errorf("expected field order not to matter, got %q and %q", a, b)
+	}
+	if a == c {
+		t.Error("expected different fields to produce different keys")
+	}
+	if !strings.HasPrefix(a, "payment-") || Validate(a) != nil {
+		t.Errorf("expected valid namespaced key, got %q", a)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		key  string
+		want error
+	}{
+		{UUIDv7(), nil},
+		{ULID(), nil},
+		{"", ErrEmptyKey},
+		{"abc", ErrKeyTooShort},
+		{strings.Repeat("a", 256), ErrKeyTooLong},
+		{"contains a space!", ErrInvalidCharacter},
+	}
+
+	for _, tt := range tests {
+		if err := Validate(tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("Validate(%q) = %v, want %v", tt.key, err, tt.want)
+		}
+	}
+
+	if err := (Rules{MinLength: 3}).Validate("abc"); err != nil {
+		t.Errorf("expected custom rules to accept short key, got %v", err)
+	}
+}
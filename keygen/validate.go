@@ -0,0 +1,67 @@
+package keygen
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validation errors returned by Validate
+var (
+	// ErrEmptyKey is returned for an empty key
+	ErrEmptyKey = errors.New("keygen: key is empty")
+
+	// ErrKeyTooShort is returned for keys too short to be unique
+	ErrKeyTooShort = errors.New("keygen: key is too short")
+
+	// ErrKeyTooLong is returned for keys longer than servers commonly accept
+	ErrKeyTooLong = errors.New("keygen: key is too long")
+
+	// ErrInvalidCharacter is returned for keys containing characters outside printable ASCII
+	ErrInvalidCharacter = errors.New("keygen: key contains an invalid character")
+)
+
+// Rules are the constraints enforced by a validator
+type Rules struct {
+	// MinLength is the minimum key length, guarding against low-entropy keys
+	// Default: 16
+	MinLength int
+
+	// MaxLength is the maximum key length
+	// Default: 255
+	MaxLength int
+}
+
+// DefaultRules follow common idempotency key guidance: unique, opaque keys of
+// 16 to 255 printable ASCII characters without spaces
+var DefaultRules = Rules{MinLength: 16, MaxLength: 255}
+
+// Validate checks key against DefaultRules
+func Validate(key string) error {
+	return DefaultRules.Validate(key)
+}
+
+// Validate checks key against the rules
+func (r Rules) Validate(key string) error {
+	if r.MinLength == 0 {
+		r.MinLength = DefaultRules.MinLength
+	}
+	if r.MaxLength == 0 {
+		r.MaxLength = DefaultRules.MaxLength
+	}
+
+	switch {
+	case key == "":
+		return ErrEmptyKey
+	case len(key) < r.MinLength:
+		return fmt.Errorf("%w: %d characters, want at least %d", ErrKeyTooShort, len(key), r.MinLength)
+	case len(key) > r.MaxLength:
+		return fmt.Errorf("%w: %d characters, want at most %d", ErrKeyTooLong, len(key), r.MaxLength)
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] > '~' {
+			return fmt.Errorf("%w at position %d", ErrInvalidCharacter, i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package idempotency
+
+import "context"
+
+type keyScopeContextKey struct{}
+
+// WithKeyScope returns a copy of ctx carrying the scope (authenticated user, tenant...)
+// of the request, set by an authentication middleware running before the idempotency
+// middleware and read by key.ContextScope
+func WithKeyScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, keyScopeContextKey{}, scope)
+}
+
+// KeyScopeFromContext returns the scope set with WithKeyScope, or "" if none
+func KeyScopeFromContext(ctx context.Context) string {
+	scope, _ := ctx.Value(keyScopeContextKey{}).(string)
+	return scope
+}
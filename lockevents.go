@@ -0,0 +1,70 @@
+package idempotency
+
+import "time"
+
+// LockEventKind is the kind of a LockEvent
+type LockEventKind string
+
+const (
+	// LockAcquired means a request acquired the lock of its key. Wait is the time spent
+	// acquiring it.
+	LockAcquired LockEventKind = "acquired"
+
+	// LockReleased means a request completed or failed within its lock timeout. Hold is
+	// the time the lock was held.
+	LockReleased LockEventKind = "released"
+
+	// LockExpired means a request completed or failed after its lock timeout, so its lock
+	// had already expired and duplicates could be processed concurrently. Hold is the time
+	// the request ran; LockTimeout is likely too short.
+	LockExpired LockEventKind = "expired"
+
+	// LockTakeover means a pending record abandoned by its request (e.g. after a crash)
+	// was reclaimed once it expired. Hold is its age; PendingTTL may be too long.
+	LockTakeover LockEventKind = "takeover"
+)
+
+// LockEvent reports lock activity, to tune LockTimeout and PendingTTL from data
+type LockEvent struct {
+	Kind    LockEventKind
+	Key     string
+	Wait    time.Duration
+	Hold    time.Duration
+	Timeout time.Duration
+	Time    time.Time
+}
+
+// observeLock reports a lock event to the OnLockEvent hook if configured
+func (m *Manager) observeLock(kind LockEventKind, key string, wait, hold, timeout time.Duration) {
+	if m.config.OnLockEvent == nil {
+		return
+	}
+	m.config.OnLockEvent(LockEvent{
+		Kind:    kind,
+		Key:     key,
+		Wait:    wait,
+		Hold:    hold,
+		Timeout: timeout,
+		Time:    m.now(),
+	})
+}
+
+// observeRelease reports the release of the lock held by the pending record
+func (m *Manager) observeRelease(record *Record) {
+	if m.config.OnLockEvent == nil || record.Status != StatusPending {
+		return
+	}
+
+	timeout := record.LockTimeout
+	if timeout == 0 {
+		timeout = m.config.LockTimeout
+	}
+	hold := m.now().Sub(record.CreatedAt)
+
+	// Renewed locks legitimately outlive their timeout
+	kind := LockReleased
+	if hold > timeout && m.config.LockRenewalInterval == 0 {
+		kind = LockExpired
+	}
+	m.observeLock(kind, record.Key, 0, hold, timeout)
+}
@@ -0,0 +1,68 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManager_OnLockEvent(t *testing.T) {
+	ctx := context.Background()
+	store := newListingStorage()
+	var events []LockEvent
+	m, _ := NewManager(Config{
+		Storage:     store,
+		LockTimeout: time.Minute,
+		OnLockEvent: func(event LockEvent) { events = append(events, event) },
+	})
+
+	req := func(key string) *Request {
+		return &Request{Method: "POST", Path: "/pay", IdempotencyKey: key, Body: []byte("{}")}
+	}
+	last := func() LockEvent {
+		t.Helper()
+		if len(events) == 0 {
+			t.Fatal("expected a lock event")
+		}
+		return events[len(events)-1]
+	}
+
+	t.Run("AcquiredAndReleased", func(t *testing.T) {
+		if err := m.Lock(ctx, req("k1")); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		if e := last(); e.Kind != LockAcquired || e.Key != "k1" || e.Timeout != time.Minute {
+			t.Errorf("expected acquired event, got %+v", e)
+		}
+
+		if err := m.Store(ctx, "k1", &Response{StatusCode: 200}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		if e := last(); e.Kind != LockReleased || e.Hold <= 0 || e.Hold > time.Minute {
+			t.Errorf("expected released event with hold time, got %+v", e)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		_ = m.Lock(ctx, req("k2"))
+		store.records["k2"].CreatedAt = time.Now().Add(-2 * time.Minute)
+
+		_ = m.Fail(ctx, "k2")
+		if e := last(); e.Kind != LockExpired || e.Hold < 2*time.Minute {
+			t.Errorf("expected expired event, got %+v", e)
+		}
+	})
+
+	t.Run("Takeover", func(t *testing.T) {
+		_ = m.Lock(ctx, req("k3"))
+		store.records["k3"].ExpiresAt = time.Now().Add(-time.Second)
+
+		if _, err := m.Check(ctx, req("k3")); errors.Is(err, ErrRequestInProgress) {
+			t.Fatal("expected abandoned pending record to be reclaimed")
+		}
+		if e := last(); e.Kind != LockTakeover || e.Key != "k3" {
+			t.Errorf("expected takeover event, got %+v", e)
+		}
+	})
+}
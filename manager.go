@@ -2,13 +2,37 @@ package idempotency
 
 import (
 	"context"
+	"math"
+	"net/textproto"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Manager handles idempotency checks and response caching
 type Manager struct {
-	config Config
+	config   Config
+	quota    *pendingQuota
+	renewals *lockRenewals
+
+	// uncached is the set of canonical response header names not cached
+	uncached map[string]bool
+
+	// paths selects the request paths idempotency applies to
+	paths *PathFilter
+
+	// cacheOnly is the set of canonical response header names cached, nil to cache all
+	// headers but uncached
+	cacheOnly map[string]bool
+
+	// tracer creates the spans of manager and storage operations, nil without
+	// Config.TracerProvider
+	tracer  trace.Tracer
+	backend string
 }
 
 // Config returns the manager's configuration (read-only)
@@ -16,6 +40,11 @@ func (m *Manager) Config() Config {
 	return m.config
 }
 
+// now returns the time of Config.Clock
+func (m *Manager) now() time.Time {
+	return m.config.Clock.Now()
+}
+
 // NewManager creates a new idempotency manager with the given configuration
 func NewManager(config Config) (*Manager, error) {
 	// Set defaults
@@ -26,8 +55,30 @@ func NewManager(config Config) (*Manager, error) {
 		return nil, err
 	}
 
+	if setter, ok := config.Storage.(CodecSetter); ok && config.Codec != nil {
+		setter.SetCodec(config.Codec)
+	}
+
+	if setter, ok := config.Storage.(ClockSetter); ok && config.Clock != SystemClock {
+		setter.SetClock(config.Clock)
+	}
+
+	if notifier, ok := config.Storage.(ExpiryNotifier); ok && Supports[ExpiryNotifier](config.Storage) && config.OnExpired != nil {
+		notifier.OnExpired(namespacedExpiry(config.KeyPrefix, config.OnExpired))
+	}
+
+	// The patterns were validated with the configuration
+	paths, _ := NewPathFilter(config.IncludePaths, config.ExcludePaths)
+
 	return &Manager{
-		config: config,
+		config:    config,
+		quota:     newPendingQuota(),
+		renewals:  newLockRenewals(),
+		uncached:  uncachedHeaders(config.StripHeaders, config.TTLHeader),
+		cacheOnly: headerSet(config.CacheHeaders),
+		paths:     paths,
+		tracer:    newTracer(config.TracerProvider),
+		backend:   backendName(config.Storage),
 	}, nil
 }
 
@@ -36,42 +87,92 @@ func NewManager(config Config) (*Manager, error) {
 // - *CachedResponse: if the request was already processed successfully
 // - error: ErrRequestInProgress if currently being processed, or other errors
 func (m *Manager) Check(ctx context.Context, req *Request) (*CachedResponse, error) {
+	ctx, span := m.startSpan(ctx, "idempotency.Check")
+	cached, err := m.check(ctx, req)
+	span.SetAttributes(attrKey.String(req.IdempotencyKey), attrCacheHit.Bool(cached != nil))
+	endSpan(span, err)
+	return cached, err
+}
+
+// check is Check without tracing
+func (m *Manager) check(ctx context.Context, req *Request) (*CachedResponse, error) {
+	if err := m.resolveKey(ctx, req); err != nil || req.IdempotencyKey == "" {
+		return nil, err
+	}
+
+	// Check if record exists
+	record, err := m.storageGet(ctx, req.IdempotencyKey)
+	if err != nil {
+		if m.config.FailClosed {
+			return nil, NewStorageError("get", err)
+		}
+		// Storage error - this is a new request
+		return nil, nil
+	}
+	return m.checkRecord(ctx, req, record)
+}
+
+// resolveKey generates the idempotency key of req with the key strategy if not already
+// set, and scopes it. The key is left empty when idempotency does not apply.
+func (m *Manager) resolveKey(ctx context.Context, req *Request) error {
+	req.ctx = ctx
 	// Generate idempotency key if not already set
 	if req.IdempotencyKey == "" {
-		if m.config.KeyStrategy != nil {
-			key, err := m.config.KeyStrategy.Generate(req)
+		if strategy := m.keyStrategy(req); strategy != nil {
+			key, err := strategy.Generate(req)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			req.IdempotencyKey = key
+			req.generated = true
 		}
 
 		// If still no key, return (idempotency not applicable)
 		if req.IdempotencyKey == "" {
-			if m.config.RequireKey && m.IsMethodAllowed(req.Method) {
-				return nil, ErrNoIdempotencyKey
+			if m.IsKeyRequired(req) {
+				return ErrNoIdempotencyKey
 			}
-			return nil, nil
+			return nil
 		}
 	}
+	return m.scopeKey(ctx, req)
+}
 
-	// Check if record exists
-	record, err := m.config.Storage.Get(ctx, req.IdempotencyKey)
-	if err != nil || record == nil {
-		// No record found or storage error - this is a new request
+// checkRecord decides how to handle req given the record stored for its key, as
+// documented on Check. An expired record is deleted and req is handled as new.
+func (m *Manager) checkRecord(ctx context.Context, req *Request, record *Record) (*CachedResponse, error) {
+	if record == nil {
+		// No record found - this is a new request
 		return nil, nil
 	}
 
 	// Check if record is expired
-	if !record.ExpiresAt.IsZero() && time.Now().After(record.ExpiresAt) {
-		_ = m.config.Storage.Delete(ctx, req.IdempotencyKey)
+	if !record.ExpiresAt.IsZero() && m.now().After(record.ExpiresAt) {
+		reused := record.Status == StatusCompleted
+		if reused && m.config.ExpiredKeys == ExpiredKeyReject {
+			// The record is kept so every reuse is rejected until the storage drops it
+			return nil, ErrKeyExpired
+		}
+
+		_ = m.storageDelete(ctx, req.IdempotencyKey)
+		if record.Status == StatusPending {
+			m.observeLock(LockTakeover, req.IdempotencyKey, 0, m.now().Sub(record.CreatedAt), record.LockTimeout)
+		}
+		if !Supports[ExpiryNotifier](m.config.Storage) && m.config.OnExpired != nil {
+			m.config.OnExpired(req.IdempotencyKey, record)
+		}
+		req.keyExpired = reused && m.config.ExpiredKeys == ExpiredKeyWarn
 		return nil, nil
 	}
 
 	// Validate request hash if hasher is configured
 	if m.config.RequestHasher != nil {
-		reqHash, err := m.config.RequestHasher.Hash(req)
+		reqHash, err := m.requestHash(req)
 		if err == nil && record.RequestHash != "" && record.RequestHash != reqHash {
+			if m.config.MetricsCollector != nil {
+				m.config.MetricsCollector.Mismatch()
+			}
+			m.emit(ctx, DecisionMismatch, req.IdempotencyKey, req, 0)
 			return nil, ErrRequestMismatch
 		}
 	}
@@ -80,20 +181,21 @@ func (m *Manager) Check(ctx context.Context, req *Request) (*CachedResponse, err
 	switch record.Status {
 	case StatusPending:
 		// Request is currently being processed
-		if m.config.OnLockConflict != nil {
-			m.config.OnLockConflict(req.IdempotencyKey)
+		if m.config.WaitForResult {
+			return m.waitForResult(ctx, req)
 		}
+		m.conflict(ctx, req)
 		return nil, ErrRequestInProgress
 
 	case StatusCompleted:
-		// Return cached response
-		if m.config.OnCacheHit != nil {
-			m.config.OnCacheHit(req.IdempotencyKey)
-		}
-		return record.Response, nil
+		return m.replay(ctx, req, record)
 
 	case StatusFailed:
-		// Failed requests can be retried (treat as new)
+		// Failed requests can be retried (treat as new), unless their response is
+		// replayed until the FailureTTL cooldown elapses
+		if m.config.FailureTTL > 0 && record.Response != nil {
+			return m.replay(ctx, req, record)
+		}
 		return nil, nil
 
 	default:
@@ -102,104 +204,396 @@ func (m *Manager) Check(ctx context.Context, req *Request) (*CachedResponse, err
 	}
 }
 
+// replay returns the cached response of record to req, a duplicate
+func (m *Manager) replay(ctx context.Context, req *Request, record *Record) (*CachedResponse, error) {
+	if m.config.OnCacheHit != nil {
+		m.config.OnCacheHit(req.IdempotencyKey)
+	}
+	if record.Response != nil {
+		if m.config.MetricsCollector != nil {
+			m.config.MetricsCollector.CacheHit(len(record.Response.Body))
+		}
+		m.emit(ctx, DecisionReplayed, req.IdempotencyKey, req, record.Response.StatusCode)
+	}
+	expiresAt := record.ExpiresAt
+	if m.config.TrackReplays || m.config.RefreshTTLOnHit && record.Status == StatusCompleted {
+		expiresAt = m.updateReplayed(ctx, record)
+	}
+
+	resp := record.Response
+	if resp != nil && m.config.ReplayHeaders.Expires != "" {
+		// Storages may share the stored response (memory), it is copied
+		withExpiry := *resp
+		withExpiry.ExpiresAt = expiresAt
+		resp = &withExpiry
+	}
+	if resp != nil && resp.BlobKey != "" && m.config.BlobStore != nil {
+		return m.loadBlob(ctx, resp)
+	}
+	return resp, nil
+}
+
+// conflict reports that req is a duplicate of a request in progress
+func (m *Manager) conflict(ctx context.Context, req *Request) {
+	if m.config.OnLockConflict != nil {
+		m.config.OnLockConflict(req.IdempotencyKey)
+	}
+	m.lockConflict(ctx, req)
+}
+
+// lockConflict reports that the lock of req is held by another request
+func (m *Manager) lockConflict(ctx context.Context, req *Request) {
+	if m.config.MetricsCollector != nil {
+		m.config.MetricsCollector.LockConflict()
+	}
+	m.emit(ctx, DecisionConflict, req.IdempotencyKey, req, 0)
+}
+
 // Lock attempts to acquire a lock for processing the request
 func (m *Manager) Lock(ctx context.Context, req *Request) error {
+	ctx, span := m.startSpan(ctx, "idempotency.Lock")
+	err := m.lock(ctx, req)
+	span.SetAttributes(attrKey.String(req.IdempotencyKey))
+	endSpan(span, err)
+	return err
+}
+
+// lock is Lock without tracing
+func (m *Manager) lock(ctx context.Context, req *Request) error {
 	if req.IdempotencyKey == "" {
 		return ErrNoIdempotencyKey
 	}
+	start := time.Now()
+	if err := m.scopeKey(ctx, req); err != nil {
+		return err
+	}
+
+	record, policy, err := m.pendingRecord(req)
+	if err != nil {
+		return err
+	}
+
+	// Reserve a pending slot in the request scope
+	releaseQuota, err := m.acquireQuota(req)
+	if err != nil {
+		return err
+	}
+
+	// Acquire lock and store pending record atomically when supported
+	if Supports[LockSetter](m.config.Storage) {
+		locked, err := m.storageTryLockAndSet(ctx, record, policy.PendingTTL, policy.LockTimeout)
+		if err != nil {
+			releaseQuota()
+			return NewStorageError("trylock", err)
+		}
+		if !locked {
+			releaseQuota()
+			m.lockConflict(ctx, req)
+			return ErrRequestInProgress
+		}
+	} else {
+		// Try to acquire lock
+		locked, err := m.storageTryLock(ctx, req.IdempotencyKey, policy.LockTimeout)
+		if err != nil {
+			releaseQuota()
+			return NewStorageError("trylock", err)
+		}
+
+		if !locked {
+			// Lock already held by another request
+			releaseQuota()
+			m.lockConflict(ctx, req)
+			return ErrRequestInProgress
+		}
+
+		// Store pending record
+		if err := m.storageSet(ctx, record, policy.PendingTTL); err != nil {
+			// Try to unlock if set fails
+			_ = m.storageUnlock(ctx, req.IdempotencyKey)
+			releaseQuota()
+			return NewStorageError("set", err)
+		}
+	}
 
+	m.locked(req, start, policy)
+	return nil
+}
+
+// pendingRecord returns the pending record stored while req is processed, and the
+// policy it was created with
+func (m *Manager) pendingRecord(req *Request) (*Record, Policy, error) {
 	// Compute request hash
 	var reqHash string
 	if m.config.RequestHasher != nil {
-		hash, err := m.config.RequestHasher.Hash(req)
+		hash, err := m.requestHash(req)
 		if err != nil {
-			return err
+			return nil, Policy{}, err
 		}
 		reqHash = hash
 	}
 
-	// Create pending record
-	record := &Record{
+	policy := m.policy(req)
+	now := m.now()
+	return &Record{
 		Key:         req.IdempotencyKey,
 		RequestHash: reqHash,
 		Status:      StatusPending,
-		CreatedAt:   time.Now(),
-		ExpiresAt:   time.Now().Add(m.config.TTL),
-	}
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(policy.PendingTTL),
+		TTL:         policy.TTL,
+		LockTimeout: policy.LockTimeout,
+	}, policy, nil
+}
 
-	// Try to acquire lock
-	locked, err := m.config.Storage.TryLock(ctx, req.IdempotencyKey, m.config.LockTimeout)
-	if err != nil {
-		return NewStorageError("trylock", err)
-	}
+// locked reports that the lock of req was acquired, start being when acquisition began
+func (m *Manager) locked(req *Request, start time.Time, policy Policy) {
+	m.observeLock(LockAcquired, req.IdempotencyKey, time.Since(start), 0, policy.LockTimeout)
+	m.renewLock(req.IdempotencyKey, policy.LockTimeout)
 
-	if !locked {
-		// Lock already held by another request
-		return ErrRequestInProgress
+	if m.config.OnCacheMiss != nil {
+		m.config.OnCacheMiss(req.IdempotencyKey)
 	}
-
-	// Store pending record
-	if err := m.config.Storage.Set(ctx, record, m.config.TTL); err != nil {
-		// Try to unlock if set fails
-		_ = m.config.Storage.Unlock(ctx, req.IdempotencyKey)
-		return NewStorageError("set", err)
+	if m.config.MetricsCollector != nil {
+		m.config.MetricsCollector.CacheMiss()
 	}
+}
 
-	if m.config.OnCacheMiss != nil {
-		m.config.OnCacheMiss(req.IdempotencyKey)
+// Store saves the response for a successfully processed request.
+// Storage runs on a context detached from ctx cancellation, so a client disconnecting
+// after the handler returned does not prevent the response from being cached.
+func (m *Manager) Store(ctx context.Context, key string, resp *Response) error {
+	ctx, span := m.startSpan(ctx, "idempotency.Store", attrKey.String(key))
+	if resp != nil {
+		span.SetAttributes(attrStatusCode.Int(resp.StatusCode))
 	}
+	err := m.store(ctx, key, resp, false)
+	endSpan(span, err)
+	return err
+}
 
-	return nil
+// storeFailure fails the record for key with resp, a response with a retryable status
+// code replayed to retries until Config.FailureTTL elapses
+func (m *Manager) storeFailure(ctx context.Context, key string, resp *Response) error {
+	ctx, span := m.startSpan(ctx, "idempotency.Fail", attrKey.String(key), attrStatusCode.Int(resp.StatusCode))
+	err := m.store(ctx, key, resp, true)
+	endSpan(span, err)
+	return err
 }
 
-// Store saves the response for a successfully processed request
-func (m *Manager) Store(ctx context.Context, key string, resp *Response) error {
+// store is Store without tracing. A failed record is stored for Config.FailureTTL.
+func (m *Manager) store(ctx context.Context, key string, resp *Response, failed bool) error {
 	if key == "" {
 		return ErrNoIdempotencyKey
 	}
 
+	ctx = context.WithoutCancel(ctx)
+	m.stopRenewal(key)
+	defer m.quota.release(key)
+
 	// Get existing record to preserve request hash
-	record, err := m.config.Storage.Get(ctx, key)
+	record, err := m.storageGet(ctx, key)
 	if err != nil || record == nil {
 		// Create new record if not found or on error
 		record = &Record{
 			Key:       key,
-			CreatedAt: time.Now(),
+			CreatedAt: m.now(),
 		}
+	} else {
+		// Storages may share the stored record (memory), concurrent readers must not see it change
+		updated := *record
+		record = &updated
 	}
+	m.observeRelease(record)
 
 	// Update record with response
-	record.Status = StatusCompleted
-	record.Response = resp.ToCachedResponse()
-	record.ExpiresAt = time.Now().Add(m.config.TTL)
-
-	// Store updated record
-	if err := m.config.Storage.Set(ctx, record, m.config.TTL); err != nil {
-		return NewStorageError("set", err)
+	m.completeRecord(record, resp)
+	ttl := m.recordTTL(record)
+	if failed {
+		record.Status = StatusFailed
+		record.ExpiresAt = m.now().Add(m.config.FailureTTL)
+		ttl = m.config.FailureTTL
 	}
 
-	// Release lock
-	if err := m.config.Storage.Unlock(ctx, key); err != nil {
-		// Log error but don't fail the operation
-		// The lock will eventually expire
+	if Supports[SetUnlocker](m.config.Storage) {
+		// Store updated record and release lock atomically
+		if err := m.storageSetAndUnlock(ctx, record, ttl); err != nil {
+			return NewStorageError("set", err)
+		}
+	} else {
+		// Store updated record
+		if err := m.storageSet(ctx, record, ttl); err != nil {
+			return NewStorageError("set", err)
+		}
+
+		// Release lock
+		if err := m.storageUnlock(ctx, key); err != nil {
+			// Log error but don't fail the operation
+			// The lock will eventually expire
+		}
 	}
 
+	m.notifyComplete(record)
+	m.emit(ctx, DecisionStored, key, nil, resp.StatusCode)
+
 	return nil
 }
 
-// Unlock releases the lock for a request (typically called on error)
+// completeRecord marks record completed with resp
+func (m *Manager) completeRecord(record *Record, resp *Response) {
+	record.Status = StatusCompleted
+	if ttl := m.responseTTL(resp); ttl > 0 {
+		record.TTL = ttl
+	}
+	record.Response = resp.ToCachedResponse()
+	record.Response.Headers = m.cachedHeaders(resp.Headers)
+	record.Response.CompletedAt = m.now()
+	record.Response.Region = m.config.Region
+	if m.config.HTTPCaching {
+		record.Response.ETag = responseETag(record.Response)
+	}
+	// The blob store expires bodies after Config.TTL: the record must not outlive its body
+	if record.Response.BlobKey != "" && m.recordTTL(record) > m.config.TTL {
+		record.TTL = m.config.TTL
+	}
+	record.ExpiresAt = m.now().Add(m.recordTTL(record))
+}
+
+// Unlock releases the lock for a request (typically called on error).
+// A pending record is marked as failed so the request can be retried right away.
+// Like Store, it runs on a context detached from ctx cancellation.
 func (m *Manager) Unlock(ctx context.Context, key string) error {
+	ctx, span := m.startSpan(ctx, "idempotency.Unlock", attrKey.String(key))
+	err := m.unlock(ctx, key)
+	endSpan(span, err)
+	return err
+}
+
+// unlock is Unlock without tracing
+func (m *Manager) unlock(ctx context.Context, key string) error {
 	if key == "" {
 		return nil
 	}
 
-	if err := m.config.Storage.Unlock(ctx, key); err != nil {
+	ctx = context.WithoutCancel(ctx)
+	m.stopRenewal(key)
+	defer m.quota.release(key)
+
+	_ = m.markFailed(ctx, key, "")
+
+	if err := m.storageUnlock(ctx, key); err != nil {
 		return NewStorageError("unlock", err)
 	}
 
 	return nil
 }
 
+// markFailed transitions the pending record for key to failed, recording reason.
+// Missing records and records that are not pending are left untouched.
+func (m *Manager) markFailed(ctx context.Context, key, reason string) error {
+	record, err := m.storageGet(ctx, key)
+	if err != nil || record == nil || record.Status != StatusPending {
+		return nil
+	}
+
+	m.observeRelease(record)
+	failed := *record
+	record = &failed
+	record.Status = StatusFailed
+	record.Error = reason
+	if err := m.storageSet(ctx, record, m.recordTTL(record)); err != nil {
+		return NewStorageError("set", err)
+	}
+
+	m.notifyComplete(record)
+	return nil
+}
+
+// notifyComplete calls the OnComplete hook if configured
+func (m *Manager) notifyComplete(record *Record) {
+	if m.config.OnComplete != nil {
+		m.config.OnComplete(record)
+	}
+}
+
+// requestHash hashes req with the RequestHasher once, reusing the result afterwards
+func (m *Manager) requestHash(req *Request) (string, error) {
+	if req.hashed {
+		return req.hash, nil
+	}
+	hash, err := m.config.RequestHasher.Hash(req)
+	if err != nil {
+		return "", err
+	}
+	req.hash, req.hashed = hash, true
+	return hash, nil
+}
+
+// HeaderNames returns the header names accepted for the idempotency key, primary name first
+func (m *Manager) HeaderNames() []string {
+	return append([]string{m.config.HeaderName}, m.config.HeaderAliases...)
+}
+
+// KeyFromHeaders extracts the idempotency key from the configured header names.
+// Returns an empty string if none of them is present.
+func (m *Manager) KeyFromHeaders(headers map[string][]string) string {
+	for _, name := range m.HeaderNames() {
+		if value := textproto.MIMEHeader(headers).Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ReplayHeaders returns the metadata headers to add to a replayed response, named after
+// Config.ReplayHeaders
+func (m *Manager) ReplayHeaders(resp *CachedResponse) map[string]string {
+	names := m.config.ReplayHeaders
+	headers := make(map[string]string, 3)
+	set := func(name, value string) {
+		if name != "" {
+			headers[name] = value
+		}
+	}
+
+	set(names.Replayed, "true")
+	if resp != nil && !resp.CompletedAt.IsZero() {
+		set(names.OriginalTimestamp, resp.CompletedAt.UTC().Format(time.RFC3339))
+		set(names.Age, strconv.Itoa(max(int(m.now().Sub(resp.CompletedAt).Seconds()), 0)))
+	}
+	if resp != nil && resp.Region != "" {
+		set(names.OriginRegion, resp.Region)
+	}
+	if resp != nil && !resp.ExpiresAt.IsZero() {
+		set(names.Expires, resp.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if resp != nil && m.config.HTTPCaching {
+		for name, value := range m.cacheHeaders(resp) {
+			headers[name] = value
+		}
+	}
+
+	return headers
+}
+
+// RetryAfterHeader returns the Retry-After header value (seconds) to send with a 409
+// response to a duplicate of the in-progress request for key, or "" if none: the
+// remaining lock time-to-live with Config.RetryAfterFromLock, capped by
+// Config.RetryAfter
+func (m *Manager) RetryAfterHeader(ctx context.Context, key string) string {
+	retryAfter := m.config.RetryAfter
+	if Supports[LockTTLReader](m.config.Storage) && m.config.RetryAfterFromLock && key != "" {
+		remaining, err := m.storageLockTTL(ctx, key)
+		if err == nil && remaining > 0 && (retryAfter <= 0 || remaining < retryAfter) {
+			retryAfter = remaining
+		}
+	}
+
+	if retryAfter <= 0 {
+		return ""
+	}
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
+
 // IsMethodAllowed checks if idempotency should be applied to the given HTTP method
 func (m *Manager) IsMethodAllowed(method string) bool {
 	if len(m.config.AllowedMethods) == 0 {
@@ -211,8 +605,180 @@ func (m *Manager) IsMethodAllowed(method string) bool {
 
 // Close closes the manager and underlying storage
 func (m *Manager) Close() error {
+	m.stopRenewals()
 	if m.config.Storage != nil {
 		return m.config.Storage.Close()
 	}
 	return nil
 }
+
+// storageOp starts the storage operation op on key, bounded by Config.StorageTimeout.
+// done must be called with the result of the operation to report it to
+// Config.MetricsCollector and end its span, with attrs added to the span.
+func (m *Manager) storageOp(ctx context.Context, op, key string) (context.Context, func(err error, attrs ...attribute.KeyValue)) {
+	start := time.Now()
+	ctx, span := m.startSpan(ctx, "idempotency.storage."+op, attrOperation.String(op), attrBackend.String(m.backend))
+	if key != "" {
+		span.SetAttributes(attrKey.String(key))
+	}
+	cancel := context.CancelFunc(func() {})
+	if m.config.StorageTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, m.config.StorageTimeout)
+	}
+	return ctx, func(err error, attrs ...attribute.KeyValue) {
+		cancel()
+		if m.config.MetricsCollector != nil {
+			m.config.MetricsCollector.StorageOperation(op, time.Since(start), err)
+		}
+		span.SetAttributes(attrs...)
+		endSpan(span, err)
+	}
+}
+
+func (m *Manager) storageGet(ctx context.Context, key string) (*Record, error) {
+	ctx, done := m.storageOp(ctx, "get", key)
+	record, err := m.config.Storage.Get(ctx, m.storageKey(key))
+	done(err, attrFound.Bool(record != nil))
+	return m.fromStorage(record), err
+}
+
+func (m *Manager) storageSet(ctx context.Context, record *Record, ttl time.Duration) error {
+	ctx, done := m.storageOp(ctx, "set", record.Key)
+	if record.Status == StatusCompleted {
+		ttl += m.config.ExpiredKeyRetention
+	}
+	err := m.config.Storage.Set(ctx, m.toStorage(record), ttl)
+	done(err)
+	return err
+}
+
+func (m *Manager) storageSetAndUnlock(ctx context.Context, record *Record, ttl time.Duration) error {
+	ctx, done := m.storageOp(ctx, "setandunlock", record.Key)
+	if record.Status == StatusCompleted {
+		ttl += m.config.ExpiredKeyRetention
+	}
+	err := m.config.Storage.(SetUnlocker).SetAndUnlock(ctx, m.toStorage(record), ttl)
+	done(err)
+	return err
+}
+
+func (m *Manager) storageDelete(ctx context.Context, key string) error {
+	ctx, done := m.storageOp(ctx, "delete", key)
+	err := m.config.Storage.Delete(ctx, m.storageKey(key))
+	done(err)
+	return err
+}
+
+func (m *Manager) storageTryLock(ctx context.Context, key string, lockTTL time.Duration) (bool, error) {
+	ctx, done := m.storageOp(ctx, "trylock", key)
+	locked, err := m.config.Storage.TryLock(ctx, m.storageKey(key), lockTTL)
+	done(err)
+	return locked, err
+}
+
+func (m *Manager) storageTryLockAndSet(ctx context.Context, record *Record, ttl, lockTTL time.Duration) (bool, error) {
+	ctx, done := m.storageOp(ctx, "trylockandset", record.Key)
+	locked, err := m.config.Storage.(LockSetter).TryLockAndSet(ctx, m.toStorage(record), ttl, lockTTL)
+	done(err)
+	return locked, err
+}
+
+func (m *Manager) storageGetOrLock(ctx context.Context, record *Record, ttl, lockTTL time.Duration) (*Record, bool, error) {
+	ctx, done := m.storageOp(ctx, "getorlock", record.Key)
+	existing, locked, err := m.config.Storage.(GetOrLocker).GetOrLock(ctx, m.storageKey(record.Key), m.toStorage(record), ttl, lockTTL)
+	done(err, attrFound.Bool(existing != nil))
+	return m.fromStorage(existing), locked, err
+}
+
+func (m *Manager) storageExtendLock(ctx context.Context, key string, lockTTL time.Duration) (bool, error) {
+	ctx, done := m.storageOp(ctx, "extendlock", key)
+	held, err := m.config.Storage.(LockExtender).ExtendLock(ctx, m.storageKey(key), lockTTL)
+	done(err)
+	return held, err
+}
+
+func (m *Manager) storageLockTTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, done := m.storageOp(ctx, "lockttl", key)
+	ttl, err := m.config.Storage.(LockTTLReader).LockTTL(ctx, m.storageKey(key))
+	done(err)
+	return ttl, err
+}
+
+func (m *Manager) storageUnlock(ctx context.Context, key string) error {
+	ctx, done := m.storageOp(ctx, "unlock", key)
+	err := m.config.Storage.Unlock(ctx, m.storageKey(key))
+	done(err)
+	return err
+}
+
+// storageList lists the records of a RecordLister storage
+func (m *Manager) storageList(ctx context.Context) ([]*Record, error) {
+	lister, ok := m.config.Storage.(RecordLister)
+	if !ok || !Supports[RecordLister](m.config.Storage) {
+		return nil, ErrListingNotSupported
+	}
+
+	ctx, done := m.storageOp(ctx, "list", "")
+	records, err := lister.List(ctx)
+	done(err)
+	if err != nil {
+		return nil, NewStorageError("list", err)
+	}
+	if m.config.KeyPrefix == "" {
+		return records, nil
+	}
+
+	// Records of other namespaces sharing the storage are skipped
+	namespaced := make([]*Record, 0, len(records))
+	for _, record := range records {
+		if strings.HasPrefix(record.Key, m.config.KeyPrefix) {
+			namespaced = append(namespaced, m.fromStorage(record))
+		}
+	}
+	return namespaced, nil
+}
+
+// namespacedExpiry adapts onExpired to the expirations reported by an ExpiryNotifier
+// storage: records of other namespaces are skipped, keys are reported without prefix
+func namespacedExpiry(prefix string, onExpired func(key string, record *Record)) func(key string, record *Record) {
+	if prefix == "" {
+		return onExpired
+	}
+	return func(key string, record *Record) {
+		if !strings.HasPrefix(key, prefix) {
+			return
+		}
+		onExpired(strings.TrimPrefix(key, prefix), trimKeyPrefix(prefix, record))
+	}
+}
+
+// storageKey returns the key of the record or lock for key in the storage, namespaced
+// with Config.KeyPrefix
+func (m *Manager) storageKey(key string) string {
+	return m.config.KeyPrefix + key
+}
+
+// toStorage returns record as stored, a copy keyed with Config.KeyPrefix when set
+func (m *Manager) toStorage(record *Record) *Record {
+	if m.config.KeyPrefix == "" {
+		return record
+	}
+	stored := *record
+	stored.Key = m.storageKey(record.Key)
+	return &stored
+}
+
+// fromStorage returns a stored record as seen by the manager, a copy without
+// Config.KeyPrefix when set. Records are copied as storages may return their own.
+func (m *Manager) fromStorage(record *Record) *Record {
+	return trimKeyPrefix(m.config.KeyPrefix, record)
+}
+
+func trimKeyPrefix(prefix string, record *Record) *Record {
+	if prefix == "" || record == nil {
+		return record
+	}
+	unprefixed := *record
+	unprefixed.Key = strings.TrimPrefix(record.Key, prefix)
+	return &unprefixed
+}
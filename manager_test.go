@@ -227,6 +227,29 @@ func TestManager_Check_EdgeCases(t *testing.T) {
 		}
 	})
 
+	t.Run("RecordExpired_OnExpired", func(t *testing.T) {
+		var expiredKey string
+		m, _ := NewManager(Config{
+			Storage: &MockStorage{
+				GetFunc: func(ctx context.Context, key string) (*Record, error) {
+					return &Record{Key: key, ExpiresAt: time.Now().Add(-time.Hour)}, nil
+				},
+			},
+			OnExpired: func(key string, record *Record) {
+				if record != nil {
+					expiredKey = key
+				}
+			},
+		})
+		req := &Request{Method: "POST", Path: "/", IdempotencyKey: "audit-key"}
+		if _, err := m.Check(ctx, req); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if expiredKey != "audit-key" {
+			t.Errorf("Expected OnExpired for audit-key, got %q", expiredKey)
+		}
+	})
+
 	t.Run("RecordPending_Error", func(t *testing.T) {
 		m, _ := NewManager(Config{
 			Storage: &MockStorage{
@@ -245,3 +268,420 @@ func TestManager_Check_EdgeCases(t *testing.T) {
 		}
 	})
 }
+
+func TestManager_KeyFromHeaders(t *testing.T) {
+	m, _ := NewManager(Config{
+		Storage:       &MockStorage{},
+		HeaderName:    "X-Request-Key",
+		HeaderAliases: []string{"Idempotency-Key"},
+	})
+
+	if got := m.HeaderNames(); len(got) != 2 || got[0] != "X-Request-Key" {
+		t.Fatalf("unexpected header names: %v", got)
+	}
+
+	headers := map[string][]string{"Idempotency-Key": {"alias"}}
+	if got := m.KeyFromHeaders(headers); got != "alias" {
+		t.Errorf("expected alias key, got %q", got)
+	}
+
+	headers["X-Request-Key"] = []string{"primary"}
+	if got := m.KeyFromHeaders(headers); got != "primary" {
+		t.Errorf("expected primary key, got %q", got)
+	}
+
+	if got := m.KeyFromHeaders(nil); got != "" {
+		t.Errorf("expected empty key, got %q", got)
+	}
+
+	d, _ := NewManager(Config{Storage: &MockStorage{}})
+	if got := d.KeyFromHeaders(map[string][]string{"Idempotency-Key": {"k"}}); got != "k" {
+		t.Errorf("expected default header name to be used, got %q", got)
+	}
+}
+
+func TestManager_ReplayHeaders(t *testing.T) {
+	var stored *Record
+	m, _ := NewManager(Config{
+		Storage: &MockStorage{
+			SetFunc: func(ctx context.Context, r *Record, ttl time.Duration) error {
+				stored = r
+				return nil
+			},
+		},
+	})
+
+	if err := m.Store(context.Background(), "k", &Response{StatusCode: 200}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if stored == nil || stored.Response.CompletedAt.IsZero() {
+		t.Fatal("expected CompletedAt to be set on the cached response")
+	}
+
+	headers := m.ReplayHeaders(stored.Response)
+	if headers[ReplayedHeaderName] != "true" {
+		t.Errorf("expected replayed header, got %v", headers)
+	}
+	ts, err := time.Parse(time.RFC3339, headers[OriginalTimestampHeaderName])
+	if err != nil {
+		t.Fatalf("expected RFC 3339 timestamp, got %q", headers[OriginalTimestampHeaderName])
+	}
+	if ts.Unix() != stored.Response.CompletedAt.Unix() {
+		t.Errorf("expected timestamp %v, got %v", stored.Response.CompletedAt, ts)
+	}
+
+	legacy := m.ReplayHeaders(&CachedResponse{StatusCode: 200})
+	if _, ok := legacy[OriginalTimestampHeaderName]; ok {
+		t.Error("did not expect timestamp header without CompletedAt")
+	}
+	if _, ok := headers[OriginRegionHeaderName]; ok {
+		t.Error("did not expect region header without Config.Region")
+	}
+
+	regional, _ := NewManager(Config{Storage: m.config.Storage, Region: "eu-west-1"})
+	if err := regional.Store(context.Background(), "k", &Response{StatusCode: 200}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if got := regional.ReplayHeaders(stored.Response)[OriginRegionHeaderName]; got != "eu-west-1" {
+		t.Errorf("expected origin region eu-west-1, got %q", got)
+	}
+}
+
+func TestManager_ReplayHeaderNames(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	m, _ := NewManager(Config{
+		Storage: newListingStorage(),
+		Clock:   clock,
+		TTL:     time.Hour,
+		ReplayHeaders: ReplayHeaderNames{
+			Replayed:          "Idempotent-Replayed",
+			OriginalTimestamp: "X-Idempotency-Original-Date",
+			Age:               "X-Idempotency-Age",
+			Expires:           "X-Idempotency-Expires",
+		},
+	})
+
+	req := func() *Request { return &Request{Method: "POST", Path: "/orders", IdempotencyKey: "k"} }
+	if _, err := m.Check(ctx, req()); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if err := m.Store(ctx, "k", &Response{StatusCode: 201}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	clock.Advance(90 * time.Second)
+	cached, err := m.Check(ctx, req())
+	if err != nil || cached == nil {
+		t.Fatalf("expected a replay, got %v, %v", cached, err)
+	}
+
+	want := map[string]string{
+		"Idempotent-Replayed":         "true",
+		"X-Idempotency-Original-Date": "2024-01-01T00:00:00Z",
+		"X-Idempotency-Age":           "90",
+		"X-Idempotency-Expires":       "2024-01-01T01:00:00Z",
+		OriginRegionHeaderName:        "",
+	}
+	headers := m.ReplayHeaders(cached)
+	for name, value := range want {
+		if got, ok := headers[name]; value != "" && got != value || value == "" && ok {
+			t.Errorf("expected %s: %q, got %v", name, value, headers)
+		}
+	}
+	if _, ok := headers[ReplayedHeaderName]; ok {
+		t.Errorf("expected the default replayed header to be renamed, got %v", headers)
+	}
+
+	omitted, _ := NewManager(Config{Storage: &MockStorage{}, ReplayHeaders: ReplayHeaderNames{OriginalTimestamp: "-"}})
+	if headers := omitted.ReplayHeaders(cached); len(headers) != 1 || headers[ReplayedHeaderName] != "true" {
+		t.Errorf("expected only the replayed header, got %v", headers)
+	}
+}
+
+func TestManager_StoreAndUnlock_DetachedContext(t *testing.T) {
+	var setErr, unlockErr error
+	m, _ := NewManager(Config{
+		Storage: &MockStorage{
+			SetFunc: func(ctx context.Context, r *Record, ttl time.Duration) error {
+				setErr = ctx.Err()
+				return ctx.Err()
+			},
+			UnlockFunc: func(ctx context.Context, key string) error {
+				unlockErr = ctx.Err()
+				return ctx.Err()
+			},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := m.Store(ctx, "k", &Response{StatusCode: 200}); err != nil {
+		t.Fatalf("expected Store to succeed on canceled context, got %v", err)
+	}
+	if err := m.Unlock(ctx, "k"); err != nil {
+		t.Fatalf("expected Unlock to succeed on canceled context, got %v", err)
+	}
+	if setErr != nil || unlockErr != nil {
+		t.Fatalf("expected storage to receive a live context, got %v / %v", setErr, unlockErr)
+	}
+}
+
+func TestManager_StorageTimeout(t *testing.T) {
+	var deadlineSet bool
+	m, _ := NewManager(Config{
+		StorageTimeout: 20 * time.Millisecond,
+		Storage: &MockStorage{
+			GetFunc: func(ctx context.Context, key string) (*Record, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			TryLockFunc: func(ctx context.Context, k string, t time.Duration) (bool, error) {
+				_, deadlineSet = ctx.Deadline()
+				return true, nil
+			},
+		},
+	})
+
+	start := time.Now()
+	resp, err := m.Check(context.Background(), &Request{Method: "POST", IdempotencyKey: "k"})
+	if err != nil || resp != nil {
+		t.Fatalf("expected slow storage to be treated as a miss, got %v, %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected Check to return after StorageTimeout, took %s", elapsed)
+	}
+
+	if err := m.Lock(context.Background(), &Request{IdempotencyKey: "k"}); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if !deadlineSet {
+		t.Fatal("expected TryLock to receive a context with deadline")
+	}
+}
+
+func TestManager_KeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	store := newListingStorage()
+	var lockKeys []string
+	store.TryLockFunc = func(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+		lockKeys = append(lockKeys, key)
+		return true, nil
+	}
+	billing, _ := NewManager(Config{Storage: store, KeyPrefix: "billing/"})
+	shipping, _ := NewManager(Config{Storage: store, KeyPrefix: "shipping/"})
+
+	calls := 0
+	fn := func(ctx context.Context) (*Response, error) {
+		calls++
+		return &Response{StatusCode: 201}, nil
+	}
+	for _, m := range []*Manager{billing, shipping, billing} {
+		if _, err := m.Execute(ctx, "k1", fn); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected each namespace to process the key once, got %d calls", calls)
+	}
+	if _, ok := store.records["billing/k1"]; !ok || len(lockKeys) != 2 || lockKeys[0] != "billing/k1" {
+		t.Errorf("expected records and locks to be prefixed, got records %v and locks %v", store.records, lockKeys)
+	}
+
+	info, err := billing.Inspect(ctx, "k1")
+	if err != nil || info == nil || info.Key != "k1" {
+		t.Errorf("expected the record to be inspected without prefix, got %+v, %v", info, err)
+	}
+	records, _ := billing.storageList(ctx)
+	if len(records) != 1 || records[0].Key != "k1" {
+		t.Errorf("expected only the records of the namespace to be listed, got %v", records)
+	}
+}
+
+func TestManager_OnComplete(t *testing.T) {
+	ctx := context.Background()
+	records := make(map[string]*Record)
+	var events []RecordStatus
+
+	m, _ := NewManager(Config{
+		Storage: &MockStorage{
+			GetFunc: func(ctx context.Context, key string) (*Record, error) {
+				return records[key], nil
+			},
+			SetFunc: func(ctx context.Context, r *Record, ttl time.Duration) error {
+				records[r.Key] = r
+				return nil
+			},
+		},
+		OnComplete: func(record *Record) {
+			events = append(events, record.Status)
+		},
+	})
+
+	t.Run("Completed", func(t *testing.T) {
+		if err := m.Lock(ctx, &Request{IdempotencyKey: "ok"}); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		if err := m.Store(ctx, "ok", &Response{StatusCode: 201}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		if len(events) != 1 || events[0] != StatusCompleted {
+			t.Fatalf("expected one completed event, got %v", events)
+		}
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		events = nil
+		if err := m.Lock(ctx, &Request{IdempotencyKey: "ko"}); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		if err := m.Unlock(ctx, "ko"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		if len(events) != 1 || events[0] != StatusFailed {
+			t.Fatalf("expected one failed event, got %v", events)
+		}
+
+		// Failed records can be retried right away
+		resp, err := m.Check(ctx, &Request{Method: "POST", IdempotencyKey: "ko"})
+		if err != nil || resp != nil {
+			t.Fatalf("expected failed record to be retryable, got %v, %v", resp, err)
+		}
+	})
+
+	t.Run("UnlockWithoutPendingRecord", func(t *testing.T) {
+		events = nil
+		if err := m.Unlock(ctx, "missing"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		if len(events) != 0 {
+			t.Fatalf("expected no event, got %v", events)
+		}
+	})
+}
+
+// lockSetterStorage is a MockStorage implementing LockSetter
+type lockSetterStorage struct {
+	MockStorage
+	calls int
+}
+
+func (s *lockSetterStorage) TryLockAndSet(ctx context.Context, r *Record, ttl, lockTTL time.Duration) (bool, error) {
+	s.calls++
+	return s.calls == 1, nil
+}
+
+func TestManager_Lock_LockSetter(t *testing.T) {
+	store := &lockSetterStorage{}
+	store.TryLockFunc = func(ctx context.Context, k string, t time.Duration) (bool, error) {
+		return false, fmt.Errorf("TryLock must not be called")
+	}
+	m, _ := NewManager(Config{Storage: store})
+
+	if err := m.Lock(context.Background(), &Request{IdempotencyKey: "k"}); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if err := m.Lock(context.Background(), &Request{IdempotencyKey: "k"}); err != ErrRequestInProgress {
+		t.Fatalf("expected ErrRequestInProgress, got %v", err)
+	}
+}
+
+// setUnlockerStorage is a MockStorage implementing SetUnlocker
+type setUnlockerStorage struct {
+	MockStorage
+	stored *Record
+}
+
+func (s *setUnlockerStorage) SetAndUnlock(ctx context.Context, r *Record, ttl time.Duration) error {
+	s.stored = r
+	return nil
+}
+
+func TestManager_Store_SetUnlocker(t *testing.T) {
+	store := &setUnlockerStorage{}
+	store.SetFunc = func(ctx context.Context, r *Record, ttl time.Duration) error {
+		return fmt.Errorf("Set must not be called")
+	}
+	store.UnlockFunc = func(ctx context.Context, k string) error {
+		return fmt.Errorf("Unlock must not be called")
+	}
+	m, _ := NewManager(Config{Storage: store})
+
+	if err := m.Store(context.Background(), "k", &Response{StatusCode: 201}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if store.stored == nil || store.stored.Status != StatusCompleted {
+		t.Fatalf("expected the completed record to be stored, got %v", store.stored)
+	}
+}
+
+// lockTTLStorage is a MockStorage reporting a fixed remaining lock time-to-live
+type lockTTLStorage struct {
+	*MockStorage
+	ttl  time.Duration
+	keys []string
+}
+
+func (s *lockTTLStorage) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	s.keys = append(s.keys, key)
+	return s.ttl, nil
+}
+
+func TestManager_RetryAfterFromLock(t *testing.T) {
+	ctx := context.Background()
+	store := &lockTTLStorage{MockStorage: &MockStorage{}, ttl: 2500 * time.Millisecond}
+
+	tests := []struct {
+		name   string
+		config Config
+		ttl    time.Duration
+		want   string
+	}{
+		{"Disabled", Config{}, 0, ""},
+		{"Static", Config{RetryAfter: 10 * time.Second}, 0, "10"},
+		{"FromLock", Config{RetryAfterFromLock: true}, 2500 * time.Millisecond, "3"},
+		{"Capped", Config{RetryAfterFromLock: true, RetryAfter: time.Second}, time.Minute, "1"},
+		{"NotHeld", Config{RetryAfterFromLock: true, RetryAfter: 5 * time.Second}, 0, "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.ttl = tt.ttl
+			tt.config.Storage = store
+			tt.config.KeyPrefix = "orders"
+			m, _ := NewManager(tt.config)
+			if got := m.RetryAfterHeader(ctx, "k1"); got != tt.want {
+				t.Errorf("RetryAfterHeader = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if len(store.keys) == 0 || store.keys[0] == "k1" {
+		t.Errorf("expected the lock TTL to be read with the storage key, got %v", store.keys)
+	}
+}
+
+// wrappingStorage is a StorageWrapper implementing LockTTLReader whatever it wraps
+type wrappingStorage struct {
+	MockStorage
+	inner Storage
+}
+
+func (s *wrappingStorage) Unwrap() Storage {
+	return s.inner
+}
+
+func (s *wrappingStorage) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.inner.(LockTTLReader).LockTTL(ctx, key)
+}
+
+func TestSupports(t *testing.T) {
+	if !Supports[LockTTLReader](&wrappingStorage{inner: &lockTTLStorage{}}) {
+		t.Error("expected an extension implemented down the chain to be supported")
+	}
+	if Supports[LockTTLReader](&wrappingStorage{inner: &MockStorage{}}) {
+		t.Error("expected an extension missing from the wrapped storage not to be supported")
+	}
+	if Supports[LockTTLReader](&wrappingStorage{inner: &wrappingStorage{inner: &MockStorage{}}}) {
+		t.Error("expected nested wrappers to be unwrapped")
+	}
+}
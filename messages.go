@@ -0,0 +1,93 @@
+package idempotency
+
+import "errors"
+
+// Messages holds the client-facing error messages written by the middlewares.
+// Override them to localize responses or to follow a specific API style guide.
+type Messages struct {
+	// RequestInProgress is returned with 409 when the key is locked by another request
+	RequestInProgress string
+
+	// RequestMismatch is returned with 422 when the key is reused with a different payload
+	RequestMismatch string
+
+	// KeyRequired is returned with 400 when RequireKey is enabled and no key is present
+	KeyRequired string
+
+	// InvalidBody is returned with 400 when the request body cannot be read
+	InvalidBody string
+
+	// QuotaExceeded is returned with 429 when the scope has too many pending requests
+	QuotaExceeded string
+
+	// StorageUnavailable is returned with 503 when the storage fails and
+	// Config.FailClosed is enabled
+	StorageUnavailable string
+
+	// KeyExpired is returned with 422 when a key is reused after its record expired
+	// and Config.ExpiredKeys is ExpiredKeyReject
+	KeyExpired string
+}
+
+// DefaultMessages returns the default English messages
+func DefaultMessages() Messages {
+	return Messages{
+		RequestInProgress:  "request already in progress",
+		RequestMismatch:    "idempotency key reused with different payload",
+		KeyRequired:        "idempotency key is required for this request",
+		InvalidBody:        "failed to read request body",
+		QuotaExceeded:      "too many requests in progress",
+		StorageUnavailable: "idempotency storage unavailable, retry later",
+		KeyExpired:         "idempotency key expired, use a new key",
+	}
+}
+
+// setDefaults fills empty messages with their default value
+func (m *Messages) setDefaults() {
+	defaults := DefaultMessages()
+
+	if m.RequestInProgress == "" {
+		m.RequestInProgress = defaults.RequestInProgress
+	}
+	if m.RequestMismatch == "" {
+		m.RequestMismatch = defaults.RequestMismatch
+	}
+	if m.KeyRequired == "" {
+		m.KeyRequired = defaults.KeyRequired
+	}
+	if m.InvalidBody == "" {
+		m.InvalidBody = defaults.InvalidBody
+	}
+	if m.QuotaExceeded == "" {
+		m.QuotaExceeded = defaults.QuotaExceeded
+	}
+	if m.StorageUnavailable == "" {
+		m.StorageUnavailable = defaults.StorageUnavailable
+	}
+	if m.KeyExpired == "" {
+		m.KeyExpired = defaults.KeyExpired
+	}
+}
+
+// For returns the message associated with an idempotency error.
+// Returns an empty string for errors without a client-facing message.
+func (m Messages) For(err error) string {
+	switch {
+	case errors.Is(err, ErrRequestInProgress):
+		return m.RequestInProgress
+	case errors.Is(err, ErrRequestMismatch):
+		return m.RequestMismatch
+	case errors.Is(err, ErrNoIdempotencyKey):
+		return m.KeyRequired
+	case errors.Is(err, ErrQuotaExceeded):
+		return m.QuotaExceeded
+	case errors.Is(err, ErrKeyExpired):
+		return m.KeyExpired
+	case errors.Is(err, ErrInvalidBody):
+		return m.InvalidBody
+	case errors.As(err, new(*StorageError)):
+		return m.StorageUnavailable
+	default:
+		return ""
+	}
+}
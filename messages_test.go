@@ -0,0 +1,32 @@
+package idempotency
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMessages_DefaultsAndFor(t *testing.T) {
+	m := Messages{KeyRequired: "Clé d'idempotence requise"}
+	m.setDefaults()
+
+	if m.KeyRequired != "Clé d'idempotence requise" {
+		t.Fatalf("expected custom message to be kept, got %q", m.KeyRequired)
+	}
+	if m.RequestInProgress != DefaultMessages().RequestInProgress {
+		t.Fatalf("expected default in-progress message, got %q", m.RequestInProgress)
+	}
+
+	if got := m.For(fmt.Errorf("wrapped: %w", ErrRequestMismatch)); got != m.RequestMismatch {
+		t.Fatalf("expected mismatch message, got %q", got)
+	}
+	if got := m.For(ErrNoIdempotencyKey); got != m.KeyRequired {
+		t.Fatalf("expected key required message, got %q", got)
+	}
+	if got := m.For(ErrQuotaExceeded); got != m.QuotaExceeded || got == "" {
+		t.Fatalf("expected quota message, got %q", got)
+	}
+	if got := m.For(errors.New("other")); got != "" {
+		t.Fatalf("expected empty message, got %q", got)
+	}
+}
@@ -0,0 +1,24 @@
+package idempotency
+
+import "time"
+
+// MetricsCollector receives the measurements of a manager, e.g. to export them to
+// Prometheus (see the metrics package). Its methods are called synchronously on the
+// request path and must be safe for concurrent use.
+type MetricsCollector interface {
+	// CacheHit counts a duplicate answered with a cached response of bodySize bytes
+	CacheHit(bodySize int)
+
+	// CacheMiss counts a request processed for the first time, once its key is locked
+	CacheMiss()
+
+	// LockConflict counts a duplicate rejected because the original is in progress
+	LockConflict()
+
+	// Mismatch counts a key reused with a different request
+	Mismatch()
+
+	// StorageOperation observes a storage operation (get, set, delete, trylock, unlock,
+	// trylockandset, setandunlock, getorlock, extendlock, list) with its duration and error
+	StorageOperation(op string, duration time.Duration, err error)
+}
@@ -0,0 +1,141 @@
+// Package metrics exports the measurements of idempotency managers to Prometheus:
+//
+//	collector := metrics.New(metrics.Options{})
+//	prometheus.MustRegister(collector)
+//	manager, _ := idempotency.NewManager(idempotency.Config{
+//		Storage:          store,
+//		MetricsCollector: collector,
+//	})
+//
+// It exports the counters <namespace>_cache_hits_total, _cache_misses_total,
+// _lock_conflicts_total and _mismatches_total, and the histograms
+// <namespace>_storage_operation_duration_seconds (by operation and result) and
+// <namespace>_replay_size_bytes.
+package metrics
+
+import (
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options configures the metrics
+type Options struct {
+	// Namespace prefixes the metric names
+	// Default: "idempotency"
+	Namespace string
+
+	// ConstLabels are added to every metric, e.g. to tell managers apart (optional)
+	ConstLabels prometheus.Labels
+
+	// StorageBuckets are the buckets of the storage latency histogram, in seconds
+	// Default: 0.5ms to ~1s, doubling
+	StorageBuckets []float64
+
+	// SizeBuckets are the buckets of the replayed body size histogram, in bytes
+	// Default: 64B to 1MiB, quadrupling
+	SizeBuckets []float64
+}
+
+// Collector implements idempotency.MetricsCollector and prometheus.Collector
+type Collector struct {
+	hits       prometheus.Counter
+	misses     prometheus.Counter
+	conflicts  prometheus.Counter
+	mismatches prometheus.Counter
+	storage    *prometheus.HistogramVec
+	replaySize prometheus.Histogram
+}
+
+var _ idempotency.MetricsCollector = (*Collector)(nil)
+
+// New creates the metrics, to be registered with a prometheus.Registerer
+func New(opts Options) *Collector {
+	if opts.Namespace == "" {
+		opts.Namespace = "idempotency"
+	}
+	if opts.StorageBuckets == nil {
+		opts.StorageBuckets = prometheus.ExponentialBuckets(0.0005, 2, 12)
+	}
+	if opts.SizeBuckets == nil {
+		opts.SizeBuckets = prometheus.ExponentialBuckets(64, 4, 10)
+	}
+
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: opts.Namespace, Name: name, Help: help, ConstLabels: opts.ConstLabels,
+		})
+	}
+
+	return &Collector{
+		hits:       counter("cache_hits_total", "Duplicates answered with a cached response."),
+		misses:     counter("cache_misses_total", "Requests processed for the first time."),
+		conflicts:  counter("lock_conflicts_total", "Duplicates rejected while the original request was in progress."),
+		mismatches: counter("mismatches_total", "Keys reused with a different request."),
+		storage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "storage_operation_duration_seconds",
+			Help:        "Duration of the storage operations, by operation and result (ok or error).",
+			ConstLabels: opts.ConstLabels,
+			Buckets:     opts.StorageBuckets,
+		}, []string{"operation", "result"}),
+		replaySize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "replay_size_bytes",
+			Help:        "Body size of the replayed responses.",
+			ConstLabels: opts.ConstLabels,
+			Buckets:     opts.SizeBuckets,
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.hits.Describe(ch)
+	c.misses.Describe(ch)
+	c.conflicts.Describe(ch)
+	c.mismatches.Describe(ch)
+	c.storage.Describe(ch)
+	c.replaySize.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.hits.Collect(ch)
+	c.misses.Collect(ch)
+	c.conflicts.Collect(ch)
+	c.mismatches.Collect(ch)
+	c.storage.Collect(ch)
+	c.replaySize.Collect(ch)
+}
+
+// CacheHit counts a replayed response and observes its size
+func (c *Collector) CacheHit(bodySize int) {
+	c.hits.Inc()
+	c.replaySize.Observe(float64(bodySize))
+}
+
+// CacheMiss counts a request processed for the first time
+func (c *Collector) CacheMiss() {
+	c.misses.Inc()
+}
+
+// LockConflict counts a duplicate of a request in progress
+func (c *Collector) LockConflict() {
+	c.conflicts.Inc()
+}
+
+// Mismatch counts a key reused with a different request
+func (c *Collector) Mismatch() {
+	c.mismatches.Inc()
+}
+
+// StorageOperation observes the duration of a storage operation
+func (c *Collector) StorageOperation(op string, duration time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	c.storage.WithLabelValues(op, result).Observe(duration.Seconds())
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	ctx := context.Background()
+	collector := New(Options{})
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store, MetricsCollector: collector})
+
+	newReq := func(body string) *idempotency.Request {
+		return &idempotency.Request{Method: "POST", Path: "/orders", IdempotencyKey: "k1", Body: []byte(body)}
+	}
+
+	// Miss, conflict, hit and mismatch
+	outcome, _ := manager.Begin(ctx, newReq("a"))
+	_, _ = manager.Begin(ctx, newReq("a"))
+	_ = outcome.Token.Complete(&idempotency.Response{StatusCode: 201, Body: []byte("created")})
+	_, _ = manager.Begin(ctx, newReq("a"))
+	_, _ = manager.Begin(ctx, newReq("b"))
+
+	for _, c := range []struct {
+		name    string
+		counter prometheus.Counter
+	}{
+		{"misses", collector.misses},
+		{"conflicts", collector.conflicts},
+		{"hits", collector.hits},
+		{"mismatches", collector.mismatches},
+	} {
+		if got := testutil.ToFloat64(c.counter); got != 1 {
+			t.Errorf("expected 1 %s, got %v", c.name, got)
+		}
+	}
+
+	if n := testutil.CollectAndCount(collector, "idempotency_replay_size_bytes"); n != 1 {
+		t.Errorf("expected the replay size histogram, got %d series", n)
+	}
+	if n := testutil.CollectAndCount(collector, "idempotency_storage_operation_duration_seconds"); n == 0 {
+		t.Error("expected storage latency series")
+	}
+	if problems, err := testutil.GatherAndLint(registry); err != nil || len(problems) > 0 {
+		t.Errorf("lint: %v %v", problems, err)
+	}
+}
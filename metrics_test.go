@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// recordingCollector is a MetricsCollector recording what it receives
+type recordingCollector struct {
+	hits, misses, conflicts, mismatches int
+	ops                                 []string
+}
+
+func (c *recordingCollector) CacheHit(bodySize int) { c.hits++ }
+func (c *recordingCollector) CacheMiss()            { c.misses++ }
+func (c *recordingCollector) LockConflict()         { c.conflicts++ }
+func (c *recordingCollector) Mismatch()             { c.mismatches++ }
+func (c *recordingCollector) StorageOperation(op string, duration time.Duration, err error) {
+	if err != nil {
+		op += ":error"
+	}
+	c.ops = append(c.ops, op)
+}
+
+func TestManager_MetricsCollector(t *testing.T) {
+	ctx := context.Background()
+	collector := &recordingCollector{}
+	store := &MockStorage{}
+	m, _ := NewManager(Config{Storage: store, MetricsCollector: collector})
+
+	t.Run("StorageOperations", func(t *testing.T) {
+		if err := m.Lock(ctx, &Request{IdempotencyKey: "k"}); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		store.GetFunc = func(ctx context.Context, key string) (*Record, error) {
+			return nil, errors.New("down")
+		}
+		_, _ = m.Check(ctx, &Request{IdempotencyKey: "k"})
+
+		want := []string{"trylock", "set", "get:error"}
+		if len(collector.ops) != len(want) {
+			t.Fatalf("expected operations %v, got %v", want, collector.ops)
+		}
+		for i := range want {
+			if collector.ops[i] != want[i] {
+				t.Fatalf("expected operations %v, got %v", want, collector.ops)
+			}
+		}
+		if collector.misses != 1 {
+			t.Errorf("expected 1 miss, got %d", collector.misses)
+		}
+	})
+
+	t.Run("Decisions", func(t *testing.T) {
+		record := &Record{Key: "k", Status: StatusCompleted, RequestHash: "other", Response: &CachedResponse{Body: []byte("ok")}}
+		store.GetFunc = func(ctx context.Context, key string) (*Record, error) { return record, nil }
+		_, _ = m.Check(ctx, &Request{IdempotencyKey: "k", Body: []byte("a")})
+
+		record.RequestHash = ""
+		_, _ = m.Check(ctx, &Request{IdempotencyKey: "k"})
+
+		store.TryLockFunc = func(ctx context.Context, k string, t time.Duration) (bool, error) { return false, nil }
+		_ = m.Lock(ctx, &Request{IdempotencyKey: "k2"})
+
+		if collector.mismatches != 1 || collector.hits != 1 || collector.conflicts != 1 {
+			t.Errorf("expected 1 mismatch, hit and conflict, got %+v", collector)
+		}
+	})
+}
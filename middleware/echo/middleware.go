@@ -3,126 +3,114 @@ package echo
 
 import (
 	"bytes"
+	"context"
 	"io"
-	"net/http"
 
 	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/internal/engine"
 	"github.com/labstack/echo/v4"
 )
 
+// Option configures the middleware
+type Option = engine.Option
+
+// WithPaths restricts the middleware to the request paths matching one of patterns, on top of
+// Config.IncludePaths: path.Match globs (e.g. "/v1/payments/*"), or regular expressions when
+// starting with "^". It panics on an invalid pattern.
+func WithPaths(patterns ...string) Option {
+	return engine.WithPaths(patterns...)
+}
+
+// SkipPaths passes the requests whose path matches one of patterns straight to the
+// handler, on top of Config.ExcludePaths, e.g. "/health" and "/metrics". It panics on an invalid pattern.
+func SkipPaths(patterns ...string) Option {
+	return engine.SkipPaths(patterns...)
+}
+
 // Idempotency returns an Echo middleware that handles idempotency
-func Idempotency(manager *idempotency.Manager) echo.MiddlewareFunc {
+func Idempotency(manager *idempotency.Manager, opts ...Option) echo.MiddlewareFunc {
+	paths := engine.Paths(opts)
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			req := c.Request()
-
-			// 1. Extract potential idempotency key from header
-			headerKey := req.Header.Get("Idempotency-Key")
-
-			// 2. Build dummy request for potential auto-generation
-			pReq := &idempotency.Request{
-				Method:         req.Method,
-				Path:           req.URL.Path,
-				Headers:        req.Header,
-				IdempotencyKey: headerKey,
-			}
-
-			// 3. Determine if we should apply idempotency
-			isMethodAllowed := manager.IsMethodAllowed(req.Method)
-			hasKey := headerKey != ""
-
-			// If no header key and method not allowed, skip early
-			if !hasKey && !isMethodAllowed {
-				return next(c)
-			}
-
-			// 4. Handle Request Body
-			var body []byte
-			if req.Body != nil {
-				var err error
-				body, err = io.ReadAll(req.Body)
-				if err != nil {
-					return echo.NewHTTPError(http.StatusBadRequest, "failed to read request body")
-				}
-				req.Body.Close()
-				req.Body = io.NopCloser(bytes.NewBuffer(body))
-			}
-			pReq.Body = body
-
-			// 5. Check for cached response
-			cachedResp, err := manager.Check(req.Context(), pReq)
-			if err != nil {
-				if err == idempotency.ErrRequestInProgress {
-					return echo.NewHTTPError(http.StatusConflict, "request already in progress")
-				}
-				if err == idempotency.ErrRequestMismatch {
-					return echo.NewHTTPError(http.StatusUnprocessableEntity, "idempotency key reused with different payload")
-				}
-				// Other errors proceed normally
-			}
-
-			// 6. Missing Key Handling (RequireKey check)
-			if pReq.IdempotencyKey == "" {
-				if manager.Config().RequireKey && isMethodAllowed {
-					return echo.NewHTTPError(http.StatusBadRequest, "idempotency key is required for this request")
-				}
-				return next(c)
-			}
-
-			// 7. Return cached response if available
-			if cachedResp != nil {
-				for key, values := range cachedResp.Headers {
-					for _, value := range values {
-						c.Response().Header().Add(key, value)
-					}
-				}
-				c.Response().Header().Set("X-Idempotent-Replayed", "true")
-				return c.Blob(cachedResp.StatusCode, cachedResp.ContentType, cachedResp.Body)
-			}
-
-			// 8. Acquire lock
-			if err := manager.Lock(req.Context(), pReq); err != nil {
-				if err == idempotency.ErrRequestInProgress {
-					return echo.NewHTTPError(http.StatusConflict, "request already in progress")
-				}
-			}
-
-			// 9. Capture response
-			res := c.Response()
-			originalWriter := res.Writer
-			bodyBuffer := &bytes.Buffer{}
-			mw := io.MultiWriter(originalWriter, bodyBuffer)
-			res.Writer = &responseWriter{Writer: mw, ResponseWriter: originalWriter}
-
-			// 10. Process request
-			err = next(c)
-
-			// Restore original writer
-			res.Writer = originalWriter
-
-			// 11. Store response
-			if pReq.IdempotencyKey != "" && res.Status < 500 {
-				resp := &idempotency.Response{
-					StatusCode:  res.Status,
-					Headers:     res.Header().Clone(),
-					Body:        bodyBuffer.Bytes(),
-					ContentType: res.Header().Get("Content-Type"),
-				}
-				_ = manager.Store(req.Context(), pReq.IdempotencyKey, resp)
-			} else if pReq.IdempotencyKey != "" {
-				_ = manager.Unlock(req.Context(), pReq.IdempotencyKey)
-			}
-
-			return err
+			return engine.Run(manager, paths, &shim{c: c, next: next})
 		}
 	}
 }
 
-type responseWriter struct {
-	io.Writer
-	http.ResponseWriter
+// shim adapts Echo to the idempotency engine
+type shim struct {
+	c    echo.Context
+	next echo.HandlerFunc
+}
+
+func (s *shim) Context() context.Context {
+	return s.c.Request().Context()
+}
+
+func (s *shim) Request(req *idempotency.Request) {
+	r := s.c.Request()
+	req.Method = r.Method
+	req.Path = r.URL.Path
+	req.Query = r.URL.RawQuery
+	req.Headers = r.Header
+}
+
+func (s *shim) ReadBody() ([]byte, error) {
+	req := s.c.Request()
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewBuffer(body))
+	return body, nil
+}
+
+func (s *shim) Skip() error {
+	return s.next(s.c)
 }
 
-func (w *responseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+func (s *shim) Next(body *idempotency.BodyCapture) (*idempotency.Response, error) {
+	res := s.c.Response()
+	originalWriter := res.Writer
+	res.Writer = idempotency.RecordResponseBody(originalWriter, body)
+
+	err := s.next(s.c)
+
+	// Restore original writer
+	res.Writer = originalWriter
+
+	return &idempotency.Response{
+		StatusCode:  res.Status,
+		Headers:     res.Header(),
+		ContentType: res.Header().Get("Content-Type"),
+	}, err
+}
+
+func (s *shim) Header(name, value string) {
+	s.c.Response().Header().Set(name, value)
+}
+
+func (s *shim) Write(resp *idempotency.CachedResponse, extra map[string]string) error {
+	return writeCachedResponse(s.c, resp, extra)
+}
+
+func (s *shim) Error(statusCode int, message string) error {
+	return echo.NewHTTPError(statusCode, message)
+}
+
+// writeCachedResponse writes a cached response, overriding headers with extra
+func writeCachedResponse(c echo.Context, resp *idempotency.CachedResponse, extra map[string]string) error {
+	for key, values := range resp.Headers {
+		for _, value := range values {
+			c.Response().Header().Add(key, value)
+		}
+	}
+	for key, value := range extra {
+		c.Response().Header().Set(key, value)
+	}
+	return c.Blob(resp.StatusCode, resp.ContentType, resp.Body)
 }
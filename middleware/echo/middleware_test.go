@@ -112,4 +112,25 @@ func TestEchoIdempotency(t *testing.T) {
 			t.Errorf("expected 400, got %d", rec.Code)
 		}
 	})
+
+	t.Run("RequireKey_ProblemDetails", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage:        store,
+			RequireKey:     true,
+			ProblemDetails: true,
+		})
+		e2 := echo.New()
+		e2.Use(Idempotency(m2))
+		e2.POST("/test", func(c echo.Context) error {
+			return c.String(http.StatusOK, "ok")
+		})
+
+		req := httptest.NewRequest("POST", "/test", nil)
+		rec := httptest.NewRecorder()
+		e2.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != idempotency.ProblemContentType {
+			t.Errorf("expected a 400 problem, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+	})
 }
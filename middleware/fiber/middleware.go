@@ -2,106 +2,116 @@
 package fiber
 
 import (
-	"net/http"
+	"context"
+	"net/textproto"
+	"strings"
 
 	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/internal/engine"
 	"github.com/gofiber/fiber/v2"
 )
 
+// Option configures the middleware
+type Option = engine.Option
+
+// WithPaths restricts the middleware to the request paths matching one of patterns, on top of
+// Config.IncludePaths: path.Match globs (e.g. "/v1/payments/*"), or regular expressions when
+// starting with "^". It panics on an invalid pattern.
+func WithPaths(patterns ...string) Option {
+	return engine.WithPaths(patterns...)
+}
+
+// SkipPaths passes the requests whose path matches one of patterns straight to the
+// handler, on top of Config.ExcludePaths, e.g. "/health" and "/metrics". It panics on an invalid pattern.
+func SkipPaths(patterns ...string) Option {
+	return engine.SkipPaths(patterns...)
+}
+
 // Idempotency returns a Fiber middleware that handles idempotency
-func Idempotency(manager *idempotency.Manager) fiber.Handler {
+func Idempotency(manager *idempotency.Manager, opts ...Option) fiber.Handler {
+	paths := engine.Paths(opts)
 	return func(c *fiber.Ctx) error {
-		// 1. Extract potential idempotency key from header
-		headerKey := c.Get("Idempotency-Key")
-
-		// 2. Build dummy request
-		pReq := &idempotency.Request{
-			Method:         c.Method(),
-			Path:           c.Path(),
-			Headers:        make(map[string][]string),
-			Body:           c.Body(),
-			IdempotencyKey: headerKey,
-		}
+		return engine.Run(manager, paths, &shim{c: c})
+	}
+}
 
-		// Copy headers
-		c.Request().Header.VisitAll(func(key, value []byte) {
-			k := string(key)
-			pReq.Headers[k] = append(pReq.Headers[k], string(value))
-		})
+// shim adapts Fiber to the idempotency engine
+type shim struct {
+	c *fiber.Ctx
+}
 
-		// 3. Determine if we should apply idempotency
-		isMethodAllowed := manager.IsMethodAllowed(c.Method())
-		hasKey := headerKey != ""
+func (s *shim) Context() context.Context {
+	return s.c.Context()
+}
 
-		// If no header key and method not allowed, skip early
-		if !hasKey && !isMethodAllowed {
-			return c.Next()
-		}
+func (s *shim) Request(req *idempotency.Request) {
+	req.Method = s.c.Method()
+	req.Path = s.c.Path()
+	req.Query = string(s.c.Request().URI().QueryString())
+	req.Headers = make(map[string][]string)
 
-		// 4. Check for cached response
-		cachedResp, err := manager.Check(c.Context(), pReq)
-		if err != nil {
-			if err == idempotency.ErrRequestInProgress {
-				return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "request already in progress"})
-			}
-			if err == idempotency.ErrRequestMismatch {
-				return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{"error": "idempotency key reused with different payload"})
-			}
-			// Other errors proceed normally
-		}
+	// Copy headers, canonicalized like net/http even when Fiber does not normalize them
+	s.c.Request().Header.VisitAll(func(key, value []byte) {
+		k := textproto.CanonicalMIMEHeaderKey(string(key))
+		req.Headers[k] = append(req.Headers[k], string(value))
+	})
+}
 
-		// 5. Missing Key Handling (RequireKey check)
-		if pReq.IdempotencyKey == "" {
-			if manager.Config().RequireKey && isMethodAllowed {
-				return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "idempotency key is required for this request"})
-			}
-			return c.Next()
-		}
+func (s *shim) ReadBody() ([]byte, error) {
+	return s.c.Body(), nil
+}
 
-		// 6. Return cached response if available
-		if cachedResp != nil {
-			for key, values := range cachedResp.Headers {
-				for _, value := range values {
-					c.Set(key, value)
-				}
-			}
-			c.Set("X-Idempotent-Replayed", "true")
-			c.Status(cachedResp.StatusCode)
-			if cachedResp.ContentType != "" {
-				c.Set(fiber.HeaderContentType, cachedResp.ContentType)
-			}
-			return c.Send(cachedResp.Body)
-		}
+func (s *shim) Skip() error {
+	return s.c.Next()
+}
 
-		// 7. Acquire lock
-		if err := manager.Lock(c.Context(), pReq); err != nil {
-			if err == idempotency.ErrRequestInProgress {
-				return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "request already in progress"})
-			}
-		}
+func (s *shim) Next(body *idempotency.BodyCapture) (*idempotency.Response, error) {
+	err := s.c.Next()
+	if s.c.Response().IsBodyStream() || strings.HasPrefix(string(s.c.Response().Header.ContentType()), "text/event-stream") {
+		// Reading a body stream would consume it before it is sent
+		body.Stream()
+	} else {
+		_, _ = body.Write(s.c.Response().Body())
+	}
 
-		// 8. Process request
-		err = c.Next()
-
-		// 9. Store response
-		if pReq.IdempotencyKey != "" && c.Response().StatusCode() < 500 {
-			headers := make(map[string][]string)
-			c.Response().Header.VisitAll(func(key, value []byte) {
-				k := string(key)
-				headers[k] = append(headers[k], string(value))
-			})
-
-			resp := &idempotency.Response{
-				StatusCode:  c.Response().StatusCode(),
-				Headers:     headers,
-				Body:        c.Response().Body(),
-				ContentType: string(c.Response().Header.Peek(fiber.HeaderContentType)),
-			}
-			_ = manager.Store(c.Context(), pReq.IdempotencyKey, resp)
-		} else if pReq.IdempotencyKey != "" {
-			_ = manager.Unlock(c.Context(), pReq.IdempotencyKey)
-		}
+	headers := make(map[string][]string)
+	s.c.Response().Header.VisitAll(func(key, value []byte) {
+		k := textproto.CanonicalMIMEHeaderKey(string(key))
+		headers[k] = append(headers[k], string(value))
+	})
+
+	return &idempotency.Response{
+		StatusCode:  s.c.Response().StatusCode(),
+		Headers:     headers,
+		ContentType: string(s.c.Response().Header.Peek(fiber.HeaderContentType)),
+	}, err
+}
+
+func (s *shim) Header(name, value string) {
+	s.c.Set(name, value)
+}
 
-		return err
+func (s *shim) Write(resp *idempotency.CachedResponse, extra map[string]string) error {
+	return writeCachedResponse(s.c, resp, extra)
+}
+
+func (s *shim) Error(statusCode int, message string) error {
+	return s.c.Status(statusCode).JSON(fiber.Map{"error": message})
+}
+
+// writeCachedResponse writes a cached response, overriding headers with extra
+func writeCachedResponse(c *fiber.Ctx, resp *idempotency.CachedResponse, extra map[string]string) error {
+	for key, values := range resp.Headers {
+		for _, value := range values {
+			c.Set(key, value)
+		}
+	}
+	for key, value := range extra {
+		c.Set(key, value)
+	}
+	c.Status(resp.StatusCode)
+	if resp.ContentType != "" {
+		c.Set(fiber.HeaderContentType, resp.ContentType)
 	}
+	return c.Send(resp.Body)
 }
@@ -109,3 +109,51 @@ func TestFiberIdempotency(t *testing.T) {
 		}
 	})
 }
+
+func TestFiberIdempotency_CanonicalHeaders(t *testing.T) {
+	app := fiber.New(fiber.Config{DisableHeaderNormalizing: true})
+	store := &MockStorage{
+		Records: make(map[string]*idempotency.Record),
+		Locks:   make(map[string]bool),
+	}
+
+	var requestHeaders map[string][]string
+	manager, _ := idempotency.NewManager(idempotency.Config{
+		Storage: store,
+		RequestHasher: hasherFunc(func(req *idempotency.Request) (string, error) {
+			requestHeaders = req.Headers
+			return "hash", nil
+		}),
+	})
+
+	app.Use(Idempotency(manager))
+	app.Post("/test", func(c *fiber.Ctx) error {
+		c.Set("x-request-id", "42")
+		return c.SendString("ok")
+	})
+
+	req := httptest.NewRequest("POST", "/test", bytes.NewBuffer([]byte("data")))
+	req.Header["idempotency-key"] = []string{"canonical-key"}
+	req.Header["x-tenant"] = []string{"acme"}
+	if _, err := app.Test(req); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	if got := requestHeaders["X-Tenant"]; len(got) != 1 || got[0] != "acme" {
+		t.Errorf("expected canonical request header X-Tenant, got %v", requestHeaders)
+	}
+
+	record := store.Records["canonical-key"]
+	if record == nil || record.Response == nil {
+		t.Fatal("expected cached response")
+	}
+	if got := record.Response.Headers["X-Request-Id"]; len(got) != 1 || got[0] != "42" {
+		t.Errorf("expected canonical response header X-Request-Id, got %v", record.Response.Headers)
+	}
+}
+
+type hasherFunc func(req *idempotency.Request) (string, error)
+
+func (f hasherFunc) Hash(req *idempotency.Request) (string, error) {
+	return f(req)
+}
@@ -2,157 +2,151 @@
 package gin
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"io"
-	"net/http"
+	"net"
 
 	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/internal/engine"
 	"github.com/gin-gonic/gin"
 )
 
+// Option configures the middleware
+type Option = engine.Option
+
+// WithPaths restricts the middleware to the request paths matching one of patterns, on top of
+// Config.IncludePaths: path.Match globs (e.g. "/v1/payments/*"), or regular expressions when
+// starting with "^". It panics on an invalid pattern.
+func WithPaths(patterns ...string) Option {
+	return engine.WithPaths(patterns...)
+}
+
+// SkipPaths passes the requests whose path matches one of patterns straight to the
+// handler, on top of Config.ExcludePaths, e.g. "/health" and "/metrics". It panics on an invalid pattern.
+func SkipPaths(patterns ...string) Option {
+	return engine.SkipPaths(patterns...)
+}
+
 // Idempotency returns a Gin middleware that handles idempotency
-func Idempotency(manager *idempotency.Manager) gin.HandlerFunc {
+func Idempotency(manager *idempotency.Manager, opts ...Option) gin.HandlerFunc {
+	paths := engine.Paths(opts)
 	return func(c *gin.Context) {
-		// 1. Extract potential idempotency key from header
-		headerKey := c.GetHeader("Idempotency-Key")
-
-		// 2. Build dummy request for potential auto-generation
-		// We avoid reading the body until we are sure we need it
-		pReq := &idempotency.Request{
-			Method:         c.Request.Method,
-			Path:           c.Request.URL.Path,
-			Headers:        c.Request.Header,
-			IdempotencyKey: headerKey,
-		}
+		_ = engine.Run(manager, paths, &shim{c: c})
+	}
+}
 
-		// 3. Determine if we should apply idempotency
-		isMethodAllowed := manager.IsMethodAllowed(c.Request.Method)
-		hasKey := headerKey != ""
+// shim adapts Gin to the idempotency engine
+type shim struct {
+	c *gin.Context
+}
 
-		// If no header key, check if we should generate one or if it's even allowed
-		if !hasKey {
-			// If method is not allowed AND we don't have a header key, skip early
-			if !isMethodAllowed {
-				c.Next()
-				return
-			}
+func (s *shim) Context() context.Context {
+	return s.c.Request.Context()
+}
 
-			// If method is allowed, check if we have a strategy to generate it
-			// This might require reading the body later if it's a BodyHash strategy
-		}
+func (s *shim) Request(req *idempotency.Request) {
+	req.Method = s.c.Request.Method
+	req.Path = s.c.Request.URL.Path
+	req.Query = s.c.Request.URL.RawQuery
+	req.Headers = s.c.Request.Header
+}
 
-		// 4. Handle Request Body (if needed for idempotency or just to be safe)
-		var body []byte
-		if c.Request.Body != nil {
-			var err error
-			body, err = io.ReadAll(c.Request.Body)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
-				c.Abort()
-				return
-			}
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
-		}
-		pReq.Body = body
+func (s *shim) ReadBody() ([]byte, error) {
+	if s.c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(s.c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	return body, nil
+}
 
-		// 5. Final Key Generation (e.g. if using BodyHash and header was empty)
-		if pReq.IdempotencyKey == "" {
-			// Get manager.config to access the strategy
-			// (Assuming we might need to expose Strategy better or just call it)
-			// But for now, we follow the manager.Check logic which calls it.
-		}
+func (s *shim) Skip() error {
+	s.c.Next()
+	return nil
+}
 
-		// 6. Check for cached response
-		cachedResp, err := manager.Check(c.Request.Context(), pReq)
-		if err != nil {
-			if err == idempotency.ErrRequestInProgress {
-				c.JSON(http.StatusConflict, gin.H{"error": "request already in progress"})
-				c.Abort()
-				return
-			}
-			if err == idempotency.ErrRequestMismatch {
-				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "idempotency key reused with different payload"})
-				c.Abort()
-				return
-			}
-			// Other errors proceed normally
-		}
+func (s *shim) Next(body *idempotency.BodyCapture) (*idempotency.Response, error) {
+	writer := &responseWriter{
+		ResponseWriter: s.c.Writer,
+		body:           body,
+	}
+	s.c.Writer = writer
 
-		// 7. Missing Key Handling (RequireKey check)
-		// At this point, Check() should have populated IdempotencyKey if it could.
-		if pReq.IdempotencyKey == "" {
-			// If it's a method that usually requires it (or global list) and RequireKey is on
-			if manager.Config().RequireKey && isMethodAllowed {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "idempotency key is required for this request"})
-				c.Abort()
-				return
-			}
-			// Otherwise just skip
-			c.Next()
-			return
-		}
+	s.c.Next()
 
-		// 8. Return cached response if available
-		if cachedResp != nil {
-			for key, values := range cachedResp.Headers {
-				for _, value := range values {
-					c.Header(key, value)
-				}
-			}
-			c.Header("X-Idempotent-Replayed", "true")
-			c.Data(cachedResp.StatusCode, cachedResp.ContentType, cachedResp.Body)
-			c.Abort()
-			return
-		}
+	return &idempotency.Response{
+		StatusCode:  s.c.Writer.Status(),
+		Headers:     s.c.Writer.Header(),
+		ContentType: s.c.Writer.Header().Get("Content-Type"),
+	}, nil
+}
 
-		// 9. Acquire lock
-		if err := manager.Lock(c.Request.Context(), pReq); err != nil {
-			if err == idempotency.ErrRequestInProgress {
-				c.JSON(http.StatusConflict, gin.H{"error": "request already in progress"})
-				c.Abort()
-				return
-			}
-			// Proceed without idempotency if lock fails for other reasons?
-			// Usually safer to allow but depends on preference.
-			// We follow existing logic.
-		}
+func (s *shim) Header(name, value string) {
+	s.c.Header(name, value)
+}
 
-		// 10. Capture response
-		writer := &responseWriter{
-			ResponseWriter: c.Writer,
-			body:           &bytes.Buffer{},
-		}
-		c.Writer = writer
-
-		c.Next()
-
-		// 11. Store response
-		if pReq.IdempotencyKey != "" && c.Writer.Status() < 500 {
-			resp := &idempotency.Response{
-				StatusCode:  c.Writer.Status(),
-				Headers:     c.Writer.Header().Clone(),
-				Body:        writer.body.Bytes(),
-				ContentType: c.Writer.Header().Get("Content-Type"),
-			}
-			_ = manager.Store(c.Request.Context(), pReq.IdempotencyKey, resp)
-		} else if pReq.IdempotencyKey != "" {
-			_ = manager.Unlock(c.Request.Context(), pReq.IdempotencyKey)
+func (s *shim) Write(resp *idempotency.CachedResponse, extra map[string]string) error {
+	writeCachedResponse(s.c, resp, extra)
+	s.c.Abort()
+	return nil
+}
+
+func (s *shim) Error(statusCode int, message string) error {
+	s.c.AbortWithStatusJSON(statusCode, gin.H{"error": message})
+	return nil
+}
+
+// writeCachedResponse writes a cached response, overriding headers with extra
+func writeCachedResponse(c *gin.Context, resp *idempotency.CachedResponse, extra map[string]string) {
+	for key, values := range resp.Headers {
+		for _, value := range values {
+			c.Header(key, value)
 		}
 	}
+	for key, value := range extra {
+		c.Header(key, value)
+	}
+	c.Data(resp.StatusCode, resp.ContentType, resp.Body)
 }
 
 // responseWriter wraps gin.ResponseWriter to capture response body
 type responseWriter struct {
 	gin.ResponseWriter
-	body *bytes.Buffer
+	body *idempotency.BodyCapture
 }
 
 func (w *responseWriter) Write(data []byte) (int, error) {
-	w.body.Write(data)
+	w.detectStreaming()
+	_, _ = w.body.Write(data)
 	return w.ResponseWriter.Write(data)
 }
 
 func (w *responseWriter) WriteString(s string) (int, error) {
-	w.body.WriteString(s)
+	w.detectStreaming()
+	_, _ = io.WriteString(w.body, s)
 	return w.ResponseWriter.WriteString(s)
 }
+
+// Flush marks the response as streaming (e.g. c.Stream) and flushes it
+func (w *responseWriter) Flush() {
+	w.body.Stream()
+	w.ResponseWriter.Flush()
+}
+
+// Hijack hijacks the connection, the response is then not cached
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.body.Discard()
+	return w.ResponseWriter.Hijack()
+}
+
+// detectStreaming marks server-sent events (e.g. c.SSEvent) as streaming on first write
+func (w *responseWriter) detectStreaming() {
+	if !w.Written() && idempotency.IsStreamingResponse(w.Header()) {
+		w.body.Stream()
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	idempotency "github.com/fco-gt/gopotency"
 	ginmw "github.com/fco-gt/gopotency/middleware/gin"
+	"github.com/fco-gt/gopotency/storage/memory"
 	"github.com/gin-gonic/gin"
 )
 
@@ -124,6 +128,27 @@ func TestGinIdempotency(t *testing.T) {
 		}
 	})
 
+	t.Run("RequireKey_ProblemDetails", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage:        store,
+			RequireKey:     true,
+			ProblemDetails: true,
+		})
+		r2 := gin.New()
+		r2.Use(ginmw.Idempotency(m2))
+		r2.POST("/test", func(c *gin.Context) {
+			c.Status(200)
+		})
+
+		req, _ := http.NewRequest("POST", "/test", nil)
+		w := httptest.NewRecorder()
+		r2.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != idempotency.ProblemContentType {
+			t.Errorf("expected a 400 problem, got %d %q", w.Code, w.Header().Get("Content-Type"))
+		}
+	})
+
 	t.Run("WriteString", func(t *testing.T) {
 		r3 := gin.New()
 		r3.Use(ginmw.Idempotency(manager))
@@ -140,4 +165,186 @@ func TestGinIdempotency(t *testing.T) {
 			t.Errorf("expected body 'hello string', got '%s'", w.Body.String())
 		}
 	})
+
+	t.Run("CustomMessages", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage:    store,
+			RequireKey: true,
+			Messages:   idempotency.Messages{KeyRequired: "Idempotency-Key fehlt"},
+		})
+		r4 := gin.New()
+		r4.Use(ginmw.Idempotency(m2))
+		r4.POST("/test", func(c *gin.Context) {
+			c.Status(200)
+		})
+
+		req, _ := http.NewRequest("POST", "/test", nil)
+		w := httptest.NewRecorder()
+		r4.ServeHTTP(w, req)
+
+		var body map[string]string
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if body["error"] != "Idempotency-Key fehlt" {
+			t.Errorf("expected custom message, got %q", body["error"])
+		}
+	})
+}
+
+func TestGinIdempotency_Paths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &MockStorage{
+		Records: make(map[string]*idempotency.Record),
+		Locks:   make(map[string]bool),
+	}
+	manager, _ := idempotency.NewManager(idempotency.Config{
+		Storage:      store,
+		IncludePaths: []string{"/v1/payments/*"},
+	})
+
+	r := gin.New()
+	r.Use(ginmw.Idempotency(manager))
+	count := 0
+	handler := func(c *gin.Context) {
+		count++
+		c.JSON(200, gin.H{"count": count})
+	}
+	r.POST("/v1/payments/charge", handler)
+	r.POST("/v1/sessions", handler)
+
+	for _, path := range []string{"/v1/payments/charge", "/v1/sessions"} {
+		for range 2 {
+			req, _ := http.NewRequest("POST", path, bytes.NewBuffer([]byte("data")))
+			req.Header.Set("Idempotency-Key", "gin-paths")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+	if count != 3 {
+		t.Errorf("expected the payment to be replayed and the excluded path to run twice, got %d calls", count)
+	}
+}
+
+func TestGinIdempotency_PathOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &MockStorage{
+		Records: make(map[string]*idempotency.Record),
+		Locks:   make(map[string]bool),
+	}
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store})
+
+	// Two groups share the manager, each restricting its own middleware
+	r := gin.New()
+	count := 0
+	handler := func(c *gin.Context) {
+		count++
+		c.JSON(200, gin.H{"count": count})
+	}
+	payments := r.Group("/v1/payments", ginmw.Idempotency(manager, ginmw.WithPaths("/v1/payments/*")))
+	payments.POST("/charge", handler)
+	admin := r.Group("/admin", ginmw.Idempotency(manager, ginmw.SkipPaths("/admin/reindex")))
+	admin.POST("/reindex", handler)
+	admin.POST("/users", handler)
+
+	for _, path := range []string{"/v1/payments/charge", "/admin/reindex", "/admin/users"} {
+		for range 2 {
+			req, _ := http.NewRequest("POST", path, bytes.NewBuffer([]byte("data")))
+			req.Header.Set("Idempotency-Key", "gin-options"+path)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+	if count != 4 {
+		t.Errorf("expected the skipped path to run twice and the others to be replayed, got %d calls", count)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an invalid pattern to panic")
+		}
+	}()
+	ginmw.Idempotency(manager, ginmw.WithPaths("["))
+}
+
+func TestGinIdempotency_ErrorHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &MockStorage{
+		Records: make(map[string]*idempotency.Record),
+		Locks:   map[string]bool{"gin-locked": true},
+	}
+	manager, _ := idempotency.NewManager(idempotency.Config{
+		Storage: store,
+		ErrorHandler: func(err error) (int, any) {
+			if errors.Is(err, idempotency.ErrRequestInProgress) {
+				return http.StatusLocked, &idempotency.Problem{Type: "https://example.com/in-progress", Title: "In progress", Status: http.StatusLocked}
+			}
+			return 0, map[string]string{"message": err.Error()}
+		},
+	})
+
+	r := gin.New()
+	r.Use(ginmw.Idempotency(manager))
+	r.POST("/test", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req, _ := http.NewRequest("POST", "/test", bytes.NewBuffer([]byte("data")))
+	req.Header.Set("Idempotency-Key", "gin-locked")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusLocked || w.Header().Get("Content-Type") != idempotency.ProblemContentType || !strings.Contains(w.Body.String(), "in-progress") {
+		t.Errorf("expected the conflict rendered by ErrorHandler, got %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	m2, _ := idempotency.NewManager(idempotency.Config{
+		Storage:      store,
+		RequireKey:   true,
+		ErrorHandler: manager.Config().ErrorHandler,
+	})
+	r2 := gin.New()
+	r2.Use(ginmw.Idempotency(m2))
+	r2.POST("/test", func(c *gin.Context) { c.Status(http.StatusOK) })
+	w = httptest.NewRecorder()
+	r2.ServeHTTP(w, httptest.NewRequest("POST", "/test", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"message"`) {
+		t.Errorf("expected the default status code with the ErrorHandler body, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestGinIdempotency_HandlerPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, _ := idempotency.NewManager(idempotency.Config{
+		Storage:             store,
+		LockRenewalInterval: 10 * time.Millisecond,
+		MaxPendingPerScope:  1,
+	})
+	defer manager.Close()
+
+	r := gin.New()
+	r.Use(gin.RecoveryWithWriter(io.Discard), ginmw.Idempotency(manager))
+	panics := true
+	r.POST("/orders", func(c *gin.Context) {
+		if panics {
+			panic("boom")
+		}
+		c.JSON(201, gin.H{"status": "created"})
+	})
+
+	send := func(key string) int {
+		req, _ := http.NewRequest("POST", "/orders", bytes.NewBuffer([]byte("data")))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send("gin-panic"); code != http.StatusInternalServerError {
+		t.Fatalf("expected the panic to be recovered by gin, got %d", code)
+	}
+
+	// The lock, its renewal and the quota slot of the panicking request are released
+	panics = false
+	if code := send("gin-panic"); code != 201 {
+		t.Errorf("expected the retry to be processed, got %d", code)
+	}
+	if code := send("gin-other"); code != 201 {
+		t.Errorf("expected the quota slot to be released, got %d", code)
+	}
 }
@@ -0,0 +1,170 @@
+// Package gorillamux provides gorilla/mux middleware for idempotency handling, with key
+// strategies built from the route variables of the matched route.
+package gorillamux
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/internal/engine"
+	"github.com/fco-gt/gopotency/key"
+	"github.com/gorilla/mux"
+)
+
+// Option configures the middleware
+type Option = engine.Option
+
+// WithPaths restricts the middleware to the request paths matching one of patterns, on top of
+// Config.IncludePaths: path.Match globs (e.g. "/v1/payments/*"), or regular expressions when
+// starting with "^". It panics on an invalid pattern.
+func WithPaths(patterns ...string) Option {
+	return engine.WithPaths(patterns...)
+}
+
+// SkipPaths passes the requests whose path matches one of patterns straight to the
+// handler, on top of Config.ExcludePaths, e.g. "/health" and "/metrics". It panics on an invalid pattern.
+func SkipPaths(patterns ...string) Option {
+	return engine.SkipPaths(patterns...)
+}
+
+// Idempotency returns a gorilla/mux middleware that handles idempotency. Register it
+// with Router.Use, so the route is matched and its variables are available to the key
+// strategy (see Vars).
+func Idempotency(manager *idempotency.Manager, opts ...Option) mux.MiddlewareFunc {
+	paths := engine.Paths(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), varsContextKey{}, mux.Vars(r))
+			_ = engine.Run(manager, paths, &shim{w: w, r: r.WithContext(ctx), next: next})
+		})
+	}
+}
+
+type varsContextKey struct{}
+
+// Vars returns the route variables of the request, as set by the Idempotency middleware
+func Vars(req *idempotency.Request) map[string]string {
+	vars, _ := req.Context().Value(varsContextKey{}).(map[string]string)
+	return vars
+}
+
+// VarsScope returns the values of the given route variables, or of all of them when
+// none is given, to scope keys by resource with key.Scoped:
+//
+//	key.Scoped(key.HeaderBased("Idempotency-Key"), gorillamux.VarsScope("id"))
+func VarsScope(names ...string) key.ScopeFunc {
+	return func(req *idempotency.Request) string {
+		vars := Vars(req)
+		selected := names
+		if len(selected) == 0 {
+			selected = slices.Sorted(maps.Keys(vars))
+		}
+		return joinVars(vars, selected)
+	}
+}
+
+// joinVars joins the query-escaped values of the given variables as a query string, so
+// values containing separators cannot collide
+func joinVars(vars map[string]string, names []string) string {
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = url.QueryEscape(name) + "=" + url.QueryEscape(vars[name])
+	}
+	return strings.Join(parts, "&")
+}
+
+// placeholderPattern matches the {name} placeholders of key templates
+var placeholderPattern = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// KeyTemplate creates a key strategy generating keys from the route variables of the
+// request, e.g. "avatar-{id}" for "/users/{id}/avatar", for endpoints whose resource
+// identifies the operation. No key is generated when a variable is missing.
+func KeyTemplate(template string) idempotency.KeyStrategy {
+	return &templateGenerator{template: template}
+}
+
+type templateGenerator struct {
+	template string
+}
+
+func (g *templateGenerator) Generate(req *idempotency.Request) (string, error) {
+	vars := Vars(req)
+	missing := false
+	generated := placeholderPattern.ReplaceAllStringFunc(g.template, func(placeholder string) string {
+		value, ok := vars[placeholder[1:len(placeholder)-1]]
+		if !ok {
+			missing = true
+		}
+		return value
+	})
+	if missing {
+		return "", nil
+	}
+	return generated, nil
+}
+
+// shim adapts gorilla/mux to the idempotency engine
+type shim struct {
+	w    http.ResponseWriter
+	r    *http.Request
+	next http.Handler
+}
+
+func (s *shim) Context() context.Context {
+	return s.r.Context()
+}
+
+func (s *shim) Request(req *idempotency.Request) {
+	req.Method = s.r.Method
+	req.Path = s.r.URL.Path
+	req.Query = s.r.URL.RawQuery
+	req.Headers = s.r.Header
+}
+
+func (s *shim) ReadBody() ([]byte, error) {
+	if s.r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(s.r.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.r.Body.Close()
+	s.r.Body = io.NopCloser(bytes.NewBuffer(body))
+	return body, nil
+}
+
+func (s *shim) Skip() error {
+	s.next.ServeHTTP(s.w, s.r)
+	return nil
+}
+
+func (s *shim) Next(body *idempotency.BodyCapture) (*idempotency.Response, error) {
+	recorder := idempotency.RecordResponseBody(s.w, body)
+	s.next.ServeHTTP(recorder, s.r)
+	return recorder.Response(), nil
+}
+
+func (s *shim) Header(name, value string) {
+	s.w.Header().Set(name, value)
+}
+
+func (s *shim) Write(resp *idempotency.CachedResponse, extra map[string]string) error {
+	idempotency.WriteCachedResponse(s.w, resp, extra)
+	return nil
+}
+
+func (s *shim) Error(statusCode int, message string) error {
+	s.w.Header().Set("Content-Type", "application/json")
+	s.w.WriteHeader(statusCode)
+	return json.NewEncoder(s.w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,70 @@
+package gorillamux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/key"
+	"github.com/fco-gt/gopotency/storage/memory"
+	"github.com/gorilla/mux"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	newRouter := func(strategy idempotency.KeyStrategy) (*mux.Router, *int) {
+		store := memory.NewMemoryStorage()
+		t.Cleanup(func() { store.Close() })
+		manager, _ := idempotency.NewManager(idempotency.Config{Storage: store, KeyStrategy: strategy})
+
+		calls := 0
+		r := mux.NewRouter()
+		r.Use(Idempotency(manager))
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("user " + mux.Vars(r)["id"]))
+		}
+		r.HandleFunc("/users/{id}/orders", handler).Methods("POST")
+		r.HandleFunc("/users/{id}/avatar", handler).Methods("PUT")
+		return r, &calls
+	}
+	serve := func(r *mux.Router, method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"item":1}`))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("VarsScope", func(t *testing.T) {
+		r, calls := newRouter(key.Scoped(key.HeaderBased("Idempotency-Key"), VarsScope("id")))
+
+		if w := serve(r, "POST", "/users/1/orders", "k1"); w.Body.String() != "user 1" {
+			t.Fatalf("unexpected response %q", w.Body.String())
+		}
+		if w := serve(r, "POST", "/users/2/orders", "k1"); w.Body.String() != "user 2" {
+			t.Errorf("expected another resource not to share the key, got %q", w.Body.String())
+		}
+		if w := serve(r, "POST", "/users/1/orders", "k1"); w.Body.String() != "user 1" || *calls != 2 {
+			t.Errorf("expected a replay, got %q after %d calls", w.Body.String(), *calls)
+		}
+	})
+
+	t.Run("KeyTemplate", func(t *testing.T) {
+		r, calls := newRouter(KeyTemplate("avatar-{id}"))
+
+		serve(r, "PUT", "/users/7/avatar", "")
+		w := serve(r, "PUT", "/users/7/avatar", "")
+		if w.Code != http.StatusCreated || w.Header().Get(idempotency.ReplayedHeaderName) == "" || *calls != 1 {
+			t.Errorf("expected the templated key to be replayed, got %d %v after %d calls", w.Code, w.Header(), *calls)
+		}
+		serve(r, "PUT", "/users/8/avatar", "")
+		if *calls != 2 {
+			t.Errorf("expected another resource to get another key, got %d calls", *calls)
+		}
+	})
+}
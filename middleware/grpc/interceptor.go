@@ -0,0 +1,193 @@
+// Package grpc provides a gRPC unary server interceptor for idempotency handling:
+//
+//	server := grpc.NewServer(grpc.UnaryInterceptor(grpcmw.UnaryServerInterceptor(manager)))
+//
+// The idempotency key is read from the incoming metadata (e.g. "idempotency-key" for the
+// default Config.HeaderName), requests are hashed from their deterministic protobuf
+// encoding and successful responses are cached serialized, then replayed to duplicates.
+// Calls are handled as POST requests whose path is the full method name (e.g.
+// "/payments.v1.Payments/Charge"), so RoutePolicies can match them.
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/internal/engine"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// ContentType is the content type of cached responses, whose proto parameter is
+// the full name of the response message
+const ContentType = "application/x-protobuf"
+
+// Option configures the interceptor
+type Option = engine.Option
+
+// WithPaths restricts the interceptor to the full method names matching one of patterns, on top of
+// Config.IncludePaths: path.Match globs (e.g. "/payments.v1.Payments/*"), or regular expressions when
+// starting with "^". It panics on an invalid pattern.
+func WithPaths(patterns ...string) Option {
+	return engine.WithPaths(patterns...)
+}
+
+// SkipPaths passes the requests whose full method name matches one of patterns straight to the
+// handler, on top of Config.ExcludePaths, e.g. "/grpc.health.v1.Health/*". It panics on an invalid pattern.
+func SkipPaths(patterns ...string) Option {
+	return engine.SkipPaths(patterns...)
+}
+
+// UnaryServerInterceptor returns a unary server interceptor that handles idempotency.
+// Requests and responses that are not protobuf messages are passed through.
+func UnaryServerInterceptor(manager *idempotency.Manager, opts ...Option) grpc.UnaryServerInterceptor {
+	paths := engine.Paths(opts)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		s := &shim{ctx: ctx, req: msg, method: info.FullMethod, handler: handler}
+		err := engine.Run(manager, paths, s)
+		return s.resp, err
+	}
+}
+
+// shim adapts a unary call to the idempotency engine
+type shim struct {
+	ctx     context.Context
+	req     proto.Message
+	method  string
+	handler grpc.UnaryHandler
+
+	// resp is the response returned by the interceptor
+	resp any
+}
+
+func (s *shim) Context() context.Context {
+	return s.ctx
+}
+
+func (s *shim) Request(req *idempotency.Request) {
+	req.Method = http.MethodPost
+	req.Path = s.method
+
+	// Metadata keys are lowercase, header lookups expect canonical names
+	md, _ := metadata.FromIncomingContext(s.ctx)
+	req.Headers = make(map[string][]string, len(md))
+	for name, values := range md {
+		req.Headers[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+}
+
+func (s *shim) ReadBody() ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(s.req)
+}
+
+func (s *shim) Skip() error {
+	var err error
+	s.resp, err = s.handler(s.ctx, s.req)
+	return err
+}
+
+func (s *shim) Next(body *idempotency.BodyCapture) (*idempotency.Response, error) {
+	resp, err := s.handler(s.ctx, s.req)
+	s.resp = resp
+	if err != nil {
+		return nil, err
+	}
+
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		// Not cached, the record is failed so retries are processed again
+		return &idempotency.Response{StatusCode: http.StatusInternalServerError}, nil
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return &idempotency.Response{StatusCode: http.StatusInternalServerError}, nil
+	}
+	_, _ = body.Write(data)
+	return &idempotency.Response{
+		StatusCode:  http.StatusOK,
+		ContentType: mime.FormatMediaType(ContentType, map[string]string{"proto": string(msg.ProtoReflect().Descriptor().FullName())}),
+	}, nil
+}
+
+func (s *shim) Header(name, value string) {
+	_ = grpc.SetHeader(s.ctx, metadata.Pairs(name, value))
+}
+
+func (s *shim) Write(resp *idempotency.CachedResponse, extra map[string]string) error {
+	// Errors written as problem details with Config.ProblemDetails
+	if resp.ContentType == idempotency.ProblemContentType {
+		var problem idempotency.Problem
+		_ = json.Unmarshal(resp.Body, &problem)
+		return s.Error(resp.StatusCode, problem.Detail)
+	}
+	// Errors rendered by Config.ErrorHandler
+	if resp.StatusCode >= http.StatusBadRequest {
+		return s.Error(resp.StatusCode, strings.TrimSpace(string(resp.Body)))
+	}
+
+	msg, err := unmarshalResponse(resp)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	for name, value := range extra {
+		s.Header(name, value)
+	}
+	s.resp = msg
+	return nil
+}
+
+func (s *shim) Error(statusCode int, message string) error {
+	return status.Error(code(statusCode), message)
+}
+
+// unmarshalResponse decodes a cached response into a message of its recorded type
+func unmarshalResponse(resp *idempotency.CachedResponse) (proto.Message, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.ContentType)
+	if err != nil || mediaType != ContentType || params["proto"] == "" {
+		return nil, errors.New("cached response is not a protobuf message")
+	}
+
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(params["proto"]))
+	if err != nil {
+		return nil, err
+	}
+	msg := messageType.New().Interface()
+	if err := proto.Unmarshal(resp.Body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// code returns the gRPC code of the HTTP status codes written by the engine
+func code(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
@@ -0,0 +1,120 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// headerStream captures the headers set by the interceptor
+type headerStream struct {
+	header metadata.MD
+}
+
+func (s *headerStream) Method() string { return "/payments.v1.Payments/Charge" }
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+func (s *headerStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+func (s *headerStream) SetTrailer(md metadata.MD) error { return nil }
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store})
+	interceptor := UnaryServerInterceptor(manager)
+	info := &grpc.UnaryServerInfo{FullMethod: "/payments.v1.Payments/Charge"}
+
+	var calls int
+	handler := func(ctx context.Context, req any) (any, error) {
+		calls++
+		if req.(*wrapperspb.StringValue).GetValue() == "fail" {
+			return nil, status.Error(codes.Internal, "declined")
+		}
+		return wrapperspb.String("charged " + req.(*wrapperspb.StringValue).GetValue()), nil
+	}
+	call := func(key string, req proto.Message) (any, *headerStream, error) {
+		stream := &headerStream{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		if key != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("idempotency-key", key))
+		}
+		resp, err := interceptor(ctx, req, info, handler)
+		return resp, stream, err
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		calls = 0
+		resp, _, err := call("k1", wrapperspb.String("100"))
+		if err != nil || resp.(*wrapperspb.StringValue).GetValue() != "charged 100" {
+			t.Fatalf("unexpected response: %v, %v", resp, err)
+		}
+
+		resp, stream, err := call("k1", wrapperspb.String("100"))
+		if err != nil || !proto.Equal(resp.(proto.Message), wrapperspb.String("charged 100")) {
+			t.Fatalf("expected replayed response, got %v, %v", resp, err)
+		}
+		if calls != 1 {
+			t.Errorf("expected the handler to run once, ran %d times", calls)
+		}
+		if got := stream.header.Get(strings.ToLower(idempotency.ReplayedHeaderName)); len(got) != 1 || got[0] != "true" {
+			t.Errorf("expected replay header, got %v", stream.header)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		_, _, err := call("k1", wrapperspb.String("200"))
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("expected FailedPrecondition, got %v", err)
+		}
+	})
+
+	t.Run("InProgress", func(t *testing.T) {
+		_, _ = manager.Begin(context.Background(), &idempotency.Request{Method: "POST", IdempotencyKey: "pending"})
+		_, _, err := call("pending", wrapperspb.String("100"))
+		if status.Code(err) != codes.Aborted {
+			t.Fatalf("expected Aborted, got %v", err)
+		}
+	})
+
+	t.Run("HandlerError", func(t *testing.T) {
+		calls = 0
+		for range 2 {
+			if _, _, err := call("k2", wrapperspb.String("fail")); status.Code(err) != codes.Internal {
+				t.Fatalf("expected the handler error, got %v", err)
+			}
+		}
+		if calls != 2 {
+			t.Errorf("expected failed calls to be retried, handler ran %d times", calls)
+		}
+	})
+
+	t.Run("WithoutKey", func(t *testing.T) {
+		calls = 0
+		for range 2 {
+			if _, _, err := call("", wrapperspb.String("100")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if calls != 2 {
+			t.Errorf("expected the handler to run for every call, ran %d times", calls)
+		}
+	})
+}
+
+func TestUnmarshalResponse(t *testing.T) {
+	_, err := unmarshalResponse(&idempotency.CachedResponse{ContentType: "application/json"})
+	if err == nil {
+		t.Fatal("expected an error for a response that is not a protobuf message")
+	}
+}
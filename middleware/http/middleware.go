@@ -3,136 +3,100 @@ package http
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 
 	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/internal/engine"
 )
 
+// Option configures the middleware
+type Option = engine.Option
+
+// WithPaths restricts the middleware to the request paths matching one of patterns, on top of
+// Config.IncludePaths: path.Match globs (e.g. "/v1/payments/*"), or regular expressions when
+// starting with "^". It panics on an invalid pattern.
+func WithPaths(patterns ...string) Option {
+	return engine.WithPaths(patterns...)
+}
+
+// SkipPaths passes the requests whose path matches one of patterns straight to the
+// handler, on top of Config.ExcludePaths, e.g. "/health" and "/metrics". It panics on an invalid pattern.
+func SkipPaths(patterns ...string) Option {
+	return engine.SkipPaths(patterns...)
+}
+
 // Idempotency returns an HTTP middleware that handles idempotency
-func Idempotency(manager *idempotency.Manager) func(http.Handler) http.Handler {
+func Idempotency(manager *idempotency.Manager, opts ...Option) func(http.Handler) http.Handler {
+	paths := engine.Paths(opts)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// 1. Extract potential idempotency key from header
-			headerKey := r.Header.Get("Idempotency-Key")
-
-			// 2. Build dummy request for potential auto-generation
-			pReq := &idempotency.Request{
-				Method:         r.Method,
-				Path:           r.URL.Path,
-				Headers:        r.Header,
-				IdempotencyKey: headerKey,
-			}
-
-			// 3. Determine if we should apply idempotency
-			isMethodAllowed := manager.IsMethodAllowed(r.Method)
-			hasKey := headerKey != ""
-
-			// If no header key and method not allowed, skip early
-			if !hasKey && !isMethodAllowed {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			// 4. Handle Request Body
-			var body []byte
-			if r.Body != nil {
-				var err error
-				body, err = io.ReadAll(r.Body)
-				if err != nil {
-					http.Error(w, `{"error":"failed to read request body"}`, http.StatusBadRequest)
-					return
-				}
-				r.Body.Close()
-				r.Body = io.NopCloser(bytes.NewBuffer(body))
-			}
-			pReq.Body = body
-
-			// 5. Check for cached response
-			cachedResp, err := manager.Check(r.Context(), pReq)
-			if err != nil {
-				if err == idempotency.ErrRequestInProgress {
-					http.Error(w, `{"error":"request already in progress"}`, http.StatusConflict)
-					return
-				}
-				if err == idempotency.ErrRequestMismatch {
-					http.Error(w, `{"error":"idempotency key reused with different payload"}`, http.StatusUnprocessableEntity)
-					return
-				}
-				// Other errors proceed normally
-			}
-
-			// 6. Missing Key Handling (RequireKey check)
-			if pReq.IdempotencyKey == "" {
-				if manager.Config().RequireKey && isMethodAllowed {
-					http.Error(w, `{"error":"idempotency key is required for this request"}`, http.StatusBadRequest)
-					return
-				}
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			// 7. Return cached response if available
-			if cachedResp != nil {
-				for key, values := range cachedResp.Headers {
-					for _, value := range values {
-						w.Header().Add(key, value)
-					}
-				}
-				w.Header().Set("X-Idempotent-Replayed", "true")
-				w.WriteHeader(cachedResp.StatusCode)
-				w.Write(cachedResp.Body)
-				return
-			}
-
-			// 8. Acquire lock
-			if err := manager.Lock(r.Context(), pReq); err != nil {
-				if err == idempotency.ErrRequestInProgress {
-					http.Error(w, `{"error":"request already in progress"}`, http.StatusConflict)
-					return
-				}
-				// Handle other errors if necessary
-			}
-
-			// 9. Capture response
-			recorder := &responseRecorder{
-				ResponseWriter: w,
-				statusCode:     http.StatusOK,
-				body:           &bytes.Buffer{},
-			}
-
-			// 10. Process request
-			next.ServeHTTP(recorder, r)
-
-			// 11. Store response
-			if pReq.IdempotencyKey != "" && recorder.statusCode < 500 {
-				resp := &idempotency.Response{
-					StatusCode:  recorder.statusCode,
-					Headers:     recorder.Header().Clone(),
-					Body:        recorder.body.Bytes(),
-					ContentType: recorder.Header().Get("Content-Type"),
-				}
-				_ = manager.Store(r.Context(), pReq.IdempotencyKey, resp)
-			} else if pReq.IdempotencyKey != "" {
-				_ = manager.Unlock(r.Context(), pReq.IdempotencyKey)
-			}
+			_ = engine.Run(manager, paths, &shim{w: w, r: r, next: next})
 		})
 	}
 }
 
-// responseRecorder wraps http.ResponseWriter to capture response
-type responseRecorder struct {
-	http.ResponseWriter
-	statusCode int
-	body       *bytes.Buffer
+// shim adapts net/http to the idempotency engine
+type shim struct {
+	w    http.ResponseWriter
+	r    *http.Request
+	next http.Handler
+}
+
+func (s *shim) Context() context.Context {
+	return s.r.Context()
+}
+
+func (s *shim) Request(req *idempotency.Request) {
+	req.Method = s.r.Method
+	req.Path = s.r.URL.Path
+	req.Query = s.r.URL.RawQuery
+	req.Headers = s.r.Header
+}
+
+func (s *shim) ReadBody() ([]byte, error) {
+	if s.r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(s.r.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.r.Body.Close()
+	s.r.Body = io.NopCloser(bytes.NewBuffer(body))
+	return body, nil
+}
+
+func (s *shim) Skip() error {
+	s.next.ServeHTTP(s.w, s.r)
+	return nil
+}
+
+func (s *shim) Next(body *idempotency.BodyCapture) (*idempotency.Response, error) {
+	recorder := idempotency.RecordResponseBody(s.w, body)
+	s.next.ServeHTTP(recorder, s.r)
+	return recorder.Response(), nil
+}
+
+func (s *shim) Header(name, value string) {
+	s.w.Header().Set(name, value)
+}
+
+func (s *shim) Write(resp *idempotency.CachedResponse, extra map[string]string) error {
+	idempotency.WriteCachedResponse(s.w, resp, extra)
+	return nil
 }
 
-func (r *responseRecorder) WriteHeader(statusCode int) {
-	r.statusCode = statusCode
-	r.ResponseWriter.WriteHeader(statusCode)
+func (s *shim) Error(statusCode int, message string) error {
+	writeError(s.w, statusCode, message)
+	return nil
 }
 
-func (r *responseRecorder) Write(data []byte) (int, error) {
-	r.body.Write(data)
-	return r.ResponseWriter.Write(data)
+// writeError writes a JSON error body with the given status code
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
 }
@@ -3,12 +3,16 @@ package http
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
 	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/key"
+	"github.com/fco-gt/gopotency/storage/memory"
 )
 
 // MockStorage for middleware testing
@@ -86,6 +90,9 @@ func TestIdempotencyMiddleware(t *testing.T) {
 		if w.Header().Get("X-Idempotent-Replayed") != "true" {
 			t.Error("Expected X-Idempotent-Replayed header to be true")
 		}
+		if w.Header().Get("X-Idempotency-Original-Timestamp") == "" {
+			t.Error("Expected X-Idempotency-Original-Timestamp header to be set")
+		}
 	})
 
 	t.Run("Conflict_InProgress", func(t *testing.T) {
@@ -117,4 +124,291 @@ func TestIdempotencyMiddleware(t *testing.T) {
 			t.Errorf("Expected 400 Bad Request, got %d", w.Code)
 		}
 	})
+
+	t.Run("RequireKey_ProblemDetails", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage:        store,
+			RequireKey:     true,
+			ProblemDetails: true,
+		})
+		req := httptest.NewRequest("POST", "/test", nil)
+		w := httptest.NewRecorder()
+		Idempotency(m2)(handler).ServeHTTP(w, req)
+
+		var problem idempotency.Problem
+		_ = json.Unmarshal(w.Body.Bytes(), &problem)
+		if w.Code != http.StatusBadRequest || w.Header().Get("Content-Type") != idempotency.ProblemContentType {
+			t.Fatalf("expected a 400 problem, got %d %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		if problem.Status != http.StatusBadRequest || problem.Detail != m2.Config().Messages.KeyRequired {
+			t.Errorf("unexpected problem: %+v", problem)
+		}
+	})
+
+	t.Run("UpgradeRequest_Bypass", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/test", bytes.NewBuffer([]byte("data")))
+		req.Header.Set("Idempotency-Key", "key-upgrade")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", w.Code)
+		}
+		if _, ok := store.Records["key-upgrade"]; ok {
+			t.Error("Expected upgrade request not to be recorded")
+		}
+	})
+
+	t.Run("CustomHeaderName", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage:    store,
+			HeaderName: "X-Request-Key",
+		})
+		mw2 := Idempotency(m2)(handler)
+
+		req := httptest.NewRequest("POST", "/test", bytes.NewBuffer([]byte("data")))
+		req.Header.Set("X-Request-Key", "custom-key")
+		w := httptest.NewRecorder()
+
+		mw2.ServeHTTP(w, req)
+
+		if _, ok := store.Records["custom-key"]; !ok {
+			t.Error("Expected record to be stored under the custom header key")
+		}
+	})
+
+	t.Run("RoutePolicy_GETCaching", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage: store,
+			RoutePolicies: []idempotency.RoutePolicy{{
+				Method:      "GET",
+				Path:        "/reports",
+				KeyStrategy: key.RequestURI("Authorization"),
+			}},
+		})
+		calls := 0
+		mw2 := Idempotency(m2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Write([]byte("report"))
+		}))
+
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("GET", "/reports?year=2024", nil)
+			w := httptest.NewRecorder()
+			mw2.ServeHTTP(w, req)
+			if w.Body.String() != "report" {
+				t.Errorf("Expected 'report', got '%s'", w.Body.String())
+			}
+		}
+		if calls != 1 {
+			t.Errorf("Expected handler to run once, ran %d times", calls)
+		}
+	})
+
+	t.Run("PendingQuota", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage:            store,
+			MaxPendingPerScope: 1,
+			QuotaScope:         func(req *idempotency.Request) string { return req.Headers["Authorization"][0] },
+		})
+
+		var nested *httptest.ResponseRecorder
+		var mw2 http.Handler
+		mw2 = Idempotency(m2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Idempotency-Key") == "quota-1" {
+				// Second key of the same user while the first one is pending
+				req := httptest.NewRequest("POST", "/test", nil)
+				req.Header.Set("Idempotency-Key", "quota-2")
+				req.Header.Set("Authorization", "user-1")
+				nested = httptest.NewRecorder()
+				mw2.ServeHTTP(nested, req)
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+		req := httptest.NewRequest("POST", "/test", nil)
+		req.Header.Set("Idempotency-Key", "quota-1")
+		req.Header.Set("Authorization", "user-1")
+		w := httptest.NewRecorder()
+		mw2.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Errorf("Expected 201 for first request, got %d", w.Code)
+		}
+		if nested == nil || nested.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected 429 for concurrent request over quota, got %v", nested)
+		}
+
+		// The slot is released once the first request completes
+		req = httptest.NewRequest("POST", "/test", nil)
+		req.Header.Set("Idempotency-Key", "quota-3")
+		req.Header.Set("Authorization", "user-1")
+		w = httptest.NewRecorder()
+		mw2.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Errorf("Expected 201 after release, got %d", w.Code)
+		}
+	})
+
+	t.Run("HTTPCaching_NotModified", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage:     store,
+			HTTPCaching: true,
+		})
+		mw2 := Idempotency(m2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"order-1"`)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("order"))
+		}))
+
+		req := httptest.NewRequest("POST", "/test", nil)
+		req.Header.Set("Idempotency-Key", "etag-1")
+		mw2.ServeHTTP(httptest.NewRecorder(), req)
+
+		req = httptest.NewRequest("POST", "/test", nil)
+		req.Header.Set("Idempotency-Key", "etag-1")
+		req.Header.Set("If-None-Match", `"order-1"`)
+		w := httptest.NewRecorder()
+		mw2.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotModified {
+			t.Fatalf("Expected 304, got %d", w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("Expected empty body, got '%s'", w.Body.String())
+		}
+		if w.Header().Get("Age") == "" || w.Header().Get("ETag") != `"order-1"` {
+			t.Errorf("Expected Age and ETag headers, got %v", w.Header())
+		}
+	})
+
+	t.Run("ScopedKeys", func(t *testing.T) {
+		m2, _ := idempotency.NewManager(idempotency.Config{
+			Storage:     store,
+			KeyStrategy: key.Scoped(key.HeaderBased("Idempotency-Key"), key.ContextScope()),
+		})
+		mw2 := Idempotency(m2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(idempotency.KeyScopeFromContext(r.Context())))
+		}))
+		// Authentication middleware setting the tenant of the request
+		serve := func(tenant string) string {
+			req := httptest.NewRequest("POST", "/test", nil)
+			req.Header.Set("Idempotency-Key", "shared-key")
+			req = req.WithContext(idempotency.WithKeyScope(req.Context(), tenant))
+			w := httptest.NewRecorder()
+			mw2.ServeHTTP(w, req)
+			return w.Body.String()
+		}
+
+		if serve("acme") != "acme" || serve("globex") != "globex" {
+			t.Error("expected each tenant to get its own response")
+		}
+		if serve("acme") != "acme" {
+			t.Error("expected the tenant's response to be replayed")
+		}
+	})
+
+	t.Run("ServerSentEvents", func(t *testing.T) {
+		calls := 0
+		mw2 := Idempotency(manager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: tick\n\n"))
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("expected Flush to be supported, got %v", err)
+			}
+		}))
+
+		for range 2 {
+			req := httptest.NewRequest("POST", "/events", nil)
+			req.Header.Set("Idempotency-Key", "sse-1")
+			w := httptest.NewRecorder()
+			mw2.ServeHTTP(w, req)
+			if !w.Flushed || w.Body.String() != "data: tick\n\n" {
+				t.Fatalf("expected the event to be streamed, got %q", w.Body.String())
+			}
+		}
+		if calls != 2 {
+			t.Errorf("expected streaming responses to pass through uncached, handler called %d times", calls)
+		}
+	})
+}
+
+func TestIdempotencyMiddleware_WaitForResult(t *testing.T) {
+	manager, _ := idempotency.NewManager(idempotency.Config{
+		Storage:       memory.NewMemoryStorage(),
+		WaitForResult: true,
+		PollInterval:  time.Millisecond,
+	})
+	started, release := make(chan struct{}), make(chan struct{})
+	mw := Idempotency(manager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/orders", nil)
+		req.Header.Set("Idempotency-Key", "wait-1")
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, req)
+		return w
+	}
+
+	original := make(chan *httptest.ResponseRecorder)
+	go func() { original <- send() }()
+	<-started
+
+	duplicate := make(chan *httptest.ResponseRecorder)
+	go func() { duplicate <- send() }()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	if w := <-original; w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 for the original, got %d", w.Code)
+	}
+	w := <-duplicate
+	if w.Code != http.StatusCreated || w.Body.String() != "created" {
+		t.Fatalf("Expected the duplicate to replay 201 'created', got %d '%s'", w.Code, w.Body.String())
+	}
+	if w.Header().Get(idempotency.ReplayedHeaderName) != "true" {
+		t.Error("Expected the duplicate to be a replay")
+	}
+}
+
+func BenchmarkIdempotency(b *testing.B) {
+	storage := &MockStorage{Records: make(map[string]*idempotency.Record), Locks: make(map[string]bool)}
+	manager, _ := idempotency.NewManager(idempotency.Config{Storage: storage, TTL: time.Hour})
+	handler := Idempotency(manager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+	body := []byte(`{"amount":100}`)
+
+	b.Run("FirstRequest", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; b.Loop(); i++ {
+			req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(body))
+			req.Header.Set("Idempotency-Key", "key-"+strconv.Itoa(i))
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+
+	b.Run("Replay", func(b *testing.B) {
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(body))
+		req.Header.Set("Idempotency-Key", "replayed")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		b.ReportAllocs()
+		for b.Loop() {
+			req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader(body))
+			req.Header.Set("Idempotency-Key", "replayed")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
 }
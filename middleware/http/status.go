@@ -0,0 +1,60 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// StatusHandler returns an HTTP handler reporting the state of an idempotency key,
+// to be mounted at the URL returned by Config.AsyncStatusURL.
+// The key is read from the "key" path value (e.g. "GET /idempotency/{key}") or from
+// the "key" query parameter.
+//
+//   - pending: 202 Accepted with {"status":"pending"}
+//   - failed: 200 OK with {"status":"failed"}
+//   - completed: the final response, replayed as-is
+//   - unknown or expired key: 404 Not Found
+func StatusHandler(manager *idempotency.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		if key == "" {
+			key = r.URL.Query().Get("key")
+		}
+		if key == "" {
+			idempotency.WriteCachedResponse(w, manager.ErrorResponse(http.StatusBadRequest, manager.Config().Messages.KeyRequired), nil)
+			return
+		}
+
+		record, err := manager.GetRecord(r.Context(), key)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if record == nil {
+			writeStatus(w, http.StatusNotFound, "not_found")
+			return
+		}
+
+		switch record.Status {
+		case idempotency.StatusCompleted:
+			if record.Response != nil {
+				idempotency.WriteCachedResponse(w, record.Response, manager.ReplayHeaders(record.Response))
+				return
+			}
+			writeStatus(w, http.StatusOK, string(record.Status))
+		case idempotency.StatusFailed:
+			writeStatus(w, http.StatusOK, string(record.Status))
+		default:
+			writeStatus(w, http.StatusAccepted, string(idempotency.StatusPending))
+		}
+	})
+}
+
+// writeStatus writes a JSON status body with the given status code
+func writeStatus(w http.ResponseWriter, statusCode int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
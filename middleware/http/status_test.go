@@ -0,0 +1,93 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+func TestAsyncStatusFlow(t *testing.T) {
+	store := &MockStorage{
+		Records: make(map[string]*idempotency.Record),
+		Locks:   make(map[string]bool),
+	}
+	manager, _ := idempotency.NewManager(idempotency.Config{
+		Storage: store,
+		AsyncStatusURL: func(key string) string {
+			return "/idempotency/" + key
+		},
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /payments", Idempotency(manager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("paid"))
+	})))
+	mux.Handle("GET /idempotency/{key}", StatusHandler(manager))
+
+	// Simulate an original request still being processed
+	store.Records["pay-1"] = &idempotency.Record{
+		Key:       "pay-1",
+		Status:    idempotency.StatusPending,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	t.Run("DuplicateInProgress_Accepted", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/payments", bytes.NewBufferString("data"))
+		req.Header.Set("Idempotency-Key", "pay-1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d", w.Code)
+		}
+		if w.Header().Get("Location") != "/idempotency/pay-1" {
+			t.Errorf("Unexpected Location: %q", w.Header().Get("Location"))
+		}
+	})
+
+	t.Run("Status_Pending", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/idempotency/pay-1", nil))
+		if w.Code != http.StatusAccepted {
+			t.Errorf("Expected 202, got %d", w.Code)
+		}
+	})
+
+	t.Run("Status_Completed", func(t *testing.T) {
+		store.Records["pay-1"].Status = idempotency.StatusCompleted
+		store.Records["pay-1"].Response = &idempotency.CachedResponse{
+			StatusCode: http.StatusCreated,
+			Body:       []byte("paid"),
+		}
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/idempotency/pay-1", nil))
+		if w.Code != http.StatusCreated || w.Body.String() != "paid" {
+			t.Errorf("Expected replayed 201 'paid', got %d '%s'", w.Code, w.Body.String())
+		}
+		if w.Header().Get(idempotency.ReplayedHeaderName) != "true" {
+			t.Error("Expected replay header")
+		}
+	})
+
+	t.Run("Status_Unknown", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/idempotency/unknown", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("Status_QueryParameter", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		StatusHandler(manager).ServeHTTP(w, httptest.NewRequest("GET", "/status?key=pay-1", nil))
+		if w.Code != http.StatusCreated {
+			t.Errorf("Expected 201, got %d", w.Code)
+		}
+	})
+}
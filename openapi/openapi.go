@@ -0,0 +1,408 @@
+// Package openapi provides OpenAPI 3 fragments describing idempotency-protected endpoints.
+//
+// The fragments document the idempotency key header, the error responses returned by
+// the middlewares (400, 409, 422, 429) and the headers added to replayed responses, so every
+// protected operation is documented consistently.
+//
+// The package does not depend on any OpenAPI framework: fragments can be embedded
+// manually, or applied by PatchSpec to any JSON encoded OpenAPI 3 document, e.g. the
+// document generated by huma once marshaled:
+//
+//	spec, _ := json.Marshal(api.OpenAPI())
+//	spec, err := openapi.PatchSpec(spec, openapi.Options{Required: true})
+//
+// Set Options.Manager so the fragments follow its configuration (header names, replay
+// headers, problem details, messages, Retry-After) instead of the defaults:
+//
+//	spec, err := openapi.PatchSpec(spec, openapi.Options{Manager: manager})
+//
+// There is no huma operation modifier nor swag integration. swag generates Swagger 2.0
+// documents, which must be converted to OpenAPI 3 before being patched.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Schema is a minimal OpenAPI schema object
+type Schema struct {
+	Type       string            `json:"type,omitempty"`
+	Format     string            `json:"format,omitempty"`
+	MaxLength  int               `json:"maxLength,omitempty"`
+	Enum       []string          `json:"enum,omitempty"`
+	Example    any               `json:"example,omitempty"`
+	Properties map[string]Schema `json:"properties,omitempty"`
+	Required   []string          `json:"required,omitempty"`
+}
+
+// Parameter is an OpenAPI parameter object
+type Parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+	Schema      Schema `json:"schema"`
+}
+
+// Header is an OpenAPI header object
+type Header struct {
+	Description string `json:"description,omitempty"`
+	Schema      Schema `json:"schema"`
+}
+
+// MediaType is an OpenAPI media type object
+type MediaType struct {
+	Schema Schema `json:"schema"`
+}
+
+// Response is an OpenAPI response object
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Options configures the generated fragments
+type Options struct {
+	// Manager, when set, documents its configuration: the key header names
+	// (HeaderNames), the replay headers (Config.ReplayHeaders, Config.HTTPCaching), the
+	// error bodies (Config.ProblemDetails, Config.ErrorHandler, Config.Messages), the
+	// Retry-After header of 409 responses and the methods patched by PatchSpec
+	// (Config.AllowedMethods). Required and Quota also follow Config.RequireKey and
+	// Config.MaxPendingPerScope. Without it, the fragments describe the default
+	// configuration (optional)
+	Manager *idempotency.Manager
+
+	// HeaderName is the idempotency key header name
+	// Default: the first of Manager.HeaderNames, or idempotency.DefaultHeaderName
+	HeaderName string
+
+	// Required marks the header as required (use with Config.RequireKey)
+	Required bool
+
+	// Quota documents the 429 response (use with Config.MaxPendingPerScope)
+	Quota bool
+
+	// Methods lists the HTTP methods patched by PatchSpec
+	// Default: Config.AllowedMethods of Manager, or ["POST", "PUT", "PATCH", "DELETE"]
+	Methods []string
+}
+
+func (o *Options) setDefaults() {
+	if m := o.Manager; m != nil {
+		config := m.Config()
+		if o.HeaderName == "" {
+			o.HeaderName = m.HeaderNames()[0]
+		}
+		if o.Methods == nil {
+			o.Methods = config.AllowedMethods
+		}
+		o.Required = o.Required || config.RequireKey
+		o.Quota = o.Quota || config.MaxPendingPerScope > 0
+	}
+	if o.HeaderName == "" {
+		o.HeaderName = idempotency.DefaultHeaderName
+	}
+	if o.Methods == nil {
+		o.Methods = []string{"POST", "PUT", "PATCH", "DELETE"}
+	}
+}
+
+// KeyParameter returns the idempotency key header parameter
+func KeyParameter(opts Options) Parameter {
+	opts.setDefaults()
+	description := "Unique key identifying the operation. Retrying with the same key replays the original response."
+	if opts.Manager != nil {
+		if aliases := opts.Manager.HeaderNames()[1:]; len(aliases) > 0 {
+			description += " Also accepted in: " + strings.Join(aliases, ", ") + "."
+		}
+	}
+	return Parameter{
+		Name:        opts.HeaderName,
+		In:          "header",
+		Description: description,
+		Required:    opts.Required,
+		Schema: Schema{
+			Type:      "string",
+			MaxLength: 255,
+			Example:   "8e03978e-40d5-43e8-bc93-6894a57f9324",
+		},
+	}
+}
+
+// ErrorSchema returns the schema of the error body written by the middlewares: an
+// "error" message, problem details with Config.ProblemDetails, or any value with
+// Config.ErrorHandler
+func ErrorSchema(opts Options) Schema {
+	if opts.Manager != nil {
+		switch config := opts.Manager.Config(); {
+		case config.ErrorHandler != nil:
+			return Schema{}
+		case config.ProblemDetails:
+			return Schema{
+				Type: "object",
+				Properties: map[string]Schema{
+					"type":   {Type: "string"},
+					"title":  {Type: "string"},
+					"status": {Type: "integer"},
+					"detail": {Type: "string"},
+				},
+				Required: []string{"type", "title", "status"},
+			}
+		}
+	}
+	return Schema{
+		Type: "object",
+		Properties: map[string]Schema{
+			"error": {Type: "string"},
+		},
+		Required: []string{"error"},
+	}
+}
+
+// ReplayHeaders returns the headers added to replayed responses, named after
+// Config.ReplayHeaders of opts.Manager
+func ReplayHeaders(opts Options) map[string]Header {
+	descriptions := map[string]Header{
+		"Age": {
+			Description: "Number of seconds since the original request completed, present on replayed responses.",
+			Schema:      Schema{Type: "integer"},
+		},
+		"ETag": {
+			Description: "Entity tag of the original response, present on replayed responses.",
+			Schema:      Schema{Type: "string"},
+		},
+		"Cache-Control": {
+			Description: "Set to \"no-store\" on replayed responses without their own Cache-Control.",
+			Schema:      Schema{Type: "string"},
+		},
+	}
+	names := idempotency.DefaultReplayHeaderNames()
+	if opts.Manager != nil {
+		names = opts.Manager.Config().ReplayHeaders
+	}
+	for name, header := range map[string]Header{
+		names.Replayed: {
+			Description: "Present with value \"true\" when the response is replayed from the idempotency cache.",
+			Schema:      Schema{Type: "string", Enum: []string{"true"}},
+		},
+		names.OriginalTimestamp: {
+			Description: "Time (RFC 3339) at which the original request completed, present on replayed responses.",
+			Schema:      Schema{Type: "string", Format: "date-time"},
+		},
+		names.OriginRegion: {
+			Description: "Region that processed the original request, present on replayed responses in multi-region deployments.",
+			Schema:      Schema{Type: "string"},
+		},
+		names.Age: descriptions["Age"],
+		names.Expires: {
+			Description: "Time (RFC 3339) until which retries with the same key are replayed, present on replayed responses.",
+			Schema:      Schema{Type: "string", Format: "date-time"},
+		},
+	} {
+		descriptions[name] = header
+	}
+
+	// The headers of a replay whose metadata is all set are the headers documented
+	headers := make(map[string]Header)
+	if opts.Manager == nil {
+		for _, name := range []string{names.Replayed, names.OriginalTimestamp, names.OriginRegion} {
+			headers[name] = descriptions[name]
+		}
+		return headers
+	}
+	now := time.Now()
+	sample := &idempotency.CachedResponse{CompletedAt: now, ExpiresAt: now, Region: "region", ETag: `"etag"`}
+	for name := range opts.Manager.ReplayHeaders(sample) {
+		header, ok := descriptions[name]
+		if !ok {
+			header = Header{Schema: Schema{Type: "string"}}
+		}
+		headers[name] = header
+	}
+	return headers
+}
+
+// ErrorResponses returns the error responses of protected operations keyed by status code
+func ErrorResponses(opts Options) map[string]Response {
+	opts.setDefaults()
+	messages := idempotency.DefaultMessages()
+	var retryAfter bool
+	if m := opts.Manager; m != nil {
+		config := m.Config()
+		messages = config.Messages
+		retryAfter = config.RetryAfter > 0 || config.RetryAfterFromLock
+	}
+
+	// content documents the body written for err, rendered by the manager when set
+	content := func(err error, statusCode int, message string) map[string]MediaType {
+		schema := ErrorSchema(opts)
+		if opts.Manager == nil {
+			schema.Example = map[string]string{"error": message}
+			return map[string]MediaType{"application/json": {Schema: schema}}
+		}
+		resp := opts.Manager.HandleError(err, statusCode, message)
+		if json.Unmarshal(resp.Body, &schema.Example) != nil {
+			schema.Example = string(resp.Body)
+		}
+		return map[string]MediaType{resp.ContentType: {Schema: schema}}
+	}
+
+	conflict := Response{
+		Description: "A request with the same idempotency key is already in progress.",
+		Content:     content(idempotency.ErrRequestInProgress, http.StatusConflict, messages.RequestInProgress),
+	}
+	if retryAfter {
+		conflict.Headers = map[string]Header{
+			"Retry-After": {
+				Description: "Number of seconds after which the request may be retried.",
+				Schema:      Schema{Type: "integer"},
+			},
+		}
+	}
+	responses := map[string]Response{
+		strconv.Itoa(http.StatusConflict): conflict,
+		strconv.Itoa(http.StatusUnprocessableEntity): {
+			Description: "The idempotency key was reused with a different payload.",
+			Content:     content(idempotency.ErrRequestMismatch, http.StatusUnprocessableEntity, messages.RequestMismatch),
+		},
+	}
+
+	if opts.Required {
+		responses[strconv.Itoa(http.StatusBadRequest)] = Response{
+			Description: fmt.Sprintf("The %s header is missing.", opts.HeaderName),
+			Content:     content(idempotency.ErrNoIdempotencyKey, http.StatusBadRequest, messages.KeyRequired),
+		}
+	}
+
+	if opts.Quota {
+		responses[strconv.Itoa(http.StatusTooManyRequests)] = Response{
+			Description: "Too many requests of the same scope are in progress.",
+			Content:     content(idempotency.ErrQuotaExceeded, http.StatusTooManyRequests, messages.QuotaExceeded),
+		}
+	}
+
+	return responses
+}
+
+// PatchSpec adds the idempotency parameter, error responses and replay headers to every
+// operation of a JSON encoded OpenAPI 3 document whose method is listed in opts.Methods.
+// Existing parameters and responses with the same name or status code are left untouched.
+func PatchSpec(spec []byte, opts Options) ([]byte, error) {
+	opts.setDefaults()
+
+	var doc map[string]any
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode spec: %w", err)
+	}
+
+	paths, _ := doc["paths"].(map[string]any)
+	for _, item := range paths {
+		pathItem, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, method := range opts.Methods {
+			operation, ok := pathItem[strings.ToLower(method)].(map[string]any)
+			if !ok {
+				continue
+			}
+			if err := patchOperation(operation, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+func patchOperation(operation map[string]any, opts Options) error {
+	param, err := toGeneric(KeyParameter(opts))
+	if err != nil {
+		return err
+	}
+
+	params, _ := operation["parameters"].([]any)
+	hasParam := false
+	for _, p := range params {
+		if m, ok := p.(map[string]any); ok && m["in"] == "header" && isKeyHeader(fmt.Sprint(m["name"]), opts) {
+			hasParam = true
+			break
+		}
+	}
+	if !hasParam {
+		operation["parameters"] = append(params, param)
+	}
+
+	responses, _ := operation["responses"].(map[string]any)
+	if responses == nil {
+		responses = make(map[string]any)
+		operation["responses"] = responses
+	}
+
+	for code, resp := range ErrorResponses(opts) {
+		if _, exists := responses[code]; exists {
+			continue
+		}
+		generic, err := toGeneric(resp)
+		if err != nil {
+			return err
+		}
+		responses[code] = generic
+	}
+
+	// Successful responses may be replays
+	for code, resp := range responses {
+		r, ok := resp.(map[string]any)
+		if !ok || !strings.HasPrefix(code, "2") {
+			continue
+		}
+		headers, _ := r["headers"].(map[string]any)
+		if headers == nil {
+			headers = make(map[string]any)
+			r["headers"] = headers
+		}
+		for name, header := range ReplayHeaders(opts) {
+			if _, exists := headers[name]; exists {
+				continue
+			}
+			generic, err := toGeneric(header)
+			if err != nil {
+				return err
+			}
+			headers[name] = generic
+		}
+	}
+
+	return nil
+}
+
+// isKeyHeader reports whether name is one of the idempotency key header names
+func isKeyHeader(name string, opts Options) bool {
+	names := []string{opts.HeaderName}
+	if opts.Manager != nil {
+		names = append(names, opts.Manager.HeaderNames()...)
+	}
+	return slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) })
+}
+
+// toGeneric converts a fragment into its generic JSON representation
+func toGeneric(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
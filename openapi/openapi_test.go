@@ -0,0 +1,173 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+func TestKeyParameter(t *testing.T) {
+	p := KeyParameter(Options{Required: true})
+	if p.Name != "Idempotency-Key" || p.In != "header" || !p.Required {
+		t.Fatalf("unexpected parameter: %+v", p)
+	}
+
+	custom := KeyParameter(Options{HeaderName: "X-Request-Id"})
+	if custom.Name != "X-Request-Id" || custom.Required {
+		t.Fatalf("unexpected parameter: %+v", custom)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	responses := ErrorResponses(Options{})
+	if _, ok := responses["409"]; !ok {
+		t.Fatal("expected 409 response")
+	}
+	if _, ok := responses["422"]; !ok {
+		t.Fatal("expected 422 response")
+	}
+	if _, ok := responses["400"]; ok {
+		t.Fatal("did not expect 400 response when key is optional")
+	}
+
+	if _, ok := ErrorResponses(Options{Required: true})["400"]; !ok {
+		t.Fatal("expected 400 response when key is required")
+	}
+	if _, ok := ErrorResponses(Options{Quota: true})["429"]; !ok {
+		t.Fatal("expected 429 response when quota is enabled")
+	}
+}
+
+func TestPatchSpec(t *testing.T) {
+	spec := []byte(`{
+		"openapi": "3.0.0",
+		"paths": {
+			"/orders": {
+				"get": {"responses": {"200": {"description": "ok"}}},
+				"post": {
+					"responses": {
+						"201": {"description": "created"},
+						"409": {"description": "custom conflict"}
+					}
+				}
+			}
+		}
+	}`)
+
+	out, err := PatchSpec(spec, Options{})
+	if err != nil {
+		t.Fatalf("PatchSpec failed: %v", err)
+	}
+
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Parameters []Parameter         `json:"parameters"`
+			Responses  map[string]Response `json:"responses"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("failed to decode patched spec: %v", err)
+	}
+
+	post := doc.Paths["/orders"]["post"]
+	if len(post.Parameters) != 1 || post.Parameters[0].Name != "Idempotency-Key" {
+		t.Fatalf("expected idempotency parameter, got %+v", post.Parameters)
+	}
+	if post.Responses["409"].Description != "custom conflict" {
+		t.Fatalf("expected existing 409 response to be kept, got %q", post.Responses["409"].Description)
+	}
+	if _, ok := post.Responses["422"]; !ok {
+		t.Fatal("expected 422 response to be added")
+	}
+	if _, ok := post.Responses["201"].Headers[idempotency.ReplayedHeaderName]; !ok {
+		t.Fatal("expected replay header on 201 response")
+	}
+
+	get := doc.Paths["/orders"]["get"]
+	if len(get.Parameters) != 0 {
+		t.Fatalf("expected GET to be left untouched, got %+v", get.Parameters)
+	}
+
+	if _, err := PatchSpec([]byte("not json"), Options{}); err == nil {
+		t.Fatal("expected error for invalid spec")
+	}
+}
+
+func TestOptions_Manager(t *testing.T) {
+	store := memory.NewMemoryStorage()
+	defer store.Close()
+	manager, err := idempotency.NewManager(idempotency.Config{
+		Storage:            store,
+		HeaderName:         "X-Request-Id",
+		HeaderAliases:      []string{"Idempotency-Key"},
+		AllowedMethods:     []string{"POST"},
+		RequireKey:         true,
+		MaxPendingPerScope: 10,
+		ProblemDetails:     true,
+		RetryAfter:         time.Second,
+		Messages:           idempotency.Messages{RequestInProgress: "busy"},
+		ReplayHeaders: idempotency.ReplayHeaderNames{
+			Replayed:     "X-Replayed",
+			OriginRegion: "-",
+			Expires:      "X-Idempotency-Expires",
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	opts := Options{Manager: manager}
+
+	if p := KeyParameter(opts); p.Name != "X-Request-Id" || !p.Required {
+		t.Errorf("expected the configured required header, got %+v", p)
+	}
+
+	headers := ReplayHeaders(opts)
+	for _, name := range []string{"X-Replayed", idempotency.OriginalTimestampHeaderName, "X-Idempotency-Expires"} {
+		if _, ok := headers[name]; !ok {
+			t.Errorf("expected the %s replay header, got %v", name, headers)
+		}
+	}
+	if _, ok := headers[idempotency.ReplayedHeaderName]; ok || len(headers) != 3 {
+		t.Errorf("expected renamed and disabled headers to be left out, got %v", headers)
+	}
+
+	responses := ErrorResponses(opts)
+	conflict := responses["409"]
+	problem, ok := conflict.Content[idempotency.ProblemContentType]
+	if !ok || problem.Schema.Properties["detail"].Type != "string" {
+		t.Fatalf("expected a problem details body, got %+v", conflict.Content)
+	}
+	if example, _ := problem.Schema.Example.(map[string]any); example["detail"] != "busy" {
+		t.Errorf("expected the configured message, got %v", problem.Schema.Example)
+	}
+	if _, ok := conflict.Headers["Retry-After"]; !ok {
+		t.Error("expected the Retry-After header on 409")
+	}
+	if _, ok := responses["400"]; !ok {
+		t.Error("expected 400 response with RequireKey")
+	}
+	if _, ok := responses["429"]; !ok {
+		t.Error("expected 429 response with MaxPendingPerScope")
+	}
+
+	spec := []byte(`{"paths": {"/orders": {
+		"post": {"parameters": [{"name": "Idempotency-Key", "in": "header"}], "responses": {"201": {"description": "created"}}},
+		"put": {"responses": {"200": {"description": "ok"}}}
+	}}}`)
+	out, err := PatchSpec(spec, opts)
+	if err != nil {
+		t.Fatalf("PatchSpec failed: %v", err)
+	}
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Parameters []Parameter `json:"parameters"`
+		} `json:"paths"`
+	}
+	_ = json.Unmarshal(out, &doc)
+	if len(doc.Paths["/orders"]["post"].Parameters) != 1 || len(doc.Paths["/orders"]["put"].Parameters) != 0 {
+		t.Errorf("expected the alias to be recognized and only allowed methods patched, got %+v", doc.Paths)
+	}
+}
@@ -0,0 +1,94 @@
+package idempotency
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pathPattern matches request paths with a path.Match glob, or with a regular
+// expression for patterns starting with "^"
+type pathPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// compilePathPatterns compiles the patterns of Config.IncludePaths and ExcludePaths
+func compilePathPatterns(patterns []string) ([]pathPattern, error) {
+	compiled := make([]pathPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if !strings.HasPrefix(pattern, "^") {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, pathPattern{glob: pattern})
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, pathPattern{re: re})
+	}
+	return compiled, nil
+}
+
+func (p pathPattern) match(reqPath string) bool {
+	if p.re != nil {
+		return p.re.MatchString(reqPath)
+	}
+	ok, _ := path.Match(p.glob, reqPath)
+	return ok
+}
+
+// PathFilter selects the request paths idempotency applies to with include and exclude
+// patterns, in the syntax of Config.IncludePaths. The Manager filters with
+// Config.IncludePaths and ExcludePaths; the middleware options WithPaths and SkipPaths
+// add a filter per middleware, so routers mounting their own middleware can share a
+// Manager.
+type PathFilter struct {
+	include []pathPattern
+	exclude []pathPattern
+}
+
+// NewPathFilter returns the filter of the paths matching one of include (all paths when
+// empty) and none of exclude
+func NewPathFilter(include, exclude []string) (*PathFilter, error) {
+	in, err := compilePathPatterns(include)
+	if err != nil {
+		return nil, err
+	}
+	ex, err := compilePathPatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &PathFilter{include: in, exclude: ex}, nil
+}
+
+// Match reports whether reqPath is selected by the filter. A nil filter matches every
+// path.
+func (f *PathFilter) Match(reqPath string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, reqPath) {
+		return false
+	}
+	return !matchAny(f.exclude, reqPath)
+}
+
+func matchAny(patterns []pathPattern, reqPath string) bool {
+	for _, p := range patterns {
+		if p.match(reqPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPathIncluded reports whether idempotency applies to the request path, according to
+// Config.IncludePaths and Config.ExcludePaths. The middlewares pass the requests of
+// other paths straight to the handler, even with an idempotency key.
+func (m *Manager) IsPathIncluded(reqPath string) bool {
+	return m.paths.Match(reqPath)
+}
@@ -0,0 +1,65 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestManager_IsPathIncluded(t *testing.T) {
+	m, err := NewManager(Config{
+		Storage:      &MockStorage{},
+		IncludePaths: []string{"/v1/payments/*", `^/v2/orders/\d+$`},
+		ExcludePaths: []string{"/v1/payments/health"},
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/v1/payments/charge", true},
+		{"/v1/payments/health", false},
+		{"/v1/payments/charge/refund", false},
+		{"/v2/orders/42", true},
+		{"/v2/orders/abc", false},
+		{"/metrics", false},
+	}
+	for _, tt := range tests {
+		if got := m.IsPathIncluded(tt.path); got != tt.want {
+			t.Errorf("IsPathIncluded(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	all, _ := NewManager(Config{Storage: &MockStorage{}, ExcludePaths: []string{"/health"}})
+	if !all.IsPathIncluded("/orders") || all.IsPathIncluded("/health") {
+		t.Error("expected every path but the excluded ones to be included")
+	}
+
+	for _, config := range []Config{
+		{Storage: &MockStorage{}, IncludePaths: []string{"/orders/["}},
+		{Storage: &MockStorage{}, ExcludePaths: []string{"^/orders/(\\d+$"}},
+	} {
+		if _, err := NewManager(config); err == nil {
+			t.Errorf("expected invalid patterns %v %v to be rejected", config.IncludePaths, config.ExcludePaths)
+		}
+	}
+}
+
+func TestWrap_ExcludedPath(t *testing.T) {
+	storage := newListingStorage()
+	m, _ := NewManager(Config{Storage: storage, ExcludePaths: []string{"/health"}})
+	handler := m.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+
+	req := httptest.NewRequest("POST", "/health", nil)
+	req.Header.Set("Idempotency-Key", "k1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if len(storage.records) != 0 {
+		t.Errorf("expected the excluded path not to be recorded, got %d records", len(storage.records))
+	}
+}
@@ -0,0 +1,238 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/textproto"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RoutePolicy customizes idempotency handling for the requests matching Method and Path.
+// A matching policy enables idempotency for the route even if its method is not listed
+// in Config.AllowedMethods, e.g. to cache expensive GET endpoints:
+//
+//	RoutePolicies: []idempotency.RoutePolicy{{
+//		Method:      "GET",
+//		Path:        "/reports/*",
+//		KeyStrategy: key.RequestURI("Authorization"),
+//	}}
+type RoutePolicy struct {
+	// Method is the HTTP method to match (empty matches any method)
+	Method string
+
+	// Path is the request path pattern to match, using path.Match syntax
+	// (e.g. "/reports/*"). Empty matches any path.
+	Path string
+
+	// KeyStrategy overrides Config.KeyStrategy for matching requests (optional)
+	KeyStrategy KeyStrategy
+
+	// ReplayDelete makes repeated DELETE requests for the same path replay the original
+	// successful response, even without an idempotency key, instead of reaching the
+	// handler again and surfacing a 404 once the resource is gone.
+	// Only 2xx responses are cached for such requests, so a first 404 is never pinned.
+	// Keys are scoped by the caller: the scope set with WithKeyScope, or else the
+	// Authorization header, so a caller never replays the delete of another.
+	ReplayDelete bool
+
+	// ReplayDeleteTTL is how long the responses of ReplayDelete requests without an
+	// idempotency key are replayed. Keep it short: a resource re-created and deleted
+	// again within this window is not deleted, the first response being replayed.
+	// Default: 1m
+	ReplayDeleteTTL time.Duration
+}
+
+// matches reports whether the policy applies to the given method and path
+func (p *RoutePolicy) matches(method, reqPath string) bool {
+	if p.Method != "" && p.Method != method {
+		return false
+	}
+	if p.Path == "" {
+		return true
+	}
+	ok, err := path.Match(p.Path, reqPath)
+	return err == nil && ok
+}
+
+// RoutePolicy returns the first route policy matching the request, or nil
+func (m *Manager) RoutePolicy(req *Request) *RoutePolicy {
+	for i := range m.config.RoutePolicies {
+		if m.config.RoutePolicies[i].matches(req.Method, req.Path) {
+			return &m.config.RoutePolicies[i]
+		}
+	}
+	return nil
+}
+
+// Policy overrides retention and strictness settings for the requests of a scope,
+// returned by Config.PolicyFor. Zero fields keep the manager configuration:
+//
+//	PolicyFor: func(tenant string) idempotency.Policy {
+//		if plans[tenant] == "enterprise" {
+//			return idempotency.Policy{TTL: 72 * time.Hour, RequireKey: true}
+//		}
+//		return idempotency.Policy{}
+//	}
+type Policy struct {
+	// TTL overrides Config.TTL, the window during which responses are replayed (optional)
+	TTL time.Duration
+
+	// LockTimeout overrides Config.LockTimeout (optional)
+	LockTimeout time.Duration
+
+	// PendingTTL overrides Config.PendingTTL (optional)
+	PendingTTL time.Duration
+
+	// RequireKey requires an idempotency key for the scope even if Config.RequireKey is false
+	RequireKey bool
+}
+
+// policy resolves the effective policy of the request from Config.PolicyFor
+func (m *Manager) policy(req *Request) Policy {
+	var p Policy
+	if m.config.PolicyFor != nil {
+		var scope string
+		if m.config.PolicyScope != nil {
+			scope = m.config.PolicyScope(req)
+		}
+		p = m.config.PolicyFor(scope)
+	}
+
+	if p.TTL <= 0 {
+		p.TTL = m.config.TTL
+	}
+	if p.LockTimeout <= 0 {
+		p.LockTimeout = m.config.LockTimeout
+	}
+	if p.PendingTTL <= 0 {
+		p.PendingTTL = m.config.PendingTTL
+	}
+	if p.PendingTTL <= 0 {
+		p.PendingTTL = p.TTL
+	}
+	if _, ok := m.keyStrategy(req).(deleteKeyStrategy); ok && req.generated {
+		p.TTL = defaultReplayDeleteTTL
+		if rp := m.RoutePolicy(req); rp.ReplayDeleteTTL > 0 {
+			p.TTL = rp.ReplayDeleteTTL
+		}
+	}
+	// A pending record must not expire while still locked
+	p.LockTimeout = min(p.LockTimeout, p.TTL)
+	p.PendingTTL = min(max(p.PendingTTL, p.LockTimeout), p.TTL)
+	p.RequireKey = p.RequireKey || m.config.RequireKey
+
+	return p
+}
+
+// recordTTL returns the retention of record, falling back to Config.TTL
+func (m *Manager) recordTTL(record *Record) time.Duration {
+	if record.TTL > 0 {
+		return record.TTL
+	}
+	return m.config.TTL
+}
+
+// responseTTL returns the retention set by the handler for resp, with Response.TTL or
+// Config.TTLHeader, or 0 to keep the policy TTL
+func (m *Manager) responseTTL(resp *Response) time.Duration {
+	if resp.TTL > 0 || m.config.TTLHeader == "" {
+		return resp.TTL
+	}
+
+	value := textproto.MIMEHeader(resp.Headers).Get(m.config.TTLHeader)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	ttl, _ := time.ParseDuration(value)
+	return ttl
+}
+
+// IsKeyRequired reports whether a request without idempotency key must be rejected,
+// either because of Config.RequireKey or the policy of its scope
+func (m *Manager) IsKeyRequired(req *Request) bool {
+	return m.IsRequestAllowed(req) && m.policy(req).RequireKey
+}
+
+// IsRequestAllowed checks if idempotency should be applied to the request,
+// either because its method is allowed or because a route policy matches it
+func (m *Manager) IsRequestAllowed(req *Request) bool {
+	return m.IsMethodAllowed(req.Method) || m.RoutePolicy(req) != nil
+}
+
+// keyStrategy returns the key strategy to use for the request
+func (m *Manager) keyStrategy(req *Request) KeyStrategy {
+	p := m.RoutePolicy(req)
+	if p != nil && p.KeyStrategy != nil {
+		return p.KeyStrategy
+	}
+	if p != nil && p.ReplayDelete && req.Method == http.MethodDelete {
+		return deleteKeyStrategy{}
+	}
+	return m.config.KeyStrategy
+}
+
+// scopeKey finalizes the idempotency key of req, once: a key sent by the client is
+// transformed by a KeyTransformer strategy, then the API version is appended
+func (m *Manager) scopeKey(ctx context.Context, req *Request) error {
+	if req.IdempotencyKey == "" || req.scoped {
+		return nil
+	}
+	req.ctx = ctx
+
+	if transformer, ok := m.keyStrategy(req).(KeyTransformer); ok && !req.generated {
+		key, err := transformer.TransformKey(req.IdempotencyKey, req)
+		if err != nil {
+			return err
+		}
+		req.IdempotencyKey = key
+	}
+	req.scoped = true
+	m.versionKey(req)
+	return nil
+}
+
+// ShouldStore reports whether a response with the given status code should be cached
+// for the request. Responses with a retryable status code (Config.RetryableStatusCodes,
+// server errors by default), or not listed in Config.CacheableStatusCodes, are not
+// cached so the request can be retried. Config.ShouldCache is called without headers.
+func (m *Manager) ShouldStore(req *Request, statusCode int) bool {
+	return m.shouldCache(req, statusCode, nil)
+}
+
+// shouldCache is ShouldStore with the response headers given to Config.ShouldCache
+func (m *Manager) shouldCache(req *Request, statusCode int, headers map[string][]string) bool {
+	if p := m.RoutePolicy(req); p != nil && p.ReplayDelete && req.Method == http.MethodDelete {
+		return statusCode >= 200 && statusCode < 300
+	}
+	if m.config.ShouldCache != nil {
+		return m.config.ShouldCache(statusCode, headers)
+	}
+	if m.config.CacheableStatusCodes != nil && !slices.Contains(m.config.CacheableStatusCodes, statusCode) {
+		return false
+	}
+	if m.config.RetryableStatusCodes != nil {
+		return !slices.Contains(m.config.RetryableStatusCodes, statusCode)
+	}
+	return statusCode < 500
+}
+
+// defaultReplayDeleteTTL is the default RoutePolicy.ReplayDeleteTTL
+const defaultReplayDeleteTTL = time.Minute
+
+// deleteKeyStrategy keys DELETE requests by their path and caller, so retries of a
+// caller replay its first result
+type deleteKeyStrategy struct{}
+
+func (deleteKeyStrategy) Generate(req *Request) (string, error) {
+	scope := KeyScopeFromContext(req.Context())
+	if scope == "" {
+		scope = textproto.MIMEHeader(req.Headers).Get("Authorization")
+	}
+	hash := sha256.Sum256([]byte(req.Method + ":" + req.Path + "\x00" + scope))
+	return hex.EncodeToString(hash[:]), nil
+}
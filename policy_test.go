@@ -0,0 +1,374 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+type keyStrategyFunc func(req *Request) (string, error)
+
+func (f keyStrategyFunc) Generate(req *Request) (string, error) {
+	return f(req)
+}
+
+// callerScopedFunc is a keyStrategyFunc whose keys are scoped by the caller
+type callerScopedFunc keyStrategyFunc
+
+func (f callerScopedFunc) Generate(req *Request) (string, error) {
+	return f(req)
+}
+
+func (f callerScopedFunc) CallerScoped() bool {
+	return true
+}
+
+func TestManager_RoutePolicies(t *testing.T) {
+	m, _ := NewManager(Config{
+		Storage: &MockStorage{},
+		RoutePolicies: []RoutePolicy{
+			{
+				Method: "GET",
+				Path:   "/reports/*",
+				KeyStrategy: callerScopedFunc(func(req *Request) (string, error) {
+					return "report:" + req.Path + "?" + req.Query, nil
+				}),
+			},
+			{Path: "/legacy"},
+		},
+	})
+
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/reports/monthly", true},
+		{"GET", "/reports/monthly/details", false},
+		{"GET", "/orders", false},
+		{"POST", "/orders", true},
+		{"OPTIONS", "/legacy", true},
+	}
+	for _, tt := range tests {
+		if got := m.IsRequestAllowed(&Request{Method: tt.method, Path: tt.path}); got != tt.want {
+			t.Errorf("IsRequestAllowed(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	req := &Request{Method: "GET", Path: "/reports/monthly", Query: "year=2024"}
+	if _, err := m.Check(context.Background(), req); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if req.IdempotencyKey != "report:/reports/monthly?year=2024" {
+		t.Fatalf("expected policy key strategy to be used, got %q", req.IdempotencyKey)
+	}
+
+	// GET responses must not be cached under keys shared by callers
+	_, err := NewManager(Config{
+		Storage: &MockStorage{},
+		RoutePolicies: []RoutePolicy{{
+			Method: "GET",
+			Path:   "/reports/*",
+			KeyStrategy: keyStrategyFunc(func(req *Request) (string, error) {
+				return req.Path, nil
+			}),
+		}},
+	})
+	if !errors.Is(err, ErrInvalidConfiguration) {
+		t.Errorf("expected an unscoped GET policy to be rejected, got %v", err)
+	}
+}
+
+func TestManager_ReplayDeletePolicy(t *testing.T) {
+	m, _ := NewManager(Config{
+		Storage:       &MockStorage{},
+		RoutePolicies: []RoutePolicy{{Method: "DELETE", Path: "/orders/*", ReplayDelete: true}},
+	})
+
+	first := &Request{Method: "DELETE", Path: "/orders/42"}
+	second := &Request{Method: "DELETE", Path: "/orders/42"}
+	_, _ = m.Check(context.Background(), first)
+	_, _ = m.Check(context.Background(), second)
+	if first.IdempotencyKey == "" || first.IdempotencyKey != second.IdempotencyKey {
+		t.Fatalf("expected identical DELETEs to share a key, got %q and %q", first.IdempotencyKey, second.IdempotencyKey)
+	}
+
+	other := &Request{Method: "DELETE", Path: "/orders/43"}
+	_, _ = m.Check(context.Background(), other)
+	if other.IdempotencyKey == first.IdempotencyKey {
+		t.Fatal("expected different paths to produce different keys")
+	}
+
+	if !m.ShouldStore(first, 204) || m.ShouldStore(first, 404) {
+		t.Error("expected only 2xx DELETE responses to be stored")
+	}
+
+	// Callers never share the key of a delete
+	userA := &Request{Method: "DELETE", Path: "/orders/42", Headers: map[string][]string{"Authorization": {"Bearer a"}}}
+	userB := &Request{Method: "DELETE", Path: "/orders/42", Headers: map[string][]string{"Authorization": {"Bearer b"}}}
+	scoped := &Request{Method: "DELETE", Path: "/orders/42"}
+	_, _ = m.Check(context.Background(), userA)
+	_, _ = m.Check(context.Background(), userB)
+	_, _ = m.Check(WithKeyScope(context.Background(), "user-a"), scoped)
+	if userA.IdempotencyKey == userB.IdempotencyKey || scoped.IdempotencyKey == first.IdempotencyKey {
+		t.Error("expected DELETE keys to be scoped by the caller")
+	}
+
+	// Deletes are only replayed for a short window, client keys keep Config.TTL
+	if p := m.policy(first); p.TTL != time.Minute {
+		t.Errorf("expected the default ReplayDeleteTTL, got %v", p.TTL)
+	}
+	keyed := &Request{Method: "DELETE", Path: "/orders/42", IdempotencyKey: "k1"}
+	if p := m.policy(keyed); p.TTL != m.config.TTL {
+		t.Errorf("expected requests with a key to keep Config.TTL, got %v", p.TTL)
+	}
+	if !m.ShouldStore(&Request{Method: "POST", Path: "/orders"}, 404) {
+		t.Error("expected 4xx to be stored outside ReplayDelete routes")
+	}
+	if m.ShouldStore(&Request{Method: "POST", Path: "/orders"}, 500) {
+		t.Error("expected 5xx never to be stored")
+	}
+}
+
+func TestManager_CacheableStatusCodes(t *testing.T) {
+	req := &Request{Method: "POST", Path: "/orders"}
+	tests := []struct {
+		name   string
+		config Config
+		cached []int
+		failed []int
+	}{
+		{"Default", Config{}, []int{200, 201, 404, 409}, []int{500, 503}},
+		{"Only2xx", Config{CacheableStatusCodes: []int{200, 201}}, []int{200, 201}, []int{204, 404, 500}},
+		{"CacheableAndRetryable", Config{CacheableStatusCodes: []int{200, 503}, RetryableStatusCodes: []int{503}}, []int{200}, []int{404, 503}},
+		{"Predicate", Config{
+			CacheableStatusCodes: []int{200},
+			ShouldCache: func(statusCode int, headers map[string][]string) bool {
+				return statusCode != http.StatusConflict && headers["Cache-Control"] == nil
+			},
+		}, []int{404, 500}, []int{409}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Storage = &MockStorage{}
+			m, err := NewManager(tt.config)
+			if err != nil {
+				t.Fatalf("NewManager failed: %v", err)
+			}
+			for _, code := range tt.cached {
+				if !m.ShouldStore(req, code) {
+					t.Errorf("expected %d to be cached", code)
+				}
+			}
+			for _, code := range tt.failed {
+				if m.ShouldStore(req, code) {
+					t.Errorf("expected %d not to be cached", code)
+				}
+			}
+		})
+	}
+
+	t.Run("PredicateHeaders", func(t *testing.T) {
+		storage := newListingStorage()
+		m, _ := NewManager(Config{
+			Storage: storage,
+			ShouldCache: func(statusCode int, headers map[string][]string) bool {
+				return headers["Cache-Control"] == nil
+			},
+		})
+		outcome, err := m.Begin(context.Background(), &Request{Method: "POST", Path: "/quotes", IdempotencyKey: "quote"})
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		_ = outcome.Token.Complete(&Response{StatusCode: 200, Headers: map[string][]string{"Cache-Control": {"no-store"}}})
+		if record := storage.records["quote"]; record == nil || record.Status != StatusFailed {
+			t.Errorf("expected the response not to be cached, got %+v", record)
+		}
+	})
+
+	if _, err := NewManager(Config{Storage: &MockStorage{}, CacheableStatusCodes: []int{42}}); err == nil {
+		t.Error("expected an invalid status code to be rejected")
+	}
+}
+
+func TestManager_PolicyFor(t *testing.T) {
+	records := make(map[string]*Record)
+	var setTTLs []time.Duration
+	var lockTTL time.Duration
+	store := &MockStorage{
+		GetFunc: func(ctx context.Context, key string) (*Record, error) { return records[key], nil },
+		SetFunc: func(ctx context.Context, r *Record, ttl time.Duration) error {
+			records[r.Key] = r
+			setTTLs = append(setTTLs, ttl)
+			return nil
+		},
+		TryLockFunc: func(ctx context.Context, k string, ttl time.Duration) (bool, error) {
+			lockTTL = ttl
+			return true, nil
+		},
+	}
+
+	m, _ := NewManager(Config{
+		Storage:     store,
+		TTL:         time.Hour,
+		PolicyScope: func(req *Request) string { return req.Headers["X-Tenant"][0] },
+		PolicyFor: func(scope string) Policy {
+			if scope == "enterprise" {
+				return Policy{TTL: 72 * time.Hour, LockTimeout: time.Minute, RequireKey: true}
+			}
+			return Policy{}
+		},
+	})
+
+	ctx := context.Background()
+	enterprise := map[string][]string{"X-Tenant": {"enterprise"}}
+	req := &Request{Method: "POST", Path: "/orders", Headers: enterprise, IdempotencyKey: "k1"}
+	if err := m.Lock(ctx, req); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if err := m.Store(ctx, "k1", &Response{StatusCode: 201}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	if lockTTL != time.Minute {
+		t.Errorf("expected policy lock timeout, got %s", lockTTL)
+	}
+	for _, ttl := range setTTLs {
+		if ttl != 72*time.Hour {
+			t.Errorf("expected policy TTL for every write, got %s", ttl)
+		}
+	}
+	if until := time.Until(records["k1"].ExpiresAt); until < 71*time.Hour {
+		t.Errorf("expected record to expire after the policy TTL, expires in %s", until)
+	}
+
+	if !m.IsKeyRequired(&Request{Method: "POST", Headers: enterprise}) {
+		t.Error("expected enterprise policy to require a key")
+	}
+	free := &Request{Method: "POST", Headers: map[string][]string{"X-Tenant": {"free"}}}
+	if m.IsKeyRequired(free) {
+		t.Error("expected default policy not to require a key")
+	}
+	if p := m.policy(free); p.TTL != time.Hour || p.LockTimeout != 5*time.Minute {
+		t.Errorf("expected configuration defaults, got %+v", p)
+	}
+}
+
+func TestManager_PendingTTL(t *testing.T) {
+	ttls := make(map[RecordStatus]time.Duration)
+	records := make(map[string]*Record)
+	store := &MockStorage{
+		GetFunc: func(ctx context.Context, key string) (*Record, error) { return records[key], nil },
+		SetFunc: func(ctx context.Context, r *Record, ttl time.Duration) error {
+			records[r.Key] = r
+			ttls[r.Status] = ttl
+			return nil
+		},
+	}
+
+	m, _ := NewManager(Config{
+		Storage:     store,
+		TTL:         24 * time.Hour,
+		LockTimeout: time.Minute,
+		PendingTTL:  10 * time.Minute,
+	})
+
+	ctx := context.Background()
+	if err := m.Lock(ctx, &Request{Method: "POST", IdempotencyKey: "k1"}); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if until := time.Until(records["k1"].ExpiresAt); until > 10*time.Minute {
+		t.Errorf("expected pending record to expire within PendingTTL, expires in %s", until)
+	}
+	if err := m.Store(ctx, "k1", &Response{StatusCode: 200}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+
+	if ttls[StatusPending] != 10*time.Minute {
+		t.Errorf("expected pending record to be kept for PendingTTL, got %s", ttls[StatusPending])
+	}
+	if ttls[StatusCompleted] != 24*time.Hour {
+		t.Errorf("expected completed record to be kept for TTL, got %s", ttls[StatusCompleted])
+	}
+
+	// PendingTTL never drops below LockTimeout
+	if p := m.policy(&Request{}); p.PendingTTL != 10*time.Minute {
+		t.Errorf("expected PendingTTL 10m, got %s", p.PendingTTL)
+	}
+	short, _ := NewManager(Config{Storage: store, LockTimeout: time.Minute, PendingTTL: time.Second})
+	if p := short.policy(&Request{}); p.PendingTTL != time.Minute {
+		t.Errorf("expected PendingTTL raised to LockTimeout, got %s", p.PendingTTL)
+	}
+}
+
+// suffixTransformer is a KeyStrategy transforming header keys
+type suffixTransformer struct{}
+
+func (suffixTransformer) Generate(req *Request) (string, error) { return "", nil }
+
+func (suffixTransformer) TransformKey(key string, req *Request) (string, error) {
+	return key + "/" + textproto.MIMEHeader(req.Headers).Get("X-Account-Id"), nil
+}
+
+func TestManager_KeyTransformer(t *testing.T) {
+	storage := newListingStorage()
+	m, _ := NewManager(Config{Storage: storage, KeyStrategy: suffixTransformer{}})
+
+	req := &Request{
+		Method:         "POST",
+		Path:           "/payments",
+		Headers:        map[string][]string{"X-Account-Id": {"acct_1"}},
+		IdempotencyKey: "abc",
+	}
+	outcome, err := m.Begin(context.Background(), req)
+	if err != nil || outcome.Kind != OutcomeProceed {
+		t.Fatalf("expected to proceed, got %v, %v", outcome.Kind, err)
+	}
+	if req.IdempotencyKey != "abc/acct_1" {
+		t.Fatalf("expected the transformed key once, got %q", req.IdempotencyKey)
+	}
+	if storage.records["abc/acct_1"] == nil {
+		t.Error("expected the record under the transformed key")
+	}
+}
+
+func TestManager_ResponseTTL(t *testing.T) {
+	ctx := context.Background()
+	store := newListingStorage()
+	var ttls []time.Duration
+	set := store.SetFunc
+	store.SetFunc = func(ctx context.Context, r *Record, ttl time.Duration) error {
+		ttls = append(ttls, ttl)
+		return set(ctx, r, ttl)
+	}
+	m, _ := NewManager(Config{Storage: store, TTL: time.Hour, TTLHeader: "X-Idempotency-TTL"})
+
+	tests := []struct {
+		name string
+		resp *Response
+		want time.Duration
+	}{
+		{"Default", &Response{StatusCode: 200}, time.Hour},
+		{"Field", &Response{StatusCode: 200, TTL: 72 * time.Hour}, 72 * time.Hour},
+		{"HeaderDuration", &Response{StatusCode: 200, Headers: map[string][]string{"X-Idempotency-Ttl": {"5m"}}}, 5 * time.Minute},
+		{"HeaderSeconds", &Response{StatusCode: 200, Headers: map[string][]string{"X-Idempotency-Ttl": {"30"}}}, 30 * time.Second},
+		{"InvalidHeader", &Response{StatusCode: 200, Headers: map[string][]string{"X-Idempotency-Ttl": {"soon"}}}, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttls = nil
+			if err := m.Store(ctx, tt.name, tt.resp); err != nil {
+				t.Fatalf("Store failed: %v", err)
+			}
+			record := store.records[tt.name]
+			if len(ttls) != 1 || ttls[0] != tt.want || record.TTL != tt.want && tt.want != time.Hour {
+				t.Errorf("expected a retention of %s, got %v (record TTL %s)", tt.want, ttls, record.TTL)
+			}
+			if _, ok := record.Response.Headers["X-Idempotency-Ttl"]; ok {
+				t.Error("expected the TTL header not to be cached")
+			}
+		})
+	}
+}
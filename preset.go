@@ -0,0 +1,45 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"time"
+)
+
+// StrictPreset returns a configuration with safe defaults for payment-like endpoints,
+// where processing a request twice is worse than rejecting it:
+//   - keys are required (RequireKey)
+//   - requests are rejected with 503 when the storage is unavailable (FailClosed)
+//   - a key is bound to the method, path and body of its first request, even when the
+//     body is empty
+//   - session cookies and credentials are not cached (StripHeaders: SensitiveHeaders)
+//   - duplicates of in-progress requests get 409 with Retry-After
+//   - keys reused after their record expired are rejected (ExpiredKeyReject)
+//   - every decision is logged with slog.Default() for auditing (EventSink)
+//
+// Set Storage and override the remaining options as needed:
+//
+//	config := idempotency.StrictPreset()
+//	config.Storage = store
+//	manager, err := idempotency.NewManager(config)
+func StrictPreset() Config {
+	return Config{
+		RequireKey:    true,
+		FailClosed:    true,
+		RequestHasher: &strictRequestHasher{},
+		StripHeaders:  SensitiveHeaders(),
+		RetryAfter:    time.Second,
+		ExpiredKeys:   ExpiredKeyReject,
+		EventSink:     NewLogSink(slog.Default()),
+	}
+}
+
+// strictRequestHasher hashes the method, path and body of the request, so a key cannot
+// be reused for another operation
+type strictRequestHasher struct{}
+
+func (s *strictRequestHasher) Hash(req *Request) (string, error) {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.Path + " " + req.BodyDigest()))
+	return hex.EncodeToString(sum[:]), nil
+}
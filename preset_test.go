@@ -0,0 +1,99 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStrictPreset(t *testing.T) {
+	ctx := context.Background()
+	newManager := func(storage Storage) *Manager {
+		config := StrictPreset()
+		config.Storage = storage
+		config.EventSink = nil
+		m, err := NewManager(config)
+		if err != nil {
+			t.Fatalf("NewManager: %v", err)
+		}
+		return m
+	}
+	request := func(key string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/payments", nil)
+		if key != "" {
+			r.Header.Set(DefaultHeaderName, key)
+		}
+		return r
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) error {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	}
+
+	t.Run("RequireKey", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newManager(newListingStorage()).Wrap(ok).ServeHTTP(w, request(""))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("FailClosed", func(t *testing.T) {
+		down := &MockStorage{GetFunc: func(ctx context.Context, key string) (*Record, error) {
+			return nil, errors.New("connection refused")
+		}}
+		m := newManager(down)
+		if _, err := m.Check(ctx, &Request{Method: "POST", IdempotencyKey: "k"}); err == nil {
+			t.Fatal("expected the storage error")
+		}
+
+		w := httptest.NewRecorder()
+		m.Wrap(ok).ServeHTTP(w, request("k"))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected 503, got %d", w.Code)
+		}
+	})
+
+	t.Run("StripHeaders", func(t *testing.T) {
+		storage := newListingStorage()
+		m := newManager(storage)
+		m.Wrap(ok).ServeHTTP(httptest.NewRecorder(), request("strip"))
+
+		record := storage.records["strip"]
+		if record == nil || record.Response == nil {
+			t.Fatal("expected a cached response")
+		}
+		if _, ok := record.Response.Headers["Set-Cookie"]; ok {
+			t.Error("expected Set-Cookie not to be cached")
+		}
+	})
+
+	t.Run("RetryAfter", func(t *testing.T) {
+		storage := newListingStorage()
+		storage.records["busy"] = &Record{Key: "busy", Status: StatusPending, ExpiresAt: time.Now().Add(time.Hour)}
+
+		w := httptest.NewRecorder()
+		newManager(storage).Wrap(ok).ServeHTTP(w, request("busy"))
+		if w.Code != http.StatusConflict || w.Header().Get("Retry-After") != "1" {
+			t.Errorf("expected 409 with Retry-After 1, got %d %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		m := newManager(newListingStorage())
+		req := &Request{Method: "POST", Path: "/payments", IdempotencyKey: "bound"}
+		if _, err := m.Begin(ctx, req); err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		_ = m.Store(ctx, "bound", &Response{StatusCode: http.StatusCreated})
+
+		other := &Request{Method: "POST", Path: "/refunds", IdempotencyKey: "bound"}
+		if _, err := m.Check(ctx, other); !errors.Is(err, ErrRequestMismatch) {
+			t.Errorf("expected ErrRequestMismatch for another path, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is the content type of problem details error bodies
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details body, written for idempotency errors with
+// Config.ProblemDetails as recommended by the IETF Idempotency-Key header draft
+type Problem struct {
+	// Type identifies the problem type, "about:blank" when Title is the status text
+	Type string `json:"type"`
+
+	// Title is a short summary of the problem type
+	Title string `json:"title"`
+
+	// Status is the HTTP status code of the response
+	Status int `json:"status"`
+
+	// Detail is the client-facing message of the error (see Messages)
+	Detail string `json:"detail,omitempty"`
+}
+
+// HandleError returns the response written for the idempotency error err, answered
+// with statusCode and message by default: the response rendered by Config.ErrorHandler
+// when set, ErrorResponse otherwise
+func (m *Manager) HandleError(err error, statusCode int, message string) *CachedResponse {
+	if m.config.ErrorHandler == nil {
+		return m.ErrorResponse(statusCode, message)
+	}
+
+	code, body := m.config.ErrorHandler(err)
+	if code == 0 {
+		code = statusCode
+	}
+	return encodeErrorBody(code, body)
+}
+
+// encodeErrorBody returns the response of an error body returned by Config.ErrorHandler
+func encodeErrorBody(statusCode int, body any) *CachedResponse {
+	contentType := "application/json"
+	var data []byte
+	switch b := body.(type) {
+	case []byte:
+		data = b
+	case Problem, *Problem:
+		contentType = ProblemContentType
+		data, _ = json.Marshal(body)
+		data = append(data, '\n')
+	default:
+		data, _ = json.Marshal(body)
+		data = append(data, '\n')
+	}
+	return &CachedResponse{
+		StatusCode:  statusCode,
+		Headers:     map[string][]string{"Content-Type": {contentType}},
+		Body:        data,
+		ContentType: contentType,
+	}
+}
+
+// ErrorResponse returns the response written for an idempotency error (missing key,
+// conflict, mismatch...) with the given status code and message: problem details with
+// Config.ProblemDetails, a {"error": message} JSON body otherwise
+func (m *Manager) ErrorResponse(statusCode int, message string) *CachedResponse {
+	contentType := "application/json"
+	var body any = map[string]string{"error": message}
+	if m.config.ProblemDetails {
+		contentType = ProblemContentType
+		body = Problem{
+			Type:   "about:blank",
+			Title:  http.StatusText(statusCode),
+			Status: statusCode,
+			Detail: message,
+		}
+	}
+
+	data, _ := json.Marshal(body)
+	return &CachedResponse{
+		StatusCode:  statusCode,
+		Headers:     map[string][]string{"Content-Type": {contentType}},
+		Body:        append(data, '\n'),
+		ContentType: contentType,
+	}
+}
@@ -0,0 +1,26 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestManager_ErrorResponse(t *testing.T) {
+	m, _ := NewManager(Config{Storage: &MockStorage{}})
+	resp := m.ErrorResponse(http.StatusConflict, "busy")
+	if resp.ContentType != "application/json" || string(resp.Body) != "{\"error\":\"busy\"}\n" {
+		t.Errorf("unexpected default error response: %q %s", resp.ContentType, resp.Body)
+	}
+
+	m, _ = NewManager(Config{Storage: &MockStorage{}, ProblemDetails: true})
+	resp = m.ErrorResponse(http.StatusConflict, "busy")
+	var problem Problem
+	if err := json.Unmarshal(resp.Body, &problem); err != nil {
+		t.Fatalf("invalid problem body: %v", err)
+	}
+	want := Problem{Type: "about:blank", Title: "Conflict", Status: http.StatusConflict, Detail: "busy"}
+	if problem != want || resp.Headers["Content-Type"][0] != ProblemContentType {
+		t.Errorf("expected %+v, got %+v (%v)", want, problem, resp.Headers)
+	}
+}
@@ -0,0 +1,76 @@
+package idempotency
+
+import "sync"
+
+// pendingQuota counts the keys pending on this instance per scope
+type pendingQuota struct {
+	mu     sync.Mutex
+	counts map[string]int
+	scopes map[string]string
+}
+
+func newPendingQuota() *pendingQuota {
+	return &pendingQuota{
+		counts: make(map[string]int),
+		scopes: make(map[string]string),
+	}
+}
+
+// acquire counts key as pending in scope, unless scope already has limit pending keys.
+// added reports whether a slot was taken for key, false when key was already counted
+// (a duplicate of an in-flight request) or the quota is exceeded.
+func (q *pendingQuota) acquire(key, scope string, limit int) (added, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, exists := q.scopes[key]; exists {
+		return false, true
+	}
+	if q.counts[scope] >= limit {
+		return false, false
+	}
+
+	q.counts[scope]++
+	q.scopes[key] = scope
+	return true, true
+}
+
+// release stops counting key as pending. Unknown keys are ignored.
+func (q *pendingQuota) release(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	scope, exists := q.scopes[key]
+	if !exists {
+		return
+	}
+
+	delete(q.scopes, key)
+	if q.counts[scope]--; q.counts[scope] <= 0 {
+		delete(q.counts, scope)
+	}
+}
+
+// acquireQuota reserves a pending slot for req in its scope when MaxPendingPerScope is set.
+// The returned release frees the slot if it was taken by this call, and leaves the slot
+// of an in-flight request with the same key alone.
+func (m *Manager) acquireQuota(req *Request) (release func(), err error) {
+	release = func() {}
+	if m.config.MaxPendingPerScope <= 0 {
+		return release, nil
+	}
+
+	var scope string
+	if m.config.QuotaScope != nil {
+		scope = m.config.QuotaScope(req)
+	}
+
+	added, ok := m.quota.acquire(req.IdempotencyKey, scope, m.config.MaxPendingPerScope)
+	if !ok {
+		return release, ErrQuotaExceeded
+	}
+	if added {
+		release = func() { m.quota.release(req.IdempotencyKey) }
+	}
+	return release, nil
+}
@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManager_PendingQuota(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewManager(Config{
+		Storage:            newListingStorage(),
+		MaxPendingPerScope: 2,
+		QuotaScope: func(req *Request) string {
+			return req.Headers["X-Tenant"][0]
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	newReq := func(key, tenant string) *Request {
+		return &Request{IdempotencyKey: key, Headers: map[string][]string{"X-Tenant": {tenant}}}
+	}
+
+	for _, key := range []string{"a1", "a2"} {
+		if err := m.Lock(ctx, newReq(key, "a")); err != nil {
+			t.Fatalf("Lock(%s) failed: %v", key, err)
+		}
+	}
+
+	if err := m.Lock(ctx, newReq("a3", "a")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	// Other scopes are not affected
+	if err := m.Lock(ctx, newReq("b1", "b")); err != nil {
+		t.Fatalf("expected other tenant to proceed, got %v", err)
+	}
+
+	// Completing or failing a request frees its slot
+	if err := m.Store(ctx, "a1", &Response{StatusCode: 200}); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if err := m.Lock(ctx, newReq("a3", "a")); err != nil {
+		t.Fatalf("expected slot freed by Store, got %v", err)
+	}
+	if err := m.Unlock(ctx, "a2"); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if err := m.Lock(ctx, newReq("a4", "a")); err != nil {
+		t.Fatalf("expected slot freed by Unlock, got %v", err)
+	}
+}
+
+func TestConfig_Validate_MaxPendingPerScope(t *testing.T) {
+	_, err := NewManager(Config{Storage: &MockStorage{}, MaxPendingPerScope: -1})
+	if !errors.Is(err, ErrInvalidConfiguration) {
+		t.Fatalf("expected ErrInvalidConfiguration, got %v", err)
+	}
+}
+
+func TestManager_PendingQuota_DuplicateKeepsSlot(t *testing.T) {
+	ctx := context.Background()
+	store := &MockStorage{}
+	locks := make(map[string]bool)
+	store.TryLockFunc = func(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+		if locks[key] {
+			return false, nil
+		}
+		locks[key] = true
+		return true, nil
+	}
+	m, err := NewManager(Config{Storage: store, MaxPendingPerScope: 1})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	if err := m.Lock(ctx, &Request{IdempotencyKey: "k1"}); err != nil {
+		t.Fatalf("Lock(k1) failed: %v", err)
+	}
+	if err := m.Lock(ctx, &Request{IdempotencyKey: "k1"}); !errors.Is(err, ErrRequestInProgress) {
+		t.Fatalf("expected the duplicate to conflict, got %v", err)
+	}
+
+	// The duplicate must not free the slot still held by the original request
+	if err := m.Lock(ctx, &Request{IdempotencyKey: "k2"}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
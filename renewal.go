@@ -0,0 +1,78 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// lockRenewals tracks the lock renewals running on this instance per key
+type lockRenewals struct {
+	mu    sync.Mutex
+	stops map[string]func()
+}
+
+func newLockRenewals() *lockRenewals {
+	return &lockRenewals{stops: make(map[string]func())}
+}
+
+// renewLock renews the lock of key for lockTTL every Config.LockRenewalInterval until
+// stopRenewal is called or the lock is lost
+func (m *Manager) renewLock(key string, lockTTL time.Duration) {
+	if m.config.LockRenewalInterval <= 0 {
+		return
+	}
+	m.stopRenewal(key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(m.config.LockRenewalInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Errors are retried on the next tick, the lock is still valid until then
+				if held, err := m.storageExtendLock(ctx, key, lockTTL); err == nil && !held {
+					return
+				}
+			}
+		}
+	}()
+
+	m.renewals.mu.Lock()
+	m.renewals.stops[key] = func() {
+		cancel()
+		<-done
+	}
+	m.renewals.mu.Unlock()
+}
+
+// stopRenewal stops the lock renewal of key and waits for it to exit, so the lock is
+// not renewed after the record is completed or failed. Unknown keys are ignored.
+func (m *Manager) stopRenewal(key string) {
+	m.renewals.mu.Lock()
+	stop, ok := m.renewals.stops[key]
+	delete(m.renewals.stops, key)
+	m.renewals.mu.Unlock()
+
+	if ok {
+		stop()
+	}
+}
+
+// stopRenewals stops every lock renewal, e.g. before the storage is closed
+func (m *Manager) stopRenewals() {
+	m.renewals.mu.Lock()
+	stops := m.renewals.stops
+	m.renewals.stops = make(map[string]func())
+	m.renewals.mu.Unlock()
+
+	for _, stop := range stops {
+		stop()
+	}
+}
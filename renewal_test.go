@@ -0,0 +1,74 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// extenderStorage is a MockStorage implementing LockExtender
+type extenderStorage struct {
+	MockStorage
+	extended atomic.Int32
+	held     atomic.Bool
+}
+
+func (s *extenderStorage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.extended.Add(1)
+	return s.held.Load(), nil
+}
+
+func TestManager_LockRenewal(t *testing.T) {
+	store := &extenderStorage{}
+	store.held.Store(true)
+	m, err := NewManager(Config{Storage: store, LockTimeout: time.Second, LockRenewalInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	ctx := context.Background()
+
+	t.Run("RenewedUntilStored", func(t *testing.T) {
+		if err := m.Lock(ctx, &Request{IdempotencyKey: "k1"}); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		time.Sleep(30 * time.Millisecond)
+		if store.extended.Load() == 0 {
+			t.Fatal("expected the lock to be renewed")
+		}
+
+		if err := m.Store(ctx, "k1", &Response{StatusCode: 200}); err != nil {
+			t.Fatalf("Store failed: %v", err)
+		}
+		renewed := store.extended.Load()
+		time.Sleep(20 * time.Millisecond)
+		if store.extended.Load() != renewed {
+			t.Error("expected renewal to stop once the response is stored")
+		}
+	})
+
+	t.Run("LockLost", func(t *testing.T) {
+		store.held.Store(false)
+		store.extended.Store(0)
+		if err := m.Lock(ctx, &Request{IdempotencyKey: "k2"}); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		time.Sleep(30 * time.Millisecond)
+		if got := store.extended.Load(); got != 1 {
+			t.Errorf("expected renewal to stop once the lock is lost, extended %d times", got)
+		}
+		_ = m.Unlock(ctx, "k2")
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := NewManager(Config{Storage: &MockStorage{}, LockRenewalInterval: time.Second})
+		if !errors.Is(err, ErrInvalidConfiguration) {
+			t.Errorf("expected a storage without LockExtender to be rejected, got %v", err)
+		}
+		_, err = NewManager(Config{Storage: store, LockTimeout: time.Second, LockRenewalInterval: time.Second})
+		if !errors.Is(err, ErrInvalidConfiguration) {
+			t.Errorf("expected an interval not shorter than LockTimeout to be rejected, got %v", err)
+		}
+	})
+}
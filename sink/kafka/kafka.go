@@ -0,0 +1,61 @@
+// Package kafka publishes idempotency decision events to a Kafka topic.
+//
+// The sink depends on a minimal Producer interface instead of a specific client.
+// With segmentio/kafka-go:
+//
+//	writer := &kafkago.Writer{Addr: kafkago.TCP("localhost:9092")}
+//	producer := kafka.ProducerFunc(func(ctx context.Context, topic string, key, value []byte) error {
+//		return writer.WriteMessages(ctx, kafkago.Message{Topic: topic, Key: key, Value: value})
+//	})
+//	manager, _ := idempotency.NewManager(idempotency.Config{
+//		Storage:   store,
+//		EventSink: kafka.NewSink(producer, "idempotency-decisions"),
+//	})
+//
+// Events are JSON encoded idempotency.DecisionEvent values keyed by idempotency key,
+// so all decisions about a key land in the same partition.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Producer writes a message to a Kafka topic
+type Producer interface {
+	Produce(ctx context.Context, topic string, key, value []byte) error
+}
+
+// ProducerFunc adapts a function to the Producer interface
+type ProducerFunc func(ctx context.Context, topic string, key, value []byte) error
+
+// Produce calls f
+func (f ProducerFunc) Produce(ctx context.Context, topic string, key, value []byte) error {
+	return f(ctx, topic, key, value)
+}
+
+// Sink is an idempotency.EventSink writing to a Kafka topic
+type Sink struct {
+	producer Producer
+	topic    string
+}
+
+// NewSink creates a sink producing to topic
+func NewSink(producer Producer, topic string) *Sink {
+	return &Sink{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+// Publish produces event to the topic
+func (s *Sink) Publish(ctx context.Context, event idempotency.DecisionEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return s.producer.Produce(ctx, s.topic, []byte(event.Key), value)
+}
@@ -0,0 +1,31 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+func TestSink_Publish(t *testing.T) {
+	var gotTopic, gotKey string
+	var got idempotency.DecisionEvent
+	producer := ProducerFunc(func(ctx context.Context, topic string, key, value []byte) error {
+		gotTopic, gotKey = topic, string(key)
+		return json.Unmarshal(value, &got)
+	})
+
+	sink := NewSink(producer, "decisions")
+	event := idempotency.DecisionEvent{Decision: idempotency.DecisionReplayed, Key: "k1", StatusCode: 201}
+	if err := sink.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if gotTopic != "decisions" || gotKey != "k1" {
+		t.Errorf("expected topic decisions and key k1, got %q and %q", gotTopic, gotKey)
+	}
+	if got.Decision != idempotency.DecisionReplayed || got.StatusCode != 201 {
+		t.Errorf("unexpected event: %+v", got)
+	}
+}
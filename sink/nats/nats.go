@@ -0,0 +1,49 @@
+// Package nats publishes idempotency decision events to NATS subjects.
+//
+// A *nats.Conn satisfies Publisher:
+//
+//	nc, _ := natsgo.Connect(natsgo.DefaultURL)
+//	manager, _ := idempotency.NewManager(idempotency.Config{
+//		Storage:   store,
+//		EventSink: nats.NewSink(nc, "idempotency.decisions"),
+//	})
+//
+// Each decision is published to "<prefix>.<decision>" (e.g. "idempotency.decisions.replayed")
+// as a JSON encoded idempotency.DecisionEvent, so subscribers can filter by decision.
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Publisher publishes a message to a subject
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// Sink is an idempotency.EventSink publishing to NATS
+type Sink struct {
+	publisher Publisher
+	prefix    string
+}
+
+// NewSink creates a sink publishing to subjects under prefix
+func NewSink(publisher Publisher, prefix string) *Sink {
+	return &Sink{
+		publisher: publisher,
+		prefix:    prefix,
+	}
+}
+
+// Publish publishes event to the subject of its decision
+func (s *Sink) Publish(ctx context.Context, event idempotency.DecisionEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return s.publisher.Publish(s.prefix+"."+string(event.Decision), data)
+}
@@ -0,0 +1,37 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+type fakePublisher struct {
+	subject string
+	data    []byte
+}
+
+func (p *fakePublisher) Publish(subject string, data []byte) error {
+	p.subject, p.data = subject, data
+	return nil
+}
+
+func TestSink_Publish(t *testing.T) {
+	pub := &fakePublisher{}
+	sink := NewSink(pub, "idempotency.decisions")
+
+	event := idempotency.DecisionEvent{Decision: idempotency.DecisionMismatch, Key: "k1"}
+	if err := sink.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	if pub.subject != "idempotency.decisions.mismatch" {
+		t.Errorf("expected decision subject, got %q", pub.subject)
+	}
+	var got idempotency.DecisionEvent
+	if err := json.Unmarshal(pub.data, &got); err != nil || got.Key != "k1" {
+		t.Errorf("unexpected payload %s: %v", pub.data, err)
+	}
+}
@@ -0,0 +1,58 @@
+package idempotency
+
+import "context"
+
+// ListStale returns the pending records whose lock has expired, i.e. requests that
+// started more than LockTimeout ago and never completed (crashed instance, lost
+// connection...). Such records block retries with ErrRequestInProgress until their TTL
+// expires unless they are failed with Fail.
+// The storage backend must implement RecordLister.
+func (m *Manager) ListStale(ctx context.Context) ([]*Record, error) {
+	records, err := m.storageList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	var stale []*Record
+	for _, record := range records {
+		if record.Status != StatusPending {
+			continue
+		}
+		if !record.ExpiresAt.IsZero() && now.After(record.ExpiresAt) {
+			continue
+		}
+		if now.After(record.CreatedAt.Add(m.config.LockTimeout)) {
+			stale = append(stale, record)
+		}
+	}
+
+	return stale, nil
+}
+
+// Fail marks the pending record for key as failed and releases its lock, so the
+// request can be retried immediately instead of waiting for the record TTL.
+// Records that are not pending are left untouched.
+func (m *Manager) Fail(ctx context.Context, key string) error {
+	if key == "" {
+		return ErrNoIdempotencyKey
+	}
+
+	return m.fail(context.WithoutCancel(ctx), key, "")
+}
+
+// fail marks the pending record for key as failed with reason and releases its lock
+func (m *Manager) fail(ctx context.Context, key, reason string) error {
+	m.stopRenewal(key)
+	defer m.quota.release(key)
+
+	if err := m.markFailed(ctx, key, reason); err != nil {
+		return err
+	}
+
+	if err := m.storageUnlock(ctx, key); err != nil {
+		return NewStorageError("unlock", err)
+	}
+
+	return nil
+}
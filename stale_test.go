@@ -0,0 +1,120 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// listingStorage is a MockStorage implementing RecordLister
+type listingStorage struct {
+	MockStorage
+	records map[string]*Record
+}
+
+func newListingStorage() *listingStorage {
+	s := &listingStorage{records: make(map[string]*Record)}
+	s.GetFunc = func(ctx context.Context, key string) (*Record, error) {
+		return s.records[key], nil
+	}
+	s.SetFunc = func(ctx context.Context, r *Record, ttl time.Duration) error {
+		s.records[r.Key] = r
+		return nil
+	}
+	return s
+}
+
+func (s *listingStorage) List(ctx context.Context) ([]*Record, error) {
+	records := make([]*Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func TestManager_ListStale(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	t.Run("NotSupported", func(t *testing.T) {
+		m, _ := NewManager(Config{Storage: &MockStorage{}})
+		if _, err := m.ListStale(ctx); !errors.Is(err, ErrListingNotSupported) {
+			t.Fatalf("expected ErrListingNotSupported, got %v", err)
+		}
+	})
+
+	t.Run("PendingWithExpiredLock", func(t *testing.T) {
+		store := newListingStorage()
+		store.records["stale"] = &Record{Key: "stale", Status: StatusPending, CreatedAt: now.Add(-10 * time.Minute), ExpiresAt: now.Add(time.Hour)}
+		store.records["running"] = &Record{Key: "running", Status: StatusPending, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		store.records["done"] = &Record{Key: "done", Status: StatusCompleted, CreatedAt: now.Add(-10 * time.Minute), ExpiresAt: now.Add(time.Hour)}
+
+		m, _ := NewManager(Config{Storage: store, LockTimeout: time.Minute})
+		stale, err := m.ListStale(ctx)
+		if err != nil {
+			t.Fatalf("ListStale failed: %v", err)
+		}
+		if len(stale) != 1 || stale[0].Key != "stale" {
+			t.Fatalf("expected only the stale record, got %v", stale)
+		}
+	})
+}
+
+func TestManager_Fail(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("EmptyKey", func(t *testing.T) {
+		m, _ := NewManager(Config{Storage: &MockStorage{}})
+		if err := m.Fail(ctx, ""); !errors.Is(err, ErrNoIdempotencyKey) {
+			t.Fatalf("expected ErrNoIdempotencyKey, got %v", err)
+		}
+	})
+
+	t.Run("PendingRecord", func(t *testing.T) {
+		store := newListingStorage()
+		var unlocked string
+		store.UnlockFunc = func(ctx context.Context, key string) error {
+			unlocked = key
+			return nil
+		}
+		m, _ := NewManager(Config{Storage: store})
+
+		if err := m.Lock(ctx, &Request{IdempotencyKey: "k"}); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		if err := m.Fail(ctx, "k"); err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+		if store.records["k"].Status != StatusFailed || unlocked != "k" {
+			t.Fatalf("expected record failed and unlocked, got %s / %q", store.records["k"].Status, unlocked)
+		}
+	})
+
+	t.Run("CompletedRecordUntouched", func(t *testing.T) {
+		store := newListingStorage()
+		store.records["k"] = &Record{Key: "k", Status: StatusCompleted}
+		m, _ := NewManager(Config{Storage: store})
+
+		if err := m.Fail(ctx, "k"); err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+		if store.records["k"].Status != StatusCompleted {
+			t.Fatalf("expected completed record to be untouched, got %s", store.records["k"].Status)
+		}
+	})
+
+	t.Run("SetError", func(t *testing.T) {
+		store := newListingStorage()
+		store.records["k"] = &Record{Key: "k", Status: StatusPending}
+		store.SetFunc = func(ctx context.Context, r *Record, ttl time.Duration) error {
+			return errors.New("boom")
+		}
+		m, _ := NewManager(Config{Storage: store})
+
+		var se *StorageError
+		if err := m.Fail(ctx, "k"); !errors.As(err, &se) || se.Operation != "set" {
+			t.Fatalf("expected StorageError(set), got %v", err)
+		}
+	})
+}
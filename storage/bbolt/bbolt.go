@@ -0,0 +1,352 @@
+// Package bbolt provides an embedded, file-backed storage backend for gopotency built on
+// bbolt, for single-binary deployments whose idempotency records must survive restarts
+// without an external database. Expirations are stored with each entry: expired
+// entries are ignored by reads and deleted by a background sweeper.
+package bbolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	recordsBucket = []byte("idempotency_records")
+	locksBucket   = []byte("idempotency_locks")
+)
+
+// Storage is a bbolt implementation of idempotency.Storage
+type Storage struct {
+	db    *bolt.DB
+	codec idempotency.Codec
+	clock idempotency.Clock
+
+	stopCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// Options configures the bbolt storage
+type Options struct {
+	// Timeout is how long Open waits for the file lock held by another process before
+	// failing
+	// Default: 5s
+	Timeout time.Duration
+
+	// CleanupInterval is how often expired records and locks are deleted from the file.
+	// A negative interval disables the sweeper, leaving cleanup to Cleanup.
+	// Default: 1m
+	CleanupInterval time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.Timeout == 0 {
+		o.Timeout = 5 * time.Second
+	}
+	if o.CleanupInterval == 0 {
+		o.CleanupInterval = time.Minute
+	}
+}
+
+// Open opens (or creates) the bbolt database at path, creates the idempotency buckets
+// if needed and starts the sweeper. bbolt locks the file: a single process can open it
+// at a time.
+func Open(path string, opts Options) (*Storage, error) {
+	opts.setDefaults()
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: opts.Timeout})
+	if err != nil {
+		return nil, idempotency.NewStorageError("open", err)
+	}
+
+	s, err := New(db, opts.CleanupInterval)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New creates a storage on an open bbolt database and creates the idempotency buckets
+// if needed. Expired entries are deleted every cleanupInterval, never when it is not
+// positive. Closing the storage closes db.
+func New(db *bolt.DB, cleanupInterval time.Duration) (*Storage, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(recordsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(locksBucket)
+		return err
+	})
+	if err != nil {
+		return nil, idempotency.NewStorageError("migrate", err)
+	}
+
+	s := &Storage{db: db, codec: idempotency.JSONCodec, clock: idempotency.SystemClock, stopCh: make(chan struct{})}
+	if cleanupInterval > 0 {
+		go s.sweep(cleanupInterval)
+	}
+	return s, nil
+}
+
+// SetCodec replaces the codec used to serialize records (default idempotency.JSONCodec).
+// It implements idempotency.CodecSetter and must be called before the storage is used.
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	s.codec = codec
+}
+
+// SetClock replaces the clock deciding expirations (default idempotency.SystemClock).
+// It implements idempotency.ClockSetter and must be called before the storage is used.
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	s.clock = clock
+}
+
+// Get retrieves an idempotency record by key. Expired records are ignored.
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	var record *idempotency.Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = s.get(tx, key, s.clock.Now())
+		return err
+	})
+	return record, err
+}
+
+func (s *Storage) get(tx *bolt.Tx, key string, now time.Time) (*idempotency.Record, error) {
+	data, ok := live(tx.Bucket(recordsBucket).Get([]byte(key)), now)
+	if !ok {
+		return nil, nil
+	}
+	record, err := s.codec.Unmarshal(data)
+	if err != nil {
+		return nil, idempotency.NewStorageError("unmarshal", err)
+	}
+	return record, nil
+}
+
+// Set stores an idempotency record
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	return s.update("set", func(tx *bolt.Tx) error {
+		return s.set(tx, record, ttl, s.clock.Now())
+	})
+}
+
+func (s *Storage) set(tx *bolt.Tx, record *idempotency.Record, ttl time.Duration, now time.Time) error {
+	data, err := s.codec.Marshal(record)
+	if err != nil {
+		return idempotency.NewStorageError("marshal", err)
+	}
+	return tx.Bucket(recordsBucket).Put([]byte(record.Key), entry(now.Add(ttl), data))
+}
+
+// Delete removes an idempotency record and its lock
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	return s.update("delete", func(tx *bolt.Tx) error {
+		if err := tx.Bucket(recordsBucket).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return tx.Bucket(locksBucket).Delete([]byte(key))
+	})
+}
+
+// Exists checks if a non-expired record exists
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, exists = live(tx.Bucket(recordsBucket).Get([]byte(key)), s.clock.Now())
+		return nil
+	})
+	if err != nil {
+		return false, idempotency.NewStorageError("exists", err)
+	}
+	return exists, nil
+}
+
+// List returns all non-expired records. It implements idempotency.RecordLister.
+func (s *Storage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	var records []*idempotency.Record
+	now := s.clock.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).ForEach(func(_, value []byte) error {
+			data, ok := live(value, now)
+			if !ok {
+				return nil
+			}
+			record, err := s.codec.Unmarshal(data)
+			if err != nil {
+				return idempotency.NewStorageError("unmarshal", err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// TryLock attempts to acquire the lock for key, replacing an expired lock
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	var locked bool
+	err := s.update("trylock", func(tx *bolt.Tx) error {
+		var err error
+		locked, err = tryLock(tx, key, ttl, s.clock.Now())
+		return err
+	})
+	return locked, err
+}
+
+func tryLock(tx *bolt.Tx, key string, ttl time.Duration, now time.Time) (bool, error) {
+	locks := tx.Bucket(locksBucket)
+	if _, held := live(locks.Get([]byte(key)), now); held {
+		return false, nil
+	}
+	return true, locks.Put([]byte(key), entry(now.Add(ttl), nil))
+}
+
+// TryLockAndSet acquires the lock and stores the pending record in a single
+// transaction. It implements idempotency.LockSetter.
+func (s *Storage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	var locked bool
+	err := s.update("trylock", func(tx *bolt.Tx) error {
+		now := s.clock.Now()
+		var err error
+		if locked, err = tryLock(tx, record.Key, lockTTL, now); err != nil || !locked {
+			return err
+		}
+		return s.set(tx, record, ttl, now)
+	})
+	if err != nil {
+		return false, err
+	}
+	return locked, nil
+}
+
+// GetOrLock returns the non-expired record for key if there is one, otherwise acquires
+// the lock and stores record, in a single transaction. It implements
+// idempotency.GetOrLocker.
+func (s *Storage) GetOrLock(ctx context.Context, key string, record *idempotency.Record, ttl, lockTTL time.Duration) (*idempotency.Record, bool, error) {
+	var existing *idempotency.Record
+	var locked bool
+	err := s.update("getorlock", func(tx *bolt.Tx) error {
+		now := s.clock.Now()
+		var err error
+		if existing, err = s.get(tx, key, now); err != nil || existing != nil {
+			return err
+		}
+		if locked, err = tryLock(tx, key, lockTTL, now); err != nil || !locked {
+			return err
+		}
+		return s.set(tx, record, ttl, now)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, locked, nil
+}
+
+// ExtendLock makes the lock held for key expire ttl from now, reporting false if it
+// expired or was released. It implements idempotency.LockExtender.
+func (s *Storage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	var held bool
+	err := s.update("extendlock", func(tx *bolt.Tx) error {
+		now := s.clock.Now()
+		locks := tx.Bucket(locksBucket)
+		if _, held = live(locks.Get([]byte(key)), now); !held {
+			return nil
+		}
+		return locks.Put([]byte(key), entry(now.Add(ttl), nil))
+	})
+	return held, err
+}
+
+// Unlock releases the lock for key
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	return s.update("unlock", func(tx *bolt.Tx) error {
+		return tx.Bucket(locksBucket).Delete([]byte(key))
+	})
+}
+
+// Cleanup deletes expired records and locks. The sweeper calls it every
+// CleanupInterval; expired entries are already ignored by reads.
+func (s *Storage) Cleanup(ctx context.Context) error {
+	now := s.clock.Now()
+	return s.update("cleanup", func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{recordsBucket, locksBucket} {
+			c := tx.Bucket(name).Cursor()
+			for k, v := c.First(); k != nil; {
+				if _, ok := live(v, now); ok {
+					k, v = c.Next()
+					continue
+				}
+				// Next skips an entry after Delete, seek to the one following the deleted key
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				k, v = c.Seek(k)
+			}
+		}
+		return nil
+	})
+}
+
+// Close stops the sweeper and closes the database
+func (s *Storage) Close() error {
+	s.closeOnce.Do(func() { close(s.stopCh) })
+	return s.db.Close()
+}
+
+// sweep deletes expired entries every interval until the storage is closed
+func (s *Storage) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = s.Cleanup(context.Background())
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// update runs fn in a read-write transaction, wrapping bbolt errors in a StorageError
+// for op
+func (s *Storage) update(op string, fn func(tx *bolt.Tx) error) error {
+	err := s.db.Update(fn)
+	if err != nil {
+		var storageErr *idempotency.StorageError
+		if errors.As(err, &storageErr) {
+			return err
+		}
+		return idempotency.NewStorageError(op, err)
+	}
+	return nil
+}
+
+// entry encodes an expiration, as big-endian Unix nanoseconds, followed by data
+func entry(expiresAt time.Time, data []byte) []byte {
+	buf := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(buf, uint64(expiresAt.UnixNano()))
+	copy(buf[8:], data)
+	return buf
+}
+
+// live returns the data of an entry and whether it exists and has not expired. The
+// data is copied, bbolt values are only valid during their transaction.
+func live(value []byte, now time.Time) ([]byte, bool) {
+	if len(value) < 8 {
+		return nil, false
+	}
+	if int64(binary.BigEndian.Uint64(value)) <= now.UnixNano() {
+		return nil, false
+	}
+	return bytes.Clone(value[8:]), true
+}
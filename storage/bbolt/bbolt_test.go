@@ -0,0 +1,121 @@
+package bbolt
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+func TestBboltStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.db")
+	store, err := Open(path, Options{CleanupInterval: -1})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer func() { store.Close() }()
+	ctx := context.Background()
+
+	t.Run("SetAndGet", func(t *testing.T) {
+		record := &idempotency.Record{Key: "key1", Status: idempotency.StatusCompleted}
+		if err := store.Set(ctx, record, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := store.Get(ctx, "key1")
+		if err != nil || got == nil || got.Status != idempotency.StatusCompleted {
+			t.Fatalf("expected completed record, got %v, %v", got, err)
+		}
+		if exists, _ := store.Exists(ctx, "key1"); !exists {
+			t.Error("expected record to exist")
+		}
+		if records, _ := store.List(ctx); len(records) != 1 {
+			t.Errorf("expected 1 listed record, got %d", len(records))
+		}
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		_ = store.Set(ctx, &idempotency.Record{Key: "short"}, time.Millisecond)
+		_, _ = store.TryLock(ctx, "short", time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		if got, _ := store.Get(ctx, "short"); got != nil {
+			t.Errorf("expected expired record to be ignored, got %v", got)
+		}
+		if err := store.Cleanup(ctx); err != nil {
+			t.Fatalf("Cleanup failed: %v", err)
+		}
+		if records, _ := store.List(ctx); len(records) != 1 {
+			t.Errorf("expected only the live record to remain, got %d", len(records))
+		}
+	})
+
+	t.Run("Locking", func(t *testing.T) {
+		if locked, err := store.TryLock(ctx, "lock1", time.Hour); err != nil || !locked {
+			t.Fatalf("expected lock to be acquired, got %v, %v", locked, err)
+		}
+		if locked, _ := store.TryLock(ctx, "lock1", time.Hour); locked {
+			t.Error("expected lock to be held")
+		}
+		_ = store.Unlock(ctx, "lock1")
+		if locked, _ := store.TryLock(ctx, "lock1", time.Millisecond); !locked {
+			t.Error("expected lock to be acquired after unlock")
+		}
+		time.Sleep(5 * time.Millisecond)
+		if locked, _ := store.TryLock(ctx, "lock1", time.Hour); !locked {
+			t.Error("expected expired lock to be taken over")
+		}
+		if held, _ := store.ExtendLock(ctx, "lock1", time.Hour); !held {
+			t.Error("expected the held lock to be extended")
+		}
+	})
+
+	t.Run("GetOrLock", func(t *testing.T) {
+		pending := &idempotency.Record{Key: "gol", Status: idempotency.StatusPending}
+		existing, locked, err := store.GetOrLock(ctx, "gol", pending, time.Hour, time.Hour)
+		if err != nil || existing != nil || !locked {
+			t.Fatalf("expected the lock to be acquired, got %v, %v, %v", existing, locked, err)
+		}
+		existing, locked, _ = store.GetOrLock(ctx, "gol", pending, time.Hour, time.Hour)
+		if existing == nil || locked {
+			t.Errorf("expected the pending record to be returned, got %v, %v", existing, locked)
+		}
+	})
+
+	t.Run("Persistence", func(t *testing.T) {
+		if err := store.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		store, err = Open(path, Options{})
+		if err != nil {
+			t.Fatalf("reopen failed: %v", err)
+		}
+		if got, _ := store.Get(ctx, "key1"); got == nil || got.Status != idempotency.StatusCompleted {
+			t.Errorf("expected the record to survive a restart, got %v", got)
+		}
+		if locked, _ := store.TryLock(ctx, "lock1", time.Hour); locked {
+			t.Error("expected the lock to survive a restart")
+		}
+	})
+
+	t.Run("Manager", func(t *testing.T) {
+		m, err := idempotency.NewManager(idempotency.Config{Storage: store})
+		if err != nil {
+			t.Fatalf("NewManager failed: %v", err)
+		}
+		calls := 0
+		fn := func(ctx context.Context) (*idempotency.Response, error) {
+			calls++
+			return &idempotency.Response{StatusCode: 201}, nil
+		}
+		for range 2 {
+			if _, err := m.Execute(ctx, "order-1", fn); err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+		}
+		if calls != 1 {
+			t.Errorf("expected the operation to run once, ran %d times", calls)
+		}
+	})
+}
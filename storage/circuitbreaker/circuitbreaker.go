@@ -0,0 +1,310 @@
+// Package circuitbreaker provides a storage wrapper that stops calling a failing
+// backend, so a flapping Redis or database fails requests immediately instead of adding
+// its timeout to every one of them:
+//
+//	store := circuitbreaker.New(redisStore, circuitbreaker.Options{
+//		FailureThreshold: 5,
+//		OpenTimeout:      30 * time.Second,
+//		OnStateChange: func(from, to circuitbreaker.State) {
+//			log.Printf("idempotency storage circuit %s -> %s", from, to)
+//		},
+//	})
+//
+// The circuit opens after FailureThreshold consecutive failures: operations then fail
+// with ErrOpen without reaching the storage. After OpenTimeout it is half-open and lets
+// HalfOpenRequests trial operations through, closing again when they all succeed and
+// reopening on the first failure.
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// ErrOpen is returned, wrapped in an idempotency.StorageError, by operations rejected
+// while the circuit is open
+var ErrOpen = errors.New("circuitbreaker: storage circuit is open")
+
+// State is the state of the circuit
+type State int
+
+const (
+	// StateClosed lets every operation through to the storage
+	StateClosed State = iota
+
+	// StateOpen rejects every operation with ErrOpen
+	StateOpen
+
+	// StateHalfOpen lets a limited number of trial operations through to probe the
+	// storage
+	StateHalfOpen
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Options configures the circuit breaker
+type Options struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit
+	// Default: 5
+	FailureThreshold int
+
+	// OpenTimeout is how long the circuit stays open before letting trial operations
+	// through
+	// Default: 30s
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of trial operations let through, and required to
+	// succeed to close the circuit, while it is half-open
+	// Default: 1
+	HalfOpenRequests int
+
+	// IsFailure reports whether an error counts as a storage failure. Errors of
+	// operations whose context is done never count (optional, every error by default)
+	IsFailure func(err error) bool
+
+	// OnStateChange is called on every state transition, e.g. for alerting. It is
+	// called outside of the breaker lock (optional)
+	OnStateChange func(from, to State)
+}
+
+func (o *Options) setDefaults() {
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 5
+	}
+	if o.OpenTimeout <= 0 {
+		o.OpenTimeout = 30 * time.Second
+	}
+	if o.HalfOpenRequests <= 0 {
+		o.HalfOpenRequests = 1
+	}
+}
+
+// Storage guards a storage with a circuit breaker
+type Storage struct {
+	storage idempotency.Storage
+	opts    Options
+
+	mu        sync.Mutex
+	state     State
+	failures  int       // consecutive failures while closed
+	openedAt  time.Time // when the circuit last opened
+	inFlight  int       // trial operations in progress while half-open
+	successes int       // successful trial operations while half-open
+}
+
+// New creates a circuit breaker around storage
+func New(storage idempotency.Storage, opts Options) *Storage {
+	opts.setDefaults()
+	return &Storage{storage: storage, opts: opts}
+}
+
+// State returns the current state of the circuit
+func (s *Storage) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == StateOpen && time.Since(s.openedAt) >= s.opts.OpenTimeout {
+		return StateHalfOpen
+	}
+	return s.state
+}
+
+// Get retrieves a record through the breaker
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	var record *idempotency.Record
+	err := s.call(ctx, "get", func() error {
+		var err error
+		record, err = s.storage.Get(ctx, key)
+		return err
+	})
+	return record, err
+}
+
+// Set stores a record through the breaker
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	return s.call(ctx, "set", func() error {
+		return s.storage.Set(ctx, record, ttl)
+	})
+}
+
+// Delete removes a record through the breaker
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	return s.call(ctx, "delete", func() error {
+		return s.storage.Delete(ctx, key)
+	})
+}
+
+// Exists checks if a record exists through the breaker
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := s.call(ctx, "exists", func() error {
+		var err error
+		exists, err = s.storage.Exists(ctx, key)
+		return err
+	})
+	return exists, err
+}
+
+// TryLock acquires a lock through the breaker
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	var locked bool
+	err := s.call(ctx, "trylock", func() error {
+		var err error
+		locked, err = s.storage.TryLock(ctx, key, ttl)
+		return err
+	})
+	return locked, err
+}
+
+// Unlock releases a lock through the breaker
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	return s.call(ctx, "unlock", func() error {
+		return s.storage.Unlock(ctx, key)
+	})
+}
+
+// SetCodec sets the codec of the wrapped storage if it serializes records
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	if setter, ok := s.storage.(idempotency.CodecSetter); ok {
+		setter.SetCodec(codec)
+	}
+}
+
+// SetClock sets the clock of the wrapped storage if it computes expirations
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	if setter, ok := s.storage.(idempotency.ClockSetter); ok {
+		setter.SetClock(clock)
+	}
+}
+
+// Close closes the wrapped storage, regardless of the circuit state
+func (s *Storage) Close() error {
+	return s.storage.Close()
+}
+
+// call runs fn unless the circuit rejects it, and records its outcome
+func (s *Storage) call(ctx context.Context, op string, fn func() error) error {
+	trial, err := s.allow()
+	if err != nil {
+		return idempotency.NewStorageError(op, err)
+	}
+
+	err = fn()
+	s.record(trial, err, ctx.Err() != nil)
+	return err
+}
+
+// allow reports whether an operation may run, and whether it is a trial operation of
+// the half-open circuit
+func (s *Storage) allow() (trial bool, err error) {
+	s.mu.Lock()
+	var change func()
+	defer func() {
+		s.mu.Unlock()
+		if change != nil {
+			change()
+		}
+	}()
+
+	if s.state == StateOpen {
+		if time.Since(s.openedAt) < s.opts.OpenTimeout {
+			return false, ErrOpen
+		}
+		change = s.transition(StateHalfOpen)
+	}
+	if s.state == StateHalfOpen {
+		if s.inFlight >= s.opts.HalfOpenRequests {
+			return false, ErrOpen
+		}
+		s.inFlight++
+		return true, nil
+	}
+	return false, nil
+}
+
+// record updates the circuit with the outcome of an operation. Errors not counting as
+// failures, and errors of operations whose context was canceled, are ignored: they
+// neither open nor close the circuit.
+func (s *Storage) record(trial bool, err error, canceled bool) {
+	ignored := err != nil && (canceled || !s.isFailure(err))
+
+	s.mu.Lock()
+	var change func()
+	defer func() {
+		s.mu.Unlock()
+		if change != nil {
+			change()
+		}
+	}()
+
+	switch s.state {
+	case StateClosed:
+		if ignored {
+			return
+		}
+		if err == nil {
+			s.failures = 0
+			return
+		}
+		s.failures++
+		if s.failures >= s.opts.FailureThreshold {
+			change = s.transition(StateOpen)
+		}
+	case StateHalfOpen:
+		if !trial {
+			return
+		}
+		s.inFlight--
+		if ignored {
+			return
+		}
+		if err != nil {
+			change = s.transition(StateOpen)
+			return
+		}
+		s.successes++
+		if s.successes >= s.opts.HalfOpenRequests {
+			change = s.transition(StateClosed)
+		}
+	}
+}
+
+// transition moves the circuit to state, resetting its counters. It must be called with
+// the lock held and returns the callback to run once it is released.
+func (s *Storage) transition(to State) func() {
+	from := s.state
+	s.state = to
+	s.failures, s.inFlight, s.successes = 0, 0, 0
+	if to == StateOpen {
+		s.openedAt = time.Now()
+	}
+
+	if s.opts.OnStateChange == nil {
+		return nil
+	}
+	return func() { s.opts.OnStateChange(from, to) }
+}
+
+func (s *Storage) isFailure(err error) bool {
+	if s.opts.IsFailure != nil {
+		return s.opts.IsFailure(err)
+	}
+	return true
+}
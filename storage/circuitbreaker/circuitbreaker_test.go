@@ -0,0 +1,144 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+// flakyStorage is a storage whose reads fail while down is set
+type flakyStorage struct {
+	*memory.Storage
+	down  bool
+	calls int
+}
+
+func (f *flakyStorage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	f.calls++
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	return f.Storage.Get(ctx, key)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("OpensAndRecovers", func(t *testing.T) {
+		backend := &flakyStorage{Storage: memory.NewMemoryStorage(), down: true}
+		var transitions []string
+		store := New(backend, Options{
+			FailureThreshold: 3,
+			OpenTimeout:      20 * time.Millisecond,
+			OnStateChange: func(from, to State) {
+				transitions = append(transitions, from.String()+"->"+to.String())
+			},
+		})
+		defer store.Close()
+
+		for range 3 {
+			_, _ = store.Get(ctx, "k1")
+		}
+		if store.State() != StateOpen {
+			t.Fatalf("expected the circuit to open, got %v", store.State())
+		}
+
+		_, err := store.Get(ctx, "k1")
+		if !errors.Is(err, ErrOpen) || backend.calls != 3 {
+			t.Errorf("expected the open circuit to reject without calling the storage, got %v after %d calls", err, backend.calls)
+		}
+
+		time.Sleep(30 * time.Millisecond)
+		if _, err := store.Get(ctx, "k1"); err == nil || errors.Is(err, ErrOpen) {
+			t.Errorf("expected the trial operation to reach the storage, got %v", err)
+		}
+		if store.State() != StateOpen {
+			t.Fatalf("expected a failed trial to reopen the circuit, got %v", store.State())
+		}
+
+		backend.down = false
+		time.Sleep(30 * time.Millisecond)
+		if _, err := store.Get(ctx, "k1"); err != nil {
+			t.Fatalf("expected the trial operation to succeed, got %v", err)
+		}
+		if store.State() != StateClosed {
+			t.Errorf("expected a successful trial to close the circuit, got %v", store.State())
+		}
+
+		want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+		if len(transitions) != len(want) {
+			t.Fatalf("expected transitions %v, got %v", want, transitions)
+		}
+		for i := range want {
+			if transitions[i] != want[i] {
+				t.Errorf("expected transitions %v, got %v", want, transitions)
+				break
+			}
+		}
+	})
+
+	t.Run("SuccessResetsFailures", func(t *testing.T) {
+		backend := &flakyStorage{Storage: memory.NewMemoryStorage()}
+		store := New(backend, Options{FailureThreshold: 2})
+		defer store.Close()
+
+		backend.down = true
+		_, _ = store.Get(ctx, "k1")
+		backend.down = false
+		_, _ = store.Get(ctx, "k1")
+		backend.down = true
+		_, _ = store.Get(ctx, "k1")
+		if store.State() != StateClosed {
+			t.Errorf("expected non-consecutive failures to keep the circuit closed, got %v", store.State())
+		}
+	})
+
+	t.Run("IgnoredErrors", func(t *testing.T) {
+		backend := &flakyStorage{Storage: memory.NewMemoryStorage(), down: true}
+		store := New(backend, Options{FailureThreshold: 1, IsFailure: func(err error) bool { return false }})
+		defer store.Close()
+
+		_, _ = store.Get(ctx, "k1")
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		store.opts.IsFailure = nil
+		_, _ = store.Get(canceled, "k1")
+		if store.State() != StateClosed {
+			t.Errorf("expected ignored errors not to open the circuit, got %v", store.State())
+		}
+	})
+}
+
+func TestCircuitBreaker_Extensions(t *testing.T) {
+	ctx := context.Background()
+	backend := &flakyStorage{Storage: memory.NewMemoryStorage()}
+	store := New(backend, Options{FailureThreshold: 1})
+	defer store.Close()
+
+	var s idempotency.Storage = store
+	if !idempotency.Supports[idempotency.GetOrLocker](s) || !idempotency.Supports[idempotency.LockExtender](s) ||
+		!idempotency.Supports[idempotency.RecordLister](s) || idempotency.Supports[idempotency.LockSetter](s) {
+		t.Error("expected the extensions of the wrapped storage, and only them, to be supported")
+	}
+	if _, err := idempotency.NewManager(idempotency.Config{Storage: store, LockRenewalInterval: time.Second}); err != nil {
+		t.Fatalf("expected lock renewal through the breaker, got %v", err)
+	}
+
+	if _, locked, err := store.GetOrLock(ctx, "k1", &idempotency.Record{Key: "k1"}, time.Hour, time.Minute); err != nil || !locked {
+		t.Fatalf("expected GetOrLock to acquire the lock, got %v, %v", locked, err)
+	}
+	if held, err := store.ExtendLock(ctx, "k1", time.Minute); err != nil || !held {
+		t.Errorf("expected ExtendLock to renew the lock, got %v, %v", held, err)
+	}
+
+	// Extensions go through the breaker
+	backend.down = true
+	_, _ = store.Get(ctx, "k1")
+	if _, err := store.ExtendLock(ctx, "k1", time.Minute); !errors.Is(err, ErrOpen) {
+		t.Errorf("expected the open circuit to reject extensions, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+// Package compress compresses serialized records above a size threshold, with gzip or
+// zstd, to reduce the memory used by byte-oriented backends (Redis, SQL, kv...) for
+// large and compressible responses such as JSON.
+//
+// Compression applies at the codec layer. Wrap a storage with New:
+//
+//	store := compress.New(redisStore, compress.Options{Algorithm: compress.Zstd})
+//
+// or set the codec once for every backend:
+//
+//	config := idempotency.Config{Storage: store, Codec: compress.Codec(idempotency.JSONCodec, compress.Options{})}
+//
+// Compressed records are recognized by the gzip or zstd frame header, so records stored
+// before compression was enabled, or with the other algorithm, are still read.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm is a compression algorithm
+type Algorithm int
+
+const (
+	// Gzip compresses with gzip (compress/gzip)
+	Gzip Algorithm = iota
+
+	// Zstd compresses with Zstandard, faster than gzip for a better ratio
+	Zstd
+)
+
+// String returns the name of the algorithm
+func (a Algorithm) String() string {
+	switch a {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", int(a))
+	}
+}
+
+// Options configures compression
+type Options struct {
+	// Algorithm compresses records
+	// Default: Gzip
+	Algorithm Algorithm
+
+	// Threshold is the serialized size, in bytes, from which records are compressed.
+	// Smaller records are stored as is, compression would not pay off.
+	// Default: 1024
+	Threshold int
+}
+
+func (o *Options) setDefaults() {
+	if o.Threshold <= 0 {
+		o.Threshold = 1024
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Codec wraps inner to compress the records it serializes
+func Codec(inner idempotency.Codec, opts Options) idempotency.Codec {
+	opts.setDefaults()
+	return &codec{inner: inner, opts: opts}
+}
+
+type codec struct {
+	inner idempotency.Codec
+	opts  Options
+}
+
+func (c *codec) Marshal(record *idempotency.Record) ([]byte, error) {
+	data, err := c.inner.Marshal(record)
+	if err != nil || len(data) < c.opts.Threshold {
+		return data, err
+	}
+
+	switch c.opts.Algorithm {
+	case Zstd:
+		return zstdEncoder().EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	default:
+		var buf bytes.Buffer
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+func (c *codec) Unmarshal(data []byte) (*idempotency.Record, error) {
+	switch {
+	case bytes.HasPrefix(data, zstdMagic):
+		decoded, err := zstdDecoder().DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("compress: zstd: %w", err)
+		}
+		data = decoded
+	case bytes.HasPrefix(data, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("compress: gzip: %w", err)
+		}
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, fmt.Errorf("compress: gzip: %w", err)
+		}
+	}
+	return c.inner.Unmarshal(data)
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// The zstd encoder and decoder are safe for concurrent EncodeAll and DecodeAll calls,
+// they are created once on first use
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, _ := zstd.NewWriter(nil)
+		return enc
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, _ := zstd.NewReader(nil)
+		return dec
+	})
+)
+
+// Storage compresses the records serialized by a storage implementing
+// idempotency.CodecSetter. Other storages (memory) are used as is.
+type Storage struct {
+	storage idempotency.Storage
+	opts    Options
+}
+
+// New wraps storage to compress the records it serializes with its default codec
+// (JSON), or with the codec later given to SetCodec
+func New(storage idempotency.Storage, opts Options) *Storage {
+	opts.setDefaults()
+	s := &Storage{storage: storage, opts: opts}
+	s.SetCodec(idempotency.JSONCodec)
+	return s
+}
+
+// Get retrieves a record
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	return s.storage.Get(ctx, key)
+}
+
+// Set stores a record, compressed when its serialization exceeds the threshold
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	return s.storage.Set(ctx, record, ttl)
+}
+
+// Delete removes a record
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	return s.storage.Delete(ctx, key)
+}
+
+// Exists checks if a record exists
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	return s.storage.Exists(ctx, key)
+}
+
+// TryLock acquires a lock on the underlying storage
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.storage.TryLock(ctx, key, ttl)
+}
+
+// Unlock releases a lock on the underlying storage
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	return s.storage.Unlock(ctx, key)
+}
+
+// SetCodec sets the codec of the underlying storage, wrapped to compress its output
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	if setter, ok := s.storage.(idempotency.CodecSetter); ok {
+		setter.SetCodec(Codec(codec, s.opts))
+	}
+}
+
+// SetClock sets the clock of the underlying storage if it computes expirations
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	if setter, ok := s.storage.(idempotency.ClockSetter); ok {
+		setter.SetClock(clock)
+	}
+}
+
+// Close closes the underlying storage
+func (s *Storage) Close() error {
+	return s.storage.Close()
+}
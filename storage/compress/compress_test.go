@@ -0,0 +1,116 @@
+package compress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/bbolt"
+)
+
+func TestCodec(t *testing.T) {
+	large := &idempotency.Record{
+		Key:      "k1",
+		Status:   idempotency.StatusCompleted,
+		Response: &idempotency.CachedResponse{StatusCode: 200, Body: bytes.Repeat([]byte(`{"id":1,"name":"item"},`), 200)},
+	}
+	plain, _ := idempotency.JSONCodec.Marshal(large)
+
+	for _, algorithm := range []Algorithm{Gzip, Zstd} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			c := Codec(idempotency.JSONCodec, Options{Algorithm: algorithm})
+			data, err := c.Marshal(large)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if len(data) >= len(plain)/4 {
+				t.Errorf("expected the record to be compressed, got %d bytes for %d", len(data), len(plain))
+			}
+
+			got, err := c.Unmarshal(data)
+			if err != nil || !bytes.Equal(got.Response.Body, large.Response.Body) {
+				t.Fatalf("expected the record to round-trip, got %v", err)
+			}
+
+			// Records compressed with the other algorithm, or not at all, are still read
+			other := Codec(idempotency.JSONCodec, Options{Algorithm: 1 - algorithm})
+			if _, err := other.Unmarshal(data); err != nil {
+				t.Errorf("expected the other algorithm to decode the record, got %v", err)
+			}
+			if _, err := c.Unmarshal(plain); err != nil {
+				t.Errorf("expected an uncompressed record to be read, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Threshold", func(t *testing.T) {
+		small := &idempotency.Record{Key: "k2", Status: idempotency.StatusPending}
+		data, _ := Codec(idempotency.JSONCodec, Options{}).Marshal(small)
+		if plain, _ := idempotency.JSONCodec.Marshal(small); !bytes.Equal(data, plain) {
+			t.Error("expected records below the threshold to be stored as is")
+		}
+	})
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	backend, err := bbolt.Open(filepath.Join(t.TempDir(), "idempotency.db"), bbolt.Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	store := New(backend, Options{Algorithm: Zstd, Threshold: 16})
+	defer store.Close()
+
+	m, err := idempotency.NewManager(idempotency.Config{Storage: store})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	body := bytes.Repeat([]byte("compressible "), 100)
+	for range 2 {
+		resp, err := m.Execute(ctx, "order-1", func(ctx context.Context) (*idempotency.Response, error) {
+			return &idempotency.Response{StatusCode: 201, Body: body}, nil
+		})
+		if err != nil || !bytes.Equal(resp.Body, body) {
+			t.Fatalf("expected the response to be stored and replayed, got %v", err)
+		}
+	}
+	if records, _ := backend.List(ctx); len(records) != 1 {
+		t.Errorf("expected the backend to decode the compressed record, got %d records", len(records))
+	}
+}
+
+func TestStorage_Extensions(t *testing.T) {
+	ctx := context.Background()
+	backend, err := bbolt.Open(filepath.Join(t.TempDir(), "idempotency.db"), bbolt.Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	store := New(backend, Options{Threshold: 16})
+	defer store.Close()
+
+	var s idempotency.Storage = store
+	if !idempotency.Supports[idempotency.GetOrLocker](s) || !idempotency.Supports[idempotency.LockSetter](s) ||
+		!idempotency.Supports[idempotency.RecordLister](s) || idempotency.Supports[idempotency.SetUnlocker](s) {
+		t.Error("expected the extensions of the wrapped storage, and only them, to be supported")
+	}
+
+	body := bytes.Repeat([]byte("compressible "), 100)
+	pending := &idempotency.Record{Key: "order-1", Status: idempotency.StatusPending}
+	if _, locked, err := store.GetOrLock(ctx, "order-1", pending, time.Hour, time.Minute); err != nil || !locked {
+		t.Fatalf("expected the lock to be acquired, got %v", err)
+	}
+	completed := &idempotency.Record{Key: "order-1", Status: idempotency.StatusCompleted, Response: &idempotency.CachedResponse{StatusCode: 201, Body: body}}
+	if err := store.Set(ctx, completed, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if records, err := store.List(ctx); err != nil || len(records) != 1 || !bytes.Equal(records[0].Response.Body, body) {
+		t.Errorf("expected the compressed record to be listed, got %v", err)
+	}
+	if err := store.SetAndUnlock(ctx, completed, time.Hour); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected an unsupported extension to fail, got %v", err)
+	}
+}
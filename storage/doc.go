@@ -2,5 +2,18 @@
 //
 // Available implementations:
 //   - memory: In-memory storage (development/testing)
-//   - redis: Redis-backed storage (coming soon)
+//   - ristretto: High-performance in-memory storage with cost-based eviction
+//   - redis: Redis-backed storage
+//   - sql: database/sql storage (PostgreSQL/SQLite)
+//   - sqlite: Embedded SQLite storage tuned for concurrency (WAL, busy timeout)
+//   - bbolt: Embedded file-backed storage with built-in expiration, for single binaries
+//   - gorm: GORM storage (any GORM dialect)
+//   - foundationdb: FoundationDB storage with transactional locking
+//   - hazelcast: Hazelcast distributed map storage
+//   - kv: Adapter turning any Get/SetNX/Set/Delete key-value store into a Storage
+//   - replicated: Wrapper replicating records across regions (last-writer-wins)
+//   - tiered: Wrapper caching completed records of a remote storage in a local LRU
+//   - circuitbreaker: Wrapper failing fast while a storage keeps failing
+//   - encrypted: Wrapper encrypting cached response bodies at rest (AES-GCM)
+//   - compress: Wrapper compressing serialized records (gzip, zstd) above a size threshold
 package storage
@@ -0,0 +1,246 @@
+// Package encrypted provides a storage wrapper encrypting cached response bodies at
+// rest with AES-GCM, whatever the underlying backend:
+//
+//	keys, _ := encrypted.StaticKey("2024-01", key) // 32-byte key for AES-256
+//	store := encrypted.New(redisStore, keys)
+//
+// Bodies are encrypted before being handed to the storage and decrypted when read, so
+// the manager and the middlewares only ever see plaintext. Each body is bound to the
+// storage key it is written to: a ciphertext, or a whole record, copied to another key
+// fails to decrypt.
+//
+// Keys are resolved through a KeyProvider, which can be backed by a KMS. Ciphertexts
+// carry the ID of their key, so keys can be rotated while records encrypted with the
+// previous key are still replayed. Records stored before encryption was enabled are
+// read as is.
+//
+// Only Response.Body is encrypted: status codes, headers and request hashes are stored
+// in clear, and bodies offloaded to a BlobStore (BodyOverflowBlob) must be encrypted by
+// the blob store itself.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// KeyProvider resolves the AES keys (16, 24 or 32 bytes) encrypting bodies
+type KeyProvider interface {
+	// CurrentKey returns the key encrypting new bodies and its ID, at most 255 bytes long
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the key with the given ID, to decrypt bodies encrypted with it
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// ErrUnknownKey is returned by the KeyProvider of StaticKey for unknown key IDs
+var ErrUnknownKey = errors.New("encrypted: unknown key")
+
+// StaticKey returns a KeyProvider encrypting with key, identified by id. Previous keys,
+// still decrypting records stored before a rotation, can be given by ID in previous.
+func StaticKey(id string, key []byte, previous ...map[string][]byte) (KeyProvider, error) {
+	keys := map[string][]byte{id: key}
+	for _, m := range previous {
+		for prevID, prevKey := range m {
+			keys[prevID] = prevKey
+		}
+	}
+	for keyID, k := range keys {
+		if len(keyID) > 255 {
+			return nil, fmt.Errorf("encrypted: key ID %q is longer than 255 bytes", keyID)
+		}
+		if _, err := aes.NewCipher(k); err != nil {
+			return nil, fmt.Errorf("encrypted: key %q: %w", keyID, err)
+		}
+	}
+	return &staticKeys{id: id, keys: keys}, nil
+}
+
+type staticKeys struct {
+	id   string
+	keys map[string][]byte
+}
+
+func (k *staticKeys) CurrentKey(ctx context.Context) (string, []byte, error) {
+	return k.id, k.keys[k.id], nil
+}
+
+func (k *staticKeys) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// magic prefixes encrypted bodies: magic, key ID length, key ID, nonce, ciphertext
+var magic = []byte("\x00gpenc\x01")
+
+// Storage encrypts the response bodies of the records of a storage
+type Storage struct {
+	storage idempotency.Storage
+	keys    KeyProvider
+}
+
+// New creates a storage encrypting the response bodies stored in storage with keys
+func New(storage idempotency.Storage, keys KeyProvider) *Storage {
+	return &Storage{storage: storage, keys: keys}
+}
+
+// Get retrieves a record and decrypts its response body
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	record, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptRecord(ctx, key, record)
+}
+
+// Set encrypts the response body of record, leaving record untouched, and stores it
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	encrypted, err := s.encryptRecord(ctx, record)
+	if err != nil {
+		return err
+	}
+	return s.storage.Set(ctx, encrypted, ttl)
+}
+
+// encryptRecord returns a copy of record with its response body encrypted for the key
+// it is stored at, or record itself when it has no body
+func (s *Storage) encryptRecord(ctx context.Context, record *idempotency.Record) (*idempotency.Record, error) {
+	if record.Response == nil || len(record.Response.Body) == 0 {
+		return record, nil
+	}
+
+	body, err := s.encrypt(ctx, record.Key, record.Response.Body)
+	if err != nil {
+		return nil, idempotency.NewStorageError("encrypt", err)
+	}
+	encrypted := *record
+	response := *record.Response
+	response.Body = body
+	encrypted.Response = &response
+	return &encrypted, nil
+}
+
+// decryptRecord returns a copy of record, read at key, with its response body
+// decrypted. Records without encrypted body are returned as is.
+func (s *Storage) decryptRecord(ctx context.Context, key string, record *idempotency.Record) (*idempotency.Record, error) {
+	if record == nil || record.Response == nil || !bytes.HasPrefix(record.Response.Body, magic) {
+		return record, nil
+	}
+
+	body, err := s.decrypt(ctx, key, record.Response.Body)
+	if err != nil {
+		return nil, idempotency.NewStorageError("decrypt", err)
+	}
+	decrypted := *record
+	response := *record.Response
+	response.Body = body
+	decrypted.Response = &response
+	return &decrypted, nil
+}
+
+// Delete removes a record
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	return s.storage.Delete(ctx, key)
+}
+
+// Exists checks if a record exists
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	return s.storage.Exists(ctx, key)
+}
+
+// TryLock acquires a lock on the underlying storage
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.storage.TryLock(ctx, key, ttl)
+}
+
+// Unlock releases a lock on the underlying storage
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	return s.storage.Unlock(ctx, key)
+}
+
+// SetCodec sets the codec of the underlying storage if it serializes records
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	if setter, ok := s.storage.(idempotency.CodecSetter); ok {
+		setter.SetCodec(codec)
+	}
+}
+
+// SetClock sets the clock of the underlying storage if it computes expirations
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	if setter, ok := s.storage.(idempotency.ClockSetter); ok {
+		setter.SetClock(clock)
+	}
+}
+
+// Close closes the underlying storage
+func (s *Storage) Close() error {
+	return s.storage.Close()
+}
+
+// encrypt seals body with the current key, authenticating the storage key of the record
+func (s *Storage) encrypt(ctx context.Context, recordKey string, body []byte) ([]byte, error) {
+	id, key, err := s.keys.CurrentKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("encrypted: key ID %q is longer than 255 bytes", id)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(magic)+1+len(id)+aead.NonceSize()+len(body)+aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, body, []byte(recordKey)), nil
+}
+
+// decrypt opens a body sealed by encrypt
+func (s *Storage) decrypt(ctx context.Context, recordKey string, data []byte) ([]byte, error) {
+	data = data[len(magic):]
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, errors.New("encrypted: malformed body")
+	}
+	id := string(data[1 : 1+data[0]])
+	data = data[1+len(id):]
+
+	key, err := s.keys.Key(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted: malformed body")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(recordKey))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,149 @@
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+// copyingStorage serves the record stored at from when to is read, as a raw copy
+// between keys would
+type copyingStorage struct {
+	*memory.Storage
+	from, to string
+}
+
+func (c *copyingStorage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	if key == c.to {
+		key = c.from
+	}
+	return c.Storage.Get(ctx, key)
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	ctx := context.Background()
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+	body := []byte(`{"card":"4242424242424242"}`)
+	record := func(key string) *idempotency.Record {
+		return &idempotency.Record{
+			Key:      key,
+			Status:   idempotency.StatusCompleted,
+			Response: &idempotency.CachedResponse{StatusCode: 201, Body: body},
+		}
+	}
+
+	backend := memory.NewMemoryStorage()
+	defer backend.Close()
+	keys, err := StaticKey("k1", key1)
+	if err != nil {
+		t.Fatalf("StaticKey failed: %v", err)
+	}
+	store := New(backend, keys)
+
+	t.Run("EncryptsAtRest", func(t *testing.T) {
+		r := record("order-1")
+		if err := store.Set(ctx, r, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if !bytes.Equal(r.Response.Body, body) {
+			t.Error("expected the record given to Set to be left untouched")
+		}
+
+		stored, _ := backend.Get(ctx, "order-1")
+		if bytes.Contains(stored.Response.Body, []byte("4242")) {
+			t.Errorf("expected the stored body to be encrypted, got %q", stored.Response.Body)
+		}
+
+		got, err := store.Get(ctx, "order-1")
+		if err != nil || got == nil || !bytes.Equal(got.Response.Body, body) {
+			t.Fatalf("expected the decrypted body, got %v, %v", got, err)
+		}
+	})
+
+	t.Run("BoundToRecordKey", func(t *testing.T) {
+		stored, _ := backend.Get(ctx, "order-1")
+		moved := *stored
+		moved.Key = "order-2"
+		_ = backend.Set(ctx, &moved, time.Hour)
+		if _, err := store.Get(ctx, "order-2"); err == nil {
+			t.Error("expected a body copied to another record not to decrypt")
+		}
+	})
+
+	t.Run("BoundToStorageKey", func(t *testing.T) {
+		// The whole record, Key included, is copied to another storage key
+		copied := New(&copyingStorage{Storage: backend, from: "order-1", to: "order-9"}, keys)
+		if _, err := copied.Get(ctx, "order-9"); err == nil {
+			t.Error("expected a record copied to another key not to decrypt")
+		}
+		if got, err := copied.Get(ctx, "order-1"); err != nil || !bytes.Equal(got.Response.Body, body) {
+			t.Errorf("expected the record at its own key to decrypt, got %v", err)
+		}
+	})
+
+	t.Run("KeyRotation", func(t *testing.T) {
+		rotated, _ := StaticKey("k2", key2, map[string][]byte{"k1": key1})
+		rotatedStore := New(backend, rotated)
+		if got, err := rotatedStore.Get(ctx, "order-1"); err != nil || !bytes.Equal(got.Response.Body, body) {
+			t.Fatalf("expected records of the previous key to decrypt, got %v", err)
+		}
+
+		_ = rotatedStore.Set(ctx, record("order-3"), time.Hour)
+		if _, err := store.Get(ctx, "order-3"); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("expected the new key to be unknown to the old provider, got %v", err)
+		}
+	})
+
+	t.Run("PlaintextRecords", func(t *testing.T) {
+		_ = backend.Set(ctx, record("legacy"), time.Hour)
+		if got, err := store.Get(ctx, "legacy"); err != nil || !bytes.Equal(got.Response.Body, body) {
+			t.Errorf("expected records stored before encryption to be read as is, got %v", err)
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		if _, err := StaticKey("bad", []byte("short")); err == nil {
+			t.Error("expected an invalid AES key to be rejected")
+		}
+	})
+}
+
+func TestEncryptedStorage_Extensions(t *testing.T) {
+	ctx := context.Background()
+	backend := memory.NewMemoryStorage()
+	defer backend.Close()
+	keys, _ := StaticKey("k1", bytes.Repeat([]byte{1}, 32))
+	store := New(backend, keys)
+
+	var s idempotency.Storage = store
+	if !idempotency.Supports[idempotency.GetOrLocker](s) || !idempotency.Supports[idempotency.LockExtender](s) ||
+		!idempotency.Supports[idempotency.RecordLister](s) || idempotency.Supports[idempotency.SetUnlocker](s) {
+		t.Error("expected the extensions of the wrapped storage, and only them, to be supported")
+	}
+
+	body := []byte(`{"card":"4242424242424242"}`)
+	m, err := idempotency.NewManager(idempotency.Config{Storage: store, LockRenewalInterval: time.Second})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	for range 2 {
+		resp, err := m.Execute(ctx, "order-1", func(ctx context.Context) (*idempotency.Response, error) {
+			return &idempotency.Response{StatusCode: 201, Body: body}, nil
+		})
+		if err != nil || !bytes.Equal(resp.Body, body) {
+			t.Fatalf("expected the response to be stored and replayed, got %v", err)
+		}
+	}
+	if stored, _ := backend.Get(ctx, "order-1"); bytes.Contains(stored.Response.Body, []byte("4242")) {
+		t.Error("expected the stored body to be encrypted")
+	}
+	if records, err := store.List(ctx); err != nil || len(records) != 1 || !bytes.Equal(records[0].Response.Body, body) {
+		t.Errorf("expected listed records to be decrypted, got %v", err)
+	}
+}
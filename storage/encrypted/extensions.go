@@ -0,0 +1,132 @@
+package encrypted
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// The optional extensions are forwarded to the wrapped storage, encrypting and
+// decrypting the records they carry. The manager only uses those the wrapped storage
+// implements (see idempotency.StorageWrapper).
+
+// Unwrap returns the wrapped storage. It implements idempotency.StorageWrapper.
+func (s *Storage) Unwrap() idempotency.Storage {
+	return s.storage
+}
+
+// TryLockAndSet acquires the lock and stores the pending record, encrypted. It
+// implements idempotency.LockSetter.
+func (s *Storage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	setter, ok := s.storage.(idempotency.LockSetter)
+	if !ok {
+		return false, unsupported("trylockandset")
+	}
+	encrypted, err := s.encryptRecord(ctx, record)
+	if err != nil {
+		return false, err
+	}
+	return setter.TryLockAndSet(ctx, encrypted, ttl, lockTTL)
+}
+
+// SetAndUnlock stores the completed record, encrypted, and releases its lock. It
+// implements idempotency.SetUnlocker.
+func (s *Storage) SetAndUnlock(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	unlocker, ok := s.storage.(idempotency.SetUnlocker)
+	if !ok {
+		return unsupported("setandunlock")
+	}
+	encrypted, err := s.encryptRecord(ctx, record)
+	if err != nil {
+		return err
+	}
+	return unlocker.SetAndUnlock(ctx, encrypted, ttl)
+}
+
+// GetOrLock returns the decrypted record for key, or acquires the lock and stores the
+// pending record, encrypted. It implements idempotency.GetOrLocker.
+func (s *Storage) GetOrLock(ctx context.Context, key string, record *idempotency.Record, ttl, lockTTL time.Duration) (*idempotency.Record, bool, error) {
+	locker, ok := s.storage.(idempotency.GetOrLocker)
+	if !ok {
+		return nil, false, unsupported("getorlock")
+	}
+	encrypted, err := s.encryptRecord(ctx, record)
+	if err != nil {
+		return nil, false, err
+	}
+	existing, locked, err := locker.GetOrLock(ctx, key, encrypted, ttl, lockTTL)
+	if err != nil {
+		return nil, false, err
+	}
+	existing, err = s.decryptRecord(ctx, key, existing)
+	return existing, locked, err
+}
+
+// ExtendLock renews a lock on the wrapped storage. It implements
+// idempotency.LockExtender.
+func (s *Storage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	extender, ok := s.storage.(idempotency.LockExtender)
+	if !ok {
+		return false, unsupported("extendlock")
+	}
+	return extender.ExtendLock(ctx, key, ttl)
+}
+
+// LockTTL returns the remaining time-to-live of a lock on the wrapped storage. It
+// implements idempotency.LockTTLReader.
+func (s *Storage) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	reader, ok := s.storage.(idempotency.LockTTLReader)
+	if !ok {
+		return 0, unsupported("lockttl")
+	}
+	return reader.LockTTL(ctx, key)
+}
+
+// WaitForCompletion waits for a record on the wrapped storage. It implements
+// idempotency.CompletionWaiter.
+func (s *Storage) WaitForCompletion(ctx context.Context, key string) error {
+	waiter, ok := s.storage.(idempotency.CompletionWaiter)
+	if !ok {
+		return unsupported("waitforcompletion")
+	}
+	return waiter.WaitForCompletion(ctx, key)
+}
+
+// List returns the records of the wrapped storage, decrypted. It implements
+// idempotency.RecordLister.
+func (s *Storage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	lister, ok := s.storage.(idempotency.RecordLister)
+	if !ok {
+		return nil, idempotency.ErrListingNotSupported
+	}
+	records, err := lister.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		if records[i], err = s.decryptRecord(ctx, record.Key, record); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// OnExpired registers fn with the wrapped storage, decrypting the expired records when
+// possible. It implements idempotency.ExpiryNotifier.
+func (s *Storage) OnExpired(fn func(key string, record *idempotency.Record)) {
+	if notifier, ok := s.storage.(idempotency.ExpiryNotifier); ok {
+		notifier.OnExpired(func(key string, record *idempotency.Record) {
+			if decrypted, err := s.decryptRecord(context.Background(), key, record); err == nil {
+				record = decrypted
+			}
+			fn(key, record)
+		})
+	}
+}
+
+// unsupported is returned by the extensions the wrapped storage does not implement
+func unsupported(op string) error {
+	return idempotency.NewStorageError(op, errors.ErrUnsupported)
+}
@@ -0,0 +1,334 @@
+// Package foundationdb provides a FoundationDB storage backend for gopotency.
+//
+// Every operation runs inside a FoundationDB transaction, so lock acquisition and
+// record updates are strictly serializable, which suits financial workloads. The lock
+// and the pending record are created, and the completed record stored and unlocked, in
+// single transactions (idempotency.LockSetter, GetOrLocker and SetUnlocker).
+//
+// The official bindings require cgo and the FoundationDB client library, so this
+// package depends on a minimal Database interface instead. Adapting fdb.Database
+// takes a few lines:
+//
+//	type fdbDatabase struct{ db fdb.Database }
+//
+//	func (d fdbDatabase) Transact(f func(foundationdb.Transaction) (any, error)) (any, error) {
+//		return d.db.Transact(func(tr fdb.Transaction) (any, error) { return f(fdbTransaction{tr}) })
+//	}
+//
+//	type fdbTransaction struct{ tr fdb.Transaction }
+//
+//	func (t fdbTransaction) Get(key []byte) ([]byte, error) { return t.tr.Get(fdb.Key(key)).Get() }
+//	func (t fdbTransaction) Set(key, value []byte)          { t.tr.Set(fdb.Key(key), value) }
+//	func (t fdbTransaction) Clear(key []byte)               { t.tr.Clear(fdb.Key(key)) }
+//
+//	store := foundationdb.NewFoundationDBStorage(fdbDatabase{fdb.MustOpenDefault()}, "idempotency")
+package foundationdb
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Transaction is the subset of a FoundationDB transaction used by the storage
+type Transaction interface {
+	// Get returns the value of key, or nil if it does not exist
+	Get(key []byte) ([]byte, error)
+
+	// Set writes value at key
+	Set(key, value []byte)
+
+	// Clear removes key
+	Clear(key []byte)
+}
+
+// Database runs functions inside retried FoundationDB transactions
+type Database interface {
+	Transact(func(tx Transaction) (any, error)) (any, error)
+}
+
+// entry is the value stored for records and locks.
+// FoundationDB has no native TTL, so expiration is tracked alongside the data.
+type entry struct {
+	ExpiresAt time.Time
+	Data      []byte `json:",omitempty"`
+}
+
+// Storage is a FoundationDB implementation of idempotency.Storage
+type Storage struct {
+	db     Database
+	prefix string
+	codec  idempotency.Codec
+	clock  idempotency.Clock
+}
+
+// NewFoundationDBStorage creates a new FoundationDB storage instance.
+// All keys are written under the given prefix (default: "idempotency").
+func NewFoundationDBStorage(db Database, prefix string) *Storage {
+	if prefix == "" {
+		prefix = "idempotency"
+	}
+	return &Storage{
+		db:     db,
+		prefix: prefix,
+		codec:  idempotency.JSONCodec,
+		clock:  idempotency.SystemClock,
+	}
+}
+
+// SetCodec replaces the codec used to serialize records (default idempotency.JSONCodec).
+// It implements idempotency.CodecSetter and must be called before the storage is used.
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	s.codec = codec
+}
+
+// SetClock replaces the clock deciding expirations (default idempotency.SystemClock).
+// It implements idempotency.ClockSetter and must be called before the storage is used.
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	s.clock = clock
+}
+
+func (s *Storage) recordKey(key string) []byte {
+	return []byte(s.prefix + "/record/" + key)
+}
+
+func (s *Storage) lockKey(key string) []byte {
+	return []byte(s.prefix + "/lock/" + key)
+}
+
+// readEntry decodes the entry at key, returning nil if missing or expired
+func readEntry(tx Transaction, key []byte, now time.Time) (*entry, error) {
+	val, err := tx.Get(key)
+	if err != nil || val == nil {
+		return nil, err
+	}
+
+	var e entry
+	if err := json.Unmarshal(val, &e); err != nil {
+		return nil, err
+	}
+
+	if now.After(e.ExpiresAt) {
+		tx.Clear(key)
+		return nil, nil
+	}
+
+	return &e, nil
+}
+
+// getRecord decodes the record stored for key, returning nil if missing or expired
+func (s *Storage) getRecord(tx Transaction, key string, now time.Time) (*idempotency.Record, error) {
+	e, err := readEntry(tx, s.recordKey(key), now)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	return s.codec.Unmarshal(e.Data)
+}
+
+// setRecord writes record with ttl
+func (s *Storage) setRecord(tx Transaction, record *idempotency.Record, ttl time.Duration, now time.Time) error {
+	data, err := s.codec.Marshal(record)
+	if err != nil {
+		return err
+	}
+	val, err := json.Marshal(entry{ExpiresAt: now.Add(ttl), Data: data})
+	if err != nil {
+		return err
+	}
+	tx.Set(s.recordKey(record.Key), val)
+	return nil
+}
+
+// tryLock writes the lock of key with ttl unless a live one is held
+func (s *Storage) tryLock(tx Transaction, key string, ttl time.Duration, now time.Time) (bool, error) {
+	lock, err := readEntry(tx, s.lockKey(key), now)
+	if err != nil || lock != nil {
+		return false, err
+	}
+	val, err := json.Marshal(entry{ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	tx.Set(s.lockKey(key), val)
+	return true, nil
+}
+
+// Get retrieves an idempotency record by key
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, idempotency.NewStorageError("get", err)
+	}
+
+	res, err := s.db.Transact(func(tx Transaction) (any, error) {
+		return s.getRecord(tx, key, s.clock.Now())
+	})
+	if err != nil {
+		return nil, idempotency.NewStorageError("get", err)
+	}
+
+	return res.(*idempotency.Record), nil
+}
+
+// Set stores an idempotency record
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return idempotency.NewStorageError("set", err)
+	}
+
+	_, err := s.db.Transact(func(tx Transaction) (any, error) {
+		return nil, s.setRecord(tx, record, ttl, s.clock.Now())
+	})
+	if err != nil {
+		return idempotency.NewStorageError("set", err)
+	}
+
+	return nil
+}
+
+// Delete removes an idempotency record and its lock in a single transaction
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return idempotency.NewStorageError("delete", err)
+	}
+
+	_, err := s.db.Transact(func(tx Transaction) (any, error) {
+		tx.Clear(s.recordKey(key))
+		tx.Clear(s.lockKey(key))
+		return nil, nil
+	})
+	if err != nil {
+		return idempotency.NewStorageError("delete", err)
+	}
+
+	return nil
+}
+
+// Exists checks if a record exists and is not expired
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, idempotency.NewStorageError("exists", err)
+	}
+
+	res, err := s.db.Transact(func(tx Transaction) (any, error) {
+		return readEntry(tx, s.recordKey(key), s.clock.Now())
+	})
+	if err != nil {
+		return false, idempotency.NewStorageError("exists", err)
+	}
+
+	return res.(*entry) != nil, nil
+}
+
+// TryLock attempts to acquire a lock for the given key.
+// The read and the write happen in the same transaction, so two concurrent callers
+// can never both acquire the lock: FoundationDB aborts and retries the loser.
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+
+	res, err := s.db.Transact(func(tx Transaction) (any, error) {
+		return s.tryLock(tx, key, ttl, s.clock.Now())
+	})
+	if err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+
+	return res.(bool), nil
+}
+
+// TryLockAndSet acquires the lock and stores the pending record in a single
+// transaction, so a crash never leaves a lock without its record. It implements
+// idempotency.LockSetter.
+func (s *Storage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+
+	res, err := s.db.Transact(func(tx Transaction) (any, error) {
+		now := s.clock.Now()
+		locked, err := s.tryLock(tx, record.Key, lockTTL, now)
+		if err != nil || !locked {
+			return false, err
+		}
+		return true, s.setRecord(tx, record, ttl, now)
+	})
+	if err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+
+	return res.(bool), nil
+}
+
+// GetOrLock returns the non-expired record for key if there is one, otherwise acquires
+// the lock and stores record, in a single transaction. It implements
+// idempotency.GetOrLocker.
+func (s *Storage) GetOrLock(ctx context.Context, key string, record *idempotency.Record, ttl, lockTTL time.Duration) (*idempotency.Record, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, idempotency.NewStorageError("getorlock", err)
+	}
+
+	var existing *idempotency.Record
+	res, err := s.db.Transact(func(tx Transaction) (any, error) {
+		now := s.clock.Now()
+		var err error
+		if existing, err = s.getRecord(tx, key, now); err != nil || existing != nil {
+			return false, err
+		}
+		locked, err := s.tryLock(tx, key, lockTTL, now)
+		if err != nil || !locked {
+			return false, err
+		}
+		return true, s.setRecord(tx, record, ttl, now)
+	})
+	if err != nil {
+		return nil, false, idempotency.NewStorageError("getorlock", err)
+	}
+
+	return existing, res.(bool), nil
+}
+
+// SetAndUnlock stores the completed record and releases its lock in a single
+// transaction. It implements idempotency.SetUnlocker.
+func (s *Storage) SetAndUnlock(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return idempotency.NewStorageError("setandunlock", err)
+	}
+
+	_, err := s.db.Transact(func(tx Transaction) (any, error) {
+		if err := s.setRecord(tx, record, ttl, s.clock.Now()); err != nil {
+			return nil, err
+		}
+		tx.Clear(s.lockKey(record.Key))
+		return nil, nil
+	})
+	if err != nil {
+		return idempotency.NewStorageError("setandunlock", err)
+	}
+
+	return nil
+}
+
+// Unlock releases a lock
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return idempotency.NewStorageError("unlock", err)
+	}
+
+	_, err := s.db.Transact(func(tx Transaction) (any, error) {
+		tx.Clear(s.lockKey(key))
+		return nil, nil
+	})
+	if err != nil {
+		return idempotency.NewStorageError("unlock", err)
+	}
+
+	return nil
+}
+
+// Close is a no-op as the user manages the database handle
+func (s *Storage) Close() error {
+	return nil
+}
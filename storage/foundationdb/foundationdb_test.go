@@ -0,0 +1,161 @@
+package foundationdb
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// fakeDatabase is a serializable in-memory stand-in for FoundationDB
+type fakeDatabase struct {
+	mu           sync.Mutex
+	data         map[string][]byte
+	transactions int
+}
+
+func (d *fakeDatabase) Transact(f func(tx Transaction) (any, error)) (any, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.transactions++
+	return f(d)
+}
+
+func (d *fakeDatabase) Get(key []byte) ([]byte, error) { return d.data[string(key)], nil }
+func (d *fakeDatabase) Set(key, value []byte)          { d.data[string(key)] = value }
+func (d *fakeDatabase) Clear(key []byte)               { delete(d.data, string(key)) }
+
+func TestFoundationDBStorage_CompleteFlow(t *testing.T) {
+	db := &fakeDatabase{data: make(map[string][]byte)}
+	storage := NewFoundationDBStorage(db, "")
+	ctx := context.Background()
+
+	key := "test-fdb-key"
+	record := &idempotency.Record{
+		Key:       key,
+		Status:    idempotency.StatusCompleted,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	t.Run("SaveAndGetRecord", func(t *testing.T) {
+		if err := storage.Set(ctx, record, time.Hour); err != nil {
+			t.Fatalf("Set operation failed: %v", err)
+		}
+
+		got, err := storage.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get operation failed: %v", err)
+		}
+		if got == nil || got.Key != key || got.Status != idempotency.StatusCompleted {
+			t.Errorf("unexpected record: %+v", got)
+		}
+
+		exists, _ := storage.Exists(ctx, key)
+		if !exists {
+			t.Error("expected record to exist")
+		}
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		_ = storage.Set(ctx, &idempotency.Record{Key: "expired"}, -time.Second)
+		got, err := storage.Get(ctx, "expired")
+		if err != nil || got != nil {
+			t.Errorf("expected expired record to be missing, got %+v, %v", got, err)
+		}
+		if _, ok := db.data["idempotency/record/expired"]; ok {
+			t.Error("expected expired record to be cleared")
+		}
+	})
+
+	t.Run("Locks", func(t *testing.T) {
+		locked, err := storage.TryLock(ctx, key, time.Minute)
+		if err != nil || !locked {
+			t.Fatalf("expected lock to be acquired, got %v, %v", locked, err)
+		}
+
+		lockedAgain, _ := storage.TryLock(ctx, key, time.Minute)
+		if lockedAgain {
+			t.Error("lock should be already held")
+		}
+
+		if err := storage.Unlock(ctx, key); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+
+		lockedPostUnlock, _ := storage.TryLock(ctx, key, -time.Second)
+		if !lockedPostUnlock {
+			t.Error("should have re-acquired the lock after unlocking")
+		}
+
+		lockedAfterExpiry, _ := storage.TryLock(ctx, key, time.Minute)
+		if !lockedAfterExpiry {
+			t.Error("should have acquired an expired lock")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := storage.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if len(db.data) != 0 {
+			t.Errorf("expected record and lock to be removed, got %d keys", len(db.data))
+		}
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := storage.Get(canceled, key); err == nil {
+			t.Error("expected error for canceled context")
+		}
+	})
+
+	if err := storage.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestFoundationDBStorage_AtomicExtensions(t *testing.T) {
+	db := &fakeDatabase{data: make(map[string][]byte)}
+	storage := NewFoundationDBStorage(db, "")
+	ctx := context.Background()
+
+	var s idempotency.Storage = storage
+	if !idempotency.Supports[idempotency.LockSetter](s) || !idempotency.Supports[idempotency.GetOrLocker](s) ||
+		!idempotency.Supports[idempotency.SetUnlocker](s) {
+		t.Fatal("expected the atomic extensions to be supported")
+	}
+
+	pending := &idempotency.Record{Key: "k1", Status: idempotency.StatusPending}
+	db.transactions = 0
+	if locked, err := storage.TryLockAndSet(ctx, pending, time.Hour, time.Minute); err != nil || !locked {
+		t.Fatalf("expected the lock to be acquired, got %v, %v", locked, err)
+	}
+	if db.transactions != 1 || db.data["idempotency/lock/k1"] == nil || db.data["idempotency/record/k1"] == nil {
+		t.Errorf("expected the lock and the record to be written in one transaction, got %d", db.transactions)
+	}
+	if locked, _ := storage.TryLockAndSet(ctx, &idempotency.Record{Key: "k1"}, time.Hour, time.Minute); locked {
+		t.Error("expected the held lock not to be acquired again")
+	}
+
+	completed := &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}
+	if err := storage.SetAndUnlock(ctx, completed, time.Hour); err != nil {
+		t.Fatalf("SetAndUnlock failed: %v", err)
+	}
+	if db.data["idempotency/lock/k1"] != nil {
+		t.Error("expected the lock to be released")
+	}
+
+	existing, locked, err := storage.GetOrLock(ctx, "k1", pending, time.Hour, time.Minute)
+	if err != nil || locked || existing == nil || existing.Status != idempotency.StatusCompleted {
+		t.Fatalf("expected the completed record, got %+v, %v, %v", existing, locked, err)
+	}
+	db.transactions = 0
+	existing, locked, err = storage.GetOrLock(ctx, "k2", &idempotency.Record{Key: "k2"}, time.Hour, time.Minute)
+	if err != nil || !locked || existing != nil || db.transactions != 1 || db.data["idempotency/record/k2"] == nil {
+		t.Errorf("expected the lock and the record to be created in one transaction, got %v, %v", locked, err)
+	}
+}
@@ -0,0 +1,68 @@
+package gorm
+
+import (
+	"context"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"gorm.io/gorm"
+)
+
+// Cleanup deletes expired records and locks, in batches of Options.CleanupBatchSize
+// rows so a large backlog does not hold long table locks, and returns the number of
+// rows deleted. The janitor started by Options.CleanupInterval calls it periodically.
+// Unlike expired records deleted when read, records deleted by Cleanup are not reported
+// to OnExpired.
+func (s *Storage) Cleanup(ctx context.Context) (records, locks int64, err error) {
+	now := s.clock.Now()
+	db := s.db.WithContext(ctx)
+	if records, err = s.deleteExpired(func() *gorm.DB { return s.records(db) }, &IdempotencyRecord{}, now); err != nil {
+		return records, 0, err
+	}
+	locks, err = s.deleteExpired(func() *gorm.DB { return s.locks(db) }, &IdempotencyLock{}, now)
+	return records, locks, err
+}
+
+// deleteExpired deletes the rows of model expired at now, in the table bound by table,
+// batch by batch: the keys of a batch are selected, then deleted, which every dialect
+// supports
+func (s *Storage) deleteExpired(table func() *gorm.DB, model any, now time.Time) (int64, error) {
+	var deleted int64
+	for {
+		var keys []string
+		err := table().Model(model).Where("expires_at < ?", now).Limit(s.cleanupBatchSize).Pluck("key", &keys).Error
+		if err != nil {
+			return deleted, idempotency.NewStorageError("cleanup", err)
+		}
+		if len(keys) == 0 {
+			return deleted, nil
+		}
+
+		res := table().Where("key IN ? AND expires_at < ?", keys, now).Delete(model)
+		if res.Error != nil {
+			return deleted, idempotency.NewStorageError("cleanup", res.Error)
+		}
+		deleted += res.RowsAffected
+		if len(keys) < s.cleanupBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// janitor runs Cleanup every interval until ctx is canceled by Close
+func (s *Storage) janitor(ctx context.Context, interval time.Duration, onCleanup func(records, locks int64, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			records, locks, err := s.Cleanup(ctx)
+			if onCleanup != nil && ctx.Err() == nil {
+				onCleanup(records, locks, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -5,7 +5,8 @@ package gorm
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	idempotency "github.com/fco-gt/gopotency"
@@ -28,21 +29,121 @@ type IdempotencyLock struct {
 
 // Storage is a GORM implementation of idempotency.Storage.
 type Storage struct {
-	db *gorm.DB
+	db           *gorm.DB
+	recordsTable string
+	locksTable   string
+	codec        idempotency.Codec
+	clock        idempotency.Clock
+	onExpired    func(key string, record *idempotency.Record)
+
+	cleanupBatchSize int
+
+	// stopJanitor stops the janitor started by Options.CleanupInterval
+	stopJanitor context.CancelFunc
+}
+
+// Options configures the GORM storage.
+type Options struct {
+	// RecordsTable overrides the records table name.
+	// Default: GORM naming of IdempotencyRecord ("idempotency_records")
+	RecordsTable string
+
+	// LocksTable overrides the locks table name.
+	// Default: GORM naming of IdempotencyLock ("idempotency_locks")
+	LocksTable string
+
+	// TablePrefix is prepended to the default table names when they are not overridden.
+	TablePrefix string
+
+	// CleanupInterval starts a background janitor deleting expired records and locks at
+	// this interval, stopped by Close. Without it, expired rows are only deleted when
+	// read again (optional)
+	CleanupInterval time.Duration
+
+	// CleanupBatchSize is the maximum number of rows deleted by each statement of Cleanup
+	// Default: 1000
+	CleanupBatchSize int
+
+	// OnCleanup is called after every run of the janitor with the number of expired
+	// records and locks deleted, e.g. to export them as metrics (optional)
+	OnCleanup func(records, locks int64, err error)
 }
 
 // NewGormStorage creates a new GORM storage instance.
 // It is recommended to run db.AutoMigrate(&IdempotencyRecord{}, &IdempotencyLock{}) before use.
 func NewGormStorage(db *gorm.DB) *Storage {
-	return &Storage{
-		db: db,
+	return NewGormStorageWithOptions(db, Options{})
+}
+
+// NewGormStorageWithOptions creates a new GORM storage instance with custom table names.
+// Migrate custom tables with db.Table(name).AutoMigrate(&IdempotencyRecord{}) and
+// db.Table(name).AutoMigrate(&IdempotencyLock{}).
+func NewGormStorageWithOptions(db *gorm.DB, opts Options) *Storage {
+	if opts.TablePrefix != "" {
+		if opts.RecordsTable == "" {
+			opts.RecordsTable = opts.TablePrefix + "idempotency_records"
+		}
+		if opts.LocksTable == "" {
+			opts.LocksTable = opts.TablePrefix + "idempotency_locks"
+		}
+	}
+
+	if opts.CleanupBatchSize <= 0 {
+		opts.CleanupBatchSize = 1000
+	}
+
+	s := &Storage{
+		db:               db,
+		recordsTable:     opts.RecordsTable,
+		locksTable:       opts.LocksTable,
+		codec:            idempotency.JSONCodec,
+		clock:            idempotency.SystemClock,
+		cleanupBatchSize: opts.CleanupBatchSize,
 	}
+
+	if opts.CleanupInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopJanitor = cancel
+		go s.janitor(ctx, opts.CleanupInterval, opts.OnCleanup)
+	}
+	return s
+}
+
+// SetCodec replaces the codec used to serialize records (default idempotency.JSONCodec).
+// It implements idempotency.CodecSetter and must be called before the storage is used.
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	s.codec = codec
+}
+
+// SetClock replaces the clock deciding expirations (default idempotency.SystemClock).
+// It implements idempotency.ClockSetter and must be called before the storage is used.
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	s.clock = clock
+}
+
+// records binds db (the storage connection or a transaction) to the records table.
+func (s *Storage) records(db *gorm.DB) *gorm.DB {
+	if s.recordsTable != "" {
+		return db.Table(s.recordsTable)
+	}
+	return db
+}
+
+// locks binds db (the storage connection or a transaction) to the locks table.
+func (s *Storage) locks(db *gorm.DB) *gorm.DB {
+	if s.locksTable != "" {
+		return db.Table(s.locksTable)
+	}
+	return db
 }
 
+// errLockHeld rolls back TryLockAndSet when the lock is held elsewhere.
+var errLockHeld = errors.New("lock held")
+
 // Get retrieves an idempotency record by key.
 func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
 	var record IdempotencyRecord
-	result := s.db.WithContext(ctx).First(&record, "key = ?", key)
+	result := s.records(s.db.WithContext(ctx)).First(&record, "key = ?", key)
 
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
@@ -51,23 +152,48 @@ func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, err
 		return nil, idempotency.NewStorageError("get", result.Error)
 	}
 
+	r, unmarshalErr := s.codec.Unmarshal(record.Data)
+
 	// Check expiration
-	if time.Now().After(record.ExpiresAt) {
+	if s.clock.Now().After(record.ExpiresAt) {
 		_ = s.Delete(ctx, key)
+		if s.onExpired != nil && unmarshalErr == nil {
+			s.onExpired(key, r)
+		}
 		return nil, nil
 	}
 
-	var r idempotency.Record
-	if err := json.Unmarshal(record.Data, &r); err != nil {
-		return nil, idempotency.NewStorageError("unmarshal", err)
+	if unmarshalErr != nil {
+		return nil, idempotency.NewStorageError("unmarshal", unmarshalErr)
 	}
 
-	return &r, nil
+	return r, nil
+}
+
+// OnExpired registers fn, called with expired records when they are deleted.
+// Expired rows are removed lazily by Get. It implements idempotency.ExpiryNotifier
+// and must be called before the storage is used.
+func (s *Storage) OnExpired(fn func(key string, record *idempotency.Record)) {
+	s.onExpired = fn
 }
 
 // Set stores an idempotency record.
 func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
-	data, err := json.Marshal(record)
+	return s.set(s.db.WithContext(ctx), record, ttl)
+}
+
+// SetTx stores a record in tx, the transaction of the domain changes, so both commit
+// atomically (see idempotency.Token.Record). The record expires at its ExpiresAt.
+func (s *Storage) SetTx(ctx context.Context, tx *gorm.DB, record *idempotency.Record) error {
+	ttl := record.ExpiresAt.Sub(s.clock.Now())
+	if ttl <= 0 {
+		return idempotency.NewStorageError("set", fmt.Errorf("record %q is already expired", record.Key))
+	}
+	return s.set(tx.WithContext(ctx), record, ttl)
+}
+
+func (s *Storage) set(db *gorm.DB, record *idempotency.Record, ttl time.Duration) error {
+	data, err := s.codec.Marshal(record)
 	if err != nil {
 		return idempotency.NewStorageError("marshal", err)
 	}
@@ -75,11 +201,11 @@ func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.
 	gormRecord := IdempotencyRecord{
 		Key:       record.Key,
 		Data:      data,
-		ExpiresAt: time.Now().Add(ttl),
+		ExpiresAt: s.clock.Now().Add(ttl),
 	}
 
 	// Cross-database Upsert using GORM clauses
-	err = s.db.WithContext(ctx).Clauses(clause.OnConflict{
+	err = s.records(db).Clauses(clause.OnConflict{
 		UpdateAll: true,
 	}).Create(&gormRecord).Error
 
@@ -90,20 +216,21 @@ func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.
 	return nil
 }
 
-// Delete removes an idempotency record and its associated lock.
+// Delete removes an idempotency record and its associated lock in a single transaction.
 func (s *Storage) Delete(ctx context.Context, key string) error {
-	err := s.db.WithContext(ctx).Delete(&IdempotencyRecord{}, "key = ?", key).Error
-	if err != nil {
-		return idempotency.NewStorageError("delete", err)
-	}
-	return s.Unlock(ctx, key)
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := s.records(tx).Delete(&IdempotencyRecord{}, "key = ?", key).Error; err != nil {
+			return idempotency.NewStorageError("delete", err)
+		}
+		return s.unlock(tx, key)
+	})
 }
 
 // Exists checks if a record exists and is not expired.
 func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
 	var count int64
-	err := s.db.WithContext(ctx).Model(&IdempotencyRecord{}).
-		Where("key = ? AND expires_at > ?", key, time.Now()).
+	err := s.records(s.db.WithContext(ctx)).Model(&IdempotencyRecord{}).
+		Where("key = ? AND expires_at > ?", key, s.clock.Now()).
 		Count(&count).Error
 
 	if err != nil {
@@ -113,34 +240,93 @@ func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
 	return count > 0, nil
 }
 
+// List returns all non-expired records.
+func (s *Storage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	var rows []IdempotencyRecord
+	err := s.records(s.db.WithContext(ctx)).Where("expires_at > ?", s.clock.Now()).Find(&rows).Error
+	if err != nil {
+		return nil, idempotency.NewStorageError("list", err)
+	}
+
+	records := make([]*idempotency.Record, 0, len(rows))
+	for _, row := range rows {
+		r, err := s.codec.Unmarshal(row.Data)
+		if err != nil {
+			return nil, idempotency.NewStorageError("unmarshal", err)
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
 // TryLock attempts to acquire a distributed lock.
 func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
-	now := time.Now()
+	return s.tryLock(s.db.WithContext(ctx), key, ttl), nil
+}
+
+func (s *Storage) tryLock(db *gorm.DB, key string, ttl time.Duration) bool {
+	now := s.clock.Now()
 	expiresAt := now.Add(ttl)
 
 	// Clean up expired lock first if it exists (using GORM to be cross-DB)
-	s.db.WithContext(ctx).Where("key = ? AND expires_at < ?", key, now).Delete(&IdempotencyLock{})
+	s.locks(db).Where("key = ? AND expires_at < ?", key, now).Delete(&IdempotencyLock{})
 
 	// Try to create the lock
-	err := s.db.WithContext(ctx).Create(&IdempotencyLock{
+	err := s.locks(db).Create(&IdempotencyLock{
 		Key:       key,
 		ExpiresAt: expiresAt,
 	}).Error
 
-	if err != nil {
-		// If entry already exists, lock failed
+	// If entry already exists, lock failed
+	return err == nil
+}
+
+// TryLockAndSet acquires the lock for record.Key and stores record in a single
+// transaction, so a failure cannot leave a lock without its record.
+// It implements idempotency.LockSetter.
+func (s *Storage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if !s.tryLock(tx, record.Key, lockTTL) {
+			return errLockHeld
+		}
+		return s.set(tx, record, ttl)
+	})
+	if errors.Is(err, errLockHeld) {
 		return false, nil
 	}
-
+	if err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
+// ExtendLock makes the lock held for key expire ttl from now, reporting false if it
+// expired or was released. It implements idempotency.LockExtender.
+func (s *Storage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	now := s.clock.Now()
+	res := s.locks(s.db.WithContext(ctx)).Model(&IdempotencyLock{}).
+		Where("key = ? AND expires_at > ?", key, now).
+		Update("expires_at", now.Add(ttl))
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
 // Unlock releases a lock.
 func (s *Storage) Unlock(ctx context.Context, key string) error {
-	return s.db.WithContext(ctx).Delete(&IdempotencyLock{}, "key = ?", key).Error
+	return s.unlock(s.db.WithContext(ctx), key)
+}
+
+func (s *Storage) unlock(db *gorm.DB, key string) error {
+	return s.locks(db).Delete(&IdempotencyLock{}, "key = ?", key).Error
 }
 
-// Close is a no-op for GORM storage as the user manages the DB connection.
+// Close stops the janitor. The DB connection is managed by the user and left open.
 func (s *Storage) Close() error {
+	if s.stopJanitor != nil {
+		s.stopJanitor()
+	}
 	return nil
 }
@@ -3,6 +3,7 @@ package gorm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
@@ -90,6 +91,20 @@ func TestGormStorage_CompleteFlow(t *testing.T) {
 		}
 	})
 
+	t.Run("ExtendLock", func(t *testing.T) {
+		if held, _ := storage.ExtendLock(ctx, "extend-key", time.Hour); held {
+			t.Error("expected a lock not held not to be extended")
+		}
+		_, _ = storage.TryLock(ctx, "extend-key", 50*time.Millisecond)
+		if held, err := storage.ExtendLock(ctx, "extend-key", time.Hour); err != nil || !held {
+			t.Fatalf("expected the lock to be extended, got %v, %v", held, err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if locked, _ := storage.TryLock(ctx, "extend-key", time.Hour); locked {
+			t.Error("expected the extended lock to be held")
+		}
+	})
+
 	// Sub-test: Exists check
 	t.Run("Exists", func(t *testing.T) {
 		exists, err := storage.Exists(ctx, key)
@@ -106,6 +121,42 @@ func TestGormStorage_CompleteFlow(t *testing.T) {
 		}
 	})
 
+	// Sub-test: Listing records
+	t.Run("List", func(t *testing.T) {
+		records, err := storage.List(ctx)
+		if err != nil {
+			t.Fatalf("List operation failed: %v", err)
+		}
+		if len(records) != 1 || records[0].Key != key {
+			t.Errorf("Expected [%s], but got %v", key, records)
+		}
+	})
+
+	// Sub-test: Atomic lock and set
+	t.Run("TryLockAndSet", func(t *testing.T) {
+		pending := &idempotency.Record{Key: "atomic-key", Status: idempotency.StatusPending}
+		locked, err := storage.TryLockAndSet(ctx, pending, time.Hour, time.Minute)
+		if err != nil || !locked {
+			t.Fatalf("Expected lock and record, got %v, %v", locked, err)
+		}
+
+		other := &idempotency.Record{Key: "atomic-key", Status: idempotency.StatusCompleted}
+		locked, err = storage.TryLockAndSet(ctx, other, time.Hour, time.Minute)
+		if err != nil || locked {
+			t.Fatalf("Expected lock to be held, got %v, %v", locked, err)
+		}
+		if got, _ := storage.Get(ctx, "atomic-key"); got == nil || got.Status != idempotency.StatusPending {
+			t.Errorf("Expected pending record to be untouched, got %v", got)
+		}
+
+		if err := storage.Delete(ctx, "atomic-key"); err != nil {
+			t.Fatalf("Delete operation failed: %v", err)
+		}
+		if locked, _ := storage.TryLock(ctx, "atomic-key", time.Minute); !locked {
+			t.Error("Expected lock to be released by Delete")
+		}
+	})
+
 	// Sub-test: Deleting a record
 	t.Run("DeleteRecord", func(t *testing.T) {
 		err := storage.Delete(ctx, key)
@@ -146,6 +197,10 @@ func TestGormStorage_CompleteFlow(t *testing.T) {
 			ExpiresAt: time.Now().Add(-time.Hour),
 		})
 
+		var expired []string
+		storage.OnExpired(func(key string, r *idempotency.Record) { expired = append(expired, r.Key) })
+		defer storage.OnExpired(nil)
+
 		got, err := storage.Get(ctx, expKey)
 		if err != nil {
 			t.Errorf("Expected nil error for expired key, got: %v", err)
@@ -153,6 +208,9 @@ func TestGormStorage_CompleteFlow(t *testing.T) {
 		if got != nil {
 			t.Error("Expected nil record because it should be deleted upon get")
 		}
+		if len(expired) != 1 || expired[0] != expKey {
+			t.Errorf("Expected OnExpired for %s, got %v", expKey, expired)
+		}
 	})
 
 	t.Run("Close", func(t *testing.T) {
@@ -161,3 +219,137 @@ func TestGormStorage_CompleteFlow(t *testing.T) {
 		}
 	})
 }
+
+func TestGormStorage_CustomTables(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	storage := NewGormStorageWithOptions(db, Options{TablePrefix: "app_", LocksTable: "app_mutexes"})
+	if err := db.Table("app_idempotency_records").AutoMigrate(&IdempotencyRecord{}); err != nil {
+		t.Fatalf("Failed to migrate records table: %v", err)
+	}
+	if err := db.Table("app_mutexes").AutoMigrate(&IdempotencyLock{}); err != nil {
+		t.Fatalf("Failed to migrate locks table: %v", err)
+	}
+	ctx := context.Background()
+
+	record := &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}
+	if err := storage.Set(ctx, record, time.Hour); err != nil {
+		t.Fatalf("Set operation failed: %v", err)
+	}
+	if got, err := storage.Get(ctx, "k1"); err != nil || got == nil {
+		t.Fatalf("Expected record k1, got %v, %v", got, err)
+	}
+	if exists, _ := storage.Exists(ctx, "k1"); !exists {
+		t.Error("Expected record to exist")
+	}
+	if locked, _ := storage.TryLock(ctx, "k1", time.Minute); !locked {
+		t.Error("Expected to acquire lock")
+	}
+
+	var count int64
+	db.Table("app_mutexes").Count(&count)
+	if count != 1 {
+		t.Errorf("Expected lock in app_mutexes, got %d rows", count)
+	}
+	if db.Migrator().HasTable(&IdempotencyRecord{}) {
+		t.Error("Default records table should not be used")
+	}
+
+	if err := storage.Delete(ctx, "k1"); err != nil {
+		t.Fatalf("Delete operation failed: %v", err)
+	}
+}
+
+func TestGormStorage_SetTx(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	if err := db.AutoMigrate(&IdempotencyRecord{}, &IdempotencyLock{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	storage := NewGormStorage(db)
+	ctx := context.Background()
+	record := &idempotency.Record{
+		Key:       "tx-key",
+		Status:    idempotency.StatusCompleted,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	// Rolled back with the domain changes
+	errRollback := errors.New("rollback")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := storage.SetTx(ctx, tx, record); err != nil {
+			t.Fatalf("SetTx failed: %v", err)
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("expected rollback, got %v", err)
+	}
+	if got, _ := storage.Get(ctx, "tx-key"); got != nil {
+		t.Errorf("expected no record after rollback, got %v", got)
+	}
+
+	// Committed with the domain changes
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return storage.SetTx(ctx, tx, record)
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %v", err)
+	}
+	if got, _ := storage.Get(ctx, "tx-key"); got == nil || got.Status != idempotency.StatusCompleted {
+		t.Errorf("expected completed record after commit, got %v", got)
+	}
+}
+
+func TestGormStorage_Cleanup(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1) // a single in-memory database
+	if err := db.AutoMigrate(&IdempotencyRecord{}, &IdempotencyLock{}); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	type result struct{ records, locks int64 }
+	purged := make(chan result, 10)
+	storage := NewGormStorageWithOptions(db, Options{
+		CleanupInterval:  20 * time.Millisecond,
+		CleanupBatchSize: 2,
+		OnCleanup: func(records, locks int64, err error) {
+			if err == nil && records+locks > 0 {
+				purged <- result{records, locks}
+			}
+		},
+	})
+	defer storage.Close()
+	ctx := context.Background()
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		_ = storage.Set(ctx, &idempotency.Record{Key: key, Status: idempotency.StatusCompleted}, time.Millisecond)
+	}
+	_, _ = storage.TryLock(ctx, "k1", time.Millisecond)
+	_ = storage.Set(ctx, &idempotency.Record{Key: "live", Status: idempotency.StatusCompleted}, time.Hour)
+
+	select {
+	case got := <-purged:
+		if got.records != 3 || got.locks != 1 {
+			t.Errorf("expected 3 records and 1 lock to be purged, got %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the janitor to purge expired rows")
+	}
+
+	var remaining int64
+	db.Model(&IdempotencyRecord{}).Count(&remaining)
+	if remaining != 1 {
+		t.Errorf("expected only the live record to remain, got %d rows", remaining)
+	}
+}
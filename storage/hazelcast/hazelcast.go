@@ -0,0 +1,138 @@
+// Package hazelcast provides a Hazelcast (IMDG) storage backend for gopotency.
+//
+// Records are stored in a distributed map with a per-entry TTL and locks are
+// acquired with PutIfAbsent, so no Redis is required for multi-instance setups.
+// Records live under "record:"+key and locks under lockKey(key), so no idempotency key
+// can address the lock of another.
+//
+// The storage depends on the Map interface, which *hazelcast.Map from
+// github.com/hazelcast/hazelcast-go-client satisfies directly:
+//
+//	client, _ := hazelcast.StartNewClient(ctx)
+//	m, _ := client.GetMap(ctx, "idempotency")
+//	store := hazelcaststorage.NewHazelcastStorage(m)
+package hazelcast
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Map is the subset of the Hazelcast distributed map API used by the storage
+type Map interface {
+	Get(ctx context.Context, key any) (any, error)
+	SetWithTTL(ctx context.Context, key, value any, ttl time.Duration) error
+	PutIfAbsentWithTTL(ctx context.Context, key, value any, ttl time.Duration) (any, error)
+	Delete(ctx context.Context, key any) error
+	ContainsKey(ctx context.Context, key any) (bool, error)
+}
+
+// HazelcastStorage implements the idempotency.Storage interface using a Hazelcast map.
+// Records are serialized with the codec (JSON by default) and stored as byte slices.
+type HazelcastStorage struct {
+	m     Map
+	codec idempotency.Codec
+}
+
+// NewHazelcastStorage creates a new storage backed by the given Hazelcast map
+func NewHazelcastStorage(m Map) *HazelcastStorage {
+	return &HazelcastStorage{
+		m:     m,
+		codec: idempotency.JSONCodec,
+	}
+}
+
+// SetCodec replaces the codec used to serialize records (default idempotency.JSONCodec).
+// It implements idempotency.CodecSetter and must be called before the storage is used.
+func (s *HazelcastStorage) SetCodec(codec idempotency.Codec) {
+	s.codec = codec
+}
+
+func recordKey(key string) string {
+	return "record:" + key
+}
+
+func lockKey(key string) string {
+	return "lock:" + key
+}
+
+// Get retrieves an idempotency record by key.
+// If the key is not found, it returns (nil, nil).
+func (s *HazelcastStorage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	val, err := s.m.Get(ctx, recordKey(key))
+	if err != nil {
+		return nil, idempotency.NewStorageError("get", err)
+	}
+	if val == nil {
+		return nil, nil
+	}
+
+	data, ok := val.([]byte)
+	if !ok {
+		return nil, idempotency.NewStorageError("get", fmt.Errorf("unexpected value type %T", val))
+	}
+
+	r, err := s.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+	}
+
+	return r, nil
+}
+
+// Set stores an idempotency record with a per-entry TTL
+func (s *HazelcastStorage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	data, err := s.codec.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	if err := s.m.SetWithTTL(ctx, recordKey(record.Key), data, ttl); err != nil {
+		return idempotency.NewStorageError("set", err)
+	}
+
+	return nil
+}
+
+// Delete removes an idempotency record and its lock
+func (s *HazelcastStorage) Delete(ctx context.Context, key string) error {
+	if err := s.m.Delete(ctx, recordKey(key)); err != nil {
+		return idempotency.NewStorageError("delete", err)
+	}
+	return s.Unlock(ctx, key)
+}
+
+// Exists checks if a record exists for the given key
+func (s *HazelcastStorage) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := s.m.ContainsKey(ctx, recordKey(key))
+	if err != nil {
+		return false, idempotency.NewStorageError("exists", err)
+	}
+	return exists, nil
+}
+
+// TryLock attempts to acquire a distributed lock for the given key.
+// It uses PutIfAbsent with a TTL so only one client can hold the lock.
+func (s *HazelcastStorage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	prev, err := s.m.PutIfAbsentWithTTL(ctx, lockKey(key), []byte("1"), ttl)
+	if err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+	return prev == nil, nil
+}
+
+// Unlock releases the distributed lock for the given key
+func (s *HazelcastStorage) Unlock(ctx context.Context, key string) error {
+	if err := s.m.Delete(ctx, lockKey(key)); err != nil {
+		return idempotency.NewStorageError("unlock", err)
+	}
+	return nil
+}
+
+// Close is a no-op as the user manages the Hazelcast client
+func (s *HazelcastStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package hazelcast
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+type fakeEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+// fakeMap mimics the TTL semantics of a Hazelcast map
+type fakeMap struct {
+	mu      sync.Mutex
+	entries map[any]fakeEntry
+}
+
+func (m *fakeMap) live(key any) (fakeEntry, bool) {
+	e, ok := m.entries[key]
+	if ok && time.Now().After(e.expiresAt) {
+		delete(m.entries, key)
+		return fakeEntry{}, false
+	}
+	return e, ok
+}
+
+func (m *fakeMap) Get(ctx context.Context, key any) (any, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, _ := m.live(key)
+	return e.value, nil
+}
+
+func (m *fakeMap) SetWithTTL(ctx context.Context, key, value any, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = fakeEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *fakeMap) PutIfAbsentWithTTL(ctx context.Context, key, value any, ttl time.Duration) (any, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.live(key); ok {
+		return e.value, nil
+	}
+	m.entries[key] = fakeEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil, nil
+}
+
+func (m *fakeMap) Delete(ctx context.Context, key any) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *fakeMap) ContainsKey(ctx context.Context, key any) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.live(key)
+	return ok, nil
+}
+
+func TestHazelcastStorage_CompleteFlow(t *testing.T) {
+	m := &fakeMap{entries: make(map[any]fakeEntry)}
+	storage := NewHazelcastStorage(m)
+	ctx := context.Background()
+
+	key := "test-hazelcast-key"
+	record := &idempotency.Record{
+		Key:       key,
+		Status:    idempotency.StatusCompleted,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	t.Run("SaveAndGetRecord", func(t *testing.T) {
+		if err := storage.Set(ctx, record, time.Hour); err != nil {
+			t.Fatalf("Set operation failed: %v", err)
+		}
+
+		got, err := storage.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get operation failed: %v", err)
+		}
+		if got == nil || got.Key != key {
+			t.Errorf("unexpected record: %+v", got)
+		}
+
+		exists, _ := storage.Exists(ctx, key)
+		if !exists {
+			t.Error("expected record to exist")
+		}
+
+		missing, err := storage.Get(ctx, "missing")
+		if err != nil || missing != nil {
+			t.Errorf("expected (nil, nil) for missing key, got %+v, %v", missing, err)
+		}
+	})
+
+	t.Run("Locks", func(t *testing.T) {
+		locked, err := storage.TryLock(ctx, key, time.Minute)
+		if err != nil || !locked {
+			t.Fatalf("expected lock to be acquired, got %v, %v", locked, err)
+		}
+
+		lockedAgain, _ := storage.TryLock(ctx, key, time.Minute)
+		if lockedAgain {
+			t.Error("lock should be already held")
+		}
+
+		_ = storage.Unlock(ctx, key)
+
+		lockedPostUnlock, _ := storage.TryLock(ctx, key, time.Minute)
+		if !lockedPostUnlock {
+			t.Error("should have re-acquired the lock after unlocking")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := storage.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if len(m.entries) != 0 {
+			t.Errorf("expected record and lock to be removed, got %d entries", len(m.entries))
+		}
+	})
+
+	t.Run("UnexpectedValueType", func(t *testing.T) {
+		_ = m.SetWithTTL(ctx, "record:bad", "not bytes", time.Minute)
+		if _, err := storage.Get(ctx, "bad"); err == nil {
+			t.Error("expected error for unexpected value type")
+		}
+	})
+}
+
+func TestHazelcastStorage_KeyNamespaces(t *testing.T) {
+	m := &fakeMap{entries: make(map[any]fakeEntry)}
+	storage := NewHazelcastStorage(m)
+	ctx := context.Background()
+
+	if locked, _ := storage.TryLock(ctx, "k1", time.Minute); !locked {
+		t.Fatal("expected the lock to be acquired")
+	}
+
+	// A client key spelling the lock of k1 must not read, overwrite or delete it
+	if got, err := storage.Get(ctx, "lock:k1"); err != nil || got != nil {
+		t.Errorf("expected no record for lock:k1, got %+v, %v", got, err)
+	}
+	if err := storage.Set(ctx, &idempotency.Record{Key: "lock:k1"}, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := storage.Delete(ctx, "lock:k1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if locked, _ := storage.TryLock(ctx, "k1", time.Minute); locked {
+		t.Error("expected the lock of k1 to be kept")
+	}
+}
@@ -0,0 +1,260 @@
+// Package kv adapts any key-value store into an idempotency.Storage.
+//
+// Implementing the four methods of Store is enough to add a new backend: the
+// adapter takes care of serialization, expiry bookkeeping and the key convention
+// ("record:" + key and "lock:" + key, under Options.KeyPrefix).
+//
+//	type myStore struct{ /* client */ }
+//
+//	func (s *myStore) Get(ctx context.Context, key string) ([]byte, error) { ... }
+//	func (s *myStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error { ... }
+//	func (s *myStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) { ... }
+//	func (s *myStore) Delete(ctx context.Context, key string) error { ... }
+//
+//	store := kv.New(&myStore{})
+//
+// Stores without native expiration must also implement CompareAndDeleter, otherwise
+// expired locks are never reclaimed.
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Store is the minimal key-value interface required by the adapter.
+// The ttl arguments are hints: stores without native expiration may ignore them,
+// as the adapter also tracks expiration in the stored value.
+type Store interface {
+	// Get returns the value stored at key, or (nil, nil) if it does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value at key, overwriting any existing value
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// SetNX stores value at key only if it does not exist.
+	// Returns true if the value was stored.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// CompareAndDeleter is implemented by stores able to delete a key only while it holds
+// a given value. The adapter uses it to remove expired entries, so two callers racing
+// to reclaim an expired lock cannot both acquire it. Stores without it must expire
+// keys natively (honoring the ttl hints), as expired entries are then left in place.
+type CompareAndDeleter interface {
+	// CompareAndDelete removes key if its value equals old, and reports whether it did
+	CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error)
+}
+
+// entry is the serialized form of records and locks. Records are embedded as JSON,
+// or encoded in Data when a codec is set.
+type entry struct {
+	ExpiresAt time.Time
+	Record    *idempotency.Record `json:",omitempty"`
+	Data      []byte              `json:",omitempty"`
+}
+
+// Options configures the adapter
+type Options struct {
+	// KeyPrefix is prepended to the keys of records and locks in the store, so
+	// idempotency keys do not collide with other data (optional)
+	KeyPrefix string
+}
+
+// Storage adapts a Store into an idempotency.Storage
+type Storage struct {
+	store Store
+	codec idempotency.Codec
+	clock idempotency.Clock
+	opts  Options
+}
+
+// New creates a new idempotency storage on top of the given key-value store
+func New(store Store) *Storage {
+	return NewWithOptions(store, Options{})
+}
+
+// NewWithOptions creates a new idempotency storage on top of the given key-value store
+// with the given options
+func NewWithOptions(store Store, opts Options) *Storage {
+	return &Storage{
+		store: store,
+		clock: idempotency.SystemClock,
+		opts:  opts,
+	}
+}
+
+// SetCodec replaces the codec used to serialize records (default idempotency.JSONCodec).
+// It implements idempotency.CodecSetter and must be called before the storage is used.
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	s.codec = codec
+}
+
+// SetClock replaces the clock deciding expirations (default idempotency.SystemClock).
+// It implements idempotency.ClockSetter and must be called before the storage is used.
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	s.clock = clock
+}
+
+// recordKey returns the store key of the record for key. Records and locks have
+// distinct prefixes, so no idempotency key can address the lock of another.
+func (s *Storage) recordKey(key string) string {
+	return s.opts.KeyPrefix + "record:" + key
+}
+
+// lockKey returns the store key of the lock for key
+func (s *Storage) lockKey(key string) string {
+	return s.opts.KeyPrefix + "lock:" + key
+}
+
+// load reads and decodes the entry at key, returning nil if it has expired
+func (s *Storage) load(ctx context.Context, key string) (*entry, error) {
+	e, _, err := s.loadExpired(ctx, key)
+	return e, err
+}
+
+// loadExpired is load also reporting whether an expired entry is left at key. The
+// expired entry is removed when the store implements CompareAndDeleter, only if it
+// was not replaced in the meantime.
+func (s *Storage) loadExpired(ctx context.Context, key string) (e *entry, expired bool, err error) {
+	data, err := s.store.Get(ctx, key)
+	if err != nil || data == nil {
+		return nil, false, err
+	}
+
+	e = &entry{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, false, err
+	}
+	if !s.clock.Now().After(e.ExpiresAt) {
+		return e, false, nil
+	}
+
+	if deleter, ok := s.store.(CompareAndDeleter); ok {
+		deleted, err := deleter.CompareAndDelete(ctx, key, data)
+		if err != nil {
+			return nil, false, err
+		}
+		return nil, !deleted, nil
+	}
+	return nil, true, nil
+}
+
+// Get retrieves an idempotency record by key
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	e, err := s.load(ctx, s.recordKey(key))
+	if err != nil {
+		return nil, idempotency.NewStorageError("get", err)
+	}
+	if e == nil {
+		return nil, nil
+	}
+	if e.Data != nil {
+		codec := s.codec
+		if codec == nil {
+			codec = idempotency.JSONCodec
+		}
+		record, err := codec.Unmarshal(e.Data)
+		if err != nil {
+			return nil, idempotency.NewStorageError("unmarshal", err)
+		}
+		return record, nil
+	}
+	return e.Record, nil
+}
+
+// Set stores an idempotency record
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	e := entry{ExpiresAt: s.clock.Now().Add(ttl), Record: record}
+	if s.codec != nil {
+		encoded, err := s.codec.Marshal(record)
+		if err != nil {
+			return idempotency.NewStorageError("marshal", err)
+		}
+		e.Record, e.Data = nil, encoded
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return idempotency.NewStorageError("marshal", err)
+	}
+
+	if err := s.store.Set(ctx, s.recordKey(record.Key), data, ttl); err != nil {
+		return idempotency.NewStorageError("set", err)
+	}
+
+	return nil
+}
+
+// Delete removes an idempotency record and its lock
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.store.Delete(ctx, s.recordKey(key)); err != nil {
+		return idempotency.NewStorageError("delete", err)
+	}
+	return s.Unlock(ctx, key)
+}
+
+// Exists checks if a record exists and is not expired
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	e, err := s.load(ctx, s.recordKey(key))
+	if err != nil {
+		return false, idempotency.NewStorageError("exists", err)
+	}
+	return e != nil, nil
+}
+
+// TryLock attempts to acquire a lock for the given key using SetNX.
+// Expired locks are reclaimed when the store implements CompareAndDeleter.
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	data, err := json.Marshal(entry{ExpiresAt: s.clock.Now().Add(ttl)})
+	if err != nil {
+		return false, idempotency.NewStorageError("marshal", err)
+	}
+
+	locked, err := s.store.SetNX(ctx, s.lockKey(key), data, ttl)
+	if err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+	if locked {
+		return true, nil
+	}
+
+	// The lock exists, check whether it has expired. It is only deleted if it still is
+	// the expired lock read, another caller may have reclaimed it already.
+	current, expired, err := s.loadExpired(ctx, s.lockKey(key))
+	if err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+	if current != nil || expired {
+		return false, nil
+	}
+
+	locked, err = s.store.SetNX(ctx, s.lockKey(key), data, ttl)
+	if err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+	return locked, nil
+}
+
+// Unlock releases a lock
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	if err := s.store.Delete(ctx, s.lockKey(key)); err != nil {
+		return idempotency.NewStorageError("unlock", err)
+	}
+	return nil
+}
+
+// Close closes the underlying store if it implements io.Closer
+func (s *Storage) Close() error {
+	if closer, ok := s.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
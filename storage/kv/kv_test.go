@@ -0,0 +1,200 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// mapStore is a Store without native expiration
+type mapStore struct {
+	mu     sync.Mutex
+	data   map[string][]byte
+	closed bool
+
+	// afterGet is called after each Get, outside the lock
+	afterGet func(key string)
+}
+
+func (m *mapStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	value := m.data[key]
+	m.mu.Unlock()
+	if m.afterGet != nil {
+		m.afterGet(key)
+	}
+	return value, nil
+}
+
+func (m *mapStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[key] = value
+	return nil
+}
+
+func (m *mapStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.data[key]; ok {
+		return false, nil
+	}
+	m.data[key] = value
+	return true, nil
+}
+
+func (m *mapStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+func (m *mapStore) CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !bytes.Equal(m.data[key], old) {
+		return false, nil
+	}
+	delete(m.data, key)
+	return true, nil
+}
+
+func (m *mapStore) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestKVStorage_CompleteFlow(t *testing.T) {
+	backend := &mapStore{data: make(map[string][]byte)}
+	store := New(backend)
+	ctx := context.Background()
+
+	key := "test-kv-key"
+	record := &idempotency.Record{
+		Key:       key,
+		Status:    idempotency.StatusCompleted,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	t.Run("SaveAndGetRecord", func(t *testing.T) {
+		if err := store.Set(ctx, record, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := store.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got == nil || got.Key != key || got.Status != idempotency.StatusCompleted {
+			t.Errorf("unexpected record: %+v", got)
+		}
+
+		exists, _ := store.Exists(ctx, key)
+		if !exists {
+			t.Error("expected record to exist")
+		}
+	})
+
+	t.Run("ExpiredRecord", func(t *testing.T) {
+		_ = store.Set(ctx, &idempotency.Record{Key: "old"}, -time.Second)
+		got, err := store.Get(ctx, "old")
+		if err != nil || got != nil {
+			t.Errorf("expected expired record to be missing, got %+v, %v", got, err)
+		}
+		if _, ok := backend.data["record:old"]; ok {
+			t.Error("expected expired record to be deleted")
+		}
+	})
+
+	t.Run("Locks", func(t *testing.T) {
+		locked, err := store.TryLock(ctx, key, time.Minute)
+		if err != nil || !locked {
+			t.Fatalf("expected lock to be acquired, got %v, %v", locked, err)
+		}
+
+		lockedAgain, _ := store.TryLock(ctx, key, time.Minute)
+		if lockedAgain {
+			t.Error("lock should be already held")
+		}
+
+		_ = store.Unlock(ctx, key)
+
+		// Expired lock left in a store without native TTL is reclaimed
+		_, _ = store.TryLock(ctx, key, -time.Second)
+		reclaimed, _ := store.TryLock(ctx, key, time.Minute)
+		if !reclaimed {
+			t.Error("expected expired lock to be reclaimed")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := store.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if len(backend.data) != 0 {
+			t.Errorf("expected record and lock to be removed, got %d keys", len(backend.data))
+		}
+	})
+
+	if err := store.Close(); err != nil || !backend.closed {
+		t.Errorf("expected underlying store to be closed, got %v", err)
+	}
+}
+
+func TestKVStorage_KeyPrefix(t *testing.T) {
+	backend := &mapStore{data: make(map[string][]byte)}
+	store := NewWithOptions(backend, Options{KeyPrefix: "billing:"})
+	ctx := context.Background()
+
+	_ = store.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}, time.Hour)
+	_, _ = store.TryLock(ctx, "k1", time.Hour)
+	if _, ok := backend.data["billing:record:k1"]; !ok {
+		t.Error("expected the record to be stored under the prefix")
+	}
+	if _, ok := backend.data["billing:lock:k1"]; !ok {
+		t.Error("expected the lock to be stored under the prefix")
+	}
+	if got, _ := store.Get(ctx, "k1"); got == nil {
+		t.Error("expected the prefixed record to be read")
+	}
+}
+
+func TestKVStorage_ReclaimRace(t *testing.T) {
+	backend := &mapStore{data: make(map[string][]byte)}
+	store := New(backend)
+	ctx := context.Background()
+
+	// An expired lock is left in the store
+	_, _ = store.TryLock(ctx, "k1", -time.Second)
+
+	// B reads the expired lock, then A reclaims it before B acts on its read
+	var reclaimedByA bool
+	backend.afterGet = func(key string) {
+		backend.afterGet = nil
+		reclaimedByA, _ = store.TryLock(ctx, "k1", time.Minute)
+	}
+	reclaimedByB, err := store.TryLock(ctx, "k1", time.Minute)
+	if err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	if !reclaimedByA || reclaimedByB {
+		t.Errorf("expected only A to hold the lock, got A=%v B=%v", reclaimedByA, reclaimedByB)
+	}
+}
+
+func TestKVStorage_KeyNamespaces(t *testing.T) {
+	backend := &mapStore{data: make(map[string][]byte)}
+	store := New(backend)
+	ctx := context.Background()
+
+	_ = store.Set(ctx, &idempotency.Record{Key: "lock:k1", Status: idempotency.StatusCompleted}, time.Hour)
+	if locked, _ := store.TryLock(ctx, "k1", time.Hour); !locked {
+		t.Error("expected a record keyed lock:k1 not to hold the lock of k1")
+	}
+}
@@ -0,0 +1,148 @@
+package memory
+
+import "github.com/fco-gt/gopotency"
+
+// recordOverhead approximates the memory used by a record besides its strings and body
+const recordOverhead = 256
+
+// lruEntry is an element of the LRU list of a bounded shard
+type lruEntry struct {
+	key  string
+	size int64
+
+	// used orders the last use of the record among the records of all shards
+	used uint64
+}
+
+// touch marks the record for key as the most recently used. It is called with mu held
+// for reading, lruMu serializes the moves of concurrent readers.
+func (sh *shard) touch(key string) {
+	if sh.lru == nil {
+		return
+	}
+	sh.lruMu.Lock()
+	defer sh.lruMu.Unlock()
+
+	if elem, ok := sh.elems[key]; ok {
+		elem.Value.(*lruEntry).used = sh.capacity.uses.Add(1)
+		sh.lru.MoveToFront(elem)
+	}
+}
+
+// put stores record as the most recently used. It is called with mu held, the records
+// over the storage capacity are evicted afterwards by Storage.evict.
+func (sh *shard) put(record *idempotency.Record) {
+	sh.records[record.Key] = record
+	if sh.lru == nil {
+		return
+	}
+	sh.lruMu.Lock()
+	defer sh.lruMu.Unlock()
+
+	size := recordSize(record)
+	used := sh.capacity.uses.Add(1)
+	if elem, ok := sh.elems[record.Key]; ok {
+		e := elem.Value.(*lruEntry)
+		sh.bytes += size - e.size
+		sh.capacity.bytes.Add(size - e.size)
+		e.size, e.used = size, used
+		sh.lru.MoveToFront(elem)
+	} else {
+		sh.elems[record.Key] = sh.lru.PushFront(&lruEntry{key: record.Key, size: size, used: used})
+		sh.bytes += size
+		sh.capacity.bytes.Add(size)
+		sh.capacity.entries.Add(1)
+	}
+}
+
+// remove deletes the record for key. It is called with mu held.
+func (sh *shard) remove(key string) {
+	delete(sh.records, key)
+	if sh.lru == nil {
+		return
+	}
+	sh.lruMu.Lock()
+	defer sh.lruMu.Unlock()
+
+	if elem, ok := sh.elems[key]; ok {
+		size := elem.Value.(*lruEntry).size
+		sh.bytes -= size
+		sh.capacity.bytes.Add(-size)
+		sh.capacity.entries.Add(-1)
+		sh.lru.Remove(elem)
+		delete(sh.elems, key)
+	}
+}
+
+// oldest returns the last use of the least recently used record of the shard, unless
+// it is the record for keep
+func (sh *shard) oldest(keep string) (uint64, bool) {
+	sh.lruMu.Lock()
+	defer sh.lruMu.Unlock()
+
+	back := sh.lru.Back()
+	if back == nil || back.Value.(*lruEntry).key == keep {
+		return 0, false
+	}
+	return back.Value.(*lruEntry).used, true
+}
+
+// evictOldest removes the least recently used record of the shard, unless it is the
+// record for keep, and returns its key
+func (sh *shard) evictOldest(keep string) (string, bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.lruMu.Lock()
+	back := sh.lru.Back()
+	sh.lruMu.Unlock()
+	if back == nil || back.Value.(*lruEntry).key == keep {
+		return "", false
+	}
+	key := back.Value.(*lruEntry).key
+	sh.remove(key)
+	return key, true
+}
+
+// evict removes the least recently used records of all shards while the storage
+// exceeds its capacity, and returns their keys. The record just stored for keep is
+// kept even if it exceeds the capacity on its own. It is called without shard locks.
+func (s *Storage) evict(keep string) []string {
+	if s.capacity == nil {
+		return nil
+	}
+
+	var evicted []string
+	for s.capacity.exceeded() {
+		// The least recently used record is the oldest of the shard oldest records
+		var oldest *shard
+		var oldestUsed uint64
+		for _, sh := range s.shards {
+			if used, ok := sh.oldest(keep); ok && (oldest == nil || used < oldestUsed) {
+				oldest, oldestUsed = sh, used
+			}
+		}
+		if oldest == nil {
+			break
+		}
+		if key, ok := oldest.evictOldest(keep); ok {
+			evicted = append(evicted, key)
+		}
+	}
+	return evicted
+}
+
+// recordSize approximates the memory used by record
+func recordSize(record *idempotency.Record) int64 {
+	size := recordOverhead + len(record.Key) + len(record.RequestHash) + len(record.Error)
+	if resp := record.Response; resp != nil {
+		size += len(resp.Body) + len(resp.ContentType) + len(resp.ETag) + len(resp.Region) + len(resp.BlobKey)
+		for name, values := range resp.Headers {
+			size += len(name)
+			for _, value := range values {
+				size += len(value)
+			}
+		}
+	}
+	return int64(size)
+}
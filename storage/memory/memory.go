@@ -1,29 +1,115 @@
 // Package memory provides an in-memory storage implementation for idempotency records.
 //
-// This storage is suitable for development, testing, and single-instance applications.
+// This storage is suitable for development, testing, and single-instance applications;
+// bound it with Options.MaxEntries or Options.MaxBytes for long-running instances.
 // For production distributed systems, use Redis or PostgreSQL storage instead.
 package memory
 
 import (
 	"context"
-	"sync"
+	"hash/maphash"
+	"sync/atomic"
 	"time"
 
 	"github.com/fco-gt/gopotency"
 )
 
+// EvictionReason tells why a record was removed by the storage itself
+type EvictionReason string
+
+const (
+	// EvictionExpired means the record TTL elapsed
+	EvictionExpired EvictionReason = "expired"
+
+	// EvictionCapacity means the record was the least recently used when the storage
+	// reached MaxEntries or MaxBytes
+	EvictionCapacity EvictionReason = "capacity"
+)
+
+// Options configures the in-memory storage
+type Options struct {
+	// CleanupInterval is how often expired records and locks are removed
+	// Default: 1 minute
+	CleanupInterval time.Duration
+
+	// Shards is the number of independently locked partitions of the keys, rounded up
+	// to a power of two, so concurrent requests for different keys rarely contend
+	// Default: 32
+	Shards int
+
+	// MaxEntries caps the number of records. Over it, the least recently used records
+	// are evicted, so a long-running instance cannot grow without bound. An evicted
+	// record is processed again if its key is retried. The cap applies to the records of
+	// all shards together (optional)
+	MaxEntries int
+
+	// MaxBytes caps the approximate memory used by the records (keys, headers and
+	// bodies), evicting the least recently used records over it. Like MaxEntries, it
+	// applies to all shards together (optional)
+	MaxBytes int64
+
+	// OnEvict is called for every record removed by the storage (not by Delete).
+	// It is called outside of the storage lock (optional)
+	OnEvict func(key string, reason EvictionReason)
+}
+
+// Stats are counters describing the storage content
+type Stats struct {
+	// Records is the number of records currently stored, including expired ones
+	// not cleaned up yet
+	Records int
+
+	// Expired is the number of records removed because their TTL elapsed
+	Expired uint64
+
+	// Evicted is the number of records evicted over MaxEntries or MaxBytes
+	Evicted uint64
+
+	// Bytes is the approximate memory used by the records, tracked when MaxEntries or
+	// MaxBytes is set
+	Bytes int64
+}
+
 // Storage is an in-memory implementation of idempotency.Storage
 type Storage struct {
-	mu      sync.RWMutex
-	records map[string]*idempotency.Record
-	locks   map[string]time.Time
+	shards   []*shard
+	capacity *capacity
+	seed     maphash.Seed
+	opts     Options
+	expired  atomic.Uint64
+	evicted  atomic.Uint64
+
+	onExpired atomic.Pointer[func(key string, record *idempotency.Record)]
 }
 
 // NewMemoryStorage creates a new in-memory storage instance
 func NewMemoryStorage() *Storage {
+	return NewMemoryStorageWithOptions(Options{})
+}
+
+// NewMemoryStorageWithOptions creates a new in-memory storage instance with the given options
+func NewMemoryStorageWithOptions(opts Options) *Storage {
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = time.Minute
+	}
+	if opts.Shards <= 0 {
+		opts.Shards = 32
+	}
+	shards := 1
+	for shards < opts.Shards {
+		shards <<= 1
+	}
+
 	s := &Storage{
-		records: make(map[string]*idempotency.Record),
-		locks:   make(map[string]time.Time),
+		shards: make([]*shard, shards),
+		seed:   maphash.MakeSeed(),
+		opts:   opts,
+	}
+	if opts.MaxEntries > 0 || opts.MaxBytes > 0 {
+		s.capacity = &capacity{maxEntries: int64(opts.MaxEntries), maxBytes: opts.MaxBytes}
+	}
+	for i := range s.shards {
+		s.shards[i] = newShard(s.capacity)
 	}
 
 	// Start cleanup goroutine
@@ -32,129 +118,285 @@ func NewMemoryStorage() *Storage {
 	return s
 }
 
+// shard returns the shard holding key
+func (s *Storage) shard(key string) *shard {
+	return s.shards[maphash.String(s.seed, key)&uint64(len(s.shards)-1)]
+}
+
+// Stats returns the current storage counters
+func (s *Storage) Stats() Stats {
+	stats := Stats{
+		Expired: s.expired.Load(),
+		Evicted: s.evicted.Load(),
+	}
+	for _, sh := range s.shards {
+		records, bytes := sh.size()
+		stats.Records += records
+		stats.Bytes += bytes
+	}
+	return stats
+}
+
+// OnExpired registers fn, called by the cleanup goroutine with every expired record.
+// It implements idempotency.ExpiryNotifier.
+func (s *Storage) OnExpired(fn func(key string, record *idempotency.Record)) {
+	s.onExpired.Store(&fn)
+}
+
 // Get retrieves an idempotency record by key
 func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	record, exists := s.records[key]
+	record, exists := sh.records[key]
 	if !exists {
-		return nil, idempotency.NewStorageError("get", idempotency.ErrStorageOperation)
+		return nil, nil
 	}
 
 	// Check if expired
-	if time.Now().After(record.ExpiresAt) {
-		return nil, idempotency.NewStorageError("get", idempotency.ErrStorageOperation)
+	if sh.clock.Now().After(record.ExpiresAt) {
+		return nil, nil
 	}
 
+	sh.touch(key)
 	return record, nil
 }
 
 // Set stores an idempotency record
 func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	sh := s.shard(record.Key)
+	sh.mu.Lock()
 
 	// Set expiration if not already set
 	if record.ExpiresAt.IsZero() {
-		record.ExpiresAt = time.Now().Add(ttl)
+		record.ExpiresAt = sh.clock.Now().Add(ttl)
 	}
 
-	s.records[record.Key] = record
+	sh.put(record)
+	sh.mu.Unlock()
+
+	s.notifyEvicted(s.evict(record.Key))
 	return nil
 }
 
 // Delete removes an idempotency record
 func (s *Storage) Delete(ctx context.Context, key string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	delete(s.records, key)
-	delete(s.locks, key)
+	sh.remove(key)
+	delete(sh.locks, key)
 	return nil
 }
 
 // Exists checks if a record exists
 func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
 
-	record, exists := s.records[key]
+	record, exists := sh.records[key]
 	if !exists {
 		return false, nil
 	}
 
 	// Check if expired
-	if time.Now().After(record.ExpiresAt) {
+	if sh.clock.Now().After(record.ExpiresAt) {
 		return false, nil
 	}
 
 	return true, nil
 }
 
+// List returns all non-expired records
+func (s *Storage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	var records []*idempotency.Record
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		now := sh.clock.Now()
+		for _, record := range sh.records {
+			if now.After(record.ExpiresAt) {
+				continue
+			}
+			records = append(records, record)
+		}
+		sh.mu.RUnlock()
+	}
+
+	return records, nil
+}
+
 // TryLock attempts to acquire a lock for the given key
 func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
 	// Check if lock exists and is not expired
-	if lockExpiry, exists := s.locks[key]; exists {
-		if time.Now().Before(lockExpiry) {
+	if lockExpiry, exists := sh.locks[key]; exists {
+		if sh.clock.Now().Before(lockExpiry) {
 			return false, nil // Lock already held
 		}
 		// Lock expired, can be acquired
 	}
 
 	// Acquire lock
-	s.locks[key] = time.Now().Add(ttl)
+	sh.locks[key] = sh.clock.Now().Add(ttl)
 	return true, nil
 }
 
+// GetOrLock returns the non-expired record for key if there is one, otherwise acquires
+// the lock and stores record under a single storage lock. It implements
+// idempotency.GetOrLocker.
+func (s *Storage) GetOrLock(ctx context.Context, key string, record *idempotency.Record, ttl, lockTTL time.Duration) (*idempotency.Record, bool, error) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+
+	now := sh.clock.Now()
+	if existing, exists := sh.records[key]; exists && now.Before(existing.ExpiresAt) {
+		sh.touch(key)
+		sh.mu.Unlock()
+		return existing, false, nil
+	}
+	if lockExpiry, exists := sh.locks[key]; exists && now.Before(lockExpiry) {
+		sh.mu.Unlock()
+		return nil, false, nil
+	}
+
+	sh.locks[key] = now.Add(lockTTL)
+	if record.ExpiresAt.IsZero() {
+		record.ExpiresAt = now.Add(ttl)
+	}
+	sh.put(record)
+	sh.mu.Unlock()
+
+	s.notifyEvicted(s.evict(record.Key))
+	return nil, true, nil
+}
+
+// ExtendLock makes the lock held for key expire ttl from now, reporting false if it
+// expired or was released. It implements idempotency.LockExtender.
+func (s *Storage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := sh.clock.Now()
+	if lockExpiry, exists := sh.locks[key]; !exists || !now.Before(lockExpiry) {
+		return false, nil
+	}
+	sh.locks[key] = now.Add(ttl)
+	return true, nil
+}
+
+// LockTTL returns the time until the lock held for key expires, 0 when it is not held.
+// It implements idempotency.LockTTLReader.
+func (s *Storage) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	lockExpiry, exists := sh.locks[key]
+	if !exists {
+		return 0, nil
+	}
+	return max(lockExpiry.Sub(sh.clock.Now()), 0), nil
+}
+
 // Unlock releases a lock for the given key
 func (s *Storage) Unlock(ctx context.Context, key string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	delete(s.locks, key)
+	delete(sh.locks, key)
 	return nil
 }
 
 // Close closes the storage (no-op for memory storage)
 func (s *Storage) Close() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	// Clear all data
-	s.records = make(map[string]*idempotency.Record)
-	s.locks = make(map[string]time.Time)
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		sh.reset()
+		sh.mu.Unlock()
+	}
 
 	return nil
 }
 
+// SetClock replaces the clock deciding expirations, e.g. with an idempotency.FakeClock
+// in tests, together with Cleanup. It implements idempotency.ClockSetter and must be
+// called before the storage is used.
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		sh.clock = clock
+		sh.mu.Unlock()
+	}
+}
+
 // cleanup periodically removes expired records and locks
 func (s *Storage) cleanup() {
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(s.opts.CleanupInterval)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		s.mu.Lock()
+		s.Cleanup()
+	}
+}
+
+// Cleanup removes the expired records and locks, reporting the records to OnEvict and
+// OnExpired. It runs every CleanupInterval, and can be called to drive the cleanup in
+// tests. Shards are cleaned up one at a time, so requests for the other shards are
+// not blocked.
+func (s *Storage) Cleanup() {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
 
-		now := time.Now()
+		now := sh.clock.Now()
 
 		// Remove expired records
-		for key, record := range s.records {
+		evicted := make(map[string]*idempotency.Record)
+		for key, record := range sh.records {
 			if now.After(record.ExpiresAt) {
-				delete(s.records, key)
+				sh.remove(key)
+				evicted[key] = record
 			}
 		}
+		s.expired.Add(uint64(len(evicted)))
 
 		// Remove expired locks
-		for key, expiry := range s.locks {
+		for key, expiry := range sh.locks {
 			if now.After(expiry) {
-				delete(s.locks, key)
+				delete(sh.locks, key)
 			}
 		}
 
-		s.mu.Unlock()
+		sh.mu.Unlock()
+
+		onExpired := s.onExpired.Load()
+		for key, record := range evicted {
+			if s.opts.OnEvict != nil {
+				s.opts.OnEvict(key, EvictionExpired)
+			}
+			if onExpired != nil {
+				(*onExpired)(key, record)
+			}
+		}
+	}
+}
+
+// notifyEvicted counts the records evicted for capacity and reports them to OnEvict.
+// It is called without shard locks held.
+func (s *Storage) notifyEvicted(keys []string) {
+	s.evicted.Add(uint64(len(keys)))
+	if s.opts.OnEvict == nil {
+		return
+	}
+	for _, key := range keys {
+		s.opts.OnEvict(key, EvictionCapacity)
 	}
 }
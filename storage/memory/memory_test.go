@@ -2,6 +2,8 @@ package memory
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -75,6 +77,48 @@ func TestMemoryStorage_CompleteFlow(t *testing.T) {
 		}
 	})
 
+	t.Run("ExtendLock", func(t *testing.T) {
+		if held, _ := store.ExtendLock(ctx, "extend-key", time.Hour); held {
+			t.Error("expected a lock not held not to be extended")
+		}
+		_, _ = store.TryLock(ctx, "extend-key", 50*time.Millisecond)
+		if held, err := store.ExtendLock(ctx, "extend-key", time.Hour); err != nil || !held {
+			t.Fatalf("expected the lock to be extended, got %v, %v", held, err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if locked, _ := store.TryLock(ctx, "extend-key", time.Hour); locked {
+			t.Error("expected the extended lock to be held")
+		}
+	})
+
+	// Sub-test: Atomic check and lock
+	t.Run("GetOrLock", func(t *testing.T) {
+		pending := &idempotency.Record{Key: "atomic-key", Status: idempotency.StatusPending}
+		existing, locked, err := store.GetOrLock(ctx, "atomic-key", pending, time.Hour, time.Minute)
+		if err != nil || !locked || existing != nil {
+			t.Fatalf("expected lock to be acquired, got %v, %v, %v", existing, locked, err)
+		}
+
+		other := &idempotency.Record{Key: "atomic-key", Status: idempotency.StatusCompleted}
+		existing, locked, _ = store.GetOrLock(ctx, "atomic-key", other, time.Hour, time.Minute)
+		if locked || existing != pending {
+			t.Errorf("expected pending record to be returned, got %v, %v", existing, locked)
+		}
+
+		_ = store.Delete(ctx, "atomic-key")
+	})
+
+	// Sub-test: Listing
+	t.Run("List", func(t *testing.T) {
+		records, err := store.List(ctx)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(records) != 1 || records[0].Key != key {
+			t.Errorf("expected [%s], got %v", key, records)
+		}
+	})
+
 	// Sub-test: Deletion
 	t.Run("DeleteRecord", func(t *testing.T) {
 		err := store.Delete(ctx, key)
@@ -132,3 +176,224 @@ func TestMemoryStorage_CompleteFlow(t *testing.T) {
 		cleanStore.Close()
 	})
 }
+
+func TestMemoryStorage_Evictions(t *testing.T) {
+	evicted := make(chan string, 1)
+	store := NewMemoryStorageWithOptions(Options{
+		CleanupInterval: 10 * time.Millisecond,
+		OnEvict: func(key string, reason EvictionReason) {
+			if reason == EvictionExpired {
+				evicted <- key
+			}
+		},
+	})
+	defer store.Close()
+	ctx := context.Background()
+
+	_ = store.Set(ctx, &idempotency.Record{Key: "short-lived"}, 20*time.Millisecond)
+	_ = store.Set(ctx, &idempotency.Record{Key: "long-lived"}, time.Hour)
+
+	select {
+	case key := <-evicted:
+		if key != "short-lived" {
+			t.Errorf("expected short-lived to be evicted, got %s", key)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for eviction")
+	}
+
+	stats := store.Stats()
+	if stats.Expired != 1 || stats.Records != 1 {
+		t.Errorf("expected 1 expired and 1 stored record, got %+v", stats)
+	}
+}
+
+func TestMemoryStorage_OnExpired(t *testing.T) {
+	store := NewMemoryStorageWithOptions(Options{CleanupInterval: 10 * time.Millisecond})
+	defer store.Close()
+
+	expired := make(chan *idempotency.Record, 1)
+	store.OnExpired(func(key string, record *idempotency.Record) {
+		expired <- record
+	})
+
+	_ = store.Set(context.Background(), &idempotency.Record{Key: "archived", Status: idempotency.StatusCompleted}, 20*time.Millisecond)
+
+	select {
+	case record := <-expired:
+		if record == nil || record.Key != "archived" {
+			t.Errorf("expected archived record, got %v", record)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for expiry notification")
+	}
+}
+
+func TestMemoryStorage_Clock(t *testing.T) {
+	clock := idempotency.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStorageWithOptions(Options{CleanupInterval: time.Hour})
+	defer store.Close()
+	store.SetClock(clock)
+	ctx := context.Background()
+
+	_ = store.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}, time.Minute)
+	if acquired, _ := store.TryLock(ctx, "k1", 10*time.Second); !acquired {
+		t.Fatal("expected the lock to be acquired")
+	}
+	clock.Advance(4 * time.Second)
+	if ttl, _ := store.LockTTL(ctx, "k1"); ttl != 6*time.Second {
+		t.Errorf("expected 6s left on the lock, got %s", ttl)
+	}
+
+	clock.Advance(7 * time.Second)
+	if ttl, _ := store.LockTTL(ctx, "k1"); ttl != 0 {
+		t.Errorf("expected no time left on the expired lock, got %s", ttl)
+	}
+	if acquired, _ := store.TryLock(ctx, "k1", 10*time.Second); !acquired {
+		t.Error("expected the expired lock to be taken over")
+	}
+	if got, _ := store.Get(ctx, "k1"); got == nil {
+		t.Error("expected the record to live until its TTL")
+	}
+
+	clock.Advance(time.Minute)
+	if got, _ := store.Get(ctx, "k1"); got != nil {
+		t.Error("expected the record to expire with the clock")
+	}
+	store.Cleanup()
+	if stats := store.Stats(); stats.Records != 0 || stats.Expired != 1 {
+		t.Errorf("expected Cleanup to remove the expired record, got %+v", stats)
+	}
+}
+
+func TestMemoryStorage_Capacity(t *testing.T) {
+	ctx := context.Background()
+	record := func(key string, body int) *idempotency.Record {
+		return &idempotency.Record{
+			Key:      key,
+			Status:   idempotency.StatusCompleted,
+			Response: &idempotency.CachedResponse{StatusCode: 200, Body: make([]byte, body)},
+		}
+	}
+
+	t.Run("MaxEntries", func(t *testing.T) {
+		var evicted []string
+		store := NewMemoryStorageWithOptions(Options{
+			Shards:     1, // a single LRU over all keys
+			MaxEntries: 2,
+			OnEvict: func(key string, reason EvictionReason) {
+				if reason == EvictionCapacity {
+					evicted = append(evicted, key)
+				}
+			},
+		})
+		defer store.Close()
+
+		_ = store.Set(ctx, record("k1", 0), time.Hour)
+		_ = store.Set(ctx, record("k2", 0), time.Hour)
+		_, _ = store.Get(ctx, "k1") // k2 becomes the least recently used
+		_ = store.Set(ctx, record("k3", 0), time.Hour)
+
+		if got, _ := store.Get(ctx, "k2"); got != nil {
+			t.Error("expected the least recently used record to be evicted")
+		}
+		if got, _ := store.Get(ctx, "k1"); got == nil {
+			t.Error("expected the recently read record to be kept")
+		}
+		if len(evicted) != 1 || evicted[0] != "k2" {
+			t.Errorf("expected k2 to be reported evicted, got %v", evicted)
+		}
+		if stats := store.Stats(); stats.Records != 2 || stats.Evicted != 1 {
+			t.Errorf("expected 2 records and 1 eviction, got %+v", stats)
+		}
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		store := NewMemoryStorageWithOptions(Options{Shards: 1, MaxBytes: 3 << 10})
+		defer store.Close()
+
+		for _, key := range []string{"k1", "k2", "k3"} {
+			_ = store.Set(ctx, record(key, 1<<10), time.Hour)
+		}
+		if stats := store.Stats(); stats.Records != 2 || stats.Bytes > 3<<10 {
+			t.Errorf("expected the records to fit in MaxBytes, got %+v", stats)
+		}
+
+		// A record larger than MaxBytes is kept alone
+		_ = store.Set(ctx, record("large", 4<<10), time.Hour)
+		if got, _ := store.Get(ctx, "large"); got == nil || store.Stats().Records != 1 {
+			t.Errorf("expected only the large record to be kept, got %+v", store.Stats())
+		}
+
+		_ = store.Delete(ctx, "large")
+		if stats := store.Stats(); stats.Bytes != 0 {
+			t.Errorf("expected deleted records to release their bytes, got %+v", stats)
+		}
+	})
+}
+
+func TestMemoryStorage_CapacityAcrossShards(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorageWithOptions(Options{MaxEntries: 32})
+	defer store.Close()
+
+	set := func(from, to int) {
+		for i := from; i < to; i++ {
+			_ = store.Set(ctx, &idempotency.Record{Key: fmt.Sprintf("k%d", i), Status: idempotency.StatusCompleted}, time.Hour)
+		}
+	}
+
+	// The cap applies to all shards together, however the keys are spread
+	set(0, 20)
+	if stats := store.Stats(); stats.Records != 20 || stats.Evicted != 0 {
+		t.Fatalf("expected no eviction under MaxEntries, got %+v", stats)
+	}
+
+	set(20, 40)
+	if stats := store.Stats(); stats.Records != 32 || stats.Evicted != 8 {
+		t.Fatalf("expected 32 records and 8 evictions, got %+v", stats)
+	}
+	for i := range 40 {
+		got, _ := store.Get(ctx, fmt.Sprintf("k%d", i))
+		if (got == nil) != (i < 8) {
+			t.Errorf("expected only the 8 least recently used records to be evicted, k%d present: %v", i, got != nil)
+		}
+	}
+}
+
+func TestMemoryStorage_Shards(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorageWithOptions(Options{Shards: 3, MaxEntries: 1000})
+	defer store.Close()
+
+	if len(store.shards) != 4 {
+		t.Fatalf("expected the shard count to be rounded up to 4, got %d", len(store.shards))
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 100 {
+				key := fmt.Sprintf("k%d-%d", i, j)
+				if acquired, _ := store.TryLock(ctx, key, time.Minute); !acquired {
+					t.Errorf("expected the lock of %s to be acquired", key)
+				}
+				_ = store.Set(ctx, &idempotency.Record{Key: key, Status: idempotency.StatusCompleted}, time.Hour)
+				_, _ = store.Get(ctx, key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	records, _ := store.List(ctx)
+	if stats := store.Stats(); stats.Records != 800 || len(records) != 800 {
+		t.Errorf("expected 800 records across shards, got %+v and %d listed", stats, len(records))
+	}
+	for i, sh := range store.shards {
+		if len(sh.records) == 0 {
+			t.Errorf("expected keys to be spread over every shard, shard %d is empty", i)
+		}
+	}
+}
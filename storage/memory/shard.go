@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fco-gt/gopotency"
+)
+
+// shard holds the records and locks of the keys hashed to it
+type shard struct {
+	mu      sync.RWMutex
+	records map[string]*idempotency.Record
+	locks   map[string]time.Time
+
+	// lru orders the records from the most to the least recently used when the storage
+	// is bounded, nil otherwise. lruMu guards it for readers holding mu.RLock.
+	lru   *list.List
+	lruMu sync.Mutex
+	elems map[string]*list.Element
+	bytes int64
+
+	// capacity is shared by the shards of a bounded storage, nil otherwise
+	capacity *capacity
+
+	// clock decides the expirations of the shard. Each shard has its own, guarded by mu,
+	// so SetClock never holds more than one shard lock.
+	clock idempotency.Clock
+}
+
+// capacity bounds the records of all the shards of a storage, so the limits hold
+// however the keys are spread
+type capacity struct {
+	maxEntries int64
+	maxBytes   int64
+	entries    atomic.Int64
+	bytes      atomic.Int64
+
+	// uses orders the uses of records across shards, to find the least recently used
+	uses atomic.Uint64
+}
+
+// exceeded reports whether the records of the storage exceed its capacity
+func (c *capacity) exceeded() bool {
+	return (c.maxEntries > 0 && c.entries.Load() > c.maxEntries) ||
+		(c.maxBytes > 0 && c.bytes.Load() > c.maxBytes)
+}
+
+func newShard(capacity *capacity) *shard {
+	sh := &shard{capacity: capacity, clock: idempotency.SystemClock}
+	sh.reset()
+	return sh
+}
+
+// reset removes all records and locks. It is called with mu held.
+func (sh *shard) reset() {
+	sh.records = make(map[string]*idempotency.Record)
+	sh.locks = make(map[string]time.Time)
+	if sh.capacity != nil {
+		sh.lruMu.Lock()
+		if sh.lru != nil {
+			sh.capacity.entries.Add(-int64(sh.lru.Len()))
+			sh.capacity.bytes.Add(-sh.bytes)
+		}
+		sh.lru = list.New()
+		sh.elems = make(map[string]*list.Element)
+		sh.bytes = 0
+		sh.lruMu.Unlock()
+	}
+}
+
+// size returns the number of records and their approximate memory
+func (sh *shard) size() (int, int64) {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	sh.lruMu.Lock()
+	defer sh.lruMu.Unlock()
+	return len(sh.records), sh.bytes
+}
@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/redis/go-redis/v9"
+)
+
+// lockAndSetScript acquires the lock (KEYS[1]) and, only if acquired, stores the record
+// (KEYS[2]) and drops the chunk manifest (KEYS[3]) of a previous body.
+// ARGV: lock TTL (ms), record data, record TTL (ms, 0 for none).
+var lockAndSetScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], '1', 'NX', 'PX', ARGV[1]) then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[2], ARGV[2])
+end
+redis.call('DEL', KEYS[3])
+return 1
+`)
+
+// TryLockAndSet acquires the lock for record.Key and stores the pending record in a
+// single Lua script, so a crash can never leave a lock without its record or a pending
+// record without its lock. In Redis Cluster, use HashTagLayout so both keys share a slot.
+// It implements idempotency.LockSetter.
+func (s *RedisStorage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	if s.chunkSize > 0 && record.Response != nil && len(record.Response.Body) > s.chunkSize {
+		// Chunked bodies do not fit in the script, pending records have none anyway
+		locked, err := s.TryLock(ctx, record.Key, lockTTL)
+		if err != nil || !locked {
+			return locked, err
+		}
+		return true, s.Set(ctx, record, ttl)
+	}
+
+	data, err := s.recordCodec().Marshal(record)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal record: %w", err)
+	}
+	keys := []string{s.lockKey(record.Key), s.recordKey(record.Key), s.manifestKey(record.Key)}
+	locked, err := lockAndSetScript.Run(ctx, s.client, keys, max(lockTTL.Milliseconds(), 1), data, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return locked == 1, nil
+}
+
+// SetAndUnlock stores the completed record and releases its lock in a single MULTI/EXEC
+// transaction, then notifies waiting duplicates. In Redis Cluster, use HashTagLayout so
+// the record and lock keys share a slot.
+// It implements idempotency.SetUnlocker.
+func (s *RedisStorage) SetAndUnlock(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := s.writeRecord(ctx, pipe, record, ttl); err != nil {
+			return err
+		}
+		pipe.Del(ctx, s.lockKey(record.Key))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if record.Status == idempotency.StatusPending {
+		return s.client.Publish(ctx, s.doneChannel(record.Key), "unlocked").Err()
+	}
+	return s.notifyDone(ctx, record)
+}
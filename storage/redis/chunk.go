@@ -0,0 +1,129 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/redis/go-redis/v9"
+)
+
+// chunkSuffix is appended to the record key, followed by the chunk index, to build the
+// key of a body chunk. Chunks share the hash tag of their record with HashTagLayout.
+const chunkSuffix = ":chunk:"
+
+// manifestSuffix is appended to the record key to build the key of the manifest of a
+// chunked body, which holds its number of chunks
+const manifestSuffix = ":chunks"
+
+// chunkKeyPattern matches the keys of body chunks and chunk manifests
+var chunkKeyPattern = regexp.MustCompile(`(` + regexp.QuoteMeta(chunkSuffix) + `\d+|` + regexp.QuoteMeta(manifestSuffix) + `)$`)
+
+// SetChunkSize enables chunked storage of cached response bodies larger than size bytes:
+// the body is split across size-bound keys next to the record, which only keeps a
+// manifest, and reassembled by Get. This keeps large responses cacheable without
+// oversized Redis values. It must be called before the storage is used.
+// Default: 0 (bodies are stored inline)
+func (s *RedisStorage) SetChunkSize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("chunk size must not be negative, got %d", size)
+	}
+	s.chunkSize = size
+	return nil
+}
+
+// chunkKey returns the Redis key of the i-th body chunk of the record for key
+func (s *RedisStorage) chunkKey(key string, i int) string {
+	return s.recordKey(key) + chunkSuffix + strconv.Itoa(i)
+}
+
+// manifestKey returns the Redis key of the chunk manifest of the record for key
+func (s *RedisStorage) manifestKey(key string) string {
+	return s.recordKey(key) + manifestSuffix
+}
+
+// writeChunked issues the commands storing record with its response body split in chunks
+// with cmd, a transaction pipeline. The record itself is stored without body, and the
+// number of chunks in a manifest key next to it. Chunks are written before the record
+// in a single transaction, so readers never see a partial body.
+func (s *RedisStorage) writeChunked(ctx context.Context, cmd redis.Cmdable, record *idempotency.Record, ttl time.Duration) error {
+	body := record.Response.Body
+	response := *record.Response
+	response.Body = nil
+	stripped := *record
+	stripped.Response = &response
+
+	data, err := s.recordCodec().Marshal(&stripped)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	chunks := (len(body) + s.chunkSize - 1) / s.chunkSize
+	for i := 0; i < chunks; i++ {
+		end := min((i+1)*s.chunkSize, len(body))
+		cmd.Set(ctx, s.chunkKey(record.Key, i), body[i*s.chunkSize:end], ttl)
+	}
+	cmd.Set(ctx, s.manifestKey(record.Key), chunks, ttl)
+	cmd.Set(ctx, s.recordKey(record.Key), data, ttl)
+	return nil
+}
+
+// chunkCount returns the number of body chunks of the record for key, zero when its
+// body is stored inline
+func (s *RedisStorage) chunkCount(ctx context.Context, key string) (int, error) {
+	chunks, err := s.client.Get(ctx, s.manifestKey(key)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return chunks, err
+}
+
+// loadChunks reassembles the chunked response body of record
+func (s *RedisStorage) loadChunks(ctx context.Context, record *idempotency.Record, chunks int) error {
+	keys := make([]string, chunks)
+	for i := range keys {
+		keys[i] = s.chunkKey(record.Key, i)
+	}
+
+	// Chunks of a record share its hash tag with HashTagLayout, as MGET requires in Redis Cluster
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return idempotency.NewStorageError("get", err)
+	}
+
+	var body []byte
+	for i, value := range values {
+		chunk, ok := value.(string)
+		if !ok {
+			return idempotency.NewStorageError("get", fmt.Errorf("missing chunk %d of %d", i, chunks))
+		}
+		body = append(body, chunk...)
+	}
+	if record.Response != nil {
+		record.Response.Body = body
+	}
+	return nil
+}
+
+// chunkKeys returns the manifest and body chunk keys of the record for key, or nil if
+// its body is stored inline
+func (s *RedisStorage) chunkKeys(ctx context.Context, key string) []string {
+	chunks, err := s.chunkCount(ctx, key)
+	if err != nil || chunks == 0 {
+		return nil
+	}
+
+	keys := []string{s.manifestKey(key)}
+	for i := 0; i < chunks; i++ {
+		keys = append(keys, s.chunkKey(key, i))
+	}
+	return keys
+}
+
+// isChunkKey reports whether redisKey is the key of a body chunk or chunk manifest
+func isChunkKey(redisKey string) bool {
+	return chunkKeyPattern.MatchString(redisKey)
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"context"
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// expiredEvents is the keyspace notification pattern of expired keys in every database
+const expiredEvents = "__keyevent@*__:expired"
+
+// OnExpired registers fn, called with the key of every record expired by Redis.
+// The value is gone once Redis reports the expiration, so fn always receives a nil record.
+//
+// It relies on keyspace notifications for expired events, which must be enabled on the
+// server (CONFIG SET notify-keyspace-events Ex). Use a KeyLayout with a prefix so keys of
+// other applications sharing the database are not reported.
+// It implements idempotency.ExpiryNotifier; the subscription ends with Close.
+func (s *RedisStorage) OnExpired(fn func(key string, record *idempotency.Record)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsub := s.client.PSubscribe(ctx, expiredEvents)
+	s.stopExpired = cancel
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				if key, ok := s.keyOf(msg.Payload); ok {
+					fn(key, nil)
+				}
+			}
+		}
+	}()
+}
+
+// keyOf returns the idempotency key of a Redis record key, reporting false for lock and
+// chunk keys and keys that do not match the record layout
+func (s *RedisStorage) keyOf(redisKey string) (string, bool) {
+	if isChunkKey(redisKey) {
+		return "", false
+	}
+	if _, ok := matchTemplate(s.lockKey(KeyPlaceholder), redisKey); ok {
+		return "", false
+	}
+	return matchTemplate(s.recordKey(KeyPlaceholder), redisKey)
+}
+
+// matchTemplate extracts the value of KeyPlaceholder from s if it matches template
+func matchTemplate(template, s string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(template, KeyPlaceholder)
+	if len(s) <= len(prefix)+len(suffix) || !strings.HasPrefix(s, prefix) || !strings.HasSuffix(s, suffix) {
+		return "", false
+	}
+	return s[len(prefix) : len(s)-len(suffix)], true
+}
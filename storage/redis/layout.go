@@ -0,0 +1,112 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyPlaceholder is replaced by the idempotency key in KeyLayout templates
+const KeyPlaceholder = "<key>"
+
+// KeyLayout defines the Redis keys used for a record and its lock.
+// Each template must contain KeyPlaceholder, and the templates must not overlap: no
+// record key may be the lock key of another idempotency key.
+type KeyLayout struct {
+	// Record is the template of the record key
+	// Default: "rec:<key>"
+	Record string
+
+	// Lock is the template of the lock key
+	// Default: "lock:<key>"
+	Lock string
+}
+
+// DefaultKeyLayout stores records and locks under the disjoint "rec:" and "lock:"
+// namespaces
+var DefaultKeyLayout = KeyLayout{
+	Record: "rec:" + KeyPlaceholder,
+	Lock:   "lock:" + KeyPlaceholder,
+}
+
+// HashTagLayout stores a record and its lock under the same hash tag, so they always
+// share a Redis Cluster slot and can be used together in Lua scripts and transactions
+var HashTagLayout = KeyLayout{
+	Record: "idem:{<key>}",
+	Lock:   "idem:{<key>}:lock",
+}
+
+// SetKeyLayout changes the Redis keys used for records and locks.
+// It must be called before the storage is used. Overlapping templates are rejected. With a *redis.ClusterClient or a
+// *redis.Ring, both templates must put the key in the same hash tag (see HashTagLayout).
+func (s *RedisStorage) SetKeyLayout(layout KeyLayout) error {
+	if layout.Record == "" {
+		layout.Record = DefaultKeyLayout.Record
+	}
+	if layout.Lock == "" {
+		layout.Lock = DefaultKeyLayout.Lock
+	}
+	if !strings.Contains(layout.Record, KeyPlaceholder) || !strings.Contains(layout.Lock, KeyPlaceholder) {
+		return fmt.Errorf("key layout templates must contain %q", KeyPlaceholder)
+	}
+	if overlap(layout.Record, layout.Lock) {
+		return fmt.Errorf("record and lock templates overlap: %q and %q can name the same Redis key", layout.Record, layout.Lock)
+	}
+	if s.sharded() && (hashTag(layout.Record) == "" || hashTag(layout.Record) != hashTag(layout.Lock)) {
+		return fmt.Errorf("record and lock templates must share a hash tag containing %q with a sharded client", KeyPlaceholder)
+	}
+	s.layout = layout
+	return nil
+}
+
+// overlap reports whether some key expanded in template a equals another expanded in
+// template b. That happens when the text before the key of one template is a prefix of
+// the other's, and the text after the key of one is a suffix of the other's.
+func overlap(a, b string) bool {
+	prefixA, suffixA := a[:strings.Index(a, KeyPlaceholder)], a[strings.LastIndex(a, KeyPlaceholder)+len(KeyPlaceholder):]
+	prefixB, suffixB := b[:strings.Index(b, KeyPlaceholder)], b[strings.LastIndex(b, KeyPlaceholder)+len(KeyPlaceholder):]
+	prefixes := strings.HasPrefix(prefixA, prefixB) || strings.HasPrefix(prefixB, prefixA)
+	suffixes := strings.HasSuffix(suffixA, suffixB) || strings.HasSuffix(suffixB, suffixA)
+	return prefixes && suffixes
+}
+
+// sharded reports whether the client spreads keys over several nodes by hash slot
+func (s *RedisStorage) sharded() bool {
+	switch s.client.(type) {
+	case *redis.ClusterClient, *redis.Ring:
+		return true
+	default:
+		return false
+	}
+}
+
+// hashTag returns the hash tag of a template ("{<key>}" in "idem:{<key>}"), or "" if
+// the template has none or the tag does not contain the key
+func hashTag(template string) string {
+	_, rest, ok := strings.Cut(template, "{")
+	if !ok {
+		return ""
+	}
+	tag, _, ok := strings.Cut(rest, "}")
+	if !ok || !strings.Contains(tag, KeyPlaceholder) {
+		return ""
+	}
+	return tag
+}
+
+// recordKey returns the Redis key of the record for key
+func (s *RedisStorage) recordKey(key string) string {
+	if s.layout.Record == "" {
+		return "rec:" + key
+	}
+	return strings.ReplaceAll(s.layout.Record, KeyPlaceholder, key)
+}
+
+// lockKey returns the Redis key of the lock for key
+func (s *RedisStorage) lockKey(key string) string {
+	if s.layout.Lock == "" {
+		return "lock:" + key
+	}
+	return strings.ReplaceAll(s.layout.Lock, KeyPlaceholder, key)
+}
@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/redis/go-redis/v9"
+)
+
+// scanCount is the number of keys requested by each SCAN iteration
+const scanCount = 1000
+
+// List returns all non-expired records. Record keys are found with SCAN, on every
+// master of a Redis Cluster, so listing does not block the server but is not an atomic
+// snapshot. Values that do not decode as records are skipped: with a KeyLayout
+// without prefix, every key of the database matches the record layout.
+// It implements idempotency.RecordLister.
+func (s *RedisStorage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	prefix, suffix, _ := strings.Cut(s.recordKey(KeyPlaceholder), KeyPlaceholder)
+	pattern := escapeGlob(prefix) + "*" + escapeGlob(suffix)
+
+	var mu sync.Mutex
+	var keys []string
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, pattern, scanCount).Iterator()
+		for iter.Next(ctx) {
+			if key, ok := s.keyOf(iter.Val()); ok {
+				mu.Lock()
+				keys = append(keys, key)
+				mu.Unlock()
+			}
+		}
+		return iter.Err()
+	}
+
+	var err error
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+	} else {
+		err = scan(ctx, s.client)
+	}
+	if err != nil {
+		return nil, idempotency.NewStorageError("list", err)
+	}
+
+	records := make([]*idempotency.Record, 0, len(keys))
+	for _, key := range keys {
+		record, err := s.Get(ctx, key)
+		if err != nil {
+			var storageErr *idempotency.StorageError
+			if errors.As(err, &storageErr) {
+				return nil, err
+			}
+			continue
+		}
+		// Expired or deleted since the scan
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// escapeGlob escapes the characters of s that are special in SCAN MATCH patterns
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -5,7 +5,7 @@ package redis
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
 	"time"
 
@@ -14,35 +14,143 @@ import (
 )
 
 // RedisStorage implements the idempotency.Storage interface using Redis.
-// It uses JSON serialization to store the idempotency records and
-// Redis distributed locking to handle concurrent requests.
+// It serializes the idempotency records with a codec (JSON by default) and
+// uses Redis distributed locking to handle concurrent requests.
 type RedisStorage struct {
-	client *redis.Client
+	client    redis.UniversalClient
+	layout    KeyLayout
+	chunkSize int
+	codec     idempotency.Codec
+
+	// stopExpired ends the expired events subscription started by OnExpired
+	stopExpired context.CancelFunc
+
+	// shared is set when the client belongs to the application (NewFromClient), so
+	// Close leaves it open
+	shared bool
+}
+
+// Options configures the connection of NewRedisStorageWithOptions
+type Options struct {
+	// Addr is the "host:port" address of the server
+	// Default: "localhost:6379"
+	Addr string
+
+	// Username is the ACL username, for Redis 6+ and managed offerings (optional)
+	Username string
+
+	// Password is the ACL or legacy AUTH password (optional)
+	Password string
+
+	// DB is the logical database index, to keep records apart from the other data of
+	// a shared server (not supported by Redis Cluster)
+	// Default: 0
+	DB int
+
+	// KeyPrefix prefixes the Redis keys of records and locks (e.g. "idem:"), so they do
+	// not collide with other data in a shared instance. Records are stored under
+	// KeyPrefix + "rec:" (optional)
+	KeyPrefix string
+
+	// LockPrefix prefixes the Redis key of every lock. It must not overlap the record
+	// keys (see KeyLayout).
+	// Default: KeyPrefix + "lock:"
+	LockPrefix string
+
+	// TLSConfig enables TLS, required by most managed offerings (ElastiCache, Upstash...)
+	// (optional)
+	TLSConfig *tls.Config
+
+	// PoolSize is the maximum number of socket connections
+	// Default: 10 per CPU
+	PoolSize int
+
+	// MinIdleConns is the minimum number of idle connections kept open
+	// Default: 0
+	MinIdleConns int
+
+	// DialTimeout is the timeout for establishing new connections
+	// Default: 5s
+	DialTimeout time.Duration
+
+	// ReadTimeout is the timeout for socket reads
+	// Default: 3s
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the timeout for socket writes
+	// Default: ReadTimeout
+	WriteTimeout time.Duration
+
+	// PoolTimeout is how long a command waits for a connection when all are busy
+	// Default: ReadTimeout + 1s
+	PoolTimeout time.Duration
 }
 
 // NewRedisStorage initializes a new Redis client and checks the connection.
 // addr should be in the format "host:port". password can be empty if no auth is required.
+// Use NewRedisStorageWithOptions for TLS, ACL users, database index or pool settings.
 func NewRedisStorage(ctx context.Context, addr string, password string) (*RedisStorage, error) {
+	return NewRedisStorageWithOptions(ctx, Options{Addr: addr, Password: password})
+}
+
+// NewRedisStorageWithOptions initializes a new Redis client with the given connection
+// options and checks the connection.
+func NewRedisStorageWithOptions(ctx context.Context, opts Options) (*RedisStorage, error) {
+	if opts.LockPrefix == "" {
+		opts.LockPrefix = opts.KeyPrefix + "lock:"
+	}
+	s := &RedisStorage{}
+	err := s.SetKeyLayout(KeyLayout{
+		Record: opts.KeyPrefix + DefaultKeyLayout.Record,
+		Lock:   opts.LockPrefix + KeyPlaceholder,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       0,
+		Addr:         opts.Addr,
+		Username:     opts.Username,
+		Password:     opts.Password,
+		DB:           opts.DB,
+		TLSConfig:    opts.TLSConfig,
+		PoolSize:     opts.PoolSize,
+		MinIdleConns: opts.MinIdleConns,
+		DialTimeout:  opts.DialTimeout,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		PoolTimeout:  opts.PoolTimeout,
 	})
 
 	// Verify that the connection is active
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to redis: %w", err)
 	}
 
-	return &RedisStorage{
-		client: client,
-	}, nil
+	s.client = client
+	return s, nil
+}
+
+// NewFromClient creates a storage using an existing client, so applications reuse the
+// client they already configured (TLS, auth, database, pool, hooks): a *redis.Client,
+// *redis.ClusterClient, *redis.Ring or failover client. The connection is not checked
+// and Close does not close the client, which remains owned by the application.
+//
+// Clients spreading keys over several nodes (*redis.ClusterClient, *redis.Ring) use
+// HashTagLayout, so a record and its lock share a slot as the atomic operations require.
+func NewFromClient(client redis.UniversalClient) *RedisStorage {
+	s := &RedisStorage{client: client, shared: true}
+	if s.sharded() {
+		s.layout = HashTagLayout
+	}
+	return s
 }
 
 // Get retrieves an idempotency record from Redis by its key.
 // If the key is not found, it returns (nil, nil) instead of an error.
 func (s *RedisStorage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
-	val, err := s.client.Get(ctx, key).Result()
+	val, err := s.client.Get(ctx, s.recordKey(key)).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil // Record doesn't exist in Redis
@@ -50,33 +158,100 @@ func (s *RedisStorage) Get(ctx context.Context, key string) (*idempotency.Record
 		return nil, idempotency.NewStorageError("get", err)
 	}
 
-	var r idempotency.Record
-	if err := json.Unmarshal([]byte(val), &r); err != nil {
+	r, err := s.recordCodec().Unmarshal([]byte(val))
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal record: %w", err)
 	}
 
-	return &r, nil
+	// Chunked bodies are stripped from the stored record
+	if s.chunkSize > 0 && r.Response != nil && len(r.Response.Body) == 0 {
+		chunks, err := s.chunkCount(ctx, key)
+		if err != nil {
+			return nil, idempotency.NewStorageError("get", err)
+		}
+		if chunks > 0 {
+			if err := s.loadChunks(ctx, r, chunks); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r, nil
 }
 
 // Set saves an idempotency record in Redis with a specific expiration time (TTL).
-// The record is serialized to JSON before being stored.
+// The record is serialized with the storage codec before being stored.
 func (s *RedisStorage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
-	data, err := json.Marshal(record)
+	var err error
+	if s.chunkSize > 0 {
+		// Chunks, manifest and record are written in a single transaction
+		_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return s.writeRecord(ctx, pipe, record, ttl)
+		})
+	} else {
+		err = s.writeRecord(ctx, s.client, record, ttl)
+	}
+	if err != nil {
+		return err
+	}
+	return s.notifyDone(ctx, record)
+}
+
+// writeRecord issues the commands storing record with cmd, either the client or a
+// transaction pipeline
+func (s *RedisStorage) writeRecord(ctx context.Context, cmd redis.Cmdable, record *idempotency.Record, ttl time.Duration) error {
+	if s.chunkSize > 0 && record.Response != nil && len(record.Response.Body) > s.chunkSize {
+		return s.writeChunked(ctx, cmd, record, ttl)
+	}
+
+	data, err := s.recordCodec().Marshal(record)
 	if err != nil {
 		return fmt.Errorf("failed to marshal record: %w", err)
 	}
 	// Use the standard SET command with expiration
-	return s.client.Set(ctx, record.Key, data, ttl).Err()
+	if err := cmd.Set(ctx, s.recordKey(record.Key), data, ttl).Err(); err != nil {
+		return err
+	}
+	if s.chunkSize > 0 {
+		// Drop the manifest of a previously chunked body so it is not reassembled
+		return cmd.Del(ctx, s.manifestKey(record.Key)).Err()
+	}
+	return nil
 }
 
-// Delete removes an idempotency record from Redis.
+// notifyDone wakes up duplicates waiting on another instance once record is no longer pending
+func (s *RedisStorage) notifyDone(ctx context.Context, record *idempotency.Record) error {
+	if record.Status != idempotency.StatusPending {
+		return s.client.Publish(ctx, s.doneChannel(record.Key), string(record.Status)).Err()
+	}
+	return nil
+}
+
+// SetCodec sets the codec used to serialize records.
+func (s *RedisStorage) SetCodec(codec idempotency.Codec) {
+	s.codec = codec
+}
+
+// recordCodec returns the codec used to serialize records, JSON if none was set.
+func (s *RedisStorage) recordCodec() idempotency.Codec {
+	if s.codec == nil {
+		return idempotency.JSONCodec
+	}
+	return s.codec
+}
+
+// Delete removes an idempotency record from Redis, with its body chunks if any.
 func (s *RedisStorage) Delete(ctx context.Context, key string) error {
-	return s.client.Del(ctx, key).Err()
+	keys := []string{s.recordKey(key)}
+	if s.chunkSize > 0 {
+		keys = append(keys, s.chunkKeys(ctx, key)...)
+	}
+	return s.client.Del(ctx, keys...).Err()
 }
 
 // Exists checks if an idempotency record exists in Redis for the given key.
 func (s *RedisStorage) Exists(ctx context.Context, key string) (bool, error) {
-	n, err := s.client.Exists(ctx, key).Result()
+	n, err := s.client.Exists(ctx, s.recordKey(key)).Result()
 	return n > 0, err
 }
 
@@ -84,7 +259,7 @@ func (s *RedisStorage) Exists(ctx context.Context, key string) (bool, error) {
 // It uses "SET key value NX TTL" to ensure only one client can hold the lock.
 // This prevents multiple identical requests from being processed simultaneously.
 func (s *RedisStorage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
-	res, err := s.client.SetArgs(ctx, "lock:"+key, "1", redis.SetArgs{
+	res, err := s.client.SetArgs(ctx, s.lockKey(key), "1", redis.SetArgs{
 		Mode: "NX", // Only set if the key does NOT exist
 		TTL:  ttl,
 	}).Result()
@@ -98,12 +273,88 @@ func (s *RedisStorage) TryLock(ctx context.Context, key string, ttl time.Duratio
 	return res == "OK", nil
 }
 
+// ExtendLock makes the lock held for key expire ttl from now with "SET key value XX TTL",
+// reporting false if it expired or was released.
+// It implements idempotency.LockExtender.
+func (s *RedisStorage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	res, err := s.client.SetArgs(ctx, s.lockKey(key), "1", redis.SetArgs{
+		Mode: "XX", // Only set if the key still exists
+		TTL:  ttl,
+	}).Result()
+
+	if err != nil {
+		if err == redis.Nil {
+			return false, nil // The lock is gone
+		}
+		return false, err
+	}
+	return res == "OK", nil
+}
+
+// LockTTL returns the time until the lock held for key expires with PTTL, 0 when it is
+// not held. It implements idempotency.LockTTLReader.
+func (s *RedisStorage) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, s.lockKey(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL returns -2 for missing keys and -1 for keys without expiry
+	return max(ttl, 0), nil
+}
+
 // Unlock releases the distributed lock for the given key by deleting it.
+// Waiting duplicates are notified so they can re-check the record.
 func (s *RedisStorage) Unlock(ctx context.Context, key string) error {
-	return s.client.Del(ctx, "lock:"+key).Err()
+	if err := s.client.Del(ctx, s.lockKey(key)).Err(); err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, s.doneChannel(key), "unlocked").Err()
 }
 
-// Close terminates the Redis client connection.
+// WaitForCompletion blocks until the record for key is no longer pending.
+// It subscribes to a per-key Pub/Sub channel published by Set and Unlock, so a
+// duplicate waiting on one instance is woken as soon as the original request
+// completes on another one, without polling.
+func (s *RedisStorage) WaitForCompletion(ctx context.Context, key string) error {
+	pubsub := s.client.Subscribe(ctx, s.doneChannel(key))
+	defer pubsub.Close()
+
+	// Make sure the subscription is active before checking the current state,
+	// otherwise a completion happening in between would be missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return idempotency.NewStorageError("wait", err)
+	}
+
+	record, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if record == nil || record.Status != idempotency.StatusPending {
+		return nil
+	}
+
+	select {
+	case <-pubsub.Channel():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doneChannel is the Pub/Sub channel notified when the record for key completes. It
+// follows the record layout so storages with different prefixes do not share channels.
+func (s *RedisStorage) doneChannel(key string) string {
+	return "idempotency:done:" + s.recordKey(key)
+}
+
+// Close terminates the Redis client connection, unless the client was given to
+// NewFromClient.
 func (s *RedisStorage) Close() error {
+	if s.stopExpired != nil {
+		s.stopExpired()
+	}
+	if s.shared {
+		return nil
+	}
 	return s.client.Close()
 }
@@ -1,7 +1,9 @@
 package redis
 
 import (
+	"bytes"
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -85,6 +87,19 @@ func TestRedisStorage_CompleteFlow(t *testing.T) {
 		}
 	})
 
+	t.Run("ExtendLock", func(t *testing.T) {
+		if held, _ := storage.ExtendLock(ctx, "extend-key", time.Hour); held {
+			t.Error("expected a lock not held not to be extended")
+		}
+		_, _ = storage.TryLock(ctx, "extend-key", time.Second)
+		if held, err := storage.ExtendLock(ctx, "extend-key", time.Hour); err != nil || !held {
+			t.Fatalf("expected the lock to be extended, got %v, %v", held, err)
+		}
+		if ttl := mr.TTL("lock:extend-key"); ttl != time.Hour {
+			t.Errorf("expected the lock TTL to be extended, got %s", ttl)
+		}
+	})
+
 	// Sub-test: Deleting a record
 	t.Run("DeleteRecord", func(t *testing.T) {
 		err := storage.Delete(ctx, key)
@@ -111,7 +126,7 @@ func TestRedisStorage_CompleteFlow(t *testing.T) {
 
 	t.Run("Get_UnmarshalError", func(t *testing.T) {
 		// Setup bad data in redis manually
-		err := client.Set(ctx, "bad-json-key", "{invalid json}", time.Hour).Err()
+		err := client.Set(ctx, "rec:bad-json-key", "{invalid json}", time.Hour).Err()
 		if err != nil {
 			t.Fatalf("Failed to setup bad json: %v", err)
 		}
@@ -122,6 +137,45 @@ func TestRedisStorage_CompleteFlow(t *testing.T) {
 		}
 	})
 
+	t.Run("WaitForCompletion", func(t *testing.T) {
+		waitKey := "wait-key"
+		pending := &idempotency.Record{Key: waitKey, Status: idempotency.StatusPending}
+		if err := storage.Set(ctx, pending, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			done <- storage.WaitForCompletion(waitCtx, waitKey)
+		}()
+
+		// Give the waiter time to subscribe, then complete the record
+		time.Sleep(50 * time.Millisecond)
+		completed := &idempotency.Record{Key: waitKey, Status: idempotency.StatusCompleted}
+		if err := storage.Set(ctx, completed, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		if err := <-done; err != nil {
+			t.Fatalf("WaitForCompletion failed: %v", err)
+		}
+
+		// Already completed records return immediately
+		if err := storage.WaitForCompletion(ctx, waitKey); err != nil {
+			t.Fatalf("WaitForCompletion on completed record failed: %v", err)
+		}
+
+		// Timeout while still pending
+		_ = storage.Set(ctx, pending, time.Hour)
+		shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if err := storage.WaitForCompletion(shortCtx, waitKey); err == nil {
+			t.Error("Expected timeout error while record is pending")
+		}
+	})
+
 	t.Run("NewRedisStorage_ConnectionError", func(t *testing.T) {
 		// Try to connect to an invalid port to simulate connection failure
 		_, err := NewRedisStorage(ctx, "localhost:99999", "")
@@ -137,3 +191,519 @@ func TestRedisStorage_CompleteFlow(t *testing.T) {
 		}
 	})
 }
+
+func TestRedisStorage_KeyLayout(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	storage := &RedisStorage{client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	ctx := context.Background()
+
+	t.Run("InvalidTemplate", func(t *testing.T) {
+		if err := storage.SetKeyLayout(KeyLayout{Record: "idem"}); err == nil {
+			t.Error("expected error for template without placeholder")
+		}
+		if err := storage.SetKeyLayout(KeyLayout{Record: "x:<key>", Lock: "x:<key>"}); err == nil {
+			t.Error("expected error for identical templates")
+		}
+		if err := storage.SetKeyLayout(KeyLayout{Record: "<key>", Lock: "lock:<key>"}); err == nil {
+			t.Error("expected error for a lock template inside the record namespace")
+		}
+		if err := storage.SetKeyLayout(KeyLayout{Record: "idem:<key>", Lock: "idem:lock:<key>"}); err == nil {
+			t.Error("expected error for a lock prefix extending the record prefix")
+		}
+	})
+
+	t.Run("HashTag", func(t *testing.T) {
+		if err := storage.SetKeyLayout(HashTagLayout); err != nil {
+			t.Fatalf("SetKeyLayout failed: %v", err)
+		}
+
+		if err := storage.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusPending}, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if _, err := storage.TryLock(ctx, "k1", time.Minute); err != nil {
+			t.Fatalf("TryLock failed: %v", err)
+		}
+
+		if !mr.Exists("idem:{k1}") || !mr.Exists("idem:{k1}:lock") {
+			t.Errorf("expected hash-tagged keys, got %v", mr.Keys())
+		}
+
+		got, err := storage.Get(ctx, "k1")
+		if err != nil || got == nil || got.Key != "k1" {
+			t.Errorf("expected record k1, got %v, %v", got, err)
+		}
+	})
+}
+
+func TestRedisStorage_OnExpired(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	storage := &RedisStorage{client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	if err := storage.SetKeyLayout(HashTagLayout); err != nil {
+		t.Fatalf("SetKeyLayout failed: %v", err)
+	}
+	defer storage.Close()
+
+	expired := make(chan string, 2)
+	storage.OnExpired(func(key string, record *idempotency.Record) {
+		expired <- key
+	})
+
+	// miniredis does not emit keyspace notifications, publish them as Redis would
+	deadline := time.Now().Add(time.Second)
+	for mr.PubSubNumPat() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	mr.Publish("__keyevent@0__:expired", "idem:{k1}:lock")
+	mr.Publish("__keyevent@0__:expired", "other-app")
+	mr.Publish("__keyevent@0__:expired", "idem:{k1}")
+
+	select {
+	case key := <-expired:
+		if key != "k1" {
+			t.Errorf("expected k1, got %q", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected expiry notification")
+	}
+	if len(expired) != 0 {
+		t.Errorf("expected lock and foreign keys to be ignored, got %q", <-expired)
+	}
+}
+
+func TestMatchTemplate(t *testing.T) {
+	tests := []struct {
+		template, key, want string
+		ok                  bool
+	}{
+		{"<key>", "k1", "k1", true},
+		{"lock:<key>", "lock:k1", "k1", true},
+		{"lock:<key>", "k1", "", false},
+		{"idem:{<key>}", "idem:{a:b}", "a:b", true},
+		{"idem:{<key>}", "idem:{}", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := matchTemplate(tt.template, tt.key)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("matchTemplate(%q, %q) = %q, %v; want %q, %v", tt.template, tt.key, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRedisStorage_ChunkedBody(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	storage := &RedisStorage{client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	if err := storage.SetChunkSize(4); err != nil {
+		t.Fatalf("SetChunkSize failed: %v", err)
+	}
+	ctx := context.Background()
+
+	record := &idempotency.Record{
+		Key:    "big",
+		Status: idempotency.StatusCompleted,
+		Response: &idempotency.CachedResponse{
+			StatusCode: 200,
+			Body:       []byte("0123456789"),
+		},
+	}
+	if err := storage.Set(ctx, record, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	if !mr.Exists("rec:big:chunk:0") || !mr.Exists("rec:big:chunk:2") || mr.Exists("rec:big:chunk:3") {
+		t.Fatalf("expected 3 chunks, got keys %v", mr.Keys())
+	}
+	if string(record.Response.Body) != "0123456789" {
+		t.Error("expected Set not to modify the record")
+	}
+
+	got, err := storage.Get(ctx, "big")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got == nil || string(got.Response.Body) != "0123456789" {
+		t.Fatalf("expected reassembled body, got %v", got)
+	}
+
+	if err := storage.Delete(ctx, "big"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("expected record and chunks to be deleted, got %v", keys)
+	}
+
+	// Small bodies stay inline
+	record.Key = "small"
+	record.Response.Body = []byte("ok")
+	_ = storage.Set(ctx, record, time.Hour)
+	if mr.Exists("rec:small:chunk:0") {
+		t.Error("expected small body to be stored inline")
+	}
+}
+
+func TestRedisStorage_Atomic(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	storage := &RedisStorage{client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	ctx := context.Background()
+	pending := &idempotency.Record{Key: "k1", Status: idempotency.StatusPending}
+
+	t.Run("TryLockAndSet", func(t *testing.T) {
+		locked, err := storage.TryLockAndSet(ctx, pending, time.Hour, time.Minute)
+		if err != nil || !locked {
+			t.Fatalf("expected the lock, got %v, %v", locked, err)
+		}
+		if !mr.Exists("lock:k1") || mr.TTL("lock:k1") != time.Minute || mr.TTL("rec:k1") != time.Hour {
+			t.Fatalf("expected lock and record with their TTLs, got keys %v", mr.Keys())
+		}
+
+		other := &idempotency.Record{Key: "k1", Status: idempotency.StatusFailed}
+		locked, err = storage.TryLockAndSet(ctx, other, time.Hour, time.Minute)
+		if err != nil || locked {
+			t.Fatalf("expected the lock to be held, got %v, %v", locked, err)
+		}
+		if got, _ := storage.Get(ctx, "k1"); got == nil || got.Status != idempotency.StatusPending {
+			t.Errorf("expected the pending record to be kept, got %v", got)
+		}
+	})
+
+	t.Run("SetAndUnlock", func(t *testing.T) {
+		completed := &idempotency.Record{
+			Key:      "k1",
+			Status:   idempotency.StatusCompleted,
+			Response: &idempotency.CachedResponse{StatusCode: 201, Body: []byte("created")},
+		}
+		if err := storage.SetAndUnlock(ctx, completed, time.Hour); err != nil {
+			t.Fatalf("SetAndUnlock failed: %v", err)
+		}
+		if mr.Exists("lock:k1") {
+			t.Error("expected the lock to be released")
+		}
+		if got, _ := storage.Get(ctx, "k1"); got == nil || got.Status != idempotency.StatusCompleted {
+			t.Errorf("expected the completed record, got %v", got)
+		}
+	})
+
+	t.Run("Chunked", func(t *testing.T) {
+		chunked := &RedisStorage{client: storage.client}
+		_ = chunked.SetChunkSize(4)
+		record := &idempotency.Record{Key: "k2", Status: idempotency.StatusPending}
+		if locked, err := chunked.TryLockAndSet(ctx, record, time.Hour, time.Minute); err != nil || !locked {
+			t.Fatalf("expected the lock, got %v, %v", locked, err)
+		}
+
+		record.Status = idempotency.StatusCompleted
+		record.Response = &idempotency.CachedResponse{StatusCode: 200, Body: []byte("0123456789")}
+		if err := chunked.SetAndUnlock(ctx, record, time.Hour); err != nil {
+			t.Fatalf("SetAndUnlock failed: %v", err)
+		}
+		if mr.Exists("lock:k2") || !mr.Exists("rec:k2:chunk:2") {
+			t.Fatalf("expected chunks and no lock, got keys %v", mr.Keys())
+		}
+		if got, _ := chunked.Get(ctx, "k2"); got == nil || string(got.Response.Body) != "0123456789" {
+			t.Errorf("expected the reassembled body, got %v", got)
+		}
+	})
+}
+
+// prefixCodec wraps JSONCodec, prefixing the encoded bytes
+type prefixCodec struct{}
+
+func (prefixCodec) Marshal(record *idempotency.Record) ([]byte, error) {
+	data, err := idempotency.JSONCodec.Marshal(record)
+	return append([]byte("codec:"), data...), err
+}
+
+func (prefixCodec) Unmarshal(data []byte) (*idempotency.Record, error) {
+	return idempotency.JSONCodec.Unmarshal(bytes.TrimPrefix(data, []byte("codec:")))
+}
+
+func TestRedisStorage_Codec(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	storage := &RedisStorage{client: redis.NewClient(&redis.Options{Addr: mr.Addr()})}
+	storage.SetCodec(prefixCodec{})
+	if err := storage.SetChunkSize(4); err != nil {
+		t.Fatalf("SetChunkSize failed: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, body := range []string{"ok", "0123456789"} {
+		record := &idempotency.Record{
+			Key:      "key",
+			Status:   idempotency.StatusCompleted,
+			Response: &idempotency.CachedResponse{StatusCode: 200, Body: []byte(body)},
+		}
+		if err := storage.Set(ctx, record, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		raw, _ := mr.Get("rec:key")
+		if !strings.HasPrefix(raw, "codec:") {
+			t.Errorf("expected record encoded with the codec, got %q", raw)
+		}
+
+		got, err := storage.Get(ctx, "key")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got == nil || string(got.Response.Body) != body {
+			t.Errorf("expected body %q, got %v", body, got)
+		}
+	}
+
+	// Storing an empty body over a chunked one must not reassemble stale chunks
+	empty := &idempotency.Record{
+		Key:      "key",
+		Status:   idempotency.StatusCompleted,
+		Response: &idempotency.CachedResponse{StatusCode: 204},
+	}
+	if err := storage.Set(ctx, empty, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	got, err := storage.Get(ctx, "key")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got == nil || len(got.Response.Body) != 0 {
+		t.Errorf("expected empty body, got %v", got)
+	}
+}
+
+func TestNewRedisStorageWithOptions(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+	mr.RequireUserAuth("app", "secret")
+	ctx := context.Background()
+
+	if _, err := NewRedisStorageWithOptions(ctx, Options{Addr: mr.Addr(), Username: "app", Password: "wrong"}); err == nil {
+		t.Error("expected authentication error")
+	}
+
+	storage, err := NewRedisStorageWithOptions(ctx, Options{
+		Addr:        mr.Addr(),
+		Username:    "app",
+		Password:    "secret",
+		DB:          2,
+		PoolSize:    4,
+		DialTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewRedisStorageWithOptions failed: %v", err)
+	}
+	defer storage.Close()
+
+	if err := storage.Set(ctx, &idempotency.Record{Key: "key", Status: idempotency.StatusCompleted}, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if !mr.DB(2).Exists("rec:key") || mr.DB(0).Exists("rec:key") {
+		t.Error("expected record to be stored in database 2")
+	}
+}
+
+func TestNewFromClient(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), DB: 2})
+	defer client.Close()
+	storage := NewFromClient(client)
+	ctx := context.Background()
+
+	if err := storage.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	mr.Select(2)
+	if !mr.Exists("rec:k1") {
+		t.Errorf("expected the record in the database of the client, got %v", mr.Keys())
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Errorf("expected the client of the application to stay open, got %v", err)
+	}
+}
+
+func TestNewFromClient_Cluster(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	defer client.Close()
+	storage := NewFromClient(client)
+	ctx := context.Background()
+
+	record := &idempotency.Record{Key: "k1", Status: idempotency.StatusPending}
+	if locked, err := storage.TryLockAndSet(ctx, record, time.Hour, time.Minute); err != nil || !locked {
+		t.Fatalf("expected TryLockAndSet to succeed, got %v, %v", locked, err)
+	}
+	if !mr.Exists("idem:{k1}") || !mr.Exists("idem:{k1}:lock") {
+		t.Errorf("expected the record and its lock to share a hash tag, got %v", mr.Keys())
+	}
+
+	record.Status = idempotency.StatusCompleted
+	if err := storage.SetAndUnlock(ctx, record, time.Hour); err != nil {
+		t.Fatalf("SetAndUnlock failed: %v", err)
+	}
+	if mr.Exists("idem:{k1}:lock") {
+		t.Error("expected the lock to be released")
+	}
+
+	if err := storage.SetKeyLayout(KeyLayout{}); err == nil {
+		t.Error("expected a layout without shared hash tag to be rejected for a cluster client")
+	}
+}
+
+func TestRedisStorage_KeyNamespaces(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+	ctx := context.Background()
+
+	storage := NewFromClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	if locked, _ := storage.TryLock(ctx, "abc", time.Minute); !locked {
+		t.Fatal("expected the lock to be acquired")
+	}
+
+	// The record of the key "lock:abc" must not be the lock of "abc"
+	if err := storage.Set(ctx, &idempotency.Record{Key: "lock:abc", Status: idempotency.StatusPending}, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := storage.Delete(ctx, "lock:abc"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if locked, _ := storage.TryLock(ctx, "abc", time.Minute); locked {
+		t.Error("expected the lock of abc to be kept")
+	}
+}
+
+func TestRedisStorage_Prefixes(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+	ctx := context.Background()
+
+	storage, err := NewRedisStorageWithOptions(ctx, Options{Addr: mr.Addr(), DB: 3, KeyPrefix: "billing:"})
+	if err != nil {
+		t.Fatalf("NewRedisStorageWithOptions failed: %v", err)
+	}
+	defer storage.Close()
+
+	_ = storage.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusPending}, time.Hour)
+	_, _ = storage.TryLock(ctx, "k1", time.Minute)
+	mr.Select(3)
+	if !mr.Exists("billing:rec:k1") || !mr.Exists("billing:lock:k1") || mr.Exists("rec:k1") {
+		t.Errorf("expected prefixed keys in database 3, got %v", mr.Keys())
+	}
+
+	_, err = NewRedisStorageWithOptions(ctx, Options{Addr: mr.Addr(), KeyPrefix: "idem:", LockPrefix: "idem:"})
+	if err == nil {
+		t.Error("expected identical record and lock prefixes to be rejected")
+	}
+	_, err = NewRedisStorageWithOptions(ctx, Options{Addr: mr.Addr(), KeyPrefix: "idem:", LockPrefix: "idem:rec:x"})
+	if err == nil {
+		t.Error("expected a lock prefix inside the record namespace to be rejected")
+	}
+}
+
+func TestRedisStorage_LockTTL(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+	ctx := context.Background()
+
+	storage, _ := NewRedisStorage(ctx, mr.Addr(), "")
+	defer storage.Close()
+
+	if ttl, err := storage.LockTTL(ctx, "k1"); err != nil || ttl != 0 {
+		t.Errorf("expected no TTL without a lock, got %s, %v", ttl, err)
+	}
+	_, _ = storage.TryLock(ctx, "k1", time.Minute)
+	mr.FastForward(20 * time.Second)
+	if ttl, err := storage.LockTTL(ctx, "k1"); err != nil || ttl != 40*time.Second {
+		t.Errorf("expected 40s left on the lock, got %s, %v", ttl, err)
+	}
+}
+
+func TestRedisStorage_List(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start miniredis: %v", err)
+	}
+	defer mr.Close()
+	ctx := context.Background()
+
+	storage, err := NewRedisStorageWithOptions(ctx, Options{Addr: mr.Addr(), KeyPrefix: "idem:"})
+	if err != nil {
+		t.Fatalf("NewRedisStorageWithOptions failed: %v", err)
+	}
+	defer storage.Close()
+	_ = storage.SetChunkSize(4)
+
+	_ = storage.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusPending}, time.Hour)
+	_, _ = storage.TryLock(ctx, "k1", time.Minute)
+	_ = storage.Set(ctx, &idempotency.Record{
+		Key:      "k2",
+		Status:   idempotency.StatusCompleted,
+		Response: &idempotency.CachedResponse{StatusCode: 200, Body: []byte("chunked body")},
+	}, time.Hour)
+	mr.Set("other:data", "not a record")
+
+	records, err := storage.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	byKey := make(map[string]*idempotency.Record)
+	for _, r := range records {
+		byKey[r.Key] = r
+	}
+	if len(records) != 2 || byKey["k1"] == nil || byKey["k2"] == nil {
+		t.Fatalf("expected records k1 and k2 only, got %v", records)
+	}
+	if string(byKey["k2"].Response.Body) != "chunked body" {
+		t.Errorf("expected the chunked body to be reassembled, got %q", byKey["k2"].Response.Body)
+	}
+
+	if got := escapeGlob(`a*b?[c]\`); got != `a\*b\?\[c\]\\` {
+		t.Errorf("unexpected escaped pattern %q", got)
+	}
+}
@@ -0,0 +1,123 @@
+package replicated
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// The optional extensions are forwarded to the local storage, locks being regional. The
+// manager only uses those the local storage implements (see
+// idempotency.StorageWrapper).
+
+// Unwrap returns the local storage. It implements idempotency.StorageWrapper.
+func (s *Storage) Unwrap() idempotency.Storage {
+	return s.local
+}
+
+// TryLockAndSet acquires the lock and stores the pending record locally, then replicates
+// the record. It implements idempotency.LockSetter.
+func (s *Storage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	setter, ok := s.local.(idempotency.LockSetter)
+	if !ok {
+		return false, unsupported("trylockandset")
+	}
+	locked, err := setter.TryLockAndSet(ctx, record, ttl, lockTTL)
+	if err != nil || !locked {
+		return locked, err
+	}
+	s.replicate(ctx, record, ttl)
+	return true, nil
+}
+
+// SetAndUnlock stores the completed record and releases its lock locally, then
+// replicates the record. It implements idempotency.SetUnlocker.
+func (s *Storage) SetAndUnlock(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	unlocker, ok := s.local.(idempotency.SetUnlocker)
+	if !ok {
+		return unsupported("setandunlock")
+	}
+	if err := unlocker.SetAndUnlock(ctx, record, ttl); err != nil {
+		return err
+	}
+	s.replicate(ctx, record, ttl)
+	return nil
+}
+
+// GetOrLock reads the record or acquires the lock locally. When the lock is acquired but
+// a peer holds a record, the record is copied locally and returned instead, so a retry
+// landing in another region is replayed. It implements idempotency.GetOrLocker.
+func (s *Storage) GetOrLock(ctx context.Context, key string, record *idempotency.Record, ttl, lockTTL time.Duration) (*idempotency.Record, bool, error) {
+	locker, ok := s.local.(idempotency.GetOrLocker)
+	if !ok {
+		return nil, false, unsupported("getorlock")
+	}
+	existing, locked, err := locker.GetOrLock(ctx, key, record, ttl, lockTTL)
+	if err != nil || !locked {
+		return existing, locked, err
+	}
+
+	if newest := s.newestPeer(ctx, key); newest != nil {
+		if err := s.local.Set(ctx, newest, ttl); err != nil {
+			return nil, false, err
+		}
+		return newest, false, s.local.Unlock(ctx, key)
+	}
+	s.replicate(ctx, record, ttl)
+	return nil, true, nil
+}
+
+// ExtendLock renews the lock in the local region. It implements
+// idempotency.LockExtender.
+func (s *Storage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	extender, ok := s.local.(idempotency.LockExtender)
+	if !ok {
+		return false, unsupported("extendlock")
+	}
+	return extender.ExtendLock(ctx, key, ttl)
+}
+
+// LockTTL returns the remaining time-to-live of the lock in the local region. It
+// implements idempotency.LockTTLReader.
+func (s *Storage) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	reader, ok := s.local.(idempotency.LockTTLReader)
+	if !ok {
+		return 0, unsupported("lockttl")
+	}
+	return reader.LockTTL(ctx, key)
+}
+
+// WaitForCompletion waits for the record in the local region. It implements
+// idempotency.CompletionWaiter.
+func (s *Storage) WaitForCompletion(ctx context.Context, key string) error {
+	waiter, ok := s.local.(idempotency.CompletionWaiter)
+	if !ok {
+		return unsupported("waitforcompletion")
+	}
+	return waiter.WaitForCompletion(ctx, key)
+}
+
+// List returns the records of the local storage. It implements
+// idempotency.RecordLister.
+func (s *Storage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	lister, ok := s.local.(idempotency.RecordLister)
+	if !ok {
+		return nil, idempotency.ErrListingNotSupported
+	}
+	return lister.List(ctx)
+}
+
+// OnExpired registers fn with the local storage. It implements
+// idempotency.ExpiryNotifier.
+func (s *Storage) OnExpired(fn func(key string, record *idempotency.Record)) {
+	if notifier, ok := s.local.(idempotency.ExpiryNotifier); ok {
+		notifier.OnExpired(fn)
+	}
+}
+
+// unsupported is returned by the extensions the local storage does not implement
+func unsupported(op string) error {
+	return idempotency.NewStorageError(op, errors.ErrUnsupported)
+}
@@ -0,0 +1,177 @@
+// Package replicated provides a storage wrapper replicating idempotency records across
+// regions for active-active deployments.
+//
+// Each region uses its own storage as the local one and the storages of the other
+// regions as peers:
+//
+//	store := replicated.New(euStore, []idempotency.Storage{usStore, apStore}, replicated.Options{})
+//	manager, _ := idempotency.NewManager(idempotency.Config{Storage: store, Region: "eu-west-1"})
+//
+// Records are written locally and to every peer. Conflicting writes are resolved with
+// last-writer-wins: a peer keeps its record when it is newer than the replicated one.
+// Reads fall back to the peers when the local storage has no record, so a retry landing
+// in another region replays the original response instead of being processed twice.
+// Locks are regional: duplicates racing in two regions before the pending record is
+// replicated can still both run, so pin keys to a home region (key.RegionPinned) when
+// strict guarantees are required.
+package replicated
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Options configures the replicated storage
+type Options struct {
+	// OnReplicationError is called when a write to a peer fails. Peer failures never
+	// fail the local operation (optional)
+	OnReplicationError func(peer int, err error)
+}
+
+// Storage replicates records from a local storage to peer storages
+type Storage struct {
+	local idempotency.Storage
+	peers []idempotency.Storage
+	opts  Options
+}
+
+// New creates a replicated storage
+func New(local idempotency.Storage, peers []idempotency.Storage, opts Options) *Storage {
+	return &Storage{
+		local: local,
+		peers: peers,
+		opts:  opts,
+	}
+}
+
+// Get returns the local record, or the newest record found on the peers
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	record, err := s.local.Get(ctx, key)
+	if err == nil && record != nil {
+		return record, nil
+	}
+
+	if newest := s.newestPeer(ctx, key); newest != nil {
+		return newest, nil
+	}
+	return record, err
+}
+
+// newestPeer returns the newest record found on the peers, nil if none has one
+func (s *Storage) newestPeer(ctx context.Context, key string) *idempotency.Record {
+	var newest *idempotency.Record
+	for _, peer := range s.peers {
+		r, err := peer.Get(ctx, key)
+		if err != nil || r == nil {
+			continue
+		}
+		if newest == nil || Newer(r, newest) {
+			newest = r
+		}
+	}
+	return newest
+}
+
+// Set writes record locally, then to every peer unless the peer holds a newer record
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	if err := s.local.Set(ctx, record, ttl); err != nil {
+		return err
+	}
+	s.replicate(ctx, record, ttl)
+	return nil
+}
+
+// replicate writes record to every peer unless the peer holds a newer record
+func (s *Storage) replicate(ctx context.Context, record *idempotency.Record, ttl time.Duration) {
+	for i, peer := range s.peers {
+		if existing, err := peer.Get(ctx, record.Key); err == nil && existing != nil && Newer(existing, record) {
+			continue
+		}
+		if err := peer.Set(ctx, record, ttl); err != nil {
+			s.replicationError(i, err)
+		}
+	}
+}
+
+// Delete removes the record locally and from every peer
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.local.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	for i, peer := range s.peers {
+		if err := peer.Delete(ctx, key); err != nil {
+			s.replicationError(i, err)
+		}
+	}
+
+	return nil
+}
+
+// Exists checks if a record exists locally or on a peer
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	record, err := s.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return record != nil, nil
+}
+
+// TryLock acquires the lock in the local region
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.local.TryLock(ctx, key, ttl)
+}
+
+// Unlock releases the lock in the local region
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	return s.local.Unlock(ctx, key)
+}
+
+// SetCodec sets the codec of the local storage and every peer serializing records
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	for _, storage := range append([]idempotency.Storage{s.local}, s.peers...) {
+		if setter, ok := storage.(idempotency.CodecSetter); ok {
+			setter.SetCodec(codec)
+		}
+	}
+}
+
+// SetClock sets the clock of the local storage and every peer computing expirations
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	for _, storage := range append([]idempotency.Storage{s.local}, s.peers...) {
+		if setter, ok := storage.(idempotency.ClockSetter); ok {
+			setter.SetClock(clock)
+		}
+	}
+}
+
+// Close closes the local storage and every peer
+func (s *Storage) Close() error {
+	errs := []error{s.local.Close()}
+	for _, peer := range s.peers {
+		errs = append(errs, peer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Storage) replicationError(peer int, err error) {
+	if s.opts.OnReplicationError != nil {
+		s.opts.OnReplicationError(peer, err)
+	}
+}
+
+// Newer reports whether a was written after b (last-writer-wins order).
+// A record is dated by the completion of its response, or by its creation while pending.
+func Newer(a, b *idempotency.Record) bool {
+	return writeTime(a).After(writeTime(b))
+}
+
+func writeTime(r *idempotency.Record) time.Time {
+	if r.Response != nil && !r.Response.CompletedAt.IsZero() {
+		return r.Response.CompletedAt
+	}
+	return r.CreatedAt
+}
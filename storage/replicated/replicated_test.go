@@ -0,0 +1,143 @@
+package replicated
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+// failingStorage is a storage whose writes always fail
+type failingStorage struct {
+	*memory.Storage
+}
+
+func (f failingStorage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	return errors.New("region unavailable")
+}
+
+func TestReplicatedStorage(t *testing.T) {
+	ctx := context.Background()
+	eu, us := memory.NewMemoryStorage(), memory.NewMemoryStorage()
+	defer eu.Close()
+	defer us.Close()
+
+	euStore := New(eu, []idempotency.Storage{us}, Options{})
+	usStore := New(us, []idempotency.Storage{eu}, Options{})
+	now := time.Now()
+
+	t.Run("ReplicatesWrites", func(t *testing.T) {
+		record := &idempotency.Record{
+			Key:       "k1",
+			Status:    idempotency.StatusCompleted,
+			CreatedAt: now,
+			Response:  &idempotency.CachedResponse{StatusCode: 201, CompletedAt: now, Region: "eu"},
+			ExpiresAt: now.Add(time.Hour),
+		}
+		if err := euStore.Set(ctx, record, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := usStore.Get(ctx, "k1")
+		if err != nil || got == nil || got.Response.Region != "eu" {
+			t.Fatalf("expected record replicated to us, got %v, %v", got, err)
+		}
+	})
+
+	t.Run("LastWriterWins", func(t *testing.T) {
+		newer := &idempotency.Record{
+			Key:       "k2",
+			Status:    idempotency.StatusCompleted,
+			CreatedAt: now,
+			Response:  &idempotency.CachedResponse{StatusCode: 201, CompletedAt: now.Add(time.Second), Region: "us"},
+			ExpiresAt: now.Add(time.Hour),
+		}
+		older := &idempotency.Record{
+			Key:       "k2",
+			Status:    idempotency.StatusPending,
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Hour),
+		}
+		_ = usStore.Set(ctx, newer, time.Hour)
+		_ = euStore.Set(ctx, older, time.Hour)
+
+		got, _ := us.Get(ctx, "k2")
+		if got == nil || got.Status != idempotency.StatusCompleted {
+			t.Fatalf("expected newer record to be kept, got %v", got)
+		}
+	})
+
+	t.Run("ReadsFallBackToPeers", func(t *testing.T) {
+		_ = us.Set(ctx, &idempotency.Record{Key: "k3", Status: idempotency.StatusPending, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, time.Hour)
+
+		exists, err := euStore.Exists(ctx, "k3")
+		if err != nil || !exists {
+			t.Fatalf("expected record found on peer, got %v, %v", exists, err)
+		}
+	})
+
+	t.Run("DeleteEverywhere", func(t *testing.T) {
+		if err := euStore.Delete(ctx, "k1"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if exists, _ := us.Exists(ctx, "k1"); exists {
+			t.Error("expected record deleted from peer")
+		}
+	})
+
+	t.Run("ReplicationError", func(t *testing.T) {
+		down := failingStorage{memory.NewMemoryStorage()}
+		var failedPeer = -1
+		store := New(eu, []idempotency.Storage{down}, Options{
+			OnReplicationError: func(peer int, err error) { failedPeer = peer },
+		})
+
+		if err := store.Set(ctx, &idempotency.Record{Key: "k4", CreatedAt: now}, time.Hour); err != nil {
+			t.Fatalf("expected local write to succeed, got %v", err)
+		}
+		if failedPeer != 0 {
+			t.Errorf("expected replication error for peer 0, got %d", failedPeer)
+		}
+	})
+}
+
+func TestReplicatedStorage_Extensions(t *testing.T) {
+	ctx := context.Background()
+	eu, us := memory.NewMemoryStorage(), memory.NewMemoryStorage()
+	defer eu.Close()
+	defer us.Close()
+	store := New(eu, []idempotency.Storage{us}, Options{})
+
+	var s idempotency.Storage = store
+	if !idempotency.Supports[idempotency.GetOrLocker](s) || !idempotency.Supports[idempotency.LockExtender](s) ||
+		!idempotency.Supports[idempotency.LockTTLReader](s) || idempotency.Supports[idempotency.LockSetter](s) {
+		t.Error("expected the extensions of the local storage, and only them, to be supported")
+	}
+
+	// The lock is regional, the pending record is replicated
+	if _, locked, err := store.GetOrLock(ctx, "k1", &idempotency.Record{Key: "k1", Status: idempotency.StatusPending}, time.Hour, time.Minute); err != nil || !locked {
+		t.Fatalf("expected GetOrLock to acquire the lock, got %v, %v", locked, err)
+	}
+	if ttl, _ := store.LockTTL(ctx, "k1"); ttl <= 0 {
+		t.Errorf("expected the local lock to be held, got %v", ttl)
+	}
+	if held, _ := store.ExtendLock(ctx, "k1", time.Minute); !held {
+		t.Error("expected ExtendLock to renew the local lock")
+	}
+	if got, _ := us.Get(ctx, "k1"); got == nil {
+		t.Error("expected the pending record to be replicated")
+	}
+
+	// A record completed in another region is replayed instead of locking
+	_ = us.Set(ctx, &idempotency.Record{Key: "k2", Status: idempotency.StatusCompleted, CreatedAt: time.Now()}, time.Hour)
+	existing, locked, err := store.GetOrLock(ctx, "k2", &idempotency.Record{Key: "k2", Status: idempotency.StatusPending}, time.Hour, time.Minute)
+	if err != nil || locked || existing == nil || existing.Status != idempotency.StatusCompleted {
+		t.Errorf("expected the record of the peer, got %+v, %v, %v", existing, locked, err)
+	}
+	if ttl, _ := eu.LockTTL(ctx, "k2"); ttl != 0 {
+		t.Error("expected the local lock to be released")
+	}
+}
@@ -0,0 +1,171 @@
+// Package ristretto provides a high-performance in-memory storage backend for gopotency,
+// built on the Ristretto cache. Records are bounded by a memory budget with cost-based
+// eviction and TTL, and locks are kept in sharded maps, so very high RPS single-node
+// services do not contend on the single mutex of the memory backend.
+//
+// Like any cache, records may be evicted before their TTL when the budget is exceeded,
+// after which a retry is processed again. Size MaxCost for the expected traffic.
+package ristretto
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/ristretto/v2"
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// lockShards is the number of lock map shards
+const lockShards = 64
+
+// recordOverhead is the estimated cost in bytes of a record besides its key, headers and body
+const recordOverhead = 256
+
+// errRejected is returned when the cache rejects a record, e.g. under heavy contention
+var errRejected = errors.New("record rejected by the cache")
+
+// Options configures the Ristretto storage
+type Options struct {
+	// MaxCost is the memory budget of the records in bytes
+	// Default: 64 MiB
+	MaxCost int64
+
+	// NumCounters is the number of keys tracked to decide admissions and evictions,
+	// ideally 10 times the number of records expected to fit in MaxCost
+	// Default: MaxCost / 100 (records of about 1 KiB)
+	NumCounters int64
+}
+
+// Storage is a Ristretto implementation of idempotency.Storage
+type Storage struct {
+	cache *ristretto.Cache[string, *idempotency.Record]
+	locks [lockShards]lockShard
+	seed  maphash.Seed
+}
+
+// lockShard holds the locks of the keys hashed to it
+type lockShard struct {
+	mu    sync.Mutex
+	locks map[string]time.Time
+}
+
+// New creates a new Ristretto storage
+func New(opts Options) (*Storage, error) {
+	if opts.MaxCost <= 0 {
+		opts.MaxCost = 64 << 20
+	}
+	if opts.NumCounters <= 0 {
+		opts.NumCounters = max(opts.MaxCost/100, 1000)
+	}
+
+	cache, err := ristretto.NewCache(&ristretto.Config[string, *idempotency.Record]{
+		NumCounters: opts.NumCounters,
+		MaxCost:     opts.MaxCost,
+		BufferItems: 64,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Storage{cache: cache, seed: maphash.MakeSeed()}
+	for i := range s.locks {
+		s.locks[i].locks = make(map[string]time.Time)
+	}
+	return s, nil
+}
+
+// Get retrieves an idempotency record by key
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	record, ok := s.cache.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// Set stores an idempotency record. It waits for the write to be applied, so the record
+// is visible to the next Get.
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	if !s.cache.SetWithTTL(record.Key, record, cost(record), ttl) {
+		return idempotency.NewStorageError("set", errRejected)
+	}
+	s.cache.Wait()
+	return nil
+}
+
+// cost estimates the memory used by record in bytes
+func cost(record *idempotency.Record) int64 {
+	c := int64(recordOverhead + len(record.Key) + len(record.RequestHash) + len(record.Error))
+	if record.Response != nil {
+		c += int64(len(record.Response.Body))
+		for name, values := range record.Response.Headers {
+			c += int64(len(name))
+			for _, value := range values {
+				c += int64(len(value))
+			}
+		}
+	}
+	return c
+}
+
+// Delete removes an idempotency record and its lock
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	s.cache.Del(key)
+	s.cache.Wait()
+	_ = s.Unlock(ctx, key)
+	return nil
+}
+
+// Exists checks if a record exists
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	_, ok := s.cache.Get(key)
+	return ok, nil
+}
+
+// TryLock attempts to acquire a lock for the given key
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now()
+	if expiry, held := shard.locks[key]; held && now.Before(expiry) {
+		return false, nil
+	}
+
+	// Drop the other expired locks of the shard while it is locked anyway
+	if len(shard.locks) > 1024 {
+		for k, expiry := range shard.locks {
+			if now.After(expiry) {
+				delete(shard.locks, k)
+			}
+		}
+	}
+
+	shard.locks[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Unlock releases a lock for the given key
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	delete(shard.locks, key)
+	return nil
+}
+
+// shard returns the lock shard of key
+func (s *Storage) shard(key string) *lockShard {
+	return &s.locks[maphash.String(s.seed, key)%lockShards]
+}
+
+// Close stops the cache and releases its memory
+func (s *Storage) Close() error {
+	s.cache.Close()
+	return nil
+}
@@ -0,0 +1,116 @@
+package ristretto
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+func TestRistrettoStorage(t *testing.T) {
+	store, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	t.Run("SetAndGet", func(t *testing.T) {
+		record := &idempotency.Record{
+			Key:      "key1",
+			Status:   idempotency.StatusCompleted,
+			Response: &idempotency.CachedResponse{StatusCode: 200, Body: []byte("ok")},
+		}
+		if err := store.Set(ctx, record, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := store.Get(ctx, "key1")
+		if err != nil || got == nil || string(got.Response.Body) != "ok" {
+			t.Fatalf("expected stored record to be visible immediately, got %v, %v", got, err)
+		}
+		if exists, _ := store.Exists(ctx, "key1"); !exists {
+			t.Error("expected record to exist")
+		}
+
+		_ = store.Delete(ctx, "key1")
+		if got, _ := store.Get(ctx, "key1"); got != nil {
+			t.Errorf("expected record to be deleted, got %v", got)
+		}
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		_ = store.Set(ctx, &idempotency.Record{Key: "short"}, 10*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+		if got, _ := store.Get(ctx, "short"); got != nil {
+			t.Errorf("expected expired record to be gone, got %v", got)
+		}
+	})
+
+	t.Run("Locking", func(t *testing.T) {
+		if locked, _ := store.TryLock(ctx, "lock1", time.Hour); !locked {
+			t.Fatal("expected lock to be acquired")
+		}
+		if locked, _ := store.TryLock(ctx, "lock1", time.Hour); locked {
+			t.Error("expected lock to be held")
+		}
+		_ = store.Unlock(ctx, "lock1")
+		if locked, _ := store.TryLock(ctx, "lock1", time.Millisecond); !locked {
+			t.Error("expected lock to be acquired after unlock")
+		}
+		time.Sleep(5 * time.Millisecond)
+		if locked, _ := store.TryLock(ctx, "lock1", time.Hour); !locked {
+			t.Error("expected expired lock to be taken over")
+		}
+	})
+
+	t.Run("ConcurrentLock", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var acquired atomic.Int32
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if locked, _ := store.TryLock(ctx, "contended", time.Hour); locked {
+					acquired.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if acquired.Load() != 1 {
+			t.Errorf("expected exactly one lock holder, got %d", acquired.Load())
+		}
+	})
+}
+
+func TestRistrettoStorage_CostEviction(t *testing.T) {
+	store, err := New(Options{MaxCost: 64 << 10, NumCounters: 10000})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	body := make([]byte, 4<<10)
+	for i := 0; i < 100; i++ {
+		record := &idempotency.Record{
+			Key:      fmt.Sprintf("key%d", i),
+			Response: &idempotency.CachedResponse{Body: body},
+		}
+		_ = store.Set(ctx, record, time.Hour)
+	}
+
+	stored := 0
+	for i := 0; i < 100; i++ {
+		if got, _ := store.Get(ctx, fmt.Sprintf("key%d", i)); got != nil {
+			stored++
+		}
+	}
+	if stored == 0 || stored > 16 {
+		t.Errorf("expected records to stay within the memory budget, %d of 100 stored", stored)
+	}
+}
@@ -0,0 +1,113 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// advisoryLock takes the transaction-level advisory lock of key in tx, released when tx
+// ends. It reports false, without waiting, when another transaction holds it.
+func (s *Storage) advisoryLock(ctx context.Context, tx *sql.Tx, key string) (bool, error) {
+	var acquired bool
+	query := s.query("SELECT pg_try_advisory_xact_lock(hashtextextended($1, 0))")
+	if err := tx.QueryRowContext(ctx, query, s.lockSpace+":"+key).Scan(&acquired); err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+	return acquired, nil
+}
+
+// advisoryLockAndSet is TryLockAndSet with Options.AdvisoryLocks: under the advisory lock
+// of the key, record is stored unless the current record still holds the lock
+func (s *Storage) advisoryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	var locked bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		acquired, err := s.advisoryLock(ctx, tx, record.Key)
+		if err != nil || !acquired {
+			return err
+		}
+
+		current, err := s.getTx(ctx, tx, record.Key)
+		if err != nil || s.holdsLock(current) {
+			return err
+		}
+		locked = true
+		return s.set(ctx, tx, s.holding(record, lockTTL), ttl)
+	})
+	if err != nil {
+		return false, err
+	}
+	return locked, nil
+}
+
+// advisoryUnlock is Unlock with Options.AdvisoryLocks: the record for key is deleted if it
+// is still pending, which releases the lock
+func (s *Storage) advisoryUnlock(ctx context.Context, key string) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		// Wait for transactions deciding on the key, so a lock they acquire is not released
+		query := s.query("SELECT pg_advisory_xact_lock(hashtextextended($1, 0))")
+		if _, err := tx.ExecContext(ctx, query, s.lockSpace+":"+key); err != nil {
+			return idempotency.NewStorageError("unlock", err)
+		}
+
+		current, err := s.getTx(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if current != nil && current.Status == idempotency.StatusPending {
+			query := s.query("DELETE FROM {records} WHERE {key} = $1")
+			if _, err := tx.ExecContext(ctx, query, key); err != nil {
+				return idempotency.NewStorageError("unlock", err)
+			}
+		}
+		return s.unlock(ctx, tx, key)
+	})
+}
+
+// advisoryExtend is ExtendLock with Options.AdvisoryLocks: the lock timeout of the pending
+// record for key is extended to ttl from now, under the advisory lock of the key
+func (s *Storage) advisoryExtend(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	var held bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		query := s.query("SELECT pg_advisory_xact_lock(hashtextextended($1, 0))")
+		if _, err := tx.ExecContext(ctx, query, s.lockSpace+":"+key); err != nil {
+			return idempotency.NewStorageError("extendlock", err)
+		}
+
+		current, err := s.getTx(ctx, tx, key)
+		if err != nil || !s.holdsLock(current) {
+			return err
+		}
+		held = true
+		now := s.clock.Now()
+		current.LockTimeout = now.Sub(current.CreatedAt) + ttl
+		// The record holding the lock must not expire before it
+		remaining := max(current.ExpiresAt.Sub(now), ttl)
+		current.ExpiresAt = now.Add(remaining)
+		return s.set(ctx, tx, current, remaining)
+	})
+	if err != nil {
+		return false, err
+	}
+	return held, nil
+}
+
+// holding returns a copy of the pending record holding the lock for lockTTL
+func (s *Storage) holding(record *idempotency.Record, lockTTL time.Duration) *idempotency.Record {
+	pending := *record
+	if pending.CreatedAt.IsZero() {
+		pending.CreatedAt = s.clock.Now()
+	}
+	pending.LockTimeout = lockTTL
+	return &pending
+}
+
+// holdsLock reports whether record is pending and its lock has not expired
+func (s *Storage) holdsLock(record *idempotency.Record) bool {
+	if record == nil || record.Status != idempotency.StatusPending {
+		return false
+	}
+	return record.LockTimeout <= 0 || s.clock.Now().Before(record.CreatedAt.Add(record.LockTimeout))
+}
@@ -0,0 +1,59 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Cleanup deletes expired records and locks, in batches of Options.CleanupBatchSize
+// rows so a large backlog does not hold long table locks, and returns the number of
+// rows deleted. The janitor started by Options.CleanupInterval calls it periodically.
+// Unlike expired records deleted when read, records deleted by Cleanup are not reported
+// to OnExpired.
+func (s *Storage) Cleanup(ctx context.Context) (records, locks int64, err error) {
+	now := s.clock.Now()
+	if records, err = s.deleteExpired(ctx, "{records}", now); err != nil {
+		return records, 0, err
+	}
+	if s.lockSpace == "" {
+		locks, err = s.deleteExpired(ctx, "{locks}", now)
+	}
+	return records, locks, err
+}
+
+// deleteExpired deletes the rows of table expired at now, batch by batch
+func (s *Storage) deleteExpired(ctx context.Context, table string, now time.Time) (int64, error) {
+	query := s.query(deleteExpiredQuery(s.dialect, table))
+	var deleted int64
+	for {
+		res, err := s.db.ExecContext(ctx, query, now, s.cleanupBatchSize)
+		if err != nil {
+			return deleted, idempotency.NewStorageError("cleanup", err)
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+		if n < int64(s.cleanupBatchSize) {
+			return deleted, nil
+		}
+	}
+}
+
+// janitor runs Cleanup every interval until ctx is canceled by Close
+func (s *Storage) janitor(ctx context.Context, interval time.Duration, onCleanup func(records, locks int64, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			records, locks, err := s.Cleanup(ctx)
+			if onCleanup != nil && ctx.Err() == nil {
+				onCleanup(records, locks, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
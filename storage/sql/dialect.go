@@ -0,0 +1,153 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// PlaceholderStyle is the bind parameter syntax expected by the database driver
+type PlaceholderStyle int
+
+const (
+	// PlaceholderAuto detects the style from the driver of the *sql.DB
+	PlaceholderAuto PlaceholderStyle = iota
+
+	// PlaceholderDollar uses $1, $2... (PostgreSQL, SQLite)
+	PlaceholderDollar
+
+	// PlaceholderQuestion uses ? (MySQL, SQLite)
+	PlaceholderQuestion
+
+	// PlaceholderAtP uses @p1, @p2... (SQL Server)
+	PlaceholderAtP
+)
+
+// Dialect is the SQL dialect of the database, which decides the upsert syntax
+type Dialect int
+
+const (
+	// DialectAuto detects the dialect from the driver of the *sql.DB
+	DialectAuto Dialect = iota
+
+	// DialectPostgres uses INSERT ... ON CONFLICT upserts (PostgreSQL, SQLite)
+	DialectPostgres
+
+	// DialectMySQL uses INSERT ... ON DUPLICATE KEY UPDATE upserts and ? placeholders
+	// (MySQL, MariaDB)
+	DialectMySQL
+)
+
+// DetectDialect guesses the dialect from the driver of db. Unknown drivers default to
+// DialectPostgres.
+func DetectDialect(db *sql.DB) Dialect {
+	if strings.Contains(strings.ToLower(fmt.Sprintf("%T", db.Driver())), "mysql") {
+		return DialectMySQL
+	}
+	return DialectPostgres
+}
+
+// upsertRecordQuery returns the template inserting or replacing a record
+func upsertRecordQuery(dialect Dialect) string {
+	if dialect == DialectMySQL {
+		return `
+		INSERT INTO {records} ({key}, {data}, {expires_at})
+		VALUES ($1, $2, $3)
+		ON DUPLICATE KEY UPDATE {data} = VALUES({data}), {expires_at} = VALUES({expires_at})`
+	}
+	return `
+		INSERT INTO {records} ({key}, {data}, {expires_at})
+		VALUES ($1, $2, $3)
+		ON CONFLICT ({key}) DO UPDATE SET {data} = excluded.{data}, {expires_at} = excluded.{expires_at}`
+}
+
+// tryLockQuery returns the template inserting a lock, or replacing it only if it has
+// expired, so that a row is affected only when the lock is acquired. MySQL reports
+// unchanged rows as not affected (unless the clientFoundRows DSN parameter is set).
+func tryLockQuery(dialect Dialect) string {
+	if dialect == DialectMySQL {
+		return `
+		INSERT INTO {locks} ({key}, {expires_at})
+		VALUES ($1, $2)
+		ON DUPLICATE KEY UPDATE
+		{expires_at} = IF({expires_at} < $3, VALUES({expires_at}), {expires_at})`
+	}
+	return `
+		INSERT INTO {locks} ({key}, {expires_at})
+		VALUES ($1, $2)
+		ON CONFLICT ({key}) DO UPDATE
+		SET {expires_at} = excluded.{expires_at}
+		WHERE {locks}.{expires_at} < $3`
+}
+
+// deleteExpiredQuery returns the template deleting at most $2 rows of table expired
+// before $1. MySQL does not support LIMIT in subqueries but does on DELETE.
+func deleteExpiredQuery(dialect Dialect, table string) string {
+	if dialect == DialectMySQL {
+		return "DELETE FROM " + table + " WHERE {expires_at} < $1 LIMIT $2"
+	}
+	return "DELETE FROM " + table + " WHERE {key} IN (SELECT {key} FROM " + table + " WHERE {expires_at} < $1 LIMIT $2)"
+}
+
+// DetectPlaceholderStyle guesses the placeholder style from the driver of db.
+// Unknown drivers default to PlaceholderDollar.
+func DetectPlaceholderStyle(db *sql.DB) PlaceholderStyle {
+	driver := strings.ToLower(fmt.Sprintf("%T", db.Driver()))
+	switch {
+	case strings.Contains(driver, "mysql"):
+		return PlaceholderQuestion
+	case strings.Contains(driver, "mssql"), strings.Contains(driver, "sqlserver"):
+		return PlaceholderAtP
+	default:
+		return PlaceholderDollar
+	}
+}
+
+// rebind rewrites a query written with $N placeholders, each used once and in order,
+// to the given style
+func rebind(style PlaceholderStyle, query string) string {
+	if style == PlaceholderDollar || style == PlaceholderAuto {
+		return query
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(query); i++ {
+		if query[i] != '$' {
+			b.WriteByte(query[i])
+			continue
+		}
+		j := i + 1
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+		if j == i+1 {
+			b.WriteByte(query[i])
+			continue
+		}
+		if style == PlaceholderQuestion {
+			b.WriteByte('?')
+		} else {
+			b.WriteString("@p" + query[i+1:j])
+		}
+		i = j - 1
+	}
+	return b.String()
+}
+
+// quoteIdent quotes a possibly schema-qualified identifier ("schema.table") for the
+// database matching the placeholder style: backticks for MySQL, brackets for SQL
+// Server and double quotes otherwise
+func quoteIdent(style PlaceholderStyle, ident string) string {
+	parts := strings.Split(ident, ".")
+	for i, part := range parts {
+		switch style {
+		case PlaceholderQuestion:
+			parts[i] = "`" + strings.ReplaceAll(part, "`", "``") + "`"
+		case PlaceholderAtP:
+			parts[i] = "[" + strings.ReplaceAll(part, "]", "]]") + "]"
+		default:
+			parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+		}
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,153 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+func TestRebind(t *testing.T) {
+	query := "UPDATE t SET a = $1 WHERE b = $2 AND c = '$'"
+	tests := []struct {
+		style PlaceholderStyle
+		want  string
+	}{
+		{PlaceholderDollar, query},
+		{PlaceholderQuestion, "UPDATE t SET a = ? WHERE b = ? AND c = '$'"},
+		{PlaceholderAtP, "UPDATE t SET a = @p1 WHERE b = @p2 AND c = '$'"},
+	}
+
+	for _, tt := range tests {
+		if got := rebind(tt.style, query); got != tt.want {
+			t.Errorf("rebind(%d) = %q, want %q", tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	tests := []struct {
+		style PlaceholderStyle
+		ident string
+		want  string
+	}{
+		{PlaceholderDollar, "public.records", `"public"."records"`},
+		{PlaceholderDollar, `we"ird`, `"we""ird"`},
+		{PlaceholderQuestion, "key", "`key`"},
+		{PlaceholderAtP, "dbo.records", "[dbo].[records]"},
+	}
+
+	for _, tt := range tests {
+		if got := quoteIdent(tt.style, tt.ident); got != tt.want {
+			t.Errorf("quoteIdent(%q) = %q, want %q", tt.ident, got, tt.want)
+		}
+	}
+}
+
+func TestPlaceholderStyles(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	if style := DetectPlaceholderStyle(db); style != PlaceholderDollar {
+		t.Errorf("expected PlaceholderDollar for sqlite, got %d", style)
+	}
+
+	_, _ = db.Exec(`CREATE TABLE records (key TEXT PRIMARY KEY, data BLOB, expires_at DATETIME)`)
+	_, _ = db.Exec(`CREATE TABLE records_locks (key TEXT PRIMARY KEY, expires_at DATETIME)`)
+	ctx := context.Background()
+
+	// SQLite understands both ? placeholders and backtick quoting
+	store := NewSQLStorageWithOptions(db, Options{TableName: "records", Placeholder: PlaceholderQuestion})
+
+	if err := store.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	got, err := store.Get(ctx, "k1")
+	if err != nil || got == nil || got.Key != "k1" {
+		t.Fatalf("expected record k1, got %v, %v", got, err)
+	}
+
+	locked, err := store.TryLock(ctx, "k1", time.Minute)
+	if err != nil || !locked {
+		t.Fatalf("expected lock, got %v, %v", locked, err)
+	}
+	if err := store.Unlock(ctx, "k1"); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+}
+
+func TestCustomNames(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	// SQLite exposes the main database as the "main" schema
+	_, _ = db.Exec(`CREATE TABLE idem (idem_key TEXT PRIMARY KEY, payload BLOB, valid_until DATETIME)`)
+	_, _ = db.Exec(`CREATE TABLE idem_mutex (idem_key TEXT PRIMARY KEY, valid_until DATETIME)`)
+	ctx := context.Background()
+
+	store := NewSQLStorageWithOptions(db, Options{
+		Schema:         "main",
+		TableName:      "idem",
+		LocksTableName: "idem_mutex",
+		Columns:        Columns{Key: "idem_key", Data: "payload", ExpiresAt: "valid_until"},
+	})
+
+	if err := store.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if exists, err := store.Exists(ctx, "k1"); err != nil || !exists {
+		t.Fatalf("expected k1 to exist, got %v, %v", exists, err)
+	}
+	if records, err := store.List(ctx); err != nil || len(records) != 1 {
+		t.Fatalf("expected one record, got %v, %v", records, err)
+	}
+
+	locked, err := store.TryLock(ctx, "k1", time.Minute)
+	if err != nil || !locked {
+		t.Fatalf("expected lock, got %v, %v", locked, err)
+	}
+	if err := store.Delete(ctx, "k1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+}
+
+// mysqlDriver is a driver named like the MySQL drivers, to test detection
+type mysqlDriver struct{}
+
+func (mysqlDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestDialect(t *testing.T) {
+	sql.Register("fake-mysql", mysqlDriver{})
+	db, _ := sql.Open("fake-mysql", "")
+	defer db.Close()
+
+	if dialect := DetectDialect(db); dialect != DialectMySQL {
+		t.Fatalf("expected DialectMySQL, got %d", dialect)
+	}
+
+	store := NewSQLStorage(db, "idempotency_records")
+	if store.placeholder != PlaceholderQuestion {
+		t.Errorf("expected ? placeholders for MySQL, got %d", store.placeholder)
+	}
+	upsert := store.query(upsertRecordQuery(store.dialect))
+	if !strings.Contains(upsert, "ON DUPLICATE KEY UPDATE `data` = VALUES(`data`)") || strings.Contains(upsert, "$") {
+		t.Errorf("unexpected MySQL upsert %q", upsert)
+	}
+	lock := store.query(tryLockQuery(store.dialect))
+	if !strings.Contains(lock, "IF(`expires_at` < ?, VALUES(`expires_at`), `expires_at`)") {
+		t.Errorf("unexpected MySQL lock query %q", lock)
+	}
+}
@@ -0,0 +1,72 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Migrations holds the DDL creating the records and locks tables, with an index on
+// expires_at for Cleanup, one file per dialect: postgres.sql, mysql.sql and sqlite.sql.
+// The files are templates whose {records}, {locks}, {key}, {data}, {expires_at},
+// {records_expires_at_idx} and {locks_expires_at_idx} names are replaced by Migrate.
+var Migrations fs.FS = migrations
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrate creates the tables of a storage with the default table and column names, if
+// they do not exist. Storages with custom names are migrated with Storage.Migrate.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	return NewSQLStorage(db, "").Migrate(ctx)
+}
+
+// Migrate creates the records and locks tables of the storage, and their expires_at
+// indexes, if they do not exist. The DDL matches the dialect of the storage, SQLite
+// being detected from the driver. SQL Server is not supported. Migrate is idempotent
+// and can run at every startup.
+func (s *Storage) Migrate(ctx context.Context) error {
+	name, err := s.migrationFile()
+	if err != nil {
+		return idempotency.NewStorageError("migrate", err)
+	}
+	ddl, err := fs.ReadFile(migrations, "migrations/"+name)
+	if err != nil {
+		return idempotency.NewStorageError("migrate", err)
+	}
+
+	// Drivers do not all accept several statements per Exec (MySQL by default)
+	for _, stmt := range strings.Split(s.names.Replace(string(ddl)), ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return idempotency.NewStorageError("migrate", err)
+		}
+	}
+	return nil
+}
+
+// migrationFile returns the file of Migrations matching the database
+func (s *Storage) migrationFile() (string, error) {
+	switch {
+	case s.dialect == DialectMySQL:
+		return "mysql.sql", nil
+	case s.placeholder == PlaceholderAtP:
+		return "", fmt.Errorf("sql: no migration for %T", s.db.Driver())
+	case strings.Contains(strings.ToLower(fmt.Sprintf("%T", s.db.Driver())), "sqlite"):
+		return "sqlite.sql", nil
+	default:
+		return "postgres.sql", nil
+	}
+}
+
+// indexName returns the name of the expires_at index of table, without its schema
+func indexName(table string) string {
+	return table[strings.LastIndex(table, ".")+1:] + "_expires_at_idx"
+}
@@ -0,0 +1,14 @@
+-- Records table, with an index for the cleanup of expired records
+CREATE TABLE IF NOT EXISTS {records} (
+    {key} VARCHAR(255) PRIMARY KEY,
+    {data} MEDIUMBLOB NOT NULL,
+    {expires_at} DATETIME NOT NULL,
+    INDEX {records_expires_at_idx} ({expires_at})
+);
+
+-- Locks table
+CREATE TABLE IF NOT EXISTS {locks} (
+    {key} VARCHAR(255) PRIMARY KEY,
+    {expires_at} DATETIME NOT NULL,
+    INDEX {locks_expires_at_idx} ({expires_at})
+);
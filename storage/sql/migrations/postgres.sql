@@ -0,0 +1,17 @@
+-- Records table
+CREATE TABLE IF NOT EXISTS {records} (
+    {key} VARCHAR(255) PRIMARY KEY,
+    {data} BYTEA NOT NULL,
+    {expires_at} TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Index for the cleanup of expired records
+CREATE INDEX IF NOT EXISTS {records_expires_at_idx} ON {records} ({expires_at});
+
+-- Locks table
+CREATE TABLE IF NOT EXISTS {locks} (
+    {key} VARCHAR(255) PRIMARY KEY,
+    {expires_at} TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS {locks_expires_at_idx} ON {locks} ({expires_at});
@@ -0,0 +1,180 @@
+package sql
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// NotifyChannel is the Postgres channel notified with the key of records that complete
+// or whose lock is released, when ListenForCompletions is enabled
+const NotifyChannel = "idempotency_done"
+
+// waitPollInterval is the interval at which WaitForCompletion polls the record when
+// completions are not notified
+const waitPollInterval = 100 * time.Millisecond
+
+// Listener receives Postgres notifications for ListenForCompletions. database/sql has
+// no LISTEN support, so it adapts the driver: a pq.Listener, or a pgx connection
+// dedicated to listening.
+type Listener interface {
+	// Listen subscribes to channel (LISTEN)
+	Listen(ctx context.Context, channel string) error
+
+	// WaitForNotification blocks until a notification is received and returns its
+	// payload. An empty payload reports that notifications may have been lost, e.g.
+	// after a reconnection, and wakes every waiter.
+	WaitForNotification(ctx context.Context) (string, error)
+}
+
+// ListenForCompletions makes the storage notify completions with Postgres NOTIFY and
+// wakes duplicates blocked in WaitForCompletion when they are received by listener,
+// instead of polling the records table. Every instance sharing the tables must enable
+// it. Notifications sent in a transaction (see SetTx) are delivered on commit.
+// If listener fails, waiters fall back to polling. The listener connection is owned by
+// the caller, the storage stops reading it on Close.
+func (s *Storage) ListenForCompletions(listener Listener) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := listener.Listen(ctx, NotifyChannel); err != nil {
+		cancel()
+		return idempotency.NewStorageError("listen", err)
+	}
+
+	n := &notifier{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+		cancel:  cancel,
+	}
+	s.notifier = n
+	go n.run(ctx, listener)
+	return nil
+}
+
+// WaitForCompletion blocks until the record for key is no longer pending. With
+// ListenForCompletions, duplicates are woken by the notification of the original
+// request; otherwise the record is polled.
+func (s *Storage) WaitForCompletion(ctx context.Context, key string) error {
+	// Subscribe before checking the current state, so a completion happening in between
+	// is not missed
+	var wake <-chan struct{}
+	if s.notifier != nil {
+		ch := s.notifier.subscribe(key)
+		if ch != nil {
+			defer s.notifier.unsubscribe(key, ch)
+			wake = ch
+		}
+	}
+
+	for {
+		record, err := s.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if record == nil || record.Status != idempotency.StatusPending {
+			return nil
+		}
+
+		if wake != nil {
+			select {
+			case <-wake:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case <-time.After(waitPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// notifyDone sends the completion notification for key with q, if enabled
+func (s *Storage) notifyDone(ctx context.Context, q execer, key string) error {
+	if s.notifier == nil {
+		return nil
+	}
+	_, err := q.ExecContext(ctx, s.query("SELECT pg_notify($1, $2)"), NotifyChannel, key)
+	return err
+}
+
+// notifier dispatches the notifications received by a Listener to the waiting duplicates
+type notifier struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+	stopped bool
+	cancel  context.CancelFunc
+}
+
+// run reads notifications until ctx is done or the listener fails
+func (n *notifier) run(ctx context.Context, listener Listener) {
+	for {
+		key, err := listener.WaitForNotification(ctx)
+		if err != nil {
+			n.stop()
+			return
+		}
+		n.wake(key)
+	}
+}
+
+// subscribe returns a channel closed when key is notified, nil once the listener stopped
+func (n *notifier) subscribe(key string) chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopped {
+		return nil
+	}
+	ch := make(chan struct{})
+	if n.waiters[key] == nil {
+		n.waiters[key] = make(map[chan struct{}]struct{})
+	}
+	n.waiters[key][ch] = struct{}{}
+	return ch
+}
+
+func (n *notifier) unsubscribe(key string, ch chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.waiters[key], ch)
+	if len(n.waiters[key]) == 0 {
+		delete(n.waiters, key)
+	}
+}
+
+// wake wakes the waiters of key, or all of them when key is empty
+func (n *notifier) wake(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.wakeLocked(key)
+}
+
+func (n *notifier) wakeLocked(key string) {
+	if key != "" {
+		for ch := range n.waiters[key] {
+			close(ch)
+		}
+		delete(n.waiters, key)
+		return
+	}
+
+	for k, waiters := range n.waiters {
+		for ch := range waiters {
+			close(ch)
+		}
+		delete(n.waiters, k)
+	}
+}
+
+// stop wakes every waiter and makes later ones poll
+func (n *notifier) stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stopped = true
+	n.wakeLocked("")
+}
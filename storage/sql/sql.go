@@ -1,12 +1,14 @@
 // Package sql provides a SQL-based storage backend for gopotency.
 // It supports any database compatible with database/sql, such as PostgreSQL, MySQL, or SQLite.
+// The dialect and placeholder style ($1, ? or @p1) are detected from the driver and
+// identifiers are quoted.
 package sql
 
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	idempotency "github.com/fco-gt/gopotency"
@@ -14,21 +16,186 @@ import (
 
 // Storage is a SQL implementation of idempotency.Storage
 type Storage struct {
-	db        *sql.DB
-	tableName string
+	db          *sql.DB
+	dialect     Dialect
+	placeholder PlaceholderStyle
+	names       *strings.Replacer
+	codec       idempotency.Codec
+	clock       idempotency.Clock
+	onExpired   func(key string, record *idempotency.Record)
+
+	// lockSpace scopes the advisory locks of the records table, empty when locks are
+	// stored in the locks table
+	lockSpace string
+
+	// notifier is set by ListenForCompletions
+	notifier *notifier
+
+	cleanupBatchSize int
+
+	// stopJanitor stops the janitor started by Options.CleanupInterval
+	stopJanitor context.CancelFunc
+}
+
+// Columns are the column names used by the records and locks tables
+type Columns struct {
+	// Key is the primary key column of both tables
+	// Default: "key"
+	Key string
+
+	// Data is the serialized record column of the records table
+	// Default: "data"
+	Data string
+
+	// ExpiresAt is the expiration column of both tables
+	// Default: "expires_at"
+	ExpiresAt string
+}
+
+// Options configures the SQL storage
+type Options struct {
+	// Schema qualifies both tables when set (e.g. "billing")
+	Schema string
+
+	// TableName is the records table, optionally schema-qualified ("schema.table")
+	// Default: "idempotency_records"
+	TableName string
+
+	// LocksTableName is the locks table
+	// Default: TableName + "_locks"
+	LocksTableName string
+
+	// Columns are the column names of both tables
+	// Default: key, data, expires_at
+	Columns Columns
+
+	// Dialect is the SQL dialect of the database. DialectMySQL also defaults Placeholder
+	// to PlaceholderQuestion. MySQL drivers must parse times (parseTime=true).
+	// Default: DialectAuto (detected from the driver)
+	Dialect Dialect
+
+	// Placeholder is the bind parameter syntax of the driver
+	// Default: PlaceholderAuto (detected from the driver)
+	Placeholder PlaceholderStyle
+
+	// AdvisoryLocks replaces the locks table with PostgreSQL advisory locks (PostgreSQL 11+
+	// only): the pending record holds the lock of its key until its LockTimeout elapses,
+	// so no lock rows are left behind and the locks table is not needed
+	// Default: false
+	AdvisoryLocks bool
+
+	// CleanupInterval starts a background janitor deleting expired records and locks at
+	// this interval, stopped by Close. Without it, expired rows are only deleted when
+	// read again (optional)
+	CleanupInterval time.Duration
+
+	// CleanupBatchSize is the maximum number of rows deleted by each statement of Cleanup
+	// Default: 1000
+	CleanupBatchSize int
+
+	// OnCleanup is called after every run of the janitor with the number of expired
+	// records and locks deleted, e.g. to export them as metrics (optional)
+	OnCleanup func(records, locks int64, err error)
 }
 
 // NewSQLStorage creates a new SQL storage instance.
-// This implementation is optimized for PostgreSQL and SQLite as it uses
-// positional placeholders ($1, $2) and "ON CONFLICT" syntax.
+// The dialect and placeholder style are detected from the driver and identifiers are
+// quoted. Writes use "ON CONFLICT" upserts, supported by PostgreSQL and SQLite, or
+// "ON DUPLICATE KEY UPDATE" with MySQL drivers.
 func NewSQLStorage(db *sql.DB, tableName string) *Storage {
-	if tableName == "" {
-		tableName = "idempotency_records"
+	return NewSQLStorageWithOptions(db, Options{TableName: tableName})
+}
+
+// NewSQLStorageWithOptions creates a new SQL storage instance with the given options
+func NewSQLStorageWithOptions(db *sql.DB, opts Options) *Storage {
+	if opts.TableName == "" {
+		opts.TableName = "idempotency_records"
+	}
+	if opts.LocksTableName == "" {
+		opts.LocksTableName = opts.TableName + "_locks"
+	}
+	if opts.Columns.Key == "" {
+		opts.Columns.Key = "key"
+	}
+	if opts.Columns.Data == "" {
+		opts.Columns.Data = "data"
+	}
+	if opts.Columns.ExpiresAt == "" {
+		opts.Columns.ExpiresAt = "expires_at"
 	}
-	return &Storage{
-		db:        db,
-		tableName: tableName,
+	if opts.CleanupBatchSize <= 0 {
+		opts.CleanupBatchSize = 1000
 	}
+	if opts.Dialect == DialectAuto {
+		opts.Dialect = DetectDialect(db)
+	}
+	if opts.Placeholder == PlaceholderAuto {
+		if opts.Dialect == DialectMySQL {
+			opts.Placeholder = PlaceholderQuestion
+		} else {
+			opts.Placeholder = DetectPlaceholderStyle(db)
+		}
+	}
+
+	table := func(name string) string {
+		if opts.Schema != "" {
+			name = opts.Schema + "." + name
+		}
+		return quoteIdent(opts.Placeholder, name)
+	}
+
+	var lockSpace string
+	if opts.AdvisoryLocks {
+		lockSpace = opts.TableName
+		if opts.Schema != "" {
+			lockSpace = opts.Schema + "." + lockSpace
+		}
+	}
+
+	s := &Storage{
+		db:               db,
+		codec:            idempotency.JSONCodec,
+		clock:            idempotency.SystemClock,
+		dialect:          opts.Dialect,
+		placeholder:      opts.Placeholder,
+		lockSpace:        lockSpace,
+		cleanupBatchSize: opts.CleanupBatchSize,
+		names: strings.NewReplacer(
+			"{records}", table(opts.TableName),
+			"{locks}", table(opts.LocksTableName),
+			"{key}", quoteIdent(opts.Placeholder, opts.Columns.Key),
+			"{data}", quoteIdent(opts.Placeholder, opts.Columns.Data),
+			"{expires_at}", quoteIdent(opts.Placeholder, opts.Columns.ExpiresAt),
+			"{records_expires_at_idx}", quoteIdent(opts.Placeholder, indexName(opts.TableName)),
+			"{locks_expires_at_idx}", quoteIdent(opts.Placeholder, indexName(opts.LocksTableName)),
+		),
+	}
+
+	if opts.CleanupInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopJanitor = cancel
+		go s.janitor(ctx, opts.CleanupInterval, opts.OnCleanup)
+	}
+	return s
+}
+
+// SetCodec replaces the codec used to serialize records (default idempotency.JSONCodec).
+// It implements idempotency.CodecSetter and must be called before the storage is used.
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	s.codec = codec
+}
+
+// SetClock replaces the clock deciding expirations (default idempotency.SystemClock).
+// It implements idempotency.ClockSetter and must be called before the storage is used.
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	s.clock = clock
+}
+
+// query replaces the {records}, {locks}, {key}, {data} and {expires_at} names of a
+// query template with the quoted identifiers and rebinds its $N placeholders to the
+// driver style
+func (s *Storage) query(format string) string {
+	return rebind(s.placeholder, s.names.Replace(format))
 }
 
 // Get retrieves an idempotency record by key
@@ -36,7 +203,7 @@ func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, err
 	var data []byte
 	var expiresAt time.Time
 
-	query := fmt.Sprintf("SELECT data, expires_at FROM %s WHERE key = $1", s.tableName)
+	query := s.query("SELECT {data}, {expires_at} FROM {records} WHERE {key} = $1")
 	err := s.db.QueryRowContext(ctx, query, key).Scan(&data, &expiresAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -45,77 +212,138 @@ func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, err
 		return nil, idempotency.NewStorageError("get", err)
 	}
 
+	record, unmarshalErr := s.codec.Unmarshal(data)
+
 	// Check expiration
-	if time.Now().After(expiresAt) {
+	if s.clock.Now().After(expiresAt) {
 		_ = s.Delete(ctx, key)
+		if s.onExpired != nil && unmarshalErr == nil {
+			s.onExpired(key, record)
+		}
 		return nil, nil
 	}
 
-	var record idempotency.Record
-	if err := json.Unmarshal(data, &record); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("failed to unmarshal record: %w", unmarshalErr)
 	}
 
-	return &record, nil
+	return record, nil
+}
+
+// OnExpired registers fn, called with expired records when they are deleted.
+// Expired rows are removed lazily by Get. It implements idempotency.ExpiryNotifier
+// and must be called before the storage is used.
+func (s *Storage) OnExpired(fn func(key string, record *idempotency.Record)) {
+	s.onExpired = fn
 }
 
 // Set stores an idempotency record
 func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
-	data, err := json.Marshal(record)
+	return s.set(ctx, s.db, record, ttl)
+}
+
+// SetTx stores a record in tx, the transaction of the domain changes, so both commit
+// atomically (see idempotency.Token.Record). The record expires at its ExpiresAt.
+func (s *Storage) SetTx(ctx context.Context, tx *sql.Tx, record *idempotency.Record) error {
+	ttl := record.ExpiresAt.Sub(s.clock.Now())
+	if ttl <= 0 {
+		return fmt.Errorf("record %q is already expired", record.Key)
+	}
+	return s.set(ctx, tx, record, ttl)
+}
+
+func (s *Storage) set(ctx context.Context, q execer, record *idempotency.Record, ttl time.Duration) error {
+	data, err := s.codec.Marshal(record)
 	if err != nil {
 		return fmt.Errorf("failed to marshal record: %w", err)
 	}
 
-	expiresAt := time.Now().Add(ttl)
-	query := fmt.Sprintf(`
-		INSERT INTO %s (key, data, expires_at) 
-		VALUES ($1, $2, $3) 
-		ON CONFLICT (key) DO UPDATE SET data = $2, expires_at = $3`, s.tableName)
+	expiresAt := s.clock.Now().Add(ttl)
+	query := s.query(upsertRecordQuery(s.dialect))
 
-	_, err = s.db.ExecContext(ctx, query, record.Key, data, expiresAt)
+	_, err = q.ExecContext(ctx, query, record.Key, data, expiresAt)
 	if err != nil {
 		return idempotency.NewStorageError("set", err)
 	}
 
+	// Wake duplicates waiting on another instance
+	if record.Status != idempotency.StatusPending {
+		if err := s.notifyDone(ctx, q, record.Key); err != nil {
+			return idempotency.NewStorageError("notify", err)
+		}
+	}
+
 	return nil
 }
 
-// Delete removes an idempotency record
+// Delete removes an idempotency record and its lock in a single transaction
 func (s *Storage) Delete(ctx context.Context, key string) error {
-	query := fmt.Sprintf("DELETE FROM %s WHERE key = $1", s.tableName)
-	_, err := s.db.ExecContext(ctx, query, key)
-	if err != nil {
-		return idempotency.NewStorageError("delete", err)
-	}
-	return s.Unlock(ctx, key)
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		query := s.query("DELETE FROM {records} WHERE {key} = $1")
+		if _, err := tx.ExecContext(ctx, query, key); err != nil {
+			return idempotency.NewStorageError("delete", err)
+		}
+		return s.unlock(ctx, tx, key)
+	})
 }
 
 // Exists checks if a record exists
 func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
 	var exists bool
-	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE key = $1 AND expires_at > $2)", s.tableName)
-	err := s.db.QueryRowContext(ctx, query, key, time.Now()).Scan(&exists)
+	query := s.query("SELECT EXISTS(SELECT 1 FROM {records} WHERE {key} = $1 AND {expires_at} > $2)")
+	err := s.db.QueryRowContext(ctx, query, key, s.clock.Now()).Scan(&exists)
 	if err != nil {
 		return false, idempotency.NewStorageError("exists", err)
 	}
 	return exists, nil
 }
 
-// TryLock attempts to acquire a lock for the given key.
-// Note: This is a simplified implementation using a locks table.
-// For PostgreSQL, dedicated advisory locks might be better.
+// List returns all non-expired records
+func (s *Storage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	query := s.query("SELECT {data} FROM {records} WHERE {expires_at} > $1")
+	rows, err := s.db.QueryContext(ctx, query, s.clock.Now())
+	if err != nil {
+		return nil, idempotency.NewStorageError("list", err)
+	}
+	defer rows.Close()
+
+	var records []*idempotency.Record
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, idempotency.NewStorageError("list", err)
+		}
+
+		record, err := s.codec.Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, idempotency.NewStorageError("list", err)
+	}
+
+	return records, nil
+}
+
+// TryLock attempts to acquire a lock for the given key, stored in the locks table.
+// With Options.AdvisoryLocks, a pending record is stored to hold the lock instead.
 func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
-	expiresAt := time.Now().Add(ttl)
+	if s.lockSpace != "" {
+		record := &idempotency.Record{Key: key, Status: idempotency.StatusPending, CreatedAt: s.clock.Now()}
+		return s.TryLockAndSet(ctx, record, ttl, ttl)
+	}
+	return s.tryLock(ctx, s.db, key, ttl)
+}
+
+func (s *Storage) tryLock(ctx context.Context, q execer, key string, ttl time.Duration) (bool, error) {
+	expiresAt := s.clock.Now().Add(ttl)
 
 	// Try to insert a lock record. If it exists and is expired, we update it.
-	query := fmt.Sprintf(`
-		INSERT INTO %s_locks (key, expires_at) 
-		VALUES ($1, $2) 
-		ON CONFLICT (key) DO UPDATE 
-		SET expires_at = $2 
-		WHERE %s_locks.expires_at < $3`, s.tableName, s.tableName)
-
-	res, err := s.db.ExecContext(ctx, query, key, expiresAt, time.Now())
+	query := s.query(tryLockQuery(s.dialect))
+
+	res, err := q.ExecContext(ctx, query, key, expiresAt, s.clock.Now())
 	if err != nil {
 		return false, idempotency.NewStorageError("trylock", err)
 	}
@@ -124,14 +352,150 @@ func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (b
 	return rows > 0, nil
 }
 
-// Unlock releases a lock
+// TryLockAndSet acquires the lock for record.Key and stores record in a single
+// transaction, so a failure cannot leave a lock without its record.
+// It implements idempotency.LockSetter.
+func (s *Storage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	if s.lockSpace != "" {
+		return s.advisoryLockAndSet(ctx, record, ttl, lockTTL)
+	}
+
+	var locked bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if locked, err = s.tryLock(ctx, tx, record.Key, lockTTL); err != nil || !locked {
+			return err
+		}
+		return s.set(ctx, tx, record, ttl)
+	})
+	if err != nil {
+		return false, err
+	}
+	return locked, nil
+}
+
+// GetOrLock returns the non-expired record for key if there is one, otherwise acquires
+// the lock and stores record, in a single transaction. It implements
+// idempotency.GetOrLocker.
+func (s *Storage) GetOrLock(ctx context.Context, key string, record *idempotency.Record, ttl, lockTTL time.Duration) (*idempotency.Record, bool, error) {
+	var existing *idempotency.Record
+	var locked bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if s.lockSpace != "" {
+			// Deciding transactions are serialized by the advisory lock of the key
+			if acquired, err := s.advisoryLock(ctx, tx, key); err != nil || !acquired {
+				return err
+			}
+		}
+
+		var err error
+		if existing, err = s.getTx(ctx, tx, key); err != nil || existing != nil {
+			return err
+		}
+
+		if s.lockSpace != "" {
+			locked = true
+			return s.set(ctx, tx, s.holding(record, lockTTL), ttl)
+		}
+		if locked, err = s.tryLock(ctx, tx, key, lockTTL); err != nil || !locked {
+			return err
+		}
+		return s.set(ctx, tx, record, ttl)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, locked, nil
+}
+
+// ExtendLock makes the lock held for key expire ttl from now, reporting false if it
+// expired or was released. With Options.AdvisoryLocks, the lock timeout of the pending
+// record is extended instead. It implements idempotency.LockExtender.
+func (s *Storage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if s.lockSpace != "" {
+		return s.advisoryExtend(ctx, key, ttl)
+	}
+
+	now := s.clock.Now()
+	query := s.query("UPDATE {locks} SET {expires_at} = $1 WHERE {key} = $2 AND {expires_at} > $3")
+	res, err := s.db.ExecContext(ctx, query, now.Add(ttl), key, now)
+	if err != nil {
+		return false, idempotency.NewStorageError("extendlock", err)
+	}
+
+	rows, _ := res.RowsAffected()
+	return rows > 0, nil
+}
+
+// getTx returns the non-expired record for key read in tx, nil if there is none
+func (s *Storage) getTx(ctx context.Context, tx *sql.Tx, key string) (*idempotency.Record, error) {
+	var data []byte
+	query := s.query("SELECT {data} FROM {records} WHERE {key} = $1 AND {expires_at} > $2")
+	err := tx.QueryRowContext(ctx, query, key, s.clock.Now()).Scan(&data)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, idempotency.NewStorageError("get", err)
+	}
+
+	record, err := s.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+	}
+	return record, nil
+}
+
+// Unlock releases a lock. With Options.AdvisoryLocks, the record holding the lock is
+// deleted if it is still pending.
 func (s *Storage) Unlock(ctx context.Context, key string) error {
-	query := fmt.Sprintf("DELETE FROM %s_locks WHERE key = $1", s.tableName)
-	_, err := s.db.ExecContext(ctx, query, key)
-	return err
+	if s.lockSpace != "" {
+		return s.advisoryUnlock(ctx, key)
+	}
+	return s.unlock(ctx, s.db, key)
+}
+
+func (s *Storage) unlock(ctx context.Context, q execer, key string) error {
+	if s.lockSpace == "" {
+		query := s.query("DELETE FROM {locks} WHERE {key} = $1")
+		if _, err := q.ExecContext(ctx, query, key); err != nil {
+			return err
+		}
+	}
+	// Wake duplicates waiting on another instance
+	return s.notifyDone(ctx, q, key)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// withTx runs fn in a transaction, committed if fn succeeds and rolled back otherwise
+func (s *Storage) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return idempotency.NewStorageError("begin", err)
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return idempotency.NewStorageError("commit", err)
+	}
+	return nil
 }
 
 // Close closes the database connection
 func (s *Storage) Close() error {
+	if s.notifier != nil {
+		s.notifier.cancel()
+	}
+	if s.stopJanitor != nil {
+		s.stopJanitor()
+	}
 	return s.db.Close()
 }
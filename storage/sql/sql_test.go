@@ -3,11 +3,13 @@ package sql
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	idempotency "github.com/fco-gt/gopotency"
-	_ "modernc.org/sqlite" // Pure Go SQLite driver for tests
+	"modernc.org/sqlite" // Pure Go SQLite driver for tests
 )
 
 func TestSQLStorage(t *testing.T) {
@@ -80,6 +82,20 @@ func TestSQLStorage(t *testing.T) {
 		}
 	})
 
+	t.Run("ExtendLock", func(t *testing.T) {
+		if held, _ := store.ExtendLock(ctx, "extend-key", time.Hour); held {
+			t.Error("expected a lock not held not to be extended")
+		}
+		_, _ = store.TryLock(ctx, "extend-key", 50*time.Millisecond)
+		if held, err := store.ExtendLock(ctx, "extend-key", time.Hour); err != nil || !held {
+			t.Fatalf("expected the lock to be extended, got %v, %v", held, err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if locked, _ := store.TryLock(ctx, "extend-key", time.Hour); locked {
+			t.Error("expected the extended lock to be held")
+		}
+	})
+
 	// 5. Test Exists
 	t.Run("Exists", func(t *testing.T) {
 		exists, err := store.Exists(ctx, "key1")
@@ -96,6 +112,16 @@ func TestSQLStorage(t *testing.T) {
 		}
 	})
 
+	t.Run("List", func(t *testing.T) {
+		records, err := store.List(ctx)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(records) != 1 || records[0].Key != "key1" {
+			t.Errorf("expected [key1], got %v", records)
+		}
+	})
+
 	// 6. Test Delete
 	t.Run("Delete", func(t *testing.T) {
 		err := store.Delete(ctx, "key1")
@@ -109,6 +135,48 @@ func TestSQLStorage(t *testing.T) {
 		}
 	})
 
+	t.Run("TryLockAndSet", func(t *testing.T) {
+		record := &idempotency.Record{Key: "atomic", Status: idempotency.StatusPending}
+		locked, err := store.TryLockAndSet(ctx, record, time.Hour, time.Minute)
+		if err != nil || !locked {
+			t.Fatalf("expected lock and record, got %v, %v", locked, err)
+		}
+
+		// Lock held: nothing must be written
+		other := &idempotency.Record{Key: "atomic", Status: idempotency.StatusCompleted}
+		locked, err = store.TryLockAndSet(ctx, other, time.Hour, time.Minute)
+		if err != nil || locked {
+			t.Fatalf("expected lock to be held, got %v, %v", locked, err)
+		}
+		if got, _ := store.Get(ctx, "atomic"); got == nil || got.Status != idempotency.StatusPending {
+			t.Errorf("expected pending record to be untouched, got %v", got)
+		}
+
+		// Delete removes record and lock together
+		if err := store.Delete(ctx, "atomic"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if locked, _ := store.TryLock(ctx, "atomic", time.Minute); !locked {
+			t.Error("expected lock to be released by Delete")
+		}
+	})
+
+	t.Run("GetOrLock", func(t *testing.T) {
+		record := &idempotency.Record{Key: "get-or-lock", Status: idempotency.StatusPending}
+		existing, locked, err := store.GetOrLock(ctx, "get-or-lock", record, time.Hour, time.Minute)
+		if err != nil || !locked || existing != nil {
+			t.Fatalf("expected lock and record, got %v, %v, %v", existing, locked, err)
+		}
+
+		// Record stored: it is returned and nothing is written
+		other := &idempotency.Record{Key: "get-or-lock", Status: idempotency.StatusCompleted}
+		existing, locked, err = store.GetOrLock(ctx, "get-or-lock", other, time.Hour, time.Minute)
+		if err != nil || locked || existing == nil || existing.Status != idempotency.StatusPending {
+			t.Fatalf("expected pending record, got %v, %v, %v", existing, locked, err)
+		}
+		_ = store.Delete(ctx, "get-or-lock")
+	})
+
 	// 8. Test Expiration
 	t.Run("Expiration", func(t *testing.T) {
 		record := &idempotency.Record{
@@ -118,6 +186,10 @@ func TestSQLStorage(t *testing.T) {
 		}
 		_ = store.Set(ctx, record, -time.Minute)
 
+		var expired *idempotency.Record
+		store.OnExpired(func(key string, r *idempotency.Record) { expired = r })
+		defer store.OnExpired(nil)
+
 		got, err := store.Get(ctx, "expired-key")
 		if err != nil {
 			t.Fatalf("Get unexpected error: %v", err)
@@ -125,6 +197,50 @@ func TestSQLStorage(t *testing.T) {
 		if got != nil {
 			t.Error("Expected nil for expired record")
 		}
+		if expired == nil || expired.Key != "expired-key" {
+			t.Errorf("Expected OnExpired with the expired record, got %v", expired)
+		}
+	})
+
+	// Test SetTx
+	t.Run("SetTx", func(t *testing.T) {
+		record := &idempotency.Record{
+			Key:       "tx-key",
+			Status:    idempotency.StatusCompleted,
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+
+		for _, commit := range []bool{false, true} {
+			tx, err := db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatalf("BeginTx failed: %v", err)
+			}
+			if err := store.SetTx(ctx, tx, record); err != nil {
+				t.Fatalf("SetTx failed: %v", err)
+			}
+			if commit {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			if err != nil {
+				t.Fatalf("failed to end transaction: %v", err)
+			}
+
+			got, err := store.Get(ctx, "tx-key")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if (got != nil) != commit {
+				t.Errorf("commit=%v: expected record to be stored only on commit, got %v", commit, got)
+			}
+		}
+
+		tx, _ := db.BeginTx(ctx, nil)
+		defer tx.Rollback()
+		if err := store.SetTx(ctx, tx, &idempotency.Record{Key: "expired"}); err == nil {
+			t.Error("expected error for a record without expiration")
+		}
 	})
 
 	// 7. Test Close
@@ -166,3 +282,334 @@ func TestSQLStorage(t *testing.T) {
 		}
 	})
 }
+
+// chanListener is a Listener receiving the notifications of the pg_notify stub
+type chanListener struct {
+	notifications chan string
+}
+
+func (l *chanListener) Listen(ctx context.Context, channel string) error {
+	return nil
+}
+
+func (l *chanListener) WaitForNotification(ctx context.Context) (string, error) {
+	select {
+	case payload := <-l.notifications:
+		return payload, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// pgNotifications receives the payloads sent with the pg_notify stub
+var pgNotifications = make(chan string, 10)
+
+func init() {
+	// SQLite stub of the Postgres pg_notify(channel, payload) function
+	sqlite.MustRegisterScalarFunction("pg_notify", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if args[0] == NotifyChannel {
+			pgNotifications <- args[1].(string)
+		}
+		return nil, nil
+	})
+
+	// SQLite stubs of the Postgres advisory lock functions, advisoryBusy simulating a lock
+	// held by another transaction
+	sqlite.MustRegisterScalarFunction("hashtextextended", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return args[0], nil
+	})
+	sqlite.MustRegisterScalarFunction("pg_try_advisory_xact_lock", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		advisoryKeys <- args[0].(string)
+		return !advisoryBusy.Load(), nil
+	})
+	sqlite.MustRegisterScalarFunction("pg_advisory_xact_lock", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return nil, nil
+	})
+}
+
+// advisoryKeys receives the advisory lock keys taken
+var advisoryKeys = make(chan string, 10)
+
+var advisoryBusy atomic.Bool
+
+func TestSQLStorage_WaitForCompletion(t *testing.T) {
+	listener := &chanListener{notifications: pgNotifications}
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1) // a single in-memory database
+	for _, table := range []string{"idempotency_records", "idempotency_records_locks"} {
+		if _, err := db.Exec(`CREATE TABLE ` + table + ` (key TEXT PRIMARY KEY, data BLOB, expires_at DATETIME)`); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+
+	store := NewSQLStorageWithOptions(db, Options{})
+	defer store.Close() // stops reading notifications
+	ctx := context.Background()
+	pending := &idempotency.Record{Key: "key", Status: idempotency.StatusPending}
+
+	wait := func(timeout time.Duration) chan error {
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			done <- store.WaitForCompletion(ctx, "key")
+		}()
+		return done
+	}
+
+	t.Run("Polling", func(t *testing.T) {
+		_ = store.Set(ctx, pending, time.Hour)
+		done := wait(time.Second)
+		time.Sleep(20 * time.Millisecond)
+		_ = store.Set(ctx, &idempotency.Record{Key: "key", Status: idempotency.StatusCompleted}, time.Hour)
+
+		if err := <-done; err != nil {
+			t.Fatalf("expected completion to be polled, got %v", err)
+		}
+		if len(listener.notifications) != 0 {
+			t.Error("expected no notification before ListenForCompletions")
+		}
+	})
+
+	if err := store.ListenForCompletions(listener); err != nil {
+		t.Fatalf("ListenForCompletions failed: %v", err)
+	}
+
+	t.Run("Notified", func(t *testing.T) {
+		_ = store.Set(ctx, pending, time.Hour)
+		done := wait(time.Second)
+
+		// Wait for the duplicate to subscribe
+		for i := 0; ; i++ {
+			store.notifier.mu.Lock()
+			subscribed := len(store.notifier.waiters["key"]) > 0
+			store.notifier.mu.Unlock()
+			if subscribed {
+				break
+			}
+			if i > 100 {
+				t.Fatal("expected the duplicate to subscribe")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		// Completion is stored without the polling fallback having a chance to run
+		_ = store.Set(ctx, &idempotency.Record{Key: "key", Status: idempotency.StatusCompleted}, time.Hour)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("expected duplicate to be woken, got %v", err)
+			}
+		case <-time.After(waitPollInterval / 2):
+			t.Fatal("expected duplicate to be woken by the notification")
+		}
+	})
+
+	t.Run("Unlock", func(t *testing.T) {
+		_ = store.Set(ctx, pending, time.Hour)
+		done := wait(time.Second)
+		time.Sleep(20 * time.Millisecond)
+		if err := store.Unlock(ctx, "key"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		if err := <-done; err != nil {
+			t.Fatalf("expected duplicate to be woken by Unlock, got %v", err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		_ = store.Set(ctx, pending, time.Hour)
+		if err := <-wait(20 * time.Millisecond); err != context.DeadlineExceeded {
+			t.Errorf("expected deadline exceeded, got %v", err)
+		}
+	})
+}
+
+func TestSQLStorage_AdvisoryLocks(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1) // a single in-memory database
+	// No locks table
+	if _, err := db.Exec(`CREATE TABLE idempotency_records (key TEXT PRIMARY KEY, data BLOB, expires_at DATETIME)`); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	store := NewSQLStorageWithOptions(db, Options{Schema: "main", AdvisoryLocks: true})
+	defer store.Close()
+	ctx := context.Background()
+	pending := func(key string) *idempotency.Record {
+		return &idempotency.Record{Key: key, Status: idempotency.StatusPending, CreatedAt: time.Now()}
+	}
+
+	t.Run("TryLockAndSet", func(t *testing.T) {
+		locked, err := store.TryLockAndSet(ctx, pending("k1"), time.Hour, time.Minute)
+		if err != nil || !locked {
+			t.Fatalf("expected the lock, got %v, %v", locked, err)
+		}
+		if key := <-advisoryKeys; key != "main.idempotency_records:k1" {
+			t.Errorf("expected the advisory lock to be scoped to the table, got %q", key)
+		}
+		if locked, _ := store.TryLockAndSet(ctx, pending("k1"), time.Hour, time.Minute); locked {
+			t.Fatal("expected the pending record to hold the lock")
+		}
+		<-advisoryKeys
+
+		// The lock expires with the LockTimeout of the pending record
+		if locked, _ := store.TryLockAndSet(ctx, pending("k2"), time.Hour, time.Millisecond); !locked {
+			t.Fatal("expected the lock")
+		}
+		time.Sleep(5 * time.Millisecond)
+		if locked, _ := store.TryLockAndSet(ctx, pending("k2"), time.Hour, time.Minute); !locked {
+			t.Fatal("expected the expired lock to be taken over")
+		}
+		<-advisoryKeys
+		<-advisoryKeys
+	})
+
+	t.Run("Busy", func(t *testing.T) {
+		advisoryBusy.Store(true)
+		defer advisoryBusy.Store(false)
+		if locked, err := store.TryLock(ctx, "k3", time.Minute); err != nil || locked {
+			t.Fatalf("expected the advisory lock to be held elsewhere, got %v, %v", locked, err)
+		}
+		<-advisoryKeys
+	})
+
+	t.Run("Unlock", func(t *testing.T) {
+		if locked, _ := store.TryLock(ctx, "k3", time.Minute); !locked {
+			t.Fatal("expected the lock")
+		}
+		<-advisoryKeys
+		if err := store.Unlock(ctx, "k3"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		if got, _ := store.Get(ctx, "k3"); got != nil {
+			t.Errorf("expected the pending record to be deleted, got %v", got)
+		}
+
+		// Completed records are kept
+		_ = store.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}, time.Hour)
+		if err := store.Unlock(ctx, "k1"); err != nil {
+			t.Fatalf("Unlock failed: %v", err)
+		}
+		if got, _ := store.Get(ctx, "k1"); got == nil {
+			t.Error("expected the completed record to be kept")
+		}
+	})
+
+	t.Run("ExtendLock", func(t *testing.T) {
+		if locked, _ := store.TryLockAndSet(ctx, pending("k5"), time.Hour, 50*time.Millisecond); !locked {
+			t.Fatal("expected the lock")
+		}
+		<-advisoryKeys
+		if held, err := store.ExtendLock(ctx, "k5", time.Hour); err != nil || !held {
+			t.Fatalf("expected the lock to be extended, got %v, %v", held, err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if locked, _ := store.TryLockAndSet(ctx, pending("k5"), time.Hour, time.Minute); locked {
+			t.Error("expected the extended lock to be held")
+		}
+		<-advisoryKeys
+		if held, _ := store.ExtendLock(ctx, "missing", time.Hour); held {
+			t.Error("expected a lock not held not to be extended")
+		}
+	})
+
+	t.Run("GetOrLock", func(t *testing.T) {
+		existing, locked, err := store.GetOrLock(ctx, "k4", pending("k4"), time.Hour, time.Minute)
+		if err != nil || existing != nil || !locked {
+			t.Fatalf("expected the lock, got %v, %v, %v", existing, locked, err)
+		}
+		<-advisoryKeys
+		existing, locked, _ = store.GetOrLock(ctx, "k4", pending("k4"), time.Hour, time.Minute)
+		if existing == nil || locked {
+			t.Fatalf("expected the pending record, got %v, %v", existing, locked)
+		}
+		<-advisoryKeys
+	})
+}
+
+func TestSQLStorage_Cleanup(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1) // a single in-memory database
+	_, _ = db.Exec(`CREATE TABLE idempotency_records (key TEXT PRIMARY KEY, data BLOB, expires_at DATETIME)`)
+	_, _ = db.Exec(`CREATE TABLE idempotency_records_locks (key TEXT PRIMARY KEY, expires_at DATETIME)`)
+
+	purged := make(chan int64, 10)
+	store := NewSQLStorageWithOptions(db, Options{
+		CleanupInterval:  20 * time.Millisecond,
+		CleanupBatchSize: 2,
+		OnCleanup: func(records, locks int64, err error) {
+			if err == nil && records+locks > 0 {
+				purged <- records + locks
+			}
+		},
+	})
+	defer store.Close()
+	ctx := context.Background()
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		_ = store.Set(ctx, &idempotency.Record{Key: key, Status: idempotency.StatusCompleted}, time.Millisecond)
+	}
+	_, _ = store.TryLock(ctx, "k1", time.Millisecond)
+	_ = store.Set(ctx, &idempotency.Record{Key: "live", Status: idempotency.StatusCompleted}, time.Hour)
+
+	select {
+	case n := <-purged:
+		if n != 4 {
+			t.Errorf("expected 3 records and 1 lock to be purged, got %d rows", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the janitor to purge expired rows")
+	}
+
+	var remaining int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM idempotency_records`).Scan(&remaining)
+	if remaining != 1 {
+		t.Errorf("expected only the live record to remain, got %d rows", remaining)
+	}
+}
+
+func TestSQLStorage_Migrate(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1) // a single in-memory database
+	defer db.Close()
+	ctx := context.Background()
+
+	for range 2 {
+		if err := Migrate(ctx, db); err != nil {
+			t.Fatalf("expected Migrate to succeed, and to be idempotent, got %v", err)
+		}
+	}
+	store := NewSQLStorage(db, "")
+	if err := store.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusCompleted}, time.Hour); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if acquired, err := store.TryLock(ctx, "k1", time.Minute); err != nil || !acquired {
+		t.Fatalf("expected the lock to be acquired, got %v, %v", acquired, err)
+	}
+
+	custom := NewSQLStorageWithOptions(db, Options{TableName: "orders_idem", Columns: Columns{Key: "idem_key"}})
+	if err := custom.Migrate(ctx); err != nil {
+		t.Fatalf("expected custom names to be migrated, got %v", err)
+	}
+	var indexes int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'orders_idem%_expires_at_idx'`).Scan(&indexes)
+	if indexes != 2 {
+		t.Errorf("expected the expires_at indexes of both tables, got %d", indexes)
+	}
+	if _, _, err := custom.Cleanup(ctx); err != nil {
+		t.Errorf("expected the migrated tables to be cleaned up, got %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+// Package sqlite provides an embedded SQLite storage backend for gopotency.
+// Unlike the generic sql package, it manages its own schema and tunes SQLite for
+// concurrent use: WAL journaling, a busy timeout and writes serialized within the
+// process, so small deployments get a reliable store without an external database.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	_ "modernc.org/sqlite" // Pure Go SQLite driver
+)
+
+// Storage is a SQLite implementation of idempotency.Storage
+type Storage struct {
+	db    *sql.DB
+	codec idempotency.Codec
+	clock idempotency.Clock
+
+	// writeMu serializes writes, SQLite allows a single writer at a time
+	writeMu sync.Mutex
+}
+
+// Options configures the SQLite storage
+type Options struct {
+	// BusyTimeout is how long a write waits for the database lock held by another
+	// process before failing
+	// Default: 5s
+	BusyTimeout time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.BusyTimeout == 0 {
+		o.BusyTimeout = 5 * time.Second
+	}
+}
+
+// Open opens (or creates) the SQLite database at path with WAL journaling and the busy
+// timeout enabled, and creates the idempotency tables if needed. Use ":memory:" for a
+// non-persistent database.
+func Open(path string, opts Options) (*Storage, error) {
+	opts.setDefaults()
+
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", opts.BusyTimeout.Milliseconds()))
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "synchronous(NORMAL)")
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite: %w", err)
+	}
+
+	// Every connection to ":memory:" opens a distinct database
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
+
+	s, err := New(context.Background(), db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New creates a storage on an open SQLite database and creates the idempotency tables
+// if needed. The connection options (WAL, busy timeout) are the responsibility of the
+// caller; prefer Open.
+func New(ctx context.Context, db *sql.DB) (*Storage, error) {
+	s := &Storage{db: db, codec: idempotency.JSONCodec, clock: idempotency.SystemClock}
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate creates the records and locks tables. Expirations are stored as Unix
+// nanoseconds, independent of the driver time format.
+func (s *Storage) migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS idempotency_records (
+			key TEXT PRIMARY KEY,
+			data BLOB NOT NULL,
+			expires_at INTEGER NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_idempotency_records_expires ON idempotency_records(expires_at);
+		CREATE TABLE IF NOT EXISTS idempotency_locks (
+			key TEXT PRIMARY KEY,
+			expires_at INTEGER NOT NULL
+		);`)
+	if err != nil {
+		return idempotency.NewStorageError("migrate", err)
+	}
+	return nil
+}
+
+// SetCodec replaces the codec used to serialize records (default idempotency.JSONCodec).
+// It implements idempotency.CodecSetter and must be called before the storage is used.
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	s.codec = codec
+}
+
+// SetClock replaces the clock deciding expirations (default idempotency.SystemClock).
+// It implements idempotency.ClockSetter and must be called before the storage is used.
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	s.clock = clock
+}
+
+// Get retrieves an idempotency record by key. Expired records are ignored.
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx,
+		"SELECT data FROM idempotency_records WHERE key = ? AND expires_at > ?",
+		key, s.clock.Now().UnixNano()).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, idempotency.NewStorageError("get", err)
+	}
+
+	record, err := s.codec.Unmarshal(data)
+	if err != nil {
+		return nil, idempotency.NewStorageError("unmarshal", err)
+	}
+	return record, nil
+}
+
+// Set stores an idempotency record
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.set(ctx, s.db, record, ttl)
+}
+
+func (s *Storage) set(ctx context.Context, q execer, record *idempotency.Record, ttl time.Duration) error {
+	data, err := s.codec.Marshal(record)
+	if err != nil {
+		return idempotency.NewStorageError("marshal", err)
+	}
+
+	_, err = q.ExecContext(ctx,
+		"INSERT OR REPLACE INTO idempotency_records (key, data, expires_at) VALUES (?, ?, ?)",
+		record.Key, data, s.clock.Now().Add(ttl).UnixNano())
+	if err != nil {
+		return idempotency.NewStorageError("set", err)
+	}
+	return nil
+}
+
+// Delete removes an idempotency record and its lock
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_records WHERE key = ?", key); err != nil {
+			return idempotency.NewStorageError("delete", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_locks WHERE key = ?", key); err != nil {
+			return idempotency.NewStorageError("delete", err)
+		}
+		return nil
+	})
+}
+
+// Exists checks if a non-expired record exists
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM idempotency_records WHERE key = ? AND expires_at > ?)",
+		key, s.clock.Now().UnixNano()).Scan(&exists)
+	if err != nil {
+		return false, idempotency.NewStorageError("exists", err)
+	}
+	return exists, nil
+}
+
+// List returns all non-expired records. It implements idempotency.RecordLister.
+func (s *Storage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT data FROM idempotency_records WHERE expires_at > ?", s.clock.Now().UnixNano())
+	if err != nil {
+		return nil, idempotency.NewStorageError("list", err)
+	}
+	defer rows.Close()
+
+	var records []*idempotency.Record
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, idempotency.NewStorageError("list", err)
+		}
+		record, err := s.codec.Unmarshal(data)
+		if err != nil {
+			return nil, idempotency.NewStorageError("unmarshal", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, idempotency.NewStorageError("list", err)
+	}
+	return records, nil
+}
+
+// TryLock attempts to acquire the lock for key. An expired lock is replaced, then the
+// lock is inserted with INSERT OR IGNORE: it is acquired only if a row was inserted.
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var locked bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		locked, err = s.tryLock(ctx, tx, key, ttl)
+		return err
+	})
+	return locked, err
+}
+
+func (s *Storage) tryLock(ctx context.Context, tx *sql.Tx, key string, ttl time.Duration) (bool, error) {
+	now := s.clock.Now()
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM idempotency_locks WHERE key = ? AND expires_at <= ?", key, now.UnixNano()); err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT OR IGNORE INTO idempotency_locks (key, expires_at) VALUES (?, ?)",
+		key, now.Add(ttl).UnixNano())
+	if err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, idempotency.NewStorageError("trylock", err)
+	}
+	return n == 1, nil
+}
+
+// TryLockAndSet acquires the lock and stores the pending record in a single
+// transaction. It implements idempotency.LockSetter.
+func (s *Storage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var locked bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if locked, err = s.tryLock(ctx, tx, record.Key, lockTTL); err != nil || !locked {
+			return err
+		}
+		return s.set(ctx, tx, record, ttl)
+	})
+	if err != nil {
+		return false, err
+	}
+	return locked, nil
+}
+
+// GetOrLock returns the non-expired record for key if there is one, otherwise acquires
+// the lock and stores record, in a single transaction. It implements
+// idempotency.GetOrLocker.
+func (s *Storage) GetOrLock(ctx context.Context, key string, record *idempotency.Record, ttl, lockTTL time.Duration) (*idempotency.Record, bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var existing *idempotency.Record
+	var locked bool
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var data []byte
+		err := tx.QueryRowContext(ctx,
+			"SELECT data FROM idempotency_records WHERE key = ? AND expires_at > ?",
+			key, s.clock.Now().UnixNano()).Scan(&data)
+		switch {
+		case err == nil:
+			if existing, err = s.codec.Unmarshal(data); err != nil {
+				return idempotency.NewStorageError("unmarshal", err)
+			}
+			return nil
+		case err != sql.ErrNoRows:
+			return idempotency.NewStorageError("get", err)
+		}
+
+		if locked, err = s.tryLock(ctx, tx, key, lockTTL); err != nil || !locked {
+			return err
+		}
+		return s.set(ctx, tx, record, ttl)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, locked, nil
+}
+
+// ExtendLock makes the lock held for key expire ttl from now, reporting false if it
+// expired or was released. It implements idempotency.LockExtender.
+func (s *Storage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	now := s.clock.Now()
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE idempotency_locks SET expires_at = ? WHERE key = ? AND expires_at > ?",
+		now.Add(ttl).UnixNano(), key, now.UnixNano())
+	if err != nil {
+		return false, idempotency.NewStorageError("extendlock", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, idempotency.NewStorageError("extendlock", err)
+	}
+	return n == 1, nil
+}
+
+// LockTTL returns the time until the lock held for key expires, 0 when it is not held.
+// It implements idempotency.LockTTLReader.
+func (s *Storage) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	var expiresAt int64
+	err := s.db.QueryRowContext(ctx, "SELECT expires_at FROM idempotency_locks WHERE key = ?", key).Scan(&expiresAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, nil
+	case err != nil:
+		return 0, idempotency.NewStorageError("lockttl", err)
+	}
+	return max(time.Unix(0, expiresAt).Sub(s.clock.Now()), 0), nil
+}
+
+// Unlock releases the lock for key
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_locks WHERE key = ?", key); err != nil {
+		return idempotency.NewStorageError("unlock", err)
+	}
+	return nil
+}
+
+// Cleanup deletes expired records and locks, e.g. periodically from a background job.
+// Expired rows are already ignored by reads.
+func (s *Storage) Cleanup(ctx context.Context) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	now := s.clock.Now().UnixNano()
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_records WHERE expires_at <= ?", now); err != nil {
+			return idempotency.NewStorageError("cleanup", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM idempotency_locks WHERE expires_at <= ?", now); err != nil {
+			return idempotency.NewStorageError("cleanup", err)
+		}
+		return nil
+	})
+}
+
+// Close closes the database
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// withTx runs fn in a transaction, committed if fn succeeds and rolled back otherwise
+func (s *Storage) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return idempotency.NewStorageError("begin", err)
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return idempotency.NewStorageError("commit", err)
+	}
+	return nil
+}
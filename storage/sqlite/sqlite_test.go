@@ -0,0 +1,179 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	store, err := Open(":memory:", Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	t.Run("SetAndGet", func(t *testing.T) {
+		record := &idempotency.Record{Key: "key1", Status: idempotency.StatusCompleted}
+		if err := store.Set(ctx, record, time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+
+		got, err := store.Get(ctx, "key1")
+		if err != nil || got == nil || got.Status != idempotency.StatusCompleted {
+			t.Fatalf("expected completed record, got %v, %v", got, err)
+		}
+		if exists, _ := store.Exists(ctx, "key1"); !exists {
+			t.Error("expected record to exist")
+		}
+		if records, _ := store.List(ctx); len(records) != 1 {
+			t.Errorf("expected 1 listed record, got %d", len(records))
+		}
+	})
+
+	t.Run("LockTTL", func(t *testing.T) {
+		if ttl, err := store.LockTTL(ctx, "ttl-key"); err != nil || ttl != 0 {
+			t.Errorf("expected no TTL without a lock, got %s, %v", ttl, err)
+		}
+		_, _ = store.TryLock(ctx, "ttl-key", time.Minute)
+		defer store.Unlock(ctx, "ttl-key")
+		if ttl, err := store.LockTTL(ctx, "ttl-key"); err != nil || ttl <= 50*time.Second || ttl > time.Minute {
+			t.Errorf("expected about a minute left on the lock, got %s, %v", ttl, err)
+		}
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		_ = store.Set(ctx, &idempotency.Record{Key: "short"}, time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		if got, _ := store.Get(ctx, "short"); got != nil {
+			t.Errorf("expected expired record to be ignored, got %v", got)
+		}
+		if err := store.Cleanup(ctx); err != nil {
+			t.Fatalf("Cleanup failed: %v", err)
+		}
+	})
+
+	t.Run("Locking", func(t *testing.T) {
+		if locked, err := store.TryLock(ctx, "lock1", time.Hour); err != nil || !locked {
+			t.Fatalf("expected lock to be acquired, got %v, %v", locked, err)
+		}
+		if locked, _ := store.TryLock(ctx, "lock1", time.Hour); locked {
+			t.Error("expected lock to be held")
+		}
+		_ = store.Unlock(ctx, "lock1")
+		if locked, _ := store.TryLock(ctx, "lock1", time.Millisecond); !locked {
+			t.Error("expected lock to be acquired after unlock")
+		}
+		time.Sleep(5 * time.Millisecond)
+		if locked, _ := store.TryLock(ctx, "lock1", time.Hour); !locked {
+			t.Error("expected expired lock to be taken over")
+		}
+	})
+
+	t.Run("ExtendLock", func(t *testing.T) {
+		if held, _ := store.ExtendLock(ctx, "extend-key", time.Hour); held {
+			t.Error("expected a lock not held not to be extended")
+		}
+		_, _ = store.TryLock(ctx, "extend-key", 50*time.Millisecond)
+		if held, err := store.ExtendLock(ctx, "extend-key", time.Hour); err != nil || !held {
+			t.Fatalf("expected the lock to be extended, got %v, %v", held, err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if locked, _ := store.TryLock(ctx, "extend-key", time.Hour); locked {
+			t.Error("expected the extended lock to be held")
+		}
+	})
+
+	t.Run("TryLockAndSet", func(t *testing.T) {
+		record := &idempotency.Record{Key: "atomic", Status: idempotency.StatusPending}
+		if locked, err := store.TryLockAndSet(ctx, record, time.Hour, time.Hour); err != nil || !locked {
+			t.Fatalf("expected lock to be acquired, got %v, %v", locked, err)
+		}
+		record.Status = idempotency.StatusCompleted
+		if locked, _ := store.TryLockAndSet(ctx, record, time.Hour, time.Hour); locked {
+			t.Error("expected lock to be held")
+		}
+		if got, _ := store.Get(ctx, "atomic"); got == nil || got.Status != idempotency.StatusPending {
+			t.Errorf("expected record not to be overwritten without the lock, got %v", got)
+		}
+
+		if err := store.Delete(ctx, "atomic"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if locked, _ := store.TryLock(ctx, "atomic", time.Hour); !locked {
+			t.Error("expected Delete to release the lock")
+		}
+	})
+
+	t.Run("GetOrLock", func(t *testing.T) {
+		record := &idempotency.Record{Key: "get-or-lock", Status: idempotency.StatusPending}
+		existing, locked, err := store.GetOrLock(ctx, "get-or-lock", record, time.Hour, time.Hour)
+		if err != nil || !locked || existing != nil {
+			t.Fatalf("expected lock to be acquired, got %v, %v, %v", existing, locked, err)
+		}
+
+		other := &idempotency.Record{Key: "get-or-lock", Status: idempotency.StatusCompleted}
+		existing, locked, err = store.GetOrLock(ctx, "get-or-lock", other, time.Hour, time.Hour)
+		if err != nil || locked || existing == nil || existing.Status != idempotency.StatusPending {
+			t.Fatalf("expected pending record, got %v, %v, %v", existing, locked, err)
+		}
+
+		// Lock held without record: nothing is returned nor written
+		_ = store.Set(ctx, &idempotency.Record{Key: "get-or-lock"}, -time.Second)
+		existing, locked, err = store.GetOrLock(ctx, "get-or-lock", other, time.Hour, time.Hour)
+		if err != nil || locked || existing != nil {
+			t.Fatalf("expected lock to be held, got %v, %v, %v", existing, locked, err)
+		}
+		if got, _ := store.Get(ctx, "get-or-lock"); got != nil {
+			t.Errorf("expected no record to be written, got %v", got)
+		}
+	})
+}
+
+func TestSQLiteStorage_ConcurrentFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.db")
+	store, err := Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	var mode string
+	if err := store.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("expected WAL journal mode, got %q, %v", mode, err)
+	}
+
+	// A single request must win the lock, without SQLITE_BUSY errors
+	var wg sync.WaitGroup
+	var acquired atomic.Int32
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locked, err := store.TryLock(ctx, "contended", time.Hour)
+			if err != nil {
+				errs <- err
+			}
+			if locked {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("TryLock failed: %v", err)
+	}
+	if acquired.Load() != 1 {
+		t.Errorf("expected exactly one lock holder, got %d", acquired.Load())
+	}
+}
@@ -0,0 +1,119 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// The optional extensions are forwarded to the remote storage. The manager only uses
+// those the remote storage implements (see idempotency.StorageWrapper).
+
+// Unwrap returns the remote storage. It implements idempotency.StorageWrapper.
+func (s *Storage) Unwrap() idempotency.Storage {
+	return s.remote
+}
+
+// TryLockAndSet acquires the lock and stores the pending record on the remote storage.
+// It implements idempotency.LockSetter.
+func (s *Storage) TryLockAndSet(ctx context.Context, record *idempotency.Record, ttl, lockTTL time.Duration) (bool, error) {
+	setter, ok := s.remote.(idempotency.LockSetter)
+	if !ok {
+		return false, unsupported("trylockandset")
+	}
+	s.evict(record.Key)
+	return setter.TryLockAndSet(ctx, record, ttl, lockTTL)
+}
+
+// SetAndUnlock stores the completed record and releases its lock on the remote storage,
+// then caches the record. It implements idempotency.SetUnlocker.
+func (s *Storage) SetAndUnlock(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	unlocker, ok := s.remote.(idempotency.SetUnlocker)
+	if !ok {
+		return unsupported("setandunlock")
+	}
+	if err := unlocker.SetAndUnlock(ctx, record, ttl); err != nil {
+		s.evict(record.Key)
+		return err
+	}
+	s.store(record, ttl)
+	return nil
+}
+
+// GetOrLock returns the locally cached record, or reads the record or acquires the lock
+// on the remote storage. It implements idempotency.GetOrLocker.
+func (s *Storage) GetOrLock(ctx context.Context, key string, record *idempotency.Record, ttl, lockTTL time.Duration) (*idempotency.Record, bool, error) {
+	locker, ok := s.remote.(idempotency.GetOrLocker)
+	if !ok {
+		return nil, false, unsupported("getorlock")
+	}
+	if cached := s.lookup(key); cached != nil {
+		s.hits.Add(1)
+		return cached, false, nil
+	}
+	s.misses.Add(1)
+
+	existing, locked, err := locker.GetOrLock(ctx, key, record, ttl, lockTTL)
+	if err == nil && existing != nil {
+		s.store(existing, s.opts.LocalTTL)
+	}
+	return existing, locked, err
+}
+
+// ExtendLock renews the lock on the remote storage. It implements
+// idempotency.LockExtender.
+func (s *Storage) ExtendLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	extender, ok := s.remote.(idempotency.LockExtender)
+	if !ok {
+		return false, unsupported("extendlock")
+	}
+	return extender.ExtendLock(ctx, key, ttl)
+}
+
+// LockTTL returns the remaining time-to-live of the lock on the remote storage. It
+// implements idempotency.LockTTLReader.
+func (s *Storage) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	reader, ok := s.remote.(idempotency.LockTTLReader)
+	if !ok {
+		return 0, unsupported("lockttl")
+	}
+	return reader.LockTTL(ctx, key)
+}
+
+// WaitForCompletion waits for the record on the remote storage. It implements
+// idempotency.CompletionWaiter.
+func (s *Storage) WaitForCompletion(ctx context.Context, key string) error {
+	waiter, ok := s.remote.(idempotency.CompletionWaiter)
+	if !ok {
+		return unsupported("waitforcompletion")
+	}
+	return waiter.WaitForCompletion(ctx, key)
+}
+
+// List returns the records of the remote storage. It implements
+// idempotency.RecordLister.
+func (s *Storage) List(ctx context.Context) ([]*idempotency.Record, error) {
+	lister, ok := s.remote.(idempotency.RecordLister)
+	if !ok {
+		return nil, idempotency.ErrListingNotSupported
+	}
+	return lister.List(ctx)
+}
+
+// OnExpired registers fn with the remote storage, evicting expired records from the
+// local cache. It implements idempotency.ExpiryNotifier.
+func (s *Storage) OnExpired(fn func(key string, record *idempotency.Record)) {
+	if notifier, ok := s.remote.(idempotency.ExpiryNotifier); ok {
+		notifier.OnExpired(func(key string, record *idempotency.Record) {
+			s.evict(key)
+			fn(key, record)
+		})
+	}
+}
+
+// unsupported is returned by the extensions the remote storage does not implement
+func unsupported(op string) error {
+	return idempotency.NewStorageError(op, errors.ErrUnsupported)
+}
@@ -0,0 +1,243 @@
+// Package tiered provides a two-tier storage wrapper: an in-process LRU cache in front
+// of a remote storage (Redis, SQL...), so replays of hot keys are served without a
+// round-trip:
+//
+//	store := tiered.New(redisStore, tiered.Options{Size: 10000, LocalTTL: time.Minute})
+//
+// Writes go through to the remote storage, which remains the source of truth. Only
+// completed records are cached locally: pending records change when another instance
+// completes them, whereas completed ones are only replaced by an explicit Delete. A
+// Delete made through another instance is seen once the local entry expires, after
+// LocalTTL at most. Locks are always acquired on the remote storage.
+package tiered
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Options configures the tiered storage
+type Options struct {
+	// Size is the maximum number of records cached locally, the least recently used
+	// record is evicted beyond it
+	// Default: 10000
+	Size int
+
+	// LocalTTL is the maximum time a record is served from the local cache, bounding how
+	// long a record deleted through another instance can still be replayed. Records are
+	// never cached beyond their own expiration.
+	// Default: 1m
+	LocalTTL time.Duration
+}
+
+func (o *Options) setDefaults() {
+	if o.Size <= 0 {
+		o.Size = 10000
+	}
+	if o.LocalTTL <= 0 {
+		o.LocalTTL = time.Minute
+	}
+}
+
+// Stats are counters describing the local cache
+type Stats struct {
+	// Entries is the number of records cached locally, including expired ones not
+	// evicted yet
+	Entries int
+
+	// Hits is the number of reads served by the local cache
+	Hits uint64
+
+	// Misses is the number of reads sent to the remote storage
+	Misses uint64
+}
+
+// Storage caches completed records of a remote storage in a local LRU
+type Storage struct {
+	remote idempotency.Storage
+	opts   Options
+	clock  idempotency.Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type entry struct {
+	record    *idempotency.Record
+	expiresAt time.Time
+}
+
+// New creates a tiered storage caching records of remote
+func New(remote idempotency.Storage, opts Options) *Storage {
+	opts.setDefaults()
+	return &Storage{
+		remote:  remote,
+		opts:    opts,
+		clock:   idempotency.SystemClock,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Stats returns the current cache counters
+func (s *Storage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Stats{
+		Entries: s.lru.Len(),
+		Hits:    s.hits.Load(),
+		Misses:  s.misses.Load(),
+	}
+}
+
+// Get returns the locally cached record, or reads it from the remote storage and caches
+// it when completed
+func (s *Storage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	if record := s.lookup(key); record != nil {
+		s.hits.Add(1)
+		return record, nil
+	}
+	s.misses.Add(1)
+
+	record, err := s.remote.Get(ctx, key)
+	if err != nil || record == nil {
+		return record, err
+	}
+	s.store(record, s.opts.LocalTTL)
+	return record, nil
+}
+
+// Set writes record to the remote storage, then caches it when completed
+func (s *Storage) Set(ctx context.Context, record *idempotency.Record, ttl time.Duration) error {
+	if err := s.remote.Set(ctx, record, ttl); err != nil {
+		s.evict(record.Key)
+		return err
+	}
+	s.store(record, ttl)
+	return nil
+}
+
+// Delete removes the record from the local cache and the remote storage
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	s.evict(key)
+	return s.remote.Delete(ctx, key)
+}
+
+// Exists checks if the record is cached locally or exists on the remote storage
+func (s *Storage) Exists(ctx context.Context, key string) (bool, error) {
+	if s.lookup(key) != nil {
+		return true, nil
+	}
+	return s.remote.Exists(ctx, key)
+}
+
+// TryLock acquires the lock on the remote storage
+func (s *Storage) TryLock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.remote.TryLock(ctx, key, ttl)
+}
+
+// Unlock releases the lock on the remote storage
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	return s.remote.Unlock(ctx, key)
+}
+
+// SetCodec sets the codec of the remote storage if it serializes records
+func (s *Storage) SetCodec(codec idempotency.Codec) {
+	if setter, ok := s.remote.(idempotency.CodecSetter); ok {
+		setter.SetCodec(codec)
+	}
+}
+
+// SetClock sets the clock expiring the local cache, and the clock of the remote storage
+// if it computes expirations. It must be called before the storage is used.
+func (s *Storage) SetClock(clock idempotency.Clock) {
+	s.clock = clock
+
+	if setter, ok := s.remote.(idempotency.ClockSetter); ok {
+		setter.SetClock(clock)
+	}
+}
+
+// Close clears the local cache and closes the remote storage
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	s.entries = make(map[string]*list.Element)
+	s.lru.Init()
+	s.mu.Unlock()
+
+	return s.remote.Close()
+}
+
+// lookup returns the non-expired cached record for key, marking it recently used
+func (s *Storage) lookup(key string) *idempotency.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*entry)
+	if !s.clock.Now().Before(e.expiresAt) {
+		s.lru.Remove(elem)
+		delete(s.entries, key)
+		return nil
+	}
+	s.lru.MoveToFront(elem)
+	return e.record
+}
+
+// store caches record for ttl, capped by LocalTTL and the record expiration, when it is
+// completed. Other records evict the cached one.
+func (s *Storage) store(record *idempotency.Record, ttl time.Duration) {
+	if record.Status != idempotency.StatusCompleted {
+		s.evict(record.Key)
+		return
+	}
+
+	now := s.clock.Now()
+	expiresAt := now.Add(min(ttl, s.opts.LocalTTL))
+	if !record.ExpiresAt.IsZero() && record.ExpiresAt.Before(expiresAt) {
+		expiresAt = record.ExpiresAt
+	}
+	if !now.Before(expiresAt) {
+		s.evict(record.Key)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[record.Key]; ok {
+		elem.Value = &entry{record: record, expiresAt: expiresAt}
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.entries[record.Key] = s.lru.PushFront(&entry{record: record, expiresAt: expiresAt})
+	if s.lru.Len() > s.opts.Size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).record.Key)
+	}
+}
+
+// evict removes key from the local cache
+func (s *Storage) evict(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.lru.Remove(elem)
+		delete(s.entries, key)
+	}
+}
@@ -0,0 +1,158 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+	"github.com/fco-gt/gopotency/storage/memory"
+)
+
+// countingStorage counts the reads reaching the remote storage
+type countingStorage struct {
+	*memory.Storage
+	gets int
+}
+
+func (c *countingStorage) Get(ctx context.Context, key string) (*idempotency.Record, error) {
+	c.gets++
+	return c.Storage.Get(ctx, key)
+}
+
+func TestTieredStorage(t *testing.T) {
+	ctx := context.Background()
+	completed := func(key string) *idempotency.Record {
+		return &idempotency.Record{Key: key, Status: idempotency.StatusCompleted, Response: &idempotency.CachedResponse{StatusCode: 201}}
+	}
+
+	t.Run("ServesCompletedRecordsLocally", func(t *testing.T) {
+		remote := &countingStorage{Storage: memory.NewMemoryStorage()}
+		store := New(remote, Options{})
+		defer store.Close()
+
+		if err := store.Set(ctx, completed("k1"), time.Hour); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		if got, _ := remote.Storage.Get(ctx, "k1"); got == nil {
+			t.Fatal("expected the record to be written through to the remote storage")
+		}
+		for range 3 {
+			if got, err := store.Get(ctx, "k1"); err != nil || got == nil {
+				t.Fatalf("expected the cached record, got %v, %v", got, err)
+			}
+		}
+		if remote.gets != 0 {
+			t.Errorf("expected no remote read, got %d", remote.gets)
+		}
+		if stats := store.Stats(); stats.Hits != 3 || stats.Entries != 1 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("ReadsPendingRecordsRemotely", func(t *testing.T) {
+		remote := &countingStorage{Storage: memory.NewMemoryStorage()}
+		store := New(remote, Options{})
+		defer store.Close()
+
+		_ = store.Set(ctx, &idempotency.Record{Key: "k1", Status: idempotency.StatusPending}, time.Hour)
+		_, _ = store.Get(ctx, "k1")
+		_ = remote.Set(ctx, completed("k1"), time.Hour) // completed by another instance
+		if got, _ := store.Get(ctx, "k1"); got == nil || got.Status != idempotency.StatusCompleted {
+			t.Errorf("expected the completion to be read from the remote storage, got %v", got)
+		}
+		if remote.gets != 2 {
+			t.Errorf("expected 2 remote reads, got %d", remote.gets)
+		}
+	})
+
+	t.Run("LocalTTL", func(t *testing.T) {
+		remote := &countingStorage{Storage: memory.NewMemoryStorage()}
+		store := New(remote, Options{LocalTTL: 10 * time.Millisecond})
+		defer store.Close()
+
+		_ = store.Set(ctx, completed("k1"), time.Hour)
+		_ = remote.Delete(ctx, "k1") // deleted through another instance
+		if got, _ := store.Get(ctx, "k1"); got == nil {
+			t.Error("expected the record to be served locally")
+		}
+		time.Sleep(20 * time.Millisecond)
+		if got, _ := store.Get(ctx, "k1"); got != nil {
+			t.Errorf("expected the local entry to expire, got %v", got)
+		}
+	})
+
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		remote := &countingStorage{Storage: memory.NewMemoryStorage()}
+		store := New(remote, Options{Size: 2})
+		defer store.Close()
+
+		_ = store.Set(ctx, completed("k1"), time.Hour)
+		_ = store.Set(ctx, completed("k2"), time.Hour)
+		_, _ = store.Get(ctx, "k1")
+		_ = store.Set(ctx, completed("k3"), time.Hour)
+
+		_, _ = store.Get(ctx, "k1")
+		_, _ = store.Get(ctx, "k3")
+		if remote.gets != 0 {
+			t.Errorf("expected k1 and k3 to be cached, got %d remote reads", remote.gets)
+		}
+		_, _ = store.Get(ctx, "k2")
+		if remote.gets != 1 {
+			t.Errorf("expected k2 to be evicted, got %d remote reads", remote.gets)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		remote := &countingStorage{Storage: memory.NewMemoryStorage()}
+		store := New(remote, Options{})
+		defer store.Close()
+
+		_ = store.Set(ctx, completed("k1"), time.Hour)
+		if err := store.Delete(ctx, "k1"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if exists, _ := store.Exists(ctx, "k1"); exists {
+			t.Error("expected the record to be deleted from both tiers")
+		}
+	})
+}
+
+func TestTieredStorage_Extensions(t *testing.T) {
+	ctx := context.Background()
+	store := New(memory.NewMemoryStorage(), Options{})
+	defer store.Close()
+
+	var s idempotency.Storage = store
+	if _, ok := s.(idempotency.StorageWrapper); !ok {
+		t.Fatal("expected the tiered storage to be a StorageWrapper")
+	}
+	if !idempotency.Supports[idempotency.GetOrLocker](s) || !idempotency.Supports[idempotency.LockExtender](s) ||
+		!idempotency.Supports[idempotency.LockTTLReader](s) || !idempotency.Supports[idempotency.RecordLister](s) {
+		t.Error("expected the extensions of the memory storage to be forwarded")
+	}
+	if idempotency.Supports[idempotency.SetUnlocker](s) {
+		t.Error("expected extensions missing from the memory storage not to be supported")
+	}
+
+	// Lock renewal requires LockExtender
+	m, err := idempotency.NewManager(idempotency.Config{Storage: store, LockRenewalInterval: time.Second})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	for range 2 {
+		resp, err := m.Execute(ctx, "k1", func(ctx context.Context) (*idempotency.Response, error) {
+			return &idempotency.Response{StatusCode: 201}, nil
+		})
+		if err != nil || resp.StatusCode != 201 {
+			t.Fatalf("expected the response to be stored and replayed, got %v", err)
+		}
+	}
+	if records, err := m.ListStale(ctx); err != nil || len(records) != 0 {
+		t.Errorf("expected listing through the tiered storage, got %v, %v", records, err)
+	}
+	if err := store.SetAndUnlock(ctx, &idempotency.Record{Key: "k2"}, time.Hour); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for an extension of the remote storage, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans created by the manager
+const tracerName = "github.com/fco-gt/gopotency"
+
+// Span attributes set by the manager
+const (
+	attrKey        = attribute.Key("idempotency.key")
+	attrCacheHit   = attribute.Key("idempotency.cache_hit")
+	attrOutcome    = attribute.Key("idempotency.outcome")
+	attrStatusCode = attribute.Key("http.response.status_code")
+	attrOperation  = attribute.Key("idempotency.storage.operation")
+	attrBackend    = attribute.Key("idempotency.storage.backend")
+	attrFound      = attribute.Key("idempotency.storage.found")
+)
+
+// newTracer returns the tracer of provider, nil when tracing is disabled
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		return nil
+	}
+	return provider.Tracer(tracerName)
+}
+
+// backendName returns the name of the storage reported in spans, e.g. "memory.Storage"
+func backendName(storage Storage) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", storage), "*")
+}
+
+// startSpan starts a span named name as a child of ctx. A no-op span is returned when
+// Config.TracerProvider is not set.
+func (m *Manager) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if m.tracer == nil {
+		return ctx, noop.Span{}
+	}
+	return m.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, recording err. Conflicts with a request in progress are expected
+// and do not mark the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrRequestInProgress) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,82 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestManager_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	storage := newListingStorage()
+	m, _ := NewManager(Config{Storage: storage, TracerProvider: provider})
+	ctx := context.Background()
+
+	outcome, err := m.Begin(ctx, &Request{Method: "POST", IdempotencyKey: "k1"})
+	if err != nil || outcome.Kind != OutcomeProceed {
+		t.Fatalf("expected to proceed, got %v, %v", outcome.Kind, err)
+	}
+	if err := outcome.Token.Complete(&Response{StatusCode: 201}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.Check(ctx, &Request{Method: "POST", IdempotencyKey: "k1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	attrs := func(name string) map[attribute.Key]attribute.Value {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("expected a %s span, got %v", name, spans)
+		}
+		values := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			values[kv.Key] = kv.Value
+		}
+		return values
+	}
+
+	if got := attrs("idempotency.Begin"); got[attrOutcome].AsString() != "proceed" || got[attrKey].AsString() != "k1" {
+		t.Errorf("unexpected Begin attributes: %v", got)
+	}
+	if got := attrs("idempotency.Store"); got[attrStatusCode].AsInt64() != 201 {
+		t.Errorf("unexpected Store attributes: %v", got)
+	}
+	if got := attrs("idempotency.Check"); !got[attrCacheHit].AsBool() {
+		t.Errorf("expected a cache hit, got %v", got)
+	}
+	got := attrs("idempotency.storage.get")
+	if got[attrBackend].AsString() != "idempotency.listingStorage" || got[attrOperation].AsString() != "get" || !got[attrFound].AsBool() {
+		t.Errorf("unexpected storage attributes: %v", got)
+	}
+	if spans["idempotency.storage.get"].Parent().SpanID() != spans["idempotency.Check"].SpanContext().SpanID() {
+		t.Error("expected the storage span to be a child of the Check span")
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		storage := &MockStorage{
+			GetFunc: func(ctx context.Context, key string) (*Record, error) { return nil, errors.New("down") },
+		}
+		m, _ := NewManager(Config{Storage: storage, TracerProvider: provider, FailClosed: true})
+
+		if _, err := m.Check(ctx, &Request{Method: "POST", IdempotencyKey: "k1"}); err == nil {
+			t.Fatal("expected a storage error")
+		}
+		for _, span := range recorder.Ended() {
+			if span.Status().Code != codes.Error {
+				t.Errorf("expected %s to be failed, got %v", span.Name(), span.Status())
+			}
+		}
+	})
+}
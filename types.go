@@ -1,6 +1,11 @@
 package idempotency
 
-import "time"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
 
 // RecordStatus represents the status of an idempotency record
 type RecordStatus string
@@ -33,8 +38,23 @@ type Record struct {
 	// CreatedAt is when the record was created
 	CreatedAt time.Time
 
+	// Error describes why processing failed if status is failed (optional)
+	Error string
+
 	// ExpiresAt is when the record should expire
 	ExpiresAt time.Time
+
+	// TTL is the retention resolved from the request policy, used when the record is
+	// updated (optional, Config.TTL when zero)
+	TTL time.Duration
+
+	// LockTimeout is the lock timeout resolved from the request policy, used to report
+	// expired locks (optional, Config.LockTimeout when zero)
+	LockTimeout time.Duration
+
+	// Replays is the number of times the response was replayed, counted with
+	// Config.TrackReplays
+	Replays int
 }
 
 // CachedResponse represents a cached HTTP response
@@ -50,9 +70,33 @@ type CachedResponse struct {
 
 	// ContentType is the content type of the response
 	ContentType string
+
+	// ETag is the entity tag of the response, the ETag header of the handler or a hash of
+	// Body, set when the response is cached with Config.HTTPCaching (optional)
+	ETag string
+
+	// CompletedAt is when the original request completed
+	CompletedAt time.Time
+
+	// Region is the region that processed the original request (optional)
+	Region string
+
+	// Truncated is set when Body was truncated to Config.MaxCachedBodySize
+	// (BodyOverflowTruncate)
+	Truncated bool
+
+	// BlobKey is the key of the body in Config.BlobStore, when it was too large to be
+	// cached with the record (BodyOverflowBlob). Body is then empty in storage.
+	BlobKey string
+
+	// ExpiresAt is when the record expires, set on the responses returned for replays
+	// (not stored, see Record.ExpiresAt)
+	ExpiresAt time.Time `json:"-"`
 }
 
-// Request represents an incoming HTTP request for idempotency checking
+// Request represents an incoming HTTP request for idempotency checking.
+// The middlewares recycle their requests once handled, so key strategies, hashers and
+// hooks must not retain them.
 type Request struct {
 	// Method is the HTTP method (GET, POST, etc.)
 	Method string
@@ -60,6 +104,9 @@ type Request struct {
 	// Path is the request path
 	Path string
 
+	// Query is the raw (encoded) query string, without the leading "?"
+	Query string
+
 	// Headers are the request headers
 	Headers map[string][]string
 
@@ -68,6 +115,45 @@ type Request struct {
 
 	// IdempotencyKey is the extracted or generated idempotency key
 	IdempotencyKey string
+
+	// bodyDigest caches BodyDigest
+	bodyDigest string
+
+	// hash caches the RequestHasher result between Check and Lock
+	hash   string
+	hashed bool
+
+	// generated is set when IdempotencyKey was generated by the key strategy
+	generated bool
+
+	// scoped is set once IdempotencyKey is transformed and versioned (see scopeKey)
+	scoped bool
+
+	// keyExpired is set by Check when the key is reused after its record expired
+	keyExpired bool
+
+	// ctx is the context the request is checked with (see Context)
+	ctx context.Context
+}
+
+// Context returns the context passed to Check, Lock or Begin with the request, so key
+// strategies can read values set by upstream middlewares (see WithKeyScope). It is
+// never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// BodyDigest returns the hex-encoded SHA-256 digest of Body. It is computed once and
+// shared by key strategies and request hashers, so Body must not change afterwards.
+func (r *Request) BodyDigest() string {
+	if r.bodyDigest == "" {
+		sum := sha256.Sum256(r.Body)
+		r.bodyDigest = hex.EncodeToString(sum[:])
+	}
+	return r.bodyDigest
 }
 
 // Response represents an HTTP response to be cached
@@ -75,7 +161,8 @@ type Response struct {
 	// StatusCode is the HTTP status code
 	StatusCode int
 
-	// Headers are the response headers
+	// Headers are the response headers. They may be the live headers of the response
+	// writer: the manager copies them when the response is cached
 	Headers map[string][]string
 
 	// Body is the response body
@@ -83,6 +170,17 @@ type Response struct {
 
 	// ContentType is the content type of the response
 	ContentType string
+
+	// Truncated is set when Body was truncated to Config.MaxCachedBodySize
+	Truncated bool
+
+	// BlobKey is the key of the body in Config.BlobStore, when it was too large to be
+	// cached with the record
+	BlobKey string
+
+	// TTL overrides the retention of this response, e.g. short for price quotes and long
+	// for created resources (optional, the policy TTL when zero)
+	TTL time.Duration
 }
 
 // ToCachedResponse converts a Response to a CachedResponse
@@ -92,5 +190,7 @@ func (r *Response) ToCachedResponse() *CachedResponse {
 		Headers:     r.Headers,
 		Body:        r.Body,
 		ContentType: r.ContentType,
+		Truncated:   r.Truncated,
+		BlobKey:     r.BlobKey,
 	}
 }
@@ -1,6 +1,9 @@
 package idempotency
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 func TestResponse_ToCachedResponse(t *testing.T) {
 	resp := &Response{
@@ -29,3 +32,40 @@ func TestResponse_ToCachedResponse(t *testing.T) {
 	}
 }
 
+
+type countingHasher struct{ calls int }
+
+func (h *countingHasher) Hash(req *Request) (string, error) {
+	h.calls++
+	return req.BodyDigest(), nil
+}
+
+func TestRequest_HashedOnce(t *testing.T) {
+	req := &Request{Method: "POST", Body: []byte(`{"amount":10}`), IdempotencyKey: "k1"}
+	digest := req.BodyDigest()
+	if len(digest) != 64 {
+		t.Fatalf("expected hex SHA-256 digest, got %q", digest)
+	}
+	req.Body = nil
+	if req.BodyDigest() != digest {
+		t.Error("expected digest to be computed once")
+	}
+
+	hasher := &countingHasher{}
+	// A failed record is hashed by Check, then retried through Lock
+	m, _ := NewManager(Config{
+		Storage: &MockStorage{
+			GetFunc: func(ctx context.Context, key string) (*Record, error) {
+				return &Record{Key: key, Status: StatusFailed}, nil
+			},
+		},
+		RequestHasher: hasher,
+	})
+	req = &Request{Method: "POST", Body: []byte("body"), IdempotencyKey: "k1"}
+	if _, err := m.Begin(context.Background(), req); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if hasher.calls != 1 {
+		t.Errorf("expected the request to be hashed once by Check and Lock, got %d calls", hasher.calls)
+	}
+}
@@ -0,0 +1,20 @@
+package idempotency
+
+import (
+	"net/textproto"
+	"strings"
+)
+
+// IsUpgradeRequest reports whether the request headers ask for a protocol upgrade
+// (e.g. WebSocket) through "Connection: Upgrade".
+// Such requests must bypass idempotency handling since the connection is hijacked.
+func IsUpgradeRequest(headers map[string][]string) bool {
+	for _, value := range textproto.MIMEHeader(headers).Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package idempotency
+
+import "testing"
+
+func TestIsUpgradeRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string][]string
+		want    bool
+	}{
+		{name: "nil headers", headers: nil, want: false},
+		{name: "keep-alive", headers: map[string][]string{"Connection": {"keep-alive"}}, want: false},
+		{name: "upgrade", headers: map[string][]string{"Connection": {"Upgrade"}}, want: true},
+		{name: "token list", headers: map[string][]string{"Connection": {"keep-alive, upgrade"}}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsUpgradeRequest(tt.headers); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package idempotency
+
+import (
+	"mime"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// VersionSeparator separates the idempotency key from the API version appended by
+// Config.APIVersion ("<key>@v2")
+const VersionSeparator = "@"
+
+// versionPattern matches path segments and vendor media type parts naming a version
+var versionPattern = regexp.MustCompile(`^v\d+(\.\d+)*$`)
+
+// VersionFromPath returns an APIVersion extractor reading the first path segment
+// naming a version, e.g. "v2" for "/api/v2/orders"
+func VersionFromPath() func(*Request) string {
+	return func(req *Request) string {
+		for _, segment := range strings.Split(req.Path, "/") {
+			if versionPattern.MatchString(segment) {
+				return segment
+			}
+		}
+		return ""
+	}
+}
+
+// VersionFromAccept returns an APIVersion extractor reading the Accept header, either
+// its version parameter ("application/json; version=2") or a vendor media type
+// ("application/vnd.acme.v2+json")
+func VersionFromAccept() func(*Request) string {
+	return func(req *Request) string {
+		for _, value := range textproto.MIMEHeader(req.Headers).Values("Accept") {
+			for _, accepted := range strings.Split(value, ",") {
+				mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+				if err != nil {
+					continue
+				}
+				if version := params["version"]; version != "" {
+					return version
+				}
+				_, subtype, _ := strings.Cut(mediaType, "/")
+				subtype, _, _ = strings.Cut(subtype, "+")
+				for _, part := range strings.Split(subtype, ".") {
+					if versionPattern.MatchString(part) {
+						return part
+					}
+				}
+			}
+		}
+		return ""
+	}
+}
+
+// VersionFromHeader returns an APIVersion extractor reading the given header,
+// e.g. "Api-Version"
+func VersionFromHeader(name string) func(*Request) string {
+	return func(req *Request) string {
+		return textproto.MIMEHeader(req.Headers).Get(name)
+	}
+}
+
+// versionKey appends the API version of req to its idempotency key
+func (m *Manager) versionKey(req *Request) {
+	if m.config.APIVersion == nil {
+		return
+	}
+	if version := m.config.APIVersion(req); version != "" {
+		req.IdempotencyKey += VersionSeparator + version
+	}
+}
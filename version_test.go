@@ -0,0 +1,65 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVersionExtractors(t *testing.T) {
+	tests := []struct {
+		name    string
+		extract func(*Request) string
+		req     *Request
+		want    string
+	}{
+		{"PathPrefix", VersionFromPath(), &Request{Path: "/v2/orders"}, "v2"},
+		{"PathNested", VersionFromPath(), &Request{Path: "/api/v1.1/orders"}, "v1.1"},
+		{"PathNone", VersionFromPath(), &Request{Path: "/orders/v2x"}, ""},
+		{"AcceptParam", VersionFromAccept(), &Request{Headers: map[string][]string{"Accept": {"application/json; version=3"}}}, "3"},
+		{"AcceptVendor", VersionFromAccept(), &Request{Headers: map[string][]string{"Accept": {"text/html, application/vnd.acme.v2+json"}}}, "v2"},
+		{"AcceptNone", VersionFromAccept(), &Request{Headers: map[string][]string{"Accept": {"application/json"}}}, ""},
+		{"Header", VersionFromHeader("Api-Version"), &Request{Headers: map[string][]string{"Api-Version": {"2024-01-01"}}}, "2024-01-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.extract(tt.req); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestManager_APIVersion(t *testing.T) {
+	storage := newListingStorage()
+	manager, err := NewManager(Config{Storage: storage, APIVersion: VersionFromPath()})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	ctx := context.Background()
+
+	process := func(path string) Outcome {
+		req := &Request{Method: "POST", Path: path, IdempotencyKey: "key", Body: []byte("{}")}
+		outcome, err := manager.Begin(ctx, req)
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		if outcome.Kind == OutcomeProceed {
+			if req.IdempotencyKey != "key@"+VersionFromPath()(req) {
+				t.Errorf("expected versioned key, got %q", req.IdempotencyKey)
+			}
+			_ = outcome.Token.Complete(&Response{StatusCode: 201, Body: []byte(path)})
+		}
+		return outcome
+	}
+
+	if outcome := process("/v1/orders"); outcome.Kind != OutcomeProceed {
+		t.Fatalf("expected first v1 request to proceed, got %v", outcome.Kind)
+	}
+	if outcome := process("/v1/orders"); outcome.Kind != OutcomeReplay || string(outcome.Response.Body) != "/v1/orders" {
+		t.Fatalf("expected v1 retry to replay, got %+v", outcome)
+	}
+	if outcome := process("/v2/orders"); outcome.Kind != OutcomeProceed {
+		t.Fatalf("expected same key against v2 to proceed, got %v", outcome.Kind)
+	}
+}
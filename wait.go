@@ -0,0 +1,48 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+)
+
+// waitForResult waits until the request in progress with the key of req is no longer
+// pending, then handles req with its record like Check: the response is replayed, or req
+// is processed if the original failed. ErrRequestInProgress is returned once
+// Config.WaitTimeout elapsed or ctx is done.
+func (m *Manager) waitForResult(ctx context.Context, req *Request) (*CachedResponse, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, m.config.WaitTimeout)
+	defer cancel()
+
+	for m.waitForCompletion(waitCtx, req.IdempotencyKey) == nil {
+		record, err := m.storageGet(waitCtx, req.IdempotencyKey)
+		if err != nil {
+			break
+		}
+		if record == nil || record.Status != StatusPending {
+			return m.checkRecord(ctx, req, record)
+		}
+	}
+
+	m.conflict(ctx, req)
+	return nil, ErrRequestInProgress
+}
+
+// waitForCompletion blocks until the record for key may no longer be pending: until the
+// storage reports it with CompletionWaiter, or for Config.PollInterval otherwise
+func (m *Manager) waitForCompletion(ctx context.Context, key string) error {
+	if Supports[CompletionWaiter](m.config.Storage) {
+		if err := m.config.Storage.(CompletionWaiter).WaitForCompletion(ctx, m.storageKey(key)); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(m.config.PollInterval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
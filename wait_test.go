@@ -0,0 +1,104 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waiterStorage is a MockStorage implementing CompletionWaiter, completing the pending
+// record when waited for
+type waiterStorage struct {
+	MockStorage
+	record *Record
+	waits  int
+}
+
+func (s *waiterStorage) WaitForCompletion(ctx context.Context, key string) error {
+	s.waits++
+	s.record = &Record{Key: key, Status: StatusCompleted, Response: &CachedResponse{StatusCode: 201}}
+	return nil
+}
+
+func TestManager_WaitForResult(t *testing.T) {
+	ctx := context.Background()
+	pending := &Record{Key: "k", Status: StatusPending}
+
+	t.Run("Polling", func(t *testing.T) {
+		var gets int
+		store := &MockStorage{GetFunc: func(ctx context.Context, key string) (*Record, error) {
+			gets++
+			if gets < 3 {
+				return pending, nil
+			}
+			return &Record{Key: key, Status: StatusCompleted, Response: &CachedResponse{StatusCode: 201}}, nil
+		}}
+		m, _ := NewManager(Config{Storage: store, WaitForResult: true, PollInterval: time.Millisecond})
+
+		cached, err := m.Check(ctx, &Request{IdempotencyKey: "k"})
+		if err != nil || cached == nil || cached.StatusCode != 201 {
+			t.Fatalf("expected the completed response, got %v, %v", cached, err)
+		}
+		if gets != 3 {
+			t.Errorf("expected 3 reads, got %d", gets)
+		}
+	})
+
+	t.Run("CompletionWaiter", func(t *testing.T) {
+		store := &waiterStorage{record: pending}
+		store.GetFunc = func(ctx context.Context, key string) (*Record, error) {
+			return store.record, nil
+		}
+		m, _ := NewManager(Config{Storage: store, WaitForResult: true, PollInterval: time.Hour})
+
+		cached, err := m.Check(ctx, &Request{IdempotencyKey: "k"})
+		if err != nil || cached == nil || cached.StatusCode != 201 {
+			t.Fatalf("expected the completed response, got %v, %v", cached, err)
+		}
+		if store.waits != 1 {
+			t.Errorf("expected 1 wait, got %d", store.waits)
+		}
+	})
+
+	t.Run("OriginalFailed", func(t *testing.T) {
+		var gets int
+		store := &MockStorage{GetFunc: func(ctx context.Context, key string) (*Record, error) {
+			gets++
+			if gets == 1 {
+				return pending, nil
+			}
+			return &Record{Key: key, Status: StatusFailed}, nil
+		}}
+		m, _ := NewManager(Config{Storage: store, WaitForResult: true, PollInterval: time.Millisecond})
+
+		cached, err := m.Check(ctx, &Request{IdempotencyKey: "k"})
+		if err != nil || cached != nil {
+			t.Fatalf("expected the request to be processed, got %v, %v", cached, err)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		store := &MockStorage{GetFunc: func(ctx context.Context, key string) (*Record, error) {
+			return pending, nil
+		}}
+		var conflicts int
+		m, _ := NewManager(Config{
+			Storage:        store,
+			WaitForResult:  true,
+			WaitTimeout:    20 * time.Millisecond,
+			PollInterval:   time.Millisecond,
+			OnLockConflict: func(key string) { conflicts++ },
+		})
+
+		start := time.Now()
+		if _, err := m.Check(ctx, &Request{IdempotencyKey: "k"}); err != ErrRequestInProgress {
+			t.Fatalf("expected ErrRequestInProgress, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+			t.Errorf("expected to wait for WaitTimeout, returned after %s", elapsed)
+		}
+		if conflicts != 1 {
+			t.Errorf("expected 1 conflict, got %d", conflicts)
+		}
+	})
+}
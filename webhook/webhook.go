@@ -0,0 +1,128 @@
+// Package webhook delivers idempotency completion events to an HTTP endpoint.
+//
+// A Notifier plugs into Config.OnComplete and POSTs a JSON event every time a record
+// transitions to completed or failed:
+//
+//	notifier := webhook.New("https://example.com/hooks/idempotency", webhook.Options{})
+//	manager, _ := idempotency.NewManager(idempotency.Config{
+//		Storage:    store,
+//		OnComplete: notifier.Notify,
+//	})
+//
+// Events carry the key, status and response status code, never the response body.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+// Event is the JSON payload sent to the webhook URL
+type Event struct {
+	Key         string                   `json:"key"`
+	Status      idempotency.RecordStatus `json:"status"`
+	StatusCode  int                      `json:"status_code,omitempty"`
+	Error       string                   `json:"error,omitempty"`
+	CreatedAt   time.Time                `json:"created_at"`
+	CompletedAt time.Time                `json:"completed_at"`
+}
+
+// Options configures a Notifier
+type Options struct {
+	// Client is the HTTP client used to deliver events
+	// Default: http.DefaultClient
+	Client *http.Client
+
+	// Timeout bounds each delivery
+	// Default: 5 seconds
+	Timeout time.Duration
+
+	// Headers are added to every delivery (e.g. an authorization token)
+	Headers map[string]string
+
+	// OnError is called when a delivery fails (optional)
+	OnError func(event Event, err error)
+}
+
+// Notifier posts completion events to a webhook URL
+type Notifier struct {
+	url  string
+	opts Options
+}
+
+// New creates a Notifier posting events to url
+func New(url string, opts Options) *Notifier {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	return &Notifier{url: url, opts: opts}
+}
+
+// NewEvent builds the event describing record
+func NewEvent(record *idempotency.Record) Event {
+	event := Event{
+		Key:         record.Key,
+		Status:      record.Status,
+		Error:       record.Error,
+		CreatedAt:   record.CreatedAt,
+		CompletedAt: time.Now(),
+	}
+	if record.Response != nil {
+		event.StatusCode = record.Response.StatusCode
+		if !record.Response.CompletedAt.IsZero() {
+			event.CompletedAt = record.Response.CompletedAt
+		}
+	}
+	return event
+}
+
+// Notify delivers the event for record in the background.
+// Its signature matches Config.OnComplete.
+func (n *Notifier) Notify(record *idempotency.Record) {
+	event := NewEvent(record)
+	go func() {
+		if err := n.Send(context.Background(), event); err != nil && n.opts.OnError != nil {
+			n.opts.OnError(event, err)
+		}
+	}()
+}
+
+// Send delivers event synchronously. Any non-2xx response is reported as an error.
+func (n *Notifier) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range n.opts.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	idempotency "github.com/fco-gt/gopotency"
+)
+
+func TestNotifier(t *testing.T) {
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event Event
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+
+	record := &idempotency.Record{
+		Key:    "k1",
+		Status: idempotency.StatusCompleted,
+		Response: &idempotency.CachedResponse{
+			StatusCode:  201,
+			Body:        []byte("secret body"),
+			CompletedAt: time.Now(),
+		},
+	}
+
+	t.Run("Notify", func(t *testing.T) {
+		n := New(server.URL, Options{Headers: map[string]string{"Authorization": "Bearer secret"}})
+		n.Notify(record)
+
+		select {
+		case event := <-received:
+			if event.Key != "k1" || event.Status != idempotency.StatusCompleted || event.StatusCode != 201 {
+				t.Errorf("unexpected event: %+v", event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for webhook delivery")
+		}
+	})
+
+	t.Run("SendError", func(t *testing.T) {
+		n := New(server.URL, Options{})
+		if err := n.Send(context.Background(), NewEvent(record)); err == nil {
+			t.Error("expected error for non-2xx response")
+		}
+	})
+
+	t.Run("OnError", func(t *testing.T) {
+		failed := make(chan error, 1)
+		n := New(server.URL, Options{OnError: func(event Event, err error) { failed <- err }})
+		n.Notify(record)
+
+		select {
+		case err := <-failed:
+			if err == nil {
+				t.Error("expected delivery error")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for OnError")
+		}
+	})
+}
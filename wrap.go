@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HandlerFunc is an HTTP handler returning an error, as used by many services that do
+// not rely on a web framework
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// Wrap adapts an error-returning handler into an idempotent http.Handler.
+// Successful responses are cached and replayed like with the HTTP middleware. When the
+// handler returns an error the record is failed, so the request can be retried, and the
+// error is written with Config.ErrorHandler unless the handler already wrote a response.
+// Without ErrorHandler, errors are answered with 500 and a generic JSON body.
+func (m *Manager) Wrap(h HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsUpgradeRequest(r.Header) || !m.IsPathIncluded(r.URL.Path) {
+			m.serve(h, w, r, nil)
+			return
+		}
+
+		req := &Request{
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			Query:          r.URL.RawQuery,
+			Headers:        r.Header,
+			IdempotencyKey: m.KeyFromHeaders(r.Header),
+		}
+		if req.IdempotencyKey == "" && !m.IsRequestAllowed(req) {
+			m.serve(h, w, r, nil)
+			return
+		}
+
+		if r.Body != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				m.writeError(w, fmt.Errorf("%w: %v", ErrInvalidBody, err), http.StatusBadRequest, m.config.Messages.InvalidBody)
+				return
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			req.Body = body
+		}
+
+		outcome, err := m.Begin(r.Context(), req)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrRequestMismatch):
+				m.writeError(w, err, http.StatusUnprocessableEntity, m.config.Messages.RequestMismatch)
+			case errors.Is(err, ErrNoIdempotencyKey):
+				m.writeError(w, err, http.StatusBadRequest, m.config.Messages.KeyRequired)
+			case errors.Is(err, ErrQuotaExceeded):
+				m.writeError(w, err, http.StatusTooManyRequests, m.config.Messages.QuotaExceeded)
+			case errors.Is(err, ErrKeyExpired):
+				m.writeError(w, err, http.StatusUnprocessableEntity, m.config.Messages.KeyExpired)
+			case m.config.FailClosed:
+				m.writeError(w, err, http.StatusServiceUnavailable, m.config.Messages.StorageUnavailable)
+			default:
+				// Storage unavailable: proceed without idempotency
+				m.serve(h, w, r, nil)
+			}
+			return
+		}
+
+		switch outcome.Kind {
+		case OutcomeReplay:
+			replay := m.Replay(outcome.Response, r.Header)
+			WriteCachedResponse(w, replay, m.ReplayHeaders(replay))
+		case OutcomeConflict:
+			if accepted := m.AcceptedResponse(req.IdempotencyKey); accepted != nil {
+				WriteCachedResponse(w, accepted, nil)
+				return
+			}
+			if retryAfter := m.RetryAfterHeader(r.Context(), req.IdempotencyKey); retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			m.writeError(w, ErrRequestInProgress, http.StatusConflict, m.config.Messages.RequestInProgress)
+		default:
+			for name, value := range outcome.Headers {
+				w.Header().Set(name, value)
+			}
+			m.serve(h, w, r, outcome.Token)
+		}
+	})
+}
+
+// serve runs h, completing or failing token (if any) with its outcome
+func (m *Manager) serve(h HandlerFunc, w http.ResponseWriter, r *http.Request, token *Token) {
+	recorder := RecordResponse(w)
+	if token != nil {
+		recorder = RecordResponseBody(w, token.BodyCapture())
+	}
+
+	if err := h(recorder, r); err != nil {
+		if token != nil {
+			_ = token.Fail(err)
+		}
+		if !recorder.Written() {
+			statusCode, body := http.StatusInternalServerError, any(map[string]string{"error": "internal server error"})
+			if m.config.ErrorHandler != nil {
+				statusCode, body = m.config.ErrorHandler(err)
+			}
+			WriteCachedResponse(w, encodeErrorBody(cmp.Or(statusCode, http.StatusInternalServerError), body), nil)
+		}
+		return
+	}
+
+	if token != nil {
+		_ = token.Complete(recorder.Response())
+	}
+}
+
+// writeError writes the response of the idempotency error err (see HandleError)
+func (m *Manager) writeError(w http.ResponseWriter, err error, statusCode int, message string) {
+	WriteCachedResponse(w, m.HandleError(err, statusCode, message), nil)
+}
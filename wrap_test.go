@@ -0,0 +1,94 @@
+package idempotency
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestManager_Wrap(t *testing.T) {
+	store := newListingStorage()
+	errDeclined := errors.New("card declined")
+	m, _ := NewManager(Config{
+		Storage: store,
+		ErrorHandler: func(err error) (int, any) {
+			switch {
+			case errors.Is(err, errDeclined):
+				return http.StatusPaymentRequired, map[string]string{"error": err.Error()}
+			case errors.Is(err, ErrRequestMismatch):
+				return 0, map[string]string{"code": "idempotency_mismatch"}
+			}
+			return http.StatusInternalServerError, map[string]string{"error": "internal"}
+		},
+	})
+
+	calls := 0
+	fail := false
+	handler := m.Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		calls++
+		if fail {
+			return errDeclined
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("paid"))
+		return nil
+	})
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/pay", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		first := do("k1", "a")
+		second := do("k1", "a")
+		if first.Code != http.StatusCreated || second.Code != http.StatusCreated || second.Body.String() != "paid" {
+			t.Fatalf("expected replayed 201, got %d / %d %q", first.Code, second.Code, second.Body.String())
+		}
+		if second.Header().Get(ReplayedHeaderName) != "true" {
+			t.Error("expected replayed header")
+		}
+		if calls != 1 {
+			t.Errorf("expected handler to run once, ran %d times", calls)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		w := do("k1", "b")
+		if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "idempotency_mismatch") {
+			t.Errorf("expected 422 rendered by ErrorHandler, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("HandlerError", func(t *testing.T) {
+		calls, fail = 0, true
+		w := do("k2", "a")
+		if w.Code != http.StatusPaymentRequired || !strings.Contains(w.Body.String(), "card declined") {
+			t.Fatalf("expected ErrorHandler response, got %d %q", w.Code, w.Body.String())
+		}
+		if r := store.records["k2"]; r.Status != StatusFailed || r.Error != "card declined" {
+			t.Fatalf("expected failed record, got %+v", r)
+		}
+
+		// Failed requests are retried
+		fail = false
+		if w := do("k2", "a"); w.Code != http.StatusCreated || calls != 2 {
+			t.Errorf("expected retry to run the handler, got %d after %d calls", w.Code, calls)
+		}
+	})
+
+	t.Run("NotApplicable", func(t *testing.T) {
+		calls = 0
+		req := httptest.NewRequest("GET", "/pay", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated || calls != 1 {
+			t.Errorf("expected GET to pass through, got %d", w.Code)
+		}
+	})
+}